@@ -0,0 +1,389 @@
+// Package app wires a server together: its database, stores, routes and
+// background jobs. Nothing is global, several apps can run in one process.
+package app
+
+import (
+	"context"
+	"crypto/tls"
+	"database/sql"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/adrienchanove/alpha-enigma-api/atrest"
+	"github.com/adrienchanove/alpha-enigma-api/database"
+	"github.com/adrienchanove/alpha-enigma-api/jwt"
+	"github.com/adrienchanove/alpha-enigma-api/routes"
+	"github.com/adrienchanove/alpha-enigma-api/store"
+	"github.com/adrienchanove/alpha-enigma-api/username"
+	"github.com/gin-gonic/gin"
+
+	swaggerFiles "github.com/swaggo/files"
+	ginSwagger "github.com/swaggo/gin-swagger"
+
+	_ "github.com/adrienchanove/alpha-enigma-api/docs"
+)
+
+// DefaultAuditRetention is how long audit events are kept by default.
+const DefaultAuditRetention = 90 * 24 * time.Hour
+
+// auditPruneInterval is how often audit events past their retention are removed.
+const auditPruneInterval = time.Hour
+
+// tokenSweepInterval is how often the expired tokens are removed.
+const tokenSweepInterval = 10 * time.Minute
+
+// DefaultShutdownTimeout is how long Run waits by default for the requests in
+// flight once asked to stop, below the 10 seconds docker stop grants.
+const DefaultShutdownTimeout = 5 * time.Second
+
+// signingKeyRotation is how often the generated signing key is replaced when
+// no key is configured.
+const signingKeyRotation = 24 * time.Hour
+
+// Config holds the settings of an app.
+type Config struct {
+	Database database.Options
+	// EncryptionKeys encrypt the metadata columns of the database, see
+	// store.EncryptedColumns. They are kept in plaintext when nil. The
+	// database is encrypted when it is first opened with keys, and cannot be
+	// opened without them once encrypted.
+	EncryptionKeys *atrest.Keyring
+	// API configures the routes. Without API.SigningKeys, a signing key is
+	// generated and rotated in process, which only suits a single instance.
+	API routes.Config
+	// Admins are the usernames granted the admin role at startup while there
+	// is no administrator, to bootstrap the first ones. Once there is one, the
+	// roles are only changed through the API: a demoted administrator stays
+	// demoted.
+	Admins []string
+	// AuditRetention is how long audit events are kept, DefaultAuditRetention
+	// when 0.
+	AuditRetention time.Duration
+	// Backups schedules the backups of a SQLite database.
+	Backups BackupConfig
+	// Swagger serves the API documentation at /doc.
+	Swagger bool
+	// AccessLog logs every request.
+	AccessLog bool
+	// CORSOrigins are the origins of the web pages allowed to call the API
+	// from a browser, "*" for any. Browsers allow none when empty.
+	CORSOrigins []string
+	// ShutdownTimeout is how long Run waits for the requests in flight once
+	// its context is done, DefaultShutdownTimeout when 0.
+	ShutdownTimeout time.Duration
+	// TLS serves the API over TLS, and enables the client certificates.
+	TLS TLSConfig
+	// TrustedProxies are the IPs and CIDR ranges of the reverse proxies whose
+	// X-Forwarded-For and X-Real-IP headers give the client IP, used by the
+	// rate limits and the audit log. No proxy is trusted when empty, the
+	// client IP is then the address of the connection.
+	TrustedProxies []string
+}
+
+// App is a server, ready to serve its Router.
+type App struct {
+	Router *gin.Engine
+	DB     *sql.DB
+	Stores *store.Stores
+	API    *routes.API
+
+	config Config
+	// tlsConfig is nil when TLS is disabled, certificate is the one served.
+	tlsConfig   *tls.Config
+	certificate *certificate
+	// stop stops the background jobs, jobs waits for them.
+	stop context.CancelFunc
+	jobs sync.WaitGroup
+}
+
+// New opens and migrates the database of config, sets up the routes and
+// starts the background jobs. Close releases them.
+func New(config Config) (*App, error) {
+	if config.AuditRetention == 0 {
+		config.AuditRetention = DefaultAuditRetention
+	}
+	if config.AuditRetention < 0 {
+		return nil, errors.New("the audit retention must be positive")
+	}
+	if config.ShutdownTimeout == 0 {
+		config.ShutdownTimeout = DefaultShutdownTimeout
+	}
+	if config.ShutdownTimeout < 0 {
+		return nil, errors.New("the shutdown timeout must be positive")
+	}
+	if err := config.Backups.check(config.Database.Dialect()); err != nil {
+		return nil, err
+	}
+
+	tlsConfig, cert, err := config.TLS.serverConfig()
+	if err != nil {
+		return nil, err
+	}
+	config.API.ClientCertificates = config.TLS.ClientCAFile != ""
+
+	db, err := database.InitDB(config.Database)
+	if err != nil {
+		return nil, err
+	}
+	if err := encryptDatabase(context.Background(), db, config.Database.Dialect(), config.EncryptionKeys); err != nil {
+		db.Close()
+		return nil, err
+	}
+	a := &App{
+		DB:          db,
+		Stores:      store.NewSQL(db, config.Database.Dialect(), config.EncryptionKeys),
+		config:      config,
+		tlsConfig:   tlsConfig,
+		certificate: cert,
+	}
+
+	var rotatedKeys *jwt.Keyring
+	if a.config.API.SigningKeys == nil {
+		if rotatedKeys, err = generateKeyring(); err != nil {
+			db.Close()
+			return nil, err
+		}
+		a.config.API.SigningKeys = rotatedKeys
+		log.Println("WARNING: no signing key is configured, signed tokens are signed with a key generated at startup: " +
+			"they are invalid after a restart and on the other instances. Set the signing keys outside of development.")
+	}
+	if a.API, err = routes.New(a.Stores, a.config.API); err != nil {
+		db.Close()
+		return nil, err
+	}
+	if err := a.promoteAdmins(context.Background()); err != nil {
+		db.Close()
+		return nil, err
+	}
+
+	a.Router = gin.New()
+	if err := a.Router.SetTrustedProxies(config.TrustedProxies); err != nil {
+		db.Close()
+		return nil, err
+	}
+	if config.AccessLog {
+		a.Router.Use(gin.Logger())
+	}
+	a.Router.Use(gin.Recovery())
+	if len(config.CORSOrigins) > 0 {
+		a.Router.Use(cors(config.CORSOrigins))
+	}
+
+	if config.Swagger {
+		// Ajout de la route pour Swagger UI
+		a.Router.GET("/doc/*any", ginSwagger.WrapHandler(swaggerFiles.Handler))
+
+		// / redirect to /doc/index.html
+		a.Router.GET("/", func(c *gin.Context) {
+			c.Redirect(301, "/doc/index.html")
+		})
+	}
+	// 404
+	a.Router.NoRoute(func(c *gin.Context) {
+		c.JSON(404, gin.H{"code": "PAGE_NOT_FOUND", "message": "Page not found"})
+	})
+
+	a.API.Register(a.Router)
+
+	ctx, stop := context.WithCancel(context.Background())
+	a.stop = stop
+	a.startJob(func() { a.pruneAuditEvents(ctx) })
+	a.startJob(func() { a.sweepTokens(ctx) })
+	if rotatedKeys != nil {
+		lifetime := a.config.API.SignedTokenLifetime
+		if lifetime == 0 {
+			lifetime = routes.DefaultTokenLifetime
+		}
+		keep := rotatedKeysKept(lifetime)
+		a.startJob(func() { a.rotateSigningKeys(ctx, rotatedKeys, keep) })
+	}
+	if a.config.Backups.Dir != "" {
+		a.startJob(func() { a.backupDatabase(ctx) })
+	}
+	if a.config.TLS.CertFile != "" {
+		a.startJob(func() { a.reloadCertificate(ctx) })
+	}
+	return a, nil
+}
+
+// encryptDatabase encrypts the metadata columns of db when it is first opened
+// with keys, and refuses the database encrypted when there are none.
+func encryptDatabase(ctx context.Context, db *sql.DB, dialect database.Dialect, keys *atrest.Keyring) error {
+	encrypted, err := store.EncryptionState(ctx, db)
+	switch {
+	case err != nil:
+		return err
+	case encrypted && keys == nil:
+		return errors.New("the database is encrypted: set the encryption keys, or run -decrypt to turn the encryption off")
+	case !encrypted && keys != nil:
+		rewritten, err := store.Reencrypt(ctx, db, dialect, keys, false)
+		if err != nil {
+			return fmt.Errorf("failed to encrypt the database: %w", err)
+		}
+		log.Printf("encrypted the database, %d rows rewritten", rewritten)
+	}
+	return nil
+}
+
+// startJob runs job in the background, Close waits for it to return.
+func (a *App) startJob(job func()) {
+	a.jobs.Add(1)
+	go func() {
+		defer a.jobs.Done()
+		job()
+	}()
+}
+
+// Run serves the app on addr until ctx is done or the server fails. Once ctx
+// is done, it stops accepting connections and waits up to the shutdown
+// timeout for the requests in flight, then cuts the remaining ones.
+func (a *App) Run(ctx context.Context, addr string) error {
+	server := &http.Server{
+		Addr:              addr,
+		Handler:           a.Router,
+		ReadHeaderTimeout: 10 * time.Second,
+		TLSConfig:         a.tlsConfig,
+	}
+
+	failed := make(chan error, 1)
+	go func() {
+		if a.tlsConfig != nil {
+			log.Printf("listening on %s with TLS", addr)
+			// the certificate is given by TLSConfig.GetCertificate
+			failed <- server.ListenAndServeTLS("", "")
+			return
+		}
+		log.Printf("listening on %s", addr)
+		failed <- server.ListenAndServe()
+	}()
+	select {
+	case err := <-failed:
+		return err
+	case <-ctx.Done():
+	}
+
+	log.Printf("shutting down, waiting up to %s for the requests in flight", a.config.ShutdownTimeout)
+	drain, cancel := context.WithTimeout(context.Background(), a.config.ShutdownTimeout)
+	defer cancel()
+	if err := server.Shutdown(drain); err != nil {
+		server.Close()
+		return fmt.Errorf("shutdown: %w, the remaining requests were cut", err)
+	}
+	return nil
+}
+
+// Close stops the background jobs and closes the database, once Run
+// returned.
+func (a *App) Close() error {
+	a.stop()
+	a.jobs.Wait()
+	return a.DB.Close()
+}
+
+// pruneAuditEvents removes the audit events past their retention, until ctx
+// is done.
+func (a *App) pruneAuditEvents(ctx context.Context) {
+	ticker := time.NewTicker(auditPruneInterval)
+	defer ticker.Stop()
+	for {
+		if pruned, err := a.Stores.Audit.Prune(ctx, time.Now().Add(-a.config.AuditRetention)); err != nil && ctx.Err() == nil {
+			log.Println(err)
+		} else if pruned > 0 {
+			log.Printf("pruned %d audit events", pruned)
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// sweepTokens removes the expired tokens every tokenSweepInterval, until ctx
+// is done. They are never returned anyway, but would otherwise be kept until
+// looked up.
+func (a *App) sweepTokens(ctx context.Context) {
+	ticker := time.NewTicker(tokenSweepInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+
+		if _, err := a.Stores.Tokens.DeleteExpired(ctx); err != nil && ctx.Err() == nil {
+			log.Println(err)
+		}
+	}
+}
+
+// generateKeyring returns a keyring with a generated key.
+func generateKeyring() (*jwt.Keyring, error) {
+	key, err := jwt.GenerateKey()
+	if err != nil {
+		return nil, err
+	}
+	return jwt.NewKeyring(key)
+}
+
+// rotatedKeysKept returns how many previous generated signing keys are kept
+// when rotating: enough for the tokens signed right before a rotation to stay
+// valid for their whole lifetime.
+func rotatedKeysKept(lifetime time.Duration) int {
+	return max(1, int((lifetime+signingKeyRotation-1)/signingKeyRotation))
+}
+
+// rotateSigningKeys replaces the generated signing key of keyring every
+// signingKeyRotation, keeping the keep previous ones to verify the tokens
+// they signed, until ctx is done.
+func (a *App) rotateSigningKeys(ctx context.Context, keyring *jwt.Keyring, keep int) {
+	ticker := time.NewTicker(signingKeyRotation)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+
+		key, err := jwt.GenerateKey()
+		if err != nil {
+			log.Println(err)
+			continue
+		}
+		keyring.Rotate(key, keep)
+		a.API.AuditSystemEvent("auth.signing_key_rotated", "kid="+key.ID)
+	}
+}
+
+// promoteAdmins grants the admin role to the users of config.Admins, unless
+// there is an administrator already.
+func (a *App) promoteAdmins(ctx context.Context) error {
+	if len(a.config.Admins) == 0 {
+		return nil
+	}
+	counts, err := a.Stores.Users.Count(ctx)
+	if err != nil || counts.Admins > 0 {
+		return err
+	}
+	for _, name := range a.config.Admins {
+		user, err := a.Stores.Users.GetByUsernameKey(ctx, username.Key(name))
+		if errors.Is(err, store.ErrNotFound) || user.Role == routes.RoleAdmin {
+			continue
+		}
+		if err == nil {
+			err = a.Stores.Users.SetRole(ctx, user.ID, routes.RoleAdmin)
+		}
+		if err != nil {
+			return err
+		}
+		a.API.AuditSystemEvent("admin.promoted", "username="+name)
+	}
+	return nil
+}
@@ -0,0 +1,312 @@
+package app
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/adrienchanove/alpha-enigma-api/atrest"
+	"github.com/adrienchanove/alpha-enigma-api/database"
+	"github.com/adrienchanove/alpha-enigma-api/ratelimit"
+	"github.com/adrienchanove/alpha-enigma-api/routes"
+	"github.com/adrienchanove/alpha-enigma-api/store"
+	"github.com/gin-gonic/gin"
+)
+
+func init() {
+	gin.SetMode(gin.TestMode)
+}
+
+// newTestApp returns an app on a SQLite database in a temporary directory,
+// closed at the end of the test.
+func newTestApp(t *testing.T, config Config) *App {
+	t.Helper()
+	config.Database = database.Options{DSN: filepath.Join(t.TempDir(), "test.db")}
+	a, err := New(config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { a.Close() })
+	return a
+}
+
+// register sends an account creation from remoteAddr with the
+// X-Forwarded-For header forwardedFor, and returns the response status. The
+// account is created when name is not empty, the request is invalid
+// otherwise.
+func register(a *App, remoteAddr string, forwardedFor string, name string) int {
+	body := `{}`
+	if name != "" {
+		content, _ := json.Marshal(map[string]string{"username": name, "publicKey": publicKey})
+		body = string(content)
+	}
+	req := httptest.NewRequest("POST", "/users/", strings.NewReader(body))
+	req.RemoteAddr = remoteAddr
+	req.Header.Set("Content-Type", "application/json")
+	if forwardedFor != "" {
+		req.Header.Set("X-Forwarded-For", forwardedFor)
+	}
+	w := httptest.NewRecorder()
+	a.Router.ServeHTTP(w, req)
+	return w.Code
+}
+
+// publicKey is the public key of the users registered by the tests.
+var publicKey = func() string {
+	key, err := rsa.GenerateKey(rand.Reader, 1024)
+	if err != nil {
+		panic(err)
+	}
+	der, err := x509.MarshalPKIXPublicKey(&key.PublicKey)
+	if err != nil {
+		panic(err)
+	}
+	return string(pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: der}))
+}()
+
+func TestForwardedForIsOnlyTrustedFromProxies(t *testing.T) {
+	limits, err := ratelimit.ParsePolicies("registration-ip=1/1h")
+	if err != nil {
+		t.Fatal(err)
+	}
+	config := Config{API: routes.Config{RateLimits: limits}}
+
+	// without trusted proxies, spoofing the header does not reset the limit
+	a := newTestApp(t, config)
+	if code := register(a, "192.0.2.1:1234", "198.51.100.1", ""); code == http.StatusTooManyRequests {
+		t.Fatalf("first registration: status %d", code)
+	}
+	for _, spoofed := range []string{"198.51.100.2", "198.51.100.3"} {
+		if code := register(a, "192.0.2.1:1234", spoofed, ""); code != http.StatusTooManyRequests {
+			t.Errorf("registration forwarded for %s: status %d, want %d", spoofed, code, http.StatusTooManyRequests)
+		}
+	}
+
+	// behind a trusted proxy, the forwarded IP is the client's
+	config.TrustedProxies = []string{"192.0.2.0/24"}
+	a = newTestApp(t, config)
+	for _, client := range []string{"198.51.100.1", "198.51.100.2"} {
+		if code := register(a, "192.0.2.1:1234", client, ""); code == http.StatusTooManyRequests {
+			t.Errorf("first registration of %s behind the proxy: status %d", client, code)
+		}
+	}
+	if code := register(a, "192.0.2.1:1234", "198.51.100.1", ""); code != http.StatusTooManyRequests {
+		t.Errorf("second registration behind the proxy: status %d, want %d", code, http.StatusTooManyRequests)
+	}
+}
+
+func TestAuditedIPIsOnlyForwardedByProxies(t *testing.T) {
+	// auditedIP sends a request with a bad token and returns the IP of the
+	// failed authentication recorded.
+	auditedIP := func(a *App) string {
+		req := httptest.NewRequest("GET", "/messages/", nil)
+		req.RemoteAddr = "192.0.2.1:1234"
+		req.Header.Set("Authorization", "Bearer forged")
+		req.Header.Set("X-Forwarded-For", "198.51.100.1")
+		a.Router.ServeHTTP(httptest.NewRecorder(), req)
+
+		events, err := a.Stores.Audit.List(context.Background(), store.AuditFilter{Event: "auth.failed", Limit: 10})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(events) != 1 {
+			t.Fatalf("%d failed authentications recorded, want 1", len(events))
+		}
+		return events[0].IP
+	}
+
+	if ip := auditedIP(newTestApp(t, Config{})); ip != "192.0.2.1" {
+		t.Errorf("audited IP %s without trusted proxies, want the connection's", ip)
+	}
+	if ip := auditedIP(newTestApp(t, Config{TrustedProxies: []string{"192.0.2.1"}})); ip != "198.51.100.1" {
+		t.Errorf("audited IP %s behind a trusted proxy, want the forwarded one", ip)
+	}
+}
+
+func TestTokensSurviveRestarts(t *testing.T) {
+	config := Config{Database: database.Options{DSN: filepath.Join(t.TempDir(), "test.db")}}
+	a, err := New(config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	now := time.Now()
+	err = a.Stores.Tokens.Save(context.Background(), store.Token{Token: "token", SessionID: "session", UserID: 1, IssuedAt: now, ExpiresAt: now.Add(time.Hour)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	a.Close()
+
+	a, err = New(config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer a.Close()
+	if token, err := a.Stores.Tokens.Get(context.Background(), "token"); err != nil || token.SessionID != "session" {
+		t.Errorf("token after a restart: %+v, %v", token, err)
+	}
+}
+
+func TestDatabaseIsEncryptedAtStartup(t *testing.T) {
+	key, err := atrest.GenerateKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+	keys, err := atrest.NewKeyring(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	config := Config{Database: database.Options{DSN: filepath.Join(t.TempDir(), "test.db")}}
+	a, err := New(config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if status := register(a, "192.0.2.1:1234", "", "alice"); status != http.StatusCreated {
+		t.Fatalf("registration status %d", status)
+	}
+	a.Close()
+
+	config.EncryptionKeys = keys
+	if a, err = New(config); err != nil {
+		t.Fatal(err)
+	}
+	if encrypted, err := store.EncryptionState(context.Background(), a.DB); err != nil || !encrypted {
+		t.Errorf("encrypted %v, %v at startup with keys", encrypted, err)
+	}
+	if user, err := a.Stores.Users.Get(context.Background(), 1); err != nil || user.Username != "alice" {
+		t.Errorf("user %+v, %v", user, err)
+	}
+	a.Close()
+
+	config.EncryptionKeys = nil
+	if a, err := New(config); err == nil {
+		a.Close()
+		t.Error("encrypted database opened without keys")
+	}
+}
+
+func TestAdminsAreOnlyPromotedAtBootstrap(t *testing.T) {
+	config := Config{Database: database.Options{DSN: filepath.Join(t.TempDir(), "test.db")}, Admins: []string{"alice"}}
+	restart := func(a *App) *App {
+		t.Helper()
+		if a != nil {
+			a.Close()
+		}
+		a, err := New(config)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return a
+	}
+	role := func(a *App, id int) string {
+		t.Helper()
+		user, err := a.Stores.Users.Get(context.Background(), id)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return user.Role
+	}
+
+	a := restart(nil)
+	for _, name := range []string{"alice", "bob"} {
+		if status := register(a, "192.0.2.1:1234", "", name); status != http.StatusCreated {
+			t.Fatalf("registration of %s: status %d", name, status)
+		}
+	}
+	a = restart(a)
+	alice, bob := 1, 2
+	if role(a, alice) != routes.RoleAdmin {
+		t.Fatal("alice is not promoted")
+	}
+
+	// alice hands the administration over to bob
+	ctx := context.Background()
+	if err := a.Stores.Users.SetRole(ctx, bob, routes.RoleAdmin); err != nil {
+		t.Fatal(err)
+	}
+	if err := a.Stores.Users.SetRole(ctx, alice, routes.RoleUser); err != nil {
+		t.Fatal(err)
+	}
+	a = restart(a)
+	defer a.Close()
+	if role(a, alice) != routes.RoleUser {
+		t.Error("alice promoted again while bob is an administrator")
+	}
+}
+
+func TestAppsAreIndependent(t *testing.T) {
+	limits, err := ratelimit.ParsePolicies("registration-ip=1/1h")
+	if err != nil {
+		t.Fatal(err)
+	}
+	config := Config{API: routes.Config{RateLimits: limits}}
+	first, second := newTestApp(t, config), newTestApp(t, config)
+
+	// each app has its own users and rate limits
+	for _, a := range []*App{first, second} {
+		if code := register(a, "192.0.2.1:1234", "", "alice"); code != http.StatusCreated {
+			t.Fatalf("registration of alice: status %d", code)
+		}
+	}
+	if code := register(first, "192.0.2.1:1234", "", "bob"); code != http.StatusTooManyRequests {
+		t.Errorf("second registration from the IP: status %d, want %d", code, http.StatusTooManyRequests)
+	}
+	if code := register(second, "192.0.2.2:1234", "", "carol"); code != http.StatusCreated {
+		t.Errorf("registration of carol: status %d", code)
+	}
+	ctx := context.Background()
+	if _, err := first.Stores.Users.GetByUsernameKey(ctx, "carol"); !errors.Is(err, store.ErrNotFound) {
+		t.Errorf("carol of the second app found in the first: %v", err)
+	}
+
+	// and its own tokens
+	now := time.Now()
+	token := store.Token{Token: "token", SessionID: "session", UserID: 1, Scopes: []string{routes.ScopeUsersRead}, IssuedAt: now, ExpiresAt: now.Add(time.Hour)}
+	if err := first.Stores.Tokens.Save(ctx, token); err != nil {
+		t.Fatal(err)
+	}
+	req := httptest.NewRequest("GET", "/users/me", nil)
+	req.Header.Set("Authorization", "Bearer token")
+	for _, test := range []struct {
+		app    *App
+		status int
+	}{{first, http.StatusOK}, {second, http.StatusUnauthorized}} {
+		w := httptest.NewRecorder()
+		test.app.Router.ServeHTTP(w, req)
+		if w.Code != test.status {
+			t.Errorf("token of the first app: status %d, want %d", w.Code, test.status)
+		}
+	}
+}
+
+func TestInvalidTrustedProxies(t *testing.T) {
+	_, err := New(Config{
+		Database:       database.Options{DSN: filepath.Join(t.TempDir(), "test.db")},
+		TrustedProxies: []string{"not an ip"},
+	})
+	if err == nil {
+		t.Error("New accepted an invalid trusted proxy")
+	}
+}
+
+func TestRotatedKeysCoverTheTokenLifetime(t *testing.T) {
+	for lifetime, want := range map[time.Duration]int{
+		time.Minute:        1,
+		signingKeyRotation: 1,
+		25 * time.Hour:     2,
+		7 * 24 * time.Hour: 7,
+	} {
+		if kept := rotatedKeysKept(lifetime); kept != want {
+			t.Errorf("lifetime %s: %d keys kept, want %d", lifetime, kept, want)
+		}
+	}
+}
@@ -0,0 +1,108 @@
+package app
+
+import (
+	"context"
+	"errors"
+	"log"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/adrienchanove/alpha-enigma-api/database"
+)
+
+// Defaults of the scheduled backups.
+const (
+	DefaultBackupInterval = 24 * time.Hour
+	DefaultBackupKeep     = 7
+)
+
+// backupPrefix and backupSuffix name the scheduled backups, the time of the
+// backup in between so they sort by name.
+const (
+	backupPrefix = "alpha-enigma-"
+	backupSuffix = ".db"
+)
+
+// BackupConfig schedules the backups of a SQLite database into a directory.
+// The backups are disabled when Dir is empty.
+type BackupConfig struct {
+	Dir string
+	// Interval is the time between two backups, DefaultBackupInterval when 0.
+	Interval time.Duration
+	// Keep is how many backups are kept, the older ones are removed.
+	// DefaultBackupKeep when 0.
+	Keep int
+}
+
+// check validates c and fills in the defaults.
+func (c *BackupConfig) check(dialect database.Dialect) error {
+	if c.Dir == "" {
+		return nil
+	}
+	if dialect != database.SQLite {
+		return database.ErrBackupUnsupported
+	}
+	if c.Interval < 0 || c.Keep < 0 {
+		return errors.New("the backup interval and the number of backups kept must be positive")
+	}
+	if c.Interval == 0 {
+		c.Interval = DefaultBackupInterval
+	}
+	if c.Keep == 0 {
+		c.Keep = DefaultBackupKeep
+	}
+	return os.MkdirAll(c.Dir, 0o700)
+}
+
+// backupDatabase backs the database up every config.Backups.Interval, keeping
+// the last config.Backups.Keep backups, until ctx is done.
+func (a *App) backupDatabase(ctx context.Context) {
+	ticker := time.NewTicker(a.config.Backups.Interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+
+		name := backupPrefix + time.Now().UTC().Format("20060102-150405") + backupSuffix
+		path := filepath.Join(a.config.Backups.Dir, name)
+		if err := database.Backup(ctx, a.DB, a.config.Database.Dialect(), path); err != nil {
+			if ctx.Err() == nil {
+				log.Println("backup failed:", err)
+			}
+			continue
+		}
+		if err := a.pruneBackups(); err != nil {
+			log.Println(err)
+		}
+	}
+}
+
+// pruneBackups removes the scheduled backups but the last
+// config.Backups.Keep ones.
+func (a *App) pruneBackups() error {
+	entries, err := os.ReadDir(a.config.Backups.Dir)
+	if err != nil {
+		return err
+	}
+	var backups []string
+	for _, entry := range entries {
+		if name := entry.Name(); strings.HasPrefix(name, backupPrefix) && strings.HasSuffix(name, backupSuffix) {
+			backups = append(backups, name)
+		}
+	}
+	slices.Sort(backups)
+
+	for len(backups) > a.config.Backups.Keep {
+		if err := os.Remove(filepath.Join(a.config.Backups.Dir, backups[0])); err != nil {
+			return err
+		}
+		backups = backups[1:]
+	}
+	return nil
+}
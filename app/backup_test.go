@@ -0,0 +1,48 @@
+package app
+
+import (
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+)
+
+func TestPruneBackups(t *testing.T) {
+	dir := t.TempDir()
+	names := []string{
+		backupPrefix + "20260103-000000" + backupSuffix,
+		backupPrefix + "20260101-000000" + backupSuffix,
+		backupPrefix + "20260104-000000" + backupSuffix,
+		backupPrefix + "20260102-000000" + backupSuffix,
+		// not scheduled backups
+		"manual.db",
+		backupPrefix + "20250101-000000" + backupSuffix + ".tmp",
+	}
+	for _, name := range names {
+		if err := os.WriteFile(filepath.Join(dir, name), nil, 0o600); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	a := &App{config: Config{Backups: BackupConfig{Dir: dir, Keep: 2}}}
+	if err := a.pruneBackups(); err != nil {
+		t.Fatal(err)
+	}
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var kept []string
+	for _, entry := range entries {
+		kept = append(kept, entry.Name())
+	}
+	want := []string{
+		backupPrefix + "20250101-000000" + backupSuffix + ".tmp",
+		backupPrefix + "20260103-000000" + backupSuffix,
+		backupPrefix + "20260104-000000" + backupSuffix,
+		"manual.db",
+	}
+	if !slices.Equal(kept, want) {
+		t.Errorf("kept %v, want %v", kept, want)
+	}
+}
@@ -0,0 +1,205 @@
+package app
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"log"
+	"math/big"
+	"net"
+	"os"
+	"sync"
+	"time"
+)
+
+// certReloadInterval is how often the certificate files are checked for
+// changes.
+const certReloadInterval = 10 * time.Second
+
+// selfSignedLifetime is how long the self-signed certificates are valid, a new
+// one is generated at every start.
+const selfSignedLifetime = 30 * 24 * time.Hour
+
+// TLSConfig serves the API over TLS, with the certificate of CertFile and
+// KeyFile or, for development, a self-signed one. TLS is disabled when both
+// are unset.
+type TLSConfig struct {
+	// CertFile and KeyFile hold the PEM certificate chain and its private key.
+	// They are reloaded when they change, so renewing the certificate needs
+	// no restart.
+	CertFile string
+	KeyFile  string
+	// SelfSigned generates a certificate for localhost at startup, which
+	// clients do not trust: only use it in development.
+	SelfSigned bool
+	// ClientCAFile holds the PEM certificates of the authorities issuing the
+	// client certificates. When set, requests presenting a client certificate
+	// they issued are authenticated as the user named by the certificate's
+	// subject common name, instead of by a bearer token.
+	ClientCAFile string
+}
+
+// enabled reports whether c serves TLS.
+func (c TLSConfig) enabled() bool {
+	return c.CertFile != "" || c.KeyFile != "" || c.SelfSigned
+}
+
+// serverConfig loads the certificate and client authorities of c and returns
+// the configuration of the TLS server, nil when TLS is disabled.
+func (c TLSConfig) serverConfig() (*tls.Config, *certificate, error) {
+	if !c.enabled() {
+		if c.ClientCAFile != "" {
+			return nil, nil, errors.New("client certificates require TLS")
+		}
+		return nil, nil, nil
+	}
+	if c.SelfSigned && (c.CertFile != "" || c.KeyFile != "") {
+		return nil, nil, errors.New("use either a self-signed certificate or certificate files")
+	}
+
+	cert := &certificate{certFile: c.CertFile, keyFile: c.KeyFile}
+	if c.SelfSigned {
+		selfSigned, err := selfSignedCertificate()
+		if err != nil {
+			return nil, nil, err
+		}
+		cert.cert = selfSigned
+	} else {
+		if c.CertFile == "" || c.KeyFile == "" {
+			return nil, nil, errors.New("the TLS certificate and key files must be set together")
+		}
+		if _, err := cert.reload(); err != nil {
+			return nil, nil, err
+		}
+	}
+
+	config := &tls.Config{
+		MinVersion:     tls.VersionTLS12,
+		GetCertificate: cert.get,
+	}
+	if c.ClientCAFile != "" {
+		content, err := os.ReadFile(c.ClientCAFile)
+		if err != nil {
+			return nil, nil, err
+		}
+		config.ClientCAs = x509.NewCertPool()
+		if !config.ClientCAs.AppendCertsFromPEM(content) {
+			return nil, nil, fmt.Errorf("%s holds no PEM certificate", c.ClientCAFile)
+		}
+		// clients without a certificate still authenticate with tokens
+		config.ClientAuth = tls.VerifyClientCertIfGiven
+	}
+	return config, cert, nil
+}
+
+// certificate is the certificate served, reloaded from its files when they
+// change.
+type certificate struct {
+	certFile string
+	keyFile  string
+
+	mu   sync.RWMutex
+	cert *tls.Certificate
+	// modTimes are the modification times of the files loaded.
+	modTimes [2]time.Time
+}
+
+func (c *certificate) get(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return c.cert, nil
+}
+
+// reload loads the files again if they changed since the last load, and
+// reports whether they did. The certificate served is kept when they fail to
+// load, e.g. while they are being replaced.
+func (c *certificate) reload() (bool, error) {
+	var modTimes [2]time.Time
+	for i, path := range []string{c.certFile, c.keyFile} {
+		info, err := os.Stat(path)
+		if err != nil {
+			return false, err
+		}
+		modTimes[i] = info.ModTime()
+	}
+	c.mu.RLock()
+	unchanged := c.cert != nil && modTimes == c.modTimes
+	c.mu.RUnlock()
+	if unchanged {
+		return false, nil
+	}
+
+	cert, err := tls.LoadX509KeyPair(c.certFile, c.keyFile)
+	if err != nil {
+		return false, fmt.Errorf("TLS certificate %s: %w", c.certFile, err)
+	}
+	c.mu.Lock()
+	c.cert, c.modTimes = &cert, modTimes
+	c.mu.Unlock()
+	return true, nil
+}
+
+// reloadCertificate reloads the certificate files every certReloadInterval
+// if they changed, until ctx is done.
+func (a *App) reloadCertificate(ctx context.Context) {
+	ticker := time.NewTicker(certReloadInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+
+		if reloaded, err := a.certificate.reload(); err != nil {
+			log.Println(err)
+		} else if reloaded {
+			log.Printf("reloaded the TLS certificate %s", a.certificate.certFile)
+		}
+	}
+}
+
+// selfSignedCertificate generates a certificate for localhost, the loopback
+// addresses and the host name.
+func selfSignedCertificate() (*tls.Certificate, error) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return nil, err
+	}
+	serial, err := rand.Int(rand.Reader, new(big.Int).Lsh(big.NewInt(1), 128))
+	if err != nil {
+		return nil, err
+	}
+
+	names := []string{"localhost"}
+	if hostname, err := os.Hostname(); err == nil && hostname != "localhost" {
+		names = append(names, hostname)
+	}
+	now := time.Now()
+	template := &x509.Certificate{
+		SerialNumber: serial,
+		Subject:      pkix.Name{CommonName: "alpha-enigma-api development"},
+		DNSNames:     names,
+		IPAddresses:  []net.IP{net.IPv4(127, 0, 0, 1), net.IPv6loopback},
+		NotBefore:    now.Add(-time.Hour),
+		NotAfter:     now.Add(selfSignedLifetime),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		return nil, err
+	}
+
+	fingerprint := sha256.Sum256(der)
+	log.Printf("generated a self-signed TLS certificate for %v, SHA-256 fingerprint %s", names, hex.EncodeToString(fingerprint[:]))
+	return &tls.Certificate{Certificate: [][]byte{der}, PrivateKey: key}, nil
+}
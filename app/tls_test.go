@@ -0,0 +1,123 @@
+package app
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"math/big"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// writeCertificate writes a new certificate of name and its key into the
+// files of c, modified at modTime.
+func writeCertificate(t *testing.T, c TLSConfig, name string, modTime time.Time) {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	now := time.Now()
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(now.UnixNano()),
+		Subject:      pkix.Name{CommonName: name},
+		DNSNames:     []string{name},
+		NotBefore:    now.Add(-time.Hour),
+		NotAfter:     now.Add(time.Hour),
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for path, block := range map[string]*pem.Block{
+		c.CertFile: {Type: "CERTIFICATE", Bytes: der},
+		c.KeyFile:  {Type: "EC PRIVATE KEY", Bytes: keyDER},
+	} {
+		if err := os.WriteFile(path, pem.EncodeToMemory(block), 0o600); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.Chtimes(path, modTime, modTime); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+// servedName returns the common name of the certificate a TLS server with
+// config presents to a new connection.
+func servedName(t *testing.T, config *tls.Config) string {
+	t.Helper()
+	listener, err := tls.Listen("tcp", "127.0.0.1:0", config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer listener.Close()
+	go func() {
+		conn, err := listener.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		conn.(*tls.Conn).Handshake()
+	}()
+
+	conn, err := tls.Dial("tcp", listener.Addr().String(), &tls.Config{InsecureSkipVerify: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	return conn.ConnectionState().PeerCertificates[0].Subject.CommonName
+}
+
+func TestCertificateReload(t *testing.T) {
+	dir := t.TempDir()
+	c := TLSConfig{CertFile: filepath.Join(dir, "cert.pem"), KeyFile: filepath.Join(dir, "key.pem")}
+	modTime := time.Now().Add(-time.Minute)
+	writeCertificate(t, c, "old.example.com", modTime)
+
+	config, cert, err := c.serverConfig()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if name := servedName(t, config); name != "old.example.com" {
+		t.Fatalf("served %s, want old.example.com", name)
+	}
+	if reloaded, err := cert.reload(); err != nil || reloaded {
+		t.Errorf("unchanged files reloaded: %v, %v", reloaded, err)
+	}
+
+	// the certificate is swapped for the new connections
+	writeCertificate(t, c, "new.example.com", modTime.Add(time.Second))
+	if reloaded, err := cert.reload(); err != nil || !reloaded {
+		t.Fatalf("renewed files not reloaded: %v, %v", reloaded, err)
+	}
+	if name := servedName(t, config); name != "new.example.com" {
+		t.Errorf("served %s after the reload, want new.example.com", name)
+	}
+
+	// a certificate not matching its key yet is not loaded, the last one
+	// is still served
+	key, err := os.ReadFile(c.KeyFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	writeCertificate(t, c, "next.example.com", modTime.Add(2*time.Second))
+	if err := os.WriteFile(c.KeyFile, key, 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := cert.reload(); err == nil {
+		t.Error("certificate loaded with the key of another one")
+	}
+	if name := servedName(t, config); name != "new.example.com" {
+		t.Errorf("served %s after a failed reload, want new.example.com", name)
+	}
+}
@@ -0,0 +1,262 @@
+// Package atrest encrypts the metadata the database keeps in plaintext:
+// messages are end-to-end encrypted, but usernames, profiles or audit
+// details would otherwise be readable by anyone holding the database file.
+//
+// Values are sealed with AES-256-GCM, bound to their column so they cannot be
+// moved to another one. Columns looked up by equality, such as the username
+// keys, hold a blind index instead: a keyed HMAC, equal for equal values.
+// Both carry the id of their key, taken from a Keyring whose first key
+// encrypts new values while the others only decrypt, so keys can be rotated
+// by re-encrypting the rows, without downtime.
+//
+// A column is either encrypted or not as a whole: the values are opened only
+// once every value is sealed, so a plaintext value shaped like a sealed one,
+// such as a bio starting with "enc:", is never taken for one.
+package atrest
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"strings"
+)
+
+var (
+	ErrUnknownKey = errors.New("value encrypted with an unknown key")
+	ErrMalformed  = errors.New("malformed encrypted value")
+)
+
+// Prefixes of the encrypted values and blind indexes.
+const (
+	sealedPrefix = "enc:"
+	indexPrefix  = "idx:"
+)
+
+// maxKeyIDLength bounds the key ids, so KeyIDBytes finds them in the first
+// bytes of a value.
+const maxKeyIDLength = 32
+
+// Key is a server encryption key.
+type Key struct {
+	ID     string
+	Secret []byte
+}
+
+// GenerateKey returns a new random encryption key.
+func GenerateKey() (Key, error) {
+	secret := make([]byte, 32)
+	if _, err := rand.Read(secret); err != nil {
+		return Key{}, fmt.Errorf("failed to generate encryption key: %w", err)
+	}
+	id := make([]byte, 6)
+	if _, err := rand.Read(id); err != nil {
+		return Key{}, fmt.Errorf("failed to generate encryption key id: %w", err)
+	}
+	return Key{ID: base64.RawURLEncoding.EncodeToString(id), Secret: secret}, nil
+}
+
+// String returns the key in the "id:base64secret" form ParseKeys reads.
+func (k Key) String() string {
+	return k.ID + ":" + base64.StdEncoding.EncodeToString(k.Secret)
+}
+
+// ParseKeys parses a list of "id:base64secret" keys separated by commas or
+// newlines, as found in an environment variable or a key file.
+func ParseKeys(s string) ([]Key, error) {
+	var keys []Key
+	for _, item := range strings.FieldsFunc(s, func(r rune) bool { return r == ',' || r == '\n' }) {
+		item = strings.TrimSpace(item)
+		if item == "" {
+			continue
+		}
+		id, encoded, ok := strings.Cut(item, ":")
+		if !ok || id == "" || strings.ContainsRune(id, ':') {
+			return nil, fmt.Errorf("invalid encryption key %q, expected id:base64secret", item)
+		}
+		if len(id) > maxKeyIDLength {
+			return nil, fmt.Errorf("encryption key id %q must be at most %d characters", id, maxKeyIDLength)
+		}
+		secret, err := base64.StdEncoding.DecodeString(encoded)
+		if err != nil {
+			return nil, fmt.Errorf("invalid encryption key %q: %w", id, err)
+		}
+		if len(secret) != 32 {
+			return nil, fmt.Errorf("encryption key %q must be 32 bytes", id)
+		}
+		keys = append(keys, Key{ID: id, Secret: secret})
+	}
+	if len(keys) == 0 {
+		return nil, errors.New("no encryption key given")
+	}
+	return keys, nil
+}
+
+// keyCiphers are the ciphers derived from a key, one for each use so the
+// secret itself never encrypts nor hashes anything.
+type keyCiphers struct {
+	id       string
+	aead     cipher.AEAD
+	indexKey []byte
+}
+
+func derive(secret []byte, use string) []byte {
+	mac := hmac.New(sha256.New, secret)
+	mac.Write([]byte(use))
+	return mac.Sum(nil)
+}
+
+// Keyring holds the encryption keys. The first key encrypts new values.
+type Keyring struct {
+	keys []keyCiphers
+}
+
+// NewKeyring returns a keyring using keys[0] as the active key.
+func NewKeyring(keys ...Key) (*Keyring, error) {
+	if len(keys) == 0 {
+		return nil, errors.New("a keyring needs at least one key")
+	}
+	k := &Keyring{}
+	for _, key := range keys {
+		block, err := aes.NewCipher(derive(key.Secret, "atrest encryption"))
+		if err != nil {
+			return nil, err
+		}
+		aead, err := cipher.NewGCM(block)
+		if err != nil {
+			return nil, err
+		}
+		k.keys = append(k.keys, keyCiphers{id: key.ID, aead: aead, indexKey: derive(key.Secret, "atrest index")})
+	}
+	return k, nil
+}
+
+// ActiveID returns the id of the key encrypting new values.
+func (k *Keyring) ActiveID() string {
+	return k.keys[0].id
+}
+
+func (k *Keyring) lookup(id string) (keyCiphers, bool) {
+	for _, key := range k.keys {
+		if key.id == id {
+			return key, true
+		}
+	}
+	return keyCiphers{}, false
+}
+
+// SealBytes encrypts data for column with the active key.
+func (k *Keyring) SealBytes(column string, data []byte) ([]byte, error) {
+	key := k.keys[0]
+	nonce := make([]byte, key.aead.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, fmt.Errorf("failed to generate nonce: %w", err)
+	}
+	sealed := []byte(sealedPrefix + key.id + ":")
+	sealed = append(sealed, nonce...)
+	return key.aead.Seal(sealed, nonce, data, []byte(column)), nil
+}
+
+// OpenBytes decrypts data sealed for column.
+func (k *Keyring) OpenBytes(column string, data []byte) ([]byte, error) {
+	id := KeyIDBytes(data)
+	if id == "" || !bytes.HasPrefix(data, []byte(sealedPrefix)) {
+		return nil, ErrMalformed
+	}
+	key, ok := k.lookup(id)
+	if !ok {
+		return nil, fmt.Errorf("%w %q", ErrUnknownKey, id)
+	}
+	sealed := data[len(sealedPrefix)+len(id)+1:]
+	if len(sealed) < key.aead.NonceSize() {
+		return nil, ErrMalformed
+	}
+	plain, err := key.aead.Open(nil, sealed[:key.aead.NonceSize()], sealed[key.aead.NonceSize():], []byte(column))
+	if err != nil {
+		return nil, ErrMalformed
+	}
+	return plain, nil
+}
+
+// Seal encrypts value for column with the active key. The empty string is
+// kept as it is.
+func (k *Keyring) Seal(column string, value string) (string, error) {
+	if value == "" {
+		return "", nil
+	}
+	sealed, err := k.SealBytes(column, []byte(value))
+	if err != nil {
+		return "", err
+	}
+	header := len(sealedPrefix) + len(k.keys[0].id) + 1
+	return string(sealed[:header]) + base64.RawStdEncoding.EncodeToString(sealed[header:]), nil
+}
+
+// Open decrypts a value sealed for column. The empty string is kept as it
+// is, like Seal does.
+func (k *Keyring) Open(column string, value string) (string, error) {
+	if value == "" {
+		return "", nil
+	}
+	id := KeyID(value)
+	if id == "" || !strings.HasPrefix(value, sealedPrefix) {
+		return "", ErrMalformed
+	}
+	header := len(sealedPrefix) + len(id) + 1
+	sealed, err := base64.RawStdEncoding.DecodeString(value[header:])
+	if err != nil {
+		return "", ErrMalformed
+	}
+	plain, err := k.OpenBytes(column, append([]byte(value[:header]), sealed...))
+	return string(plain), err
+}
+
+func index(key keyCiphers, column string, value string) string {
+	mac := hmac.New(sha256.New, key.indexKey)
+	mac.Write([]byte(column))
+	mac.Write([]byte{0})
+	mac.Write([]byte(value))
+	return indexPrefix + key.id + ":" + base64.RawStdEncoding.EncodeToString(mac.Sum(nil))
+}
+
+// Index returns the blind index of value in column with the active key.
+func (k *Keyring) Index(column string, value string) string {
+	return index(k.keys[0], column, value)
+}
+
+// Indexes returns the blind indexes of value in column with every key, the
+// active one first, to look up rows not yet re-encrypted after a rotation.
+func (k *Keyring) Indexes(column string, value string) []string {
+	indexes := make([]string, len(k.keys))
+	for i, key := range k.keys {
+		indexes[i] = index(key, column, value)
+	}
+	return indexes
+}
+
+// KeyID returns the id of the key value is encrypted or indexed with, "" when
+// it is not shaped like an encrypted value. Only the values of an encrypted
+// column are known to be encrypted.
+func KeyID(value string) string {
+	rest, ok := strings.CutPrefix(value, sealedPrefix)
+	if !ok {
+		if rest, ok = strings.CutPrefix(value, indexPrefix); !ok {
+			return ""
+		}
+	}
+	id, _, ok := strings.Cut(rest, ":")
+	if !ok || len(id) > maxKeyIDLength {
+		return ""
+	}
+	return id
+}
+
+// KeyIDBytes is KeyID for binary values.
+func KeyIDBytes(data []byte) string {
+	return KeyID(string(data[:min(len(data), len(sealedPrefix)+maxKeyIDLength+1)]))
+}
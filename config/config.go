@@ -0,0 +1,442 @@
+// Package config loads the settings of the server from, by increasing
+// precedence: the defaults, a JSON configuration file, the ENIGMA_*
+// environment variables and the command line flags.
+package config
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"net"
+	"net/url"
+	"os"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/adrienchanove/alpha-enigma-api/app"
+	"github.com/adrienchanove/alpha-enigma-api/atrest"
+	"github.com/adrienchanove/alpha-enigma-api/database"
+	"github.com/adrienchanove/alpha-enigma-api/jwt"
+	"github.com/adrienchanove/alpha-enigma-api/ratelimit"
+	"github.com/adrienchanove/alpha-enigma-api/routes"
+)
+
+// LogLevels are the valid log levels: debug logs the requests and runs gin in
+// debug mode, info logs the requests, warn and error do not.
+var LogLevels = []string{"debug", "info", "warn", "error"}
+
+// Duration is a time.Duration written as "90m" in the configuration file.
+type Duration time.Duration
+
+func (d *Duration) UnmarshalJSON(data []byte) error {
+	var s string
+	if err := json.Unmarshal(data, &s); err != nil {
+		return fmt.Errorf("invalid duration %s, expected a string such as \"90m\"", data)
+	}
+	parsed, err := time.ParseDuration(s)
+	if err != nil {
+		return err
+	}
+	*d = Duration(parsed)
+	return nil
+}
+
+// Config holds the settings of the server. The keys of the configuration
+// file are the json names of the fields.
+type Config struct {
+	// Listen is the address the server listens on, as host:port.
+	Listen string `json:"listen"`
+	// TLSCert and TLSKey serve the API over TLS, TLSSelfSigned with a
+	// generated certificate instead. TLSClientCA enables the client
+	// certificates, see app.TLSConfig.
+	TLSCert       string `json:"tlsCert"`
+	TLSKey        string `json:"tlsKey"`
+	TLSSelfSigned bool   `json:"tlsSelfSigned"`
+	TLSClientCA   string `json:"tlsClientCA"`
+	// ShutdownTimeout is how long the requests in flight are waited for on
+	// SIGINT or SIGTERM.
+	ShutdownTimeout Duration `json:"shutdownTimeout"`
+	// Database is the path of the SQLite database, or a "postgres://" URL.
+	Database string `json:"database"`
+	// SQLitePragmas replace database.DefaultPragmas when not nil.
+	SQLitePragmas []string `json:"sqlitePragmas"`
+
+	TokenLifetime       Duration `json:"tokenLifetime"`
+	SignedTokenLifetime Duration `json:"signedTokenLifetime"`
+	// TokenKeys are the signing keys of the signed tokens, see jwt.ParseKeys.
+	TokenKeys string `json:"tokenKeys"`
+
+	CORSOrigins []string `json:"corsOrigins"`
+	// TrustedProxies are the IPs and CIDR ranges of the reverse proxies
+	// allowed to give the client IP in X-Forwarded-For.
+	TrustedProxies []string `json:"trustedProxies"`
+	Swagger        bool     `json:"swagger"`
+	LogLevel       string   `json:"logLevel"`
+
+	RegistrationPoWBits int      `json:"registrationPowBits"`
+	RateLimits          string   `json:"rateLimits"`
+	DeletedMessages     string   `json:"deletedMessages"`
+	AuditRetention      Duration `json:"auditRetention"`
+	Admins              []string `json:"admins"`
+
+	// EncryptionKeys, or the file EncryptionKeysFile, hold the keys
+	// encrypting the metadata, see atrest.ParseKeys.
+	EncryptionKeys     string `json:"encryptionKeys"`
+	EncryptionKeysFile string `json:"encryptionKeysFile"`
+
+	BackupDir      string   `json:"backupDir"`
+	BackupInterval Duration `json:"backupInterval"`
+	BackupKeep     int      `json:"backupKeep"`
+}
+
+// Default returns the default settings.
+func Default() Config {
+	return Config{
+		Listen:              "localhost:8080",
+		ShutdownTimeout:     Duration(app.DefaultShutdownTimeout),
+		Database:            "./alpha-enigma.db",
+		TokenLifetime:       Duration(routes.DefaultTokenLifetime),
+		SignedTokenLifetime: Duration(routes.DefaultTokenLifetime),
+		Swagger:             true,
+		LogLevel:            "info",
+		AuditRetention:      Duration(app.DefaultAuditRetention),
+		BackupInterval:      Duration(app.DefaultBackupInterval),
+		BackupKeep:          app.DefaultBackupKeep,
+	}
+}
+
+// setting is a setting given by an environment variable, and by a flag too
+// when flag is not empty.
+type setting struct {
+	env   string
+	flag  string
+	usage string
+	// isBool makes the flag a boolean one, "-swagger" meaning "-swagger=true"
+	isBool bool
+	// keepEmpty applies the variable even when it is set to ""
+	keepEmpty bool
+	set       func(c *Config, value string) error
+}
+
+var settings = []setting{
+	{env: "ENIGMA_LISTEN", flag: "listen", usage: "address to listen on, host:port",
+		set: func(c *Config, v string) error { c.Listen = v; return nil }},
+	{env: "ENIGMA_TLS_CERT", flag: "tls-cert", usage: "PEM certificate chain to serve TLS with, reloaded when it changes",
+		set: func(c *Config, v string) error { c.TLSCert = v; return nil }},
+	{env: "ENIGMA_TLS_KEY", flag: "tls-key", usage: "PEM private key of the TLS certificate",
+		set: func(c *Config, v string) error { c.TLSKey = v; return nil }},
+	{env: "ENIGMA_TLS_SELF_SIGNED", flag: "tls-self-signed", usage: "serve TLS with a generated self-signed certificate, for development", isBool: true,
+		set: func(c *Config, v string) (err error) { c.TLSSelfSigned, err = strconv.ParseBool(v); return err }},
+	{env: "ENIGMA_TLS_CLIENT_CA", flag: "tls-client-ca", usage: "PEM certificates of the authorities issuing the client certificates users may authenticate with",
+		set: func(c *Config, v string) error { c.TLSClientCA = v; return nil }},
+	{env: "ENIGMA_SHUTDOWN_TIMEOUT", flag: "shutdown-timeout", usage: "how long to wait for the requests in flight on SIGINT or SIGTERM, such as 5s",
+		set: func(c *Config, v string) error { return setDuration(&c.ShutdownTimeout, v) }},
+	{env: "ENIGMA_DATABASE", flag: "database", usage: `SQLite database file, or "postgres://" URL`,
+		set: func(c *Config, v string) error { c.Database = v; return nil }},
+	{env: "ENIGMA_SQLITE_PRAGMAS", keepEmpty: true,
+		set: func(c *Config, v string) error { c.SQLitePragmas = split(v, ";"); return nil }},
+	{env: "ENIGMA_TOKEN_LIFETIME", flag: "token-lifetime", usage: "lifetime of the tokens, such as 1h",
+		set: func(c *Config, v string) error { return setDuration(&c.TokenLifetime, v) }},
+	{env: "ENIGMA_SIGNED_TOKEN_LIFETIME", flag: "signed-token-lifetime", usage: "lifetime of the signed tokens, such as 15m",
+		set: func(c *Config, v string) error { return setDuration(&c.SignedTokenLifetime, v) }},
+	{env: "ENIGMA_TOKEN_KEYS",
+		set: func(c *Config, v string) error { c.TokenKeys = v; return nil }},
+	{env: "ENIGMA_CORS_ORIGINS", flag: "cors-origins", usage: `comma separated origins allowed to call the API from a browser, "*" for any`,
+		set: func(c *Config, v string) error { c.CORSOrigins = split(v, ","); return nil }},
+	{env: "ENIGMA_TRUSTED_PROXIES", flag: "trusted-proxies", usage: "comma separated IPs and CIDR ranges of the reverse proxies allowed to give the client IP in X-Forwarded-For",
+		set: func(c *Config, v string) error { c.TrustedProxies = split(v, ","); return nil }},
+	{env: "ENIGMA_SWAGGER", flag: "swagger", usage: "serve the API documentation at /doc", isBool: true,
+		set: func(c *Config, v string) (err error) { c.Swagger, err = strconv.ParseBool(v); return err }},
+	{env: "ENIGMA_LOG_LEVEL", flag: "log-level", usage: "log level: " + strings.Join(LogLevels, ", "),
+		set: func(c *Config, v string) error { c.LogLevel = v; return nil }},
+	{env: "ENIGMA_REGISTRATION_POW_BITS",
+		set: func(c *Config, v string) (err error) { c.RegistrationPoWBits, err = strconv.Atoi(v); return err }},
+	{env: "ENIGMA_RATE_LIMITS",
+		set: func(c *Config, v string) error { c.RateLimits = v; return nil }},
+	{env: "ENIGMA_DELETED_MESSAGES",
+		set: func(c *Config, v string) error { c.DeletedMessages = v; return nil }},
+	{env: "ENIGMA_AUDIT_RETENTION",
+		set: func(c *Config, v string) error { return setDuration(&c.AuditRetention, v) }},
+	{env: "ENIGMA_ADMINS",
+		set: func(c *Config, v string) error { c.Admins = split(v, ","); return nil }},
+	{env: "ENIGMA_ENCRYPTION_KEYS",
+		set: func(c *Config, v string) error { c.EncryptionKeys = v; return nil }},
+	{env: "ENIGMA_ENCRYPTION_KEYS_FILE",
+		set: func(c *Config, v string) error { c.EncryptionKeysFile = v; return nil }},
+	{env: "ENIGMA_BACKUP_DIR",
+		set: func(c *Config, v string) error { c.BackupDir = v; return nil }},
+	{env: "ENIGMA_BACKUP_INTERVAL",
+		set: func(c *Config, v string) error { return setDuration(&c.BackupInterval, v) }},
+	{env: "ENIGMA_BACKUP_KEEP",
+		set: func(c *Config, v string) (err error) { c.BackupKeep, err = strconv.Atoi(v); return err }},
+}
+
+// split returns the trimmed, non empty items of the sep separated list s.
+func split(s string, sep string) []string {
+	items := []string{}
+	for _, item := range strings.Split(s, sep) {
+		if item = strings.TrimSpace(item); item != "" {
+			items = append(items, item)
+		}
+	}
+	return items
+}
+
+func setDuration(d *Duration, value string) error {
+	parsed, err := time.ParseDuration(value)
+	*d = Duration(parsed)
+	return err
+}
+
+// flagValue is a setting given on the command line.
+type flagValue struct {
+	setting setting
+	value   string
+}
+
+// Flags are the command line flags of the settings.
+type Flags struct {
+	file   string
+	values []flagValue
+}
+
+// AddFlags defines the flags of the settings in fs, and -config, the
+// configuration file, which ENIGMA_CONFIG names otherwise.
+func AddFlags(fs *flag.FlagSet) *Flags {
+	f := &Flags{}
+	fs.StringVar(&f.file, "config", "", "JSON configuration file, ENIGMA_CONFIG by default")
+	for _, s := range settings {
+		if s.flag == "" {
+			continue
+		}
+		record := func(value string) error {
+			// applied by Load, after the file and the environment
+			f.values = append(f.values, flagValue{s, value})
+			return nil
+		}
+		if s.isBool {
+			fs.BoolFunc(s.flag, s.usage+", "+s.env+" otherwise", record)
+		} else {
+			fs.Func(s.flag, s.usage+", "+s.env+" otherwise", record)
+		}
+	}
+	return f
+}
+
+// Load returns the settings, once the flags are parsed, and an error listing
+// every invalid one.
+func (f *Flags) Load() (Config, error) {
+	c := Default()
+	var errs []error
+
+	file := f.file
+	if file == "" {
+		file = os.Getenv("ENIGMA_CONFIG")
+	}
+	if file != "" {
+		if err := c.readFile(file); err != nil {
+			return Config{}, err
+		}
+	}
+
+	for _, s := range settings {
+		if value, ok := os.LookupEnv(s.env); ok && (value != "" || s.keepEmpty) {
+			if err := s.set(&c, value); err != nil {
+				errs = append(errs, fmt.Errorf("%s: %w", s.env, err))
+			}
+		}
+	}
+	for _, v := range f.values {
+		if err := v.setting.set(&c, v.value); err != nil {
+			errs = append(errs, fmt.Errorf("-%s: %w", v.setting.flag, err))
+		}
+	}
+
+	if err := errors.Join(errs...); err != nil {
+		return Config{}, err
+	}
+	return c, c.Validate()
+}
+
+// readFile reads the settings of the configuration file path over c.
+func (c *Config) readFile(path string) error {
+	content, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	decoder := json.NewDecoder(bytes.NewReader(content))
+	decoder.DisallowUnknownFields()
+	if err := decoder.Decode(c); err != nil {
+		var syntaxErr *json.SyntaxError
+		if errors.As(err, &syntaxErr) {
+			line := bytes.Count(content[:syntaxErr.Offset], []byte("\n")) + 1
+			return fmt.Errorf("%s:%d: %w", path, line, err)
+		}
+		return fmt.Errorf("%s: %w", path, err)
+	}
+	return nil
+}
+
+// Validate returns an error listing the invalid settings of c. The settings
+// the app checks itself, such as the deleted messages policy, are left to it.
+func (c Config) Validate() error {
+	var errs []error
+	invalid := func(name string, format string, args ...any) {
+		errs = append(errs, fmt.Errorf(name+": "+format, args...))
+	}
+
+	if _, port, err := net.SplitHostPort(c.Listen); err != nil {
+		invalid("listen", "%v", err)
+	} else if n, err := strconv.Atoi(port); err != nil || n < 0 || n > 65535 {
+		invalid("listen", "invalid port %q", port)
+	}
+	if (c.TLSCert == "") != (c.TLSKey == "") {
+		invalid("tlsCert", "tlsCert and tlsKey must be set together")
+	}
+	if c.TLSSelfSigned && c.TLSCert != "" {
+		invalid("tlsSelfSigned", "set either tlsSelfSigned or tlsCert and tlsKey")
+	}
+	if c.TLSClientCA != "" && c.TLSCert == "" && !c.TLSSelfSigned {
+		invalid("tlsClientCA", "client certificates require TLS, set tlsCert and tlsKey")
+	}
+	if c.Database == "" {
+		invalid("database", "must not be empty")
+	}
+	for name, d := range map[string]Duration{
+		"shutdownTimeout":     c.ShutdownTimeout,
+		"tokenLifetime":       c.TokenLifetime,
+		"signedTokenLifetime": c.SignedTokenLifetime,
+		"auditRetention":      c.AuditRetention,
+		"backupInterval":      c.BackupInterval,
+	} {
+		if d <= 0 {
+			invalid(name, "must be positive, got %s", time.Duration(d))
+		}
+	}
+	if c.BackupKeep <= 0 {
+		invalid("backupKeep", "must be positive, got %d", c.BackupKeep)
+	}
+	for _, origin := range c.CORSOrigins {
+		if origin == "*" {
+			continue
+		}
+		u, err := url.Parse(origin)
+		// browsers send the origin without a path, not even "/", which would
+		// never match
+		if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" || u.User != nil || u.Path != "" || u.RawQuery != "" || u.Fragment != "" {
+			invalid("corsOrigins", "%q is not an origin such as https://chat.example.com, without a path nor a trailing /", origin)
+		}
+	}
+	for _, proxy := range c.TrustedProxies {
+		if _, _, err := net.ParseCIDR(proxy); err != nil && net.ParseIP(proxy) == nil {
+			invalid("trustedProxies", "%q is neither an IP nor a CIDR range such as 10.0.0.0/8", proxy)
+		}
+	}
+	if !slices.Contains(LogLevels, c.LogLevel) {
+		invalid("logLevel", "%q is not one of %s", c.LogLevel, strings.Join(LogLevels, ", "))
+	}
+	if c.RateLimits != "" {
+		policies, err := ratelimit.ParsePolicies(c.RateLimits)
+		if err == nil {
+			err = routes.CheckRateLimits(policies)
+		}
+		if err != nil {
+			invalid("rateLimits", "%v", err)
+		}
+	}
+	if c.TokenKeys != "" {
+		if _, err := jwt.ParseKeys(c.TokenKeys); err != nil {
+			invalid("tokenKeys", "%v", err)
+		}
+	}
+	if _, err := c.EncryptionKeyring(); err != nil {
+		errs = append(errs, err)
+	}
+	return errors.Join(errs...)
+}
+
+// DatabaseOptions returns the options of the database.
+func (c Config) DatabaseOptions() database.Options {
+	return database.Options{DSN: c.Database, Pragmas: c.SQLitePragmas}
+}
+
+// EncryptionKeyring returns the keys encrypting the metadata, nil when there
+// are none.
+func (c Config) EncryptionKeyring() (*atrest.Keyring, error) {
+	keys := c.EncryptionKeys
+	if c.EncryptionKeysFile != "" {
+		if keys != "" {
+			return nil, errors.New("encryptionKeys and encryptionKeysFile: set only one of them")
+		}
+		content, err := os.ReadFile(c.EncryptionKeysFile)
+		if err != nil {
+			return nil, fmt.Errorf("encryptionKeysFile: %w", err)
+		}
+		keys = string(content)
+	}
+	if keys == "" {
+		return nil, nil
+	}
+
+	parsed, err := atrest.ParseKeys(keys)
+	if err != nil {
+		return nil, fmt.Errorf("encryptionKeys: %w", err)
+	}
+	return atrest.NewKeyring(parsed...)
+}
+
+// App returns the configuration of the app. c must be valid.
+func (c Config) App() (app.Config, error) {
+	config := app.Config{
+		Database: c.DatabaseOptions(),
+		API: routes.Config{
+			DeletedMessagesPolicy: c.DeletedMessages,
+			ProofOfWorkBits:       c.RegistrationPoWBits,
+			TokenLifetime:         time.Duration(c.TokenLifetime),
+			SignedTokenLifetime:   time.Duration(c.SignedTokenLifetime),
+		},
+		Admins:          c.Admins,
+		AuditRetention:  time.Duration(c.AuditRetention),
+		Swagger:         c.Swagger,
+		AccessLog:       c.LogLevel == "debug" || c.LogLevel == "info",
+		CORSOrigins:     c.CORSOrigins,
+		TrustedProxies:  c.TrustedProxies,
+		ShutdownTimeout: time.Duration(c.ShutdownTimeout),
+		TLS: app.TLSConfig{
+			CertFile:     c.TLSCert,
+			KeyFile:      c.TLSKey,
+			SelfSigned:   c.TLSSelfSigned,
+			ClientCAFile: c.TLSClientCA,
+		},
+	}
+	if c.BackupDir != "" {
+		config.Backups = app.BackupConfig{Dir: c.BackupDir, Interval: time.Duration(c.BackupInterval), Keep: c.BackupKeep}
+	}
+
+	var err error
+	if c.RateLimits != "" {
+		if config.API.RateLimits, err = ratelimit.ParsePolicies(c.RateLimits); err != nil {
+			return app.Config{}, err
+		}
+	}
+	// The signing keys are shared by every instance; to rotate, prepend a new
+	// key and drop the old one once its tokens have expired. Without them the
+	// app generates and rotates its own key.
+	if c.TokenKeys != "" {
+		keys, err := jwt.ParseKeys(c.TokenKeys)
+		if err != nil {
+			return app.Config{}, err
+		}
+		if config.API.SigningKeys, err = jwt.NewKeyring(keys...); err != nil {
+			return app.Config{}, err
+		}
+	}
+	if config.EncryptionKeys, err = c.EncryptionKeyring(); err != nil {
+		return app.Config{}, err
+	}
+	return config, nil
+}
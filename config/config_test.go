@@ -0,0 +1,166 @@
+package config
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// load parses args as the command line and returns the settings.
+func load(t *testing.T, args ...string) (Config, error) {
+	t.Helper()
+	fs := flag.NewFlagSet("test", flag.ContinueOnError)
+	flags := AddFlags(fs)
+	if err := fs.Parse(args); err != nil {
+		t.Fatal(err)
+	}
+	return flags.Load()
+}
+
+func TestTrustedProxies(t *testing.T) {
+	c, err := load(t, "-trusted-proxies", "10.0.0.0/8, 192.0.2.1,::1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := strings.Join(c.TrustedProxies, " "); got != "10.0.0.0/8 192.0.2.1 ::1" {
+		t.Errorf("trusted proxies %q", got)
+	}
+
+	t.Setenv("ENIGMA_TRUSTED_PROXIES", "10.0.0.0/33,proxy.local")
+	_, err = load(t)
+	if err == nil {
+		t.Fatal("invalid trusted proxies accepted")
+	}
+	for _, proxy := range []string{"10.0.0.0/33", "proxy.local"} {
+		if !strings.Contains(err.Error(), proxy) {
+			t.Errorf("error %q does not name %s", err, proxy)
+		}
+	}
+}
+
+func TestRateLimits(t *testing.T) {
+	t.Setenv("ENIGMA_RATE_LIMITS", "messages=30/1m,api=1200/1m")
+	if _, err := load(t); err != nil {
+		t.Fatal(err)
+	}
+	for _, invalid := range []string{"unknown=1/1m", "messages=0/1m", "messages=1/0s"} {
+		t.Setenv("ENIGMA_RATE_LIMITS", invalid)
+		if _, err := load(t); err == nil || !strings.Contains(err.Error(), "rateLimits") {
+			t.Errorf("%q: %v, want a rateLimits error", invalid, err)
+		}
+	}
+}
+
+// writeFile writes the configuration file content and returns its path.
+func writeFile(t *testing.T, content string) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "config.json")
+	if err := os.WriteFile(path, []byte(content), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestPrecedence(t *testing.T) {
+	if c, err := load(t); err != nil || c.Listen != Default().Listen {
+		t.Errorf("listen %q, %v without settings, want the default %q", c.Listen, err, Default().Listen)
+	}
+
+	// the flags win over the environment, which wins over the file
+	t.Setenv("ENIGMA_CONFIG", writeFile(t, `{"listen": ":8001", "database": "file.db", "swagger": false}`))
+	c, err := load(t)
+	if err != nil || c.Listen != ":8001" || c.Database != "file.db" || c.Swagger {
+		t.Errorf("file: listen %q, database %q, swagger %v, %v", c.Listen, c.Database, c.Swagger, err)
+	}
+	t.Setenv("ENIGMA_LISTEN", ":8002")
+	c, err = load(t)
+	if err != nil || c.Listen != ":8002" || c.Database != "file.db" {
+		t.Errorf("environment: listen %q, database %q, %v", c.Listen, c.Database, err)
+	}
+	c, err = load(t, "-listen", ":8003", "-swagger")
+	if err != nil || c.Listen != ":8003" || c.Database != "file.db" || !c.Swagger {
+		t.Errorf("flags: listen %q, database %q, swagger %v, %v", c.Listen, c.Database, c.Swagger, err)
+	}
+
+	// -config wins over ENIGMA_CONFIG
+	c, err = load(t, "-config", writeFile(t, `{"database": "flag.db"}`))
+	if err != nil || c.Database != "flag.db" {
+		t.Errorf("-config: database %q, %v", c.Database, err)
+	}
+}
+
+func TestDurations(t *testing.T) {
+	t.Setenv("ENIGMA_CONFIG", writeFile(t, `{"auditRetention": "720h", "backupInterval": "90m"}`))
+	t.Setenv("ENIGMA_SHUTDOWN_TIMEOUT", "2500ms")
+	c, err := load(t, "-token-lifetime", "1h30m")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for name, d := range map[string][2]Duration{
+		"auditRetention":  {c.AuditRetention, Duration(720 * time.Hour)},
+		"backupInterval":  {c.BackupInterval, Duration(90 * time.Minute)},
+		"shutdownTimeout": {c.ShutdownTimeout, Duration(2500 * time.Millisecond)},
+		"tokenLifetime":   {c.TokenLifetime, Duration(90 * time.Minute)},
+	} {
+		if d[0] != d[1] {
+			t.Errorf("%s %s, want %s", name, time.Duration(d[0]), time.Duration(d[1]))
+		}
+	}
+
+	for _, test := range []struct {
+		args []string
+		file string
+		want string
+	}{
+		{args: []string{"-token-lifetime", "1x"}, want: "-token-lifetime"},
+		{args: []string{"-token-lifetime", "90"}, want: "-token-lifetime"},
+		{args: []string{"-token-lifetime", "0s"}, want: "tokenLifetime"},
+		{args: []string{"-shutdown-timeout", "-1s"}, want: "shutdownTimeout"},
+		{file: `{"auditRetention": 3600}`, want: "invalid duration"},
+		{file: `{"auditRetention": "1 day"}`, want: "1 day"},
+	} {
+		if test.file != "" {
+			t.Setenv("ENIGMA_CONFIG", writeFile(t, test.file))
+		}
+		if _, err := load(t, test.args...); err == nil || !strings.Contains(err.Error(), test.want) {
+			t.Errorf("%v %s: %v, want an error about %s", test.args, test.file, err, test.want)
+		}
+	}
+}
+
+func TestLogLevel(t *testing.T) {
+	for _, level := range LogLevels {
+		if c, err := load(t, "-log-level", level); err != nil || c.LogLevel != level {
+			t.Errorf("log level %q: %q, %v", level, c.LogLevel, err)
+		}
+	}
+	for _, level := range []string{"verbose", "INFO", ""} {
+		t.Setenv("ENIGMA_CONFIG", writeFile(t, fmt.Sprintf(`{"logLevel": %q}`, level)))
+		if _, err := load(t); err == nil || !strings.Contains(err.Error(), "logLevel") {
+			t.Errorf("log level %q: %v, want a logLevel error", level, err)
+		}
+	}
+}
+
+func TestCORSOrigins(t *testing.T) {
+	c, err := load(t, "-cors-origins", "https://chat.example.com, http://localhost:3000,*")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := strings.Join(c.CORSOrigins, " "); got != "https://chat.example.com http://localhost:3000 *" {
+		t.Errorf("CORS origins %q", got)
+	}
+
+	for _, origin := range []string{
+		"https://chat.example.com/", "https://chat.example.com/app", "https://chat.example.com?x=1", "https://chat.example.com#x",
+		"https://user@chat.example.com", "ftp://chat.example.com", "chat.example.com", "https://",
+	} {
+		if _, err := load(t, "-cors-origins", origin); err == nil || !strings.Contains(err.Error(), "corsOrigins") {
+			t.Errorf("%q: %v, want a corsOrigins error", origin, err)
+		}
+	}
+}
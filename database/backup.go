@@ -0,0 +1,226 @@
+package database
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/mattn/go-sqlite3"
+)
+
+// ErrBackupUnsupported is returned when backing up or restoring a PostgreSQL
+// database, which pg_dump and pg_restore do.
+var ErrBackupUnsupported = errors.New("backups are only supported by SQLite, use pg_dump for PostgreSQL")
+
+// ErrDatabaseInUse is returned by Restore when another connection, such as
+// the one of a running server, has the database open.
+var ErrDatabaseInUse = errors.New("the database is in use, stop the server before restoring")
+
+// Backup copies the SQLite database db into a new file at path with the
+// online backup API: the copy is consistent even while the server writes.
+func Backup(ctx context.Context, db *sql.DB, dialect Dialect, path string) error {
+	if dialect != SQLite {
+		return ErrBackupUnsupported
+	}
+	if _, err := os.Stat(path); err == nil {
+		return fmt.Errorf("%s already exists", path)
+	}
+
+	// the backup is written next to path, then renamed, so path is either
+	// missing or complete
+	tmp := path + ".tmp"
+	defer os.Remove(tmp)
+	dest, err := openSQLiteFile(tmp)
+	if err != nil {
+		return err
+	}
+	conn, err := db.Conn(ctx)
+	if err != nil {
+		dest.Close()
+		return err
+	}
+	err = copyDatabase(conn, dest, false)
+	conn.Close()
+	if err == nil {
+		// the copy keeps the journal mode of db, a backup is better a single file
+		_, err = dest.Exec("PRAGMA journal_mode = DELETE", nil)
+	}
+	if closeErr := dest.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return err
+	}
+	return os.Rename(tmp, path)
+}
+
+// Restore replaces the content of the SQLite database db with the backup at
+// path, once the backup passed CheckIntegrity. The server must be stopped:
+// the database is locked exclusively during the copy, and ErrDatabaseInUse
+// returned when another connection holds it open.
+func Restore(ctx context.Context, db *sql.DB, dialect Dialect, path string) error {
+	if dialect != SQLite {
+		return ErrBackupUnsupported
+	}
+	if _, err := os.Stat(path); err != nil {
+		return err
+	}
+
+	backup := sql.OpenDB(newSQLiteConnector(readOnly(path), nil))
+	defer backup.Close()
+	if err := CheckIntegrity(ctx, backup, SQLite); err != nil {
+		return fmt.Errorf("backup %s: %w", path, err)
+	}
+	version, err := SchemaVersion(backup, SQLite)
+	if err != nil {
+		return err
+	}
+	migrations, err := Migrations(SQLite)
+	if err != nil {
+		return err
+	}
+	if latest := migrations[len(migrations)-1].Version; version > latest {
+		return fmt.Errorf("backup %s has schema version %d, newer than this server's %d", path, version, latest)
+	}
+
+	src, err := openSQLiteFile(readOnly(path))
+	if err != nil {
+		return err
+	}
+	defer src.Close()
+	conn, err := db.Conn(ctx)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	busyTimeout, err := lockDatabase(ctx, conn)
+	if err != nil {
+		return err
+	}
+	err = copyDatabase(conn, src, true)
+	if unlockErr := unlockDatabase(ctx, conn, busyTimeout); err == nil {
+		err = unlockErr
+	}
+	if err != nil {
+		return err
+	}
+	if err := CheckIntegrity(ctx, db, SQLite); err != nil {
+		return err
+	}
+	checkForeignKeys(db)
+	return nil
+}
+
+// CheckIntegrity checks the structure of the SQLite database db: its pages,
+// records and indexes.
+func CheckIntegrity(ctx context.Context, db *sql.DB, dialect Dialect) error {
+	if dialect != SQLite {
+		return ErrBackupUnsupported
+	}
+
+	rows, err := db.QueryContext(ctx, "PRAGMA integrity_check(10)")
+	if err != nil {
+		return err
+	}
+	var problems []string
+	for rows.Next() {
+		var problem string
+		if err := rows.Scan(&problem); err != nil {
+			rows.Close()
+			return err
+		}
+		if problem != "ok" {
+			problems = append(problems, problem)
+		}
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return err
+	}
+	if len(problems) > 0 {
+		return fmt.Errorf("integrity check failed: %s", strings.Join(problems, "; "))
+	}
+	return nil
+}
+
+// readOnly returns the DSN opening the SQLite file at path read only.
+func readOnly(path string) string {
+	return "file:" + path + "?mode=ro"
+}
+
+// openSQLiteFile opens a connection to the SQLite file at path, outside of
+// any pool, for the backup API.
+func openSQLiteFile(path string) (*sqlite3.SQLiteConn, error) {
+	conn, err := (&sqlite3.SQLiteDriver{}).Open(path)
+	if err != nil {
+		return nil, err
+	}
+	return conn.(*sqlite3.SQLiteConn), nil
+}
+
+// lockDatabase takes an exclusive lock on the SQLite database of conn, kept
+// until unlockDatabase, and returns the busy timeout of conn to restore. It
+// fails at once with ErrDatabaseInUse when another connection has the
+// database open: in WAL mode, the default, even an idle connection of the
+// pool of a server prevents the lock.
+func lockDatabase(ctx context.Context, conn *sql.Conn) (busyTimeout int, err error) {
+	if err := conn.QueryRowContext(ctx, "PRAGMA busy_timeout").Scan(&busyTimeout); err != nil {
+		return 0, err
+	}
+	for _, pragma := range []string{"busy_timeout = 0", "locking_mode = EXCLUSIVE"} {
+		if _, err := conn.ExecContext(ctx, "PRAGMA "+pragma); err != nil {
+			return 0, err
+		}
+	}
+	// in exclusive locking mode, the lock taken by the transaction is kept
+	// after it
+	if _, err := conn.ExecContext(ctx, "BEGIN EXCLUSIVE"); err != nil {
+		var sqliteErr sqlite3.Error
+		if errors.As(err, &sqliteErr) && sqliteErr.Code == sqlite3.ErrBusy {
+			err = ErrDatabaseInUse
+		}
+		unlockDatabase(ctx, conn, busyTimeout)
+		return 0, err
+	}
+	if _, err := conn.ExecContext(ctx, "COMMIT"); err != nil {
+		unlockDatabase(ctx, conn, busyTimeout)
+		return 0, err
+	}
+	return busyTimeout, nil
+}
+
+// unlockDatabase releases the lock of lockDatabase and restores the busy
+// timeout of conn.
+func unlockDatabase(ctx context.Context, conn *sql.Conn, busyTimeout int) error {
+	// the lock is released by the next access to the database
+	for _, query := range []string{"PRAGMA locking_mode = NORMAL", "SELECT COUNT(*) FROM sqlite_master", "PRAGMA busy_timeout = " + strconv.Itoa(busyTimeout)} {
+		if _, err := conn.ExecContext(ctx, query); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// copyDatabase copies the database of conn into other, or other into it
+// when restore is set, in a single step so the copy is a snapshot.
+func copyDatabase(conn *sql.Conn, other *sqlite3.SQLiteConn, restore bool) error {
+	return conn.Raw(func(driverConn any) error {
+		dest, src := other, driverConn.(*sqlite3.SQLiteConn)
+		if restore {
+			dest, src = src, dest
+		}
+		backup, err := dest.Backup("main", src, "main")
+		if err != nil {
+			return err
+		}
+		if _, err := backup.Step(-1); err != nil {
+			backup.Finish()
+			return err
+		}
+		return backup.Finish()
+	})
+}
@@ -0,0 +1,186 @@
+package database
+
+import (
+	"bytes"
+	"context"
+	"database/sql"
+	"errors"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+// newBackupTestDB returns a migrated SQLite database in a temporary
+// directory with users accounts.
+func newBackupTestDB(t *testing.T, users int) *sql.DB {
+	t.Helper()
+	db, err := InitDB(Options{DSN: filepath.Join(t.TempDir(), "test.db")})
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { db.Close() })
+	for i := 0; i < users; i++ {
+		name := "user" + strconv.Itoa(i) + strings.Repeat("x", 50)
+		if _, err := db.Exec("INSERT INTO users (username, username_key, username_skeleton, public_key) VALUES (?, ?, ?, ?)", name, name, name, strings.Repeat("k", 400)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return db
+}
+
+func countUsers(t *testing.T, db *sql.DB) int {
+	t.Helper()
+	var count int
+	if err := db.QueryRow("SELECT COUNT(*) FROM users").Scan(&count); err != nil {
+		t.Fatal(err)
+	}
+	return count
+}
+
+func TestBackup(t *testing.T) {
+	ctx := context.Background()
+	db := newBackupTestDB(t, 3)
+	path := filepath.Join(t.TempDir(), "backup.db")
+
+	// a failed backup leaves neither the backup nor its temporary file
+	cancelled, cancel := context.WithCancel(ctx)
+	cancel()
+	if err := Backup(cancelled, db, SQLite, path); err == nil {
+		t.Fatal("backup with a cancelled context succeeded")
+	}
+	for _, name := range []string{path, path + ".tmp"} {
+		if _, err := os.Stat(name); !errors.Is(err, os.ErrNotExist) {
+			t.Errorf("%s left by a failed backup: %v", name, err)
+		}
+	}
+
+	if err := Backup(ctx, db, SQLite, path); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(path + ".tmp"); !errors.Is(err, os.ErrNotExist) {
+		t.Errorf("temporary file left: %v", err)
+	}
+	backup := sql.OpenDB(newSQLiteConnector(readOnly(path), nil))
+	defer backup.Close()
+	if count := countUsers(t, backup); count != 3 {
+		t.Errorf("%d users in the backup, want 3", count)
+	}
+	var journalMode string
+	if err := backup.QueryRow("PRAGMA journal_mode").Scan(&journalMode); err != nil || journalMode != "delete" {
+		t.Errorf("journal mode of the backup %s, %v, want delete", journalMode, err)
+	}
+
+	// an existing file is not overwritten
+	if err := Backup(ctx, db, SQLite, path); err == nil {
+		t.Error("backup over an existing file succeeded")
+	}
+}
+
+func TestRestore(t *testing.T) {
+	ctx := context.Background()
+	db := newBackupTestDB(t, 200)
+	path := filepath.Join(t.TempDir(), "backup.db")
+	if err := Backup(ctx, db, SQLite, path); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := db.Exec("DELETE FROM users WHERE id > 100"); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := Restore(ctx, db, SQLite, path); err != nil {
+		t.Fatal(err)
+	}
+	if count := countUsers(t, db); count != 200 {
+		t.Errorf("%d users restored, want 200", count)
+	}
+	// the lock is released and the busy timeout restored
+	if _, err := db.Exec("DELETE FROM users WHERE id > 100"); err != nil {
+		t.Fatal(err)
+	}
+	var busyTimeout int
+	if err := db.QueryRow("PRAGMA busy_timeout").Scan(&busyTimeout); err != nil || busyTimeout != 5000 {
+		t.Errorf("busy timeout %d, %v, want 5000", busyTimeout, err)
+	}
+
+	content, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, test := range []struct {
+		name    string
+		prepare func(path string) error
+		err     string
+	}{
+		{"corrupt", func(path string) error {
+			// overwrite pages of the users table
+			corrupt := bytes.Clone(content)
+			copy(corrupt[8*4096:10*4096], bytes.Repeat([]byte{0xff}, 2*4096))
+			return os.WriteFile(path, corrupt, 0o600)
+		}, "malformed"},
+		{"newer schema", func(path string) error {
+			if err := os.WriteFile(path, content, 0o600); err != nil {
+				return err
+			}
+			backup, err := Open(Options{DSN: path})
+			if err != nil {
+				return err
+			}
+			defer backup.Close()
+			_, err = backup.Exec("INSERT INTO schema_version (version, name, applied_at) VALUES (1000, 'future', CURRENT_TIMESTAMP)")
+			return err
+		}, "newer than this server's"},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			backup := filepath.Join(t.TempDir(), "backup.db")
+			if err := test.prepare(backup); err != nil {
+				t.Fatal(err)
+			}
+			err := Restore(ctx, db, SQLite, backup)
+			if err == nil || !strings.Contains(err.Error(), test.err) {
+				t.Errorf("restore: %v, want an error containing %q", err, test.err)
+			}
+			if count := countUsers(t, db); count != 100 {
+				t.Errorf("%d users after the failed restore, want 100", count)
+			}
+		})
+	}
+}
+
+func TestRestoreRefusesDatabaseInUse(t *testing.T) {
+	ctx := context.Background()
+	db := newBackupTestDB(t, 1)
+	path := filepath.Join(t.TempDir(), "backup.db")
+	if err := Backup(ctx, db, SQLite, path); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := db.Exec("DELETE FROM users"); err != nil {
+		t.Fatal(err)
+	}
+
+	// db stands for the server, restore opens the database like -restore
+	var dsn string
+	if err := db.QueryRow("SELECT file FROM pragma_database_list WHERE name = 'main'").Scan(&dsn); err != nil {
+		t.Fatal(err)
+	}
+	restore, err := Open(Options{DSN: dsn})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer restore.Close()
+	if err := Restore(ctx, restore, SQLite, path); !errors.Is(err, ErrDatabaseInUse) {
+		t.Errorf("restore while the server runs: %v, want ErrDatabaseInUse", err)
+	}
+	if count := countUsers(t, db); count != 0 {
+		t.Errorf("%d users restored while the server runs", count)
+	}
+
+	db.Close()
+	if err := Restore(ctx, restore, SQLite, path); err != nil {
+		t.Fatal(err)
+	}
+	if count := countUsers(t, restore); count != 1 {
+		t.Errorf("%d users restored, want 1", count)
+	}
+}
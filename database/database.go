@@ -1,54 +1,141 @@
 package database
 
 import (
+	"context"
 	"database/sql"
+	"database/sql/driver"
+	"errors"
+	"fmt"
 	"log"
+	"time"
 
-	_ "github.com/mattn/go-sqlite3"
+	"github.com/lib/pq"
+	"github.com/mattn/go-sqlite3"
 )
 
-var DB *sql.DB
+// Options configures the database connection.
+type Options struct {
+	// DSN is the path of the SQLite database file, or a "postgres://" URL.
+	DSN string
+	// MaxOpenConns and MaxIdleConns size the connection pool, 0 keeps the
+	// database/sql defaults.
+	MaxOpenConns int
+	MaxIdleConns int
+	// ConnMaxLifetime closes the connections older than it, 0 keeps them.
+	ConnMaxLifetime time.Duration
+	// Pragmas are run on every new SQLite connection, such as
+	// "busy_timeout = 5000", DefaultPragmas when nil. PostgreSQL has none.
+	Pragmas []string
+}
 
-func InitDB(filepath string) {
-	var err error
-	DB, err = sql.Open("sqlite3", filepath)
-	if err != nil {
-		log.Fatal(err)
-	}
-
-	createTables()
-}
-
-func createTables() {
-	createUserTable := `
-	CREATE TABLE IF NOT EXISTS users (
-		id INTEGER PRIMARY KEY AUTOINCREMENT,
-		username TEXT UNIQUE,
-		public_key TEXT,
-		current_token TEXT,
-		expiration_time DATETIME,
-		next_token TEXT
-	);
-	`
-
-	createMessageTable := `
-	CREATE TABLE IF NOT EXISTS messages (
-		id INTEGER PRIMARY KEY AUTOINCREMENT,
-		content TEXT,
-		sender_id INTEGER,
-		receiver_id INTEGER,
-		FOREIGN KEY (sender_id) REFERENCES users(id),
-		FOREIGN KEY (receiver_id) REFERENCES users(id)
-	);
-	`
-
-	_, err := DB.Exec(createUserTable)
-	if err != nil {
-		log.Fatal(err)
+// DefaultPragmas are the pragmas of the SQLite connections by default: WAL
+// lets the readers run alongside the writer, busy_timeout waits for a locked
+// database instead of failing, and foreign_keys enforces the FOREIGN KEY
+// clauses, which SQLite ignores otherwise.
+var DefaultPragmas = []string{"journal_mode = WAL", "busy_timeout = 5000", "foreign_keys = ON"}
+
+// Dialect returns the dialect of the database.
+func (o Options) Dialect() Dialect {
+	return DialectOf(o.DSN)
+}
+
+// IsUniqueViolation reports whether err is caused by a UNIQUE constraint.
+func IsUniqueViolation(err error) bool {
+	var sqliteErr sqlite3.Error
+	if errors.As(err, &sqliteErr) {
+		return sqliteErr.ExtendedCode == sqlite3.ErrConstraintUnique
+	}
+	var pqErr *pq.Error
+	return errors.As(err, &pqErr) && pqErr.Code == "23505"
+}
+
+// sqliteConnector opens SQLite connections running the pragmas first.
+type sqliteConnector struct {
+	dsn    string
+	driver *sqlite3.SQLiteDriver
+}
+
+func newSQLiteConnector(dsn string, pragmas []string) sqliteConnector {
+	return sqliteConnector{dsn: dsn, driver: &sqlite3.SQLiteDriver{
+		ConnectHook: func(conn *sqlite3.SQLiteConn) error {
+			for _, pragma := range pragmas {
+				if _, err := conn.Exec("PRAGMA "+pragma, nil); err != nil {
+					return fmt.Errorf("pragma %s: %w", pragma, err)
+				}
+			}
+			return nil
+		},
+	}}
+}
+
+func (c sqliteConnector) Connect(ctx context.Context) (driver.Conn, error) {
+	return c.driver.Open(c.dsn)
+}
+
+func (c sqliteConnector) Driver() driver.Driver {
+	return c.driver
+}
+
+// Open opens the database of opts, without migrating it.
+func Open(opts Options) (*sql.DB, error) {
+	var db *sql.DB
+	switch opts.Dialect() {
+	case Postgres:
+		if len(opts.Pragmas) > 0 {
+			return nil, errors.New("pragmas are only supported by SQLite")
+		}
+		var err error
+		if db, err = sql.Open("postgres", opts.DSN); err != nil {
+			return nil, err
+		}
+	default:
+		pragmas := opts.Pragmas
+		if pragmas == nil {
+			pragmas = DefaultPragmas
+		}
+		db = sql.OpenDB(newSQLiteConnector(opts.DSN, pragmas))
 	}
 
-	_, err = DB.Exec(createMessageTable)
+	db.SetMaxOpenConns(opts.MaxOpenConns)
+	if opts.MaxIdleConns > 0 {
+		// 0 would not keep the default but disable the idle connections
+		db.SetMaxIdleConns(opts.MaxIdleConns)
+	}
+	db.SetConnMaxLifetime(opts.ConnMaxLifetime)
+	if err := db.Ping(); err != nil {
+		db.Close()
+		return nil, err
+	}
+	return db, nil
+}
+
+// InitDB opens the database of opts and applies the pending migrations.
+func InitDB(opts Options) (*sql.DB, error) {
+	db, err := Open(opts)
 	if err != nil {
-		log.Fatal(err)
+		return nil, err
+	}
+
+	if _, err := Migrate(db, opts.Dialect(), false); err != nil {
+		db.Close()
+		return nil, err
+	}
+	if opts.Dialect() == SQLite {
+		checkForeignKeys(db)
+	}
+	return db, nil
+}
+
+// checkForeignKeys logs the rows referencing missing rows, which databases
+// written before the foreign keys were enforced may have. They are kept, but
+// the writes changing their references fail until they are fixed.
+func checkForeignKeys(db *sql.DB) {
+	var violations int
+	if err := db.QueryRow("SELECT COUNT(*) FROM pragma_foreign_key_check").Scan(&violations); err != nil {
+		log.Println(err)
+		return
+	}
+	if violations > 0 {
+		log.Printf("%d rows reference missing rows, PRAGMA foreign_key_check lists them", violations)
 	}
 }
@@ -0,0 +1,69 @@
+package database
+
+import (
+	"context"
+	"database/sql"
+	"path/filepath"
+	"testing"
+)
+
+// pragmas returns the journal mode, busy timeout and foreign keys setting
+// of conn.
+func pragmas(t *testing.T, conn *sql.Conn) (journalMode string, busyTimeout int, foreignKeys bool) {
+	t.Helper()
+	ctx := context.Background()
+	for pragma, dest := range map[string]any{"journal_mode": &journalMode, "busy_timeout": &busyTimeout, "foreign_keys": &foreignKeys} {
+		if err := conn.QueryRowContext(ctx, "PRAGMA "+pragma).Scan(dest); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return journalMode, busyTimeout, foreignKeys
+}
+
+func TestSQLitePragmas(t *testing.T) {
+	ctx := context.Background()
+	for _, test := range []struct {
+		name        string
+		pragmas     []string
+		journalMode string
+		busyTimeout int
+		foreignKeys bool
+	}{
+		{"default", nil, "wal", 5000, true},
+		{"configured", []string{"journal_mode = DELETE", "busy_timeout = 100"}, "delete", 100, false},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			db, err := InitDB(Options{DSN: filepath.Join(t.TempDir(), "test.db"), Pragmas: test.pragmas})
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer db.Close()
+
+			// every connection of the pool runs them
+			var conns []*sql.Conn
+			for i := 0; i < 2; i++ {
+				conn, err := db.Conn(ctx)
+				if err != nil {
+					t.Fatal(err)
+				}
+				defer conn.Close()
+				conns = append(conns, conn)
+			}
+			for i, conn := range conns {
+				journalMode, busyTimeout, foreignKeys := pragmas(t, conn)
+				if journalMode != test.journalMode || busyTimeout != test.busyTimeout || foreignKeys != test.foreignKeys {
+					t.Errorf("connection %d: journal_mode %s, busy_timeout %d, foreign_keys %v, want %s, %d, %v",
+						i, journalMode, busyTimeout, foreignKeys, test.journalMode, test.busyTimeout, test.foreignKeys)
+				}
+			}
+
+			_, err = conns[0].ExecContext(ctx, "INSERT INTO messages (content, sender_id, receiver_id) VALUES ('hello', 1000, 1001)")
+			if test.foreignKeys && err == nil {
+				t.Error("message from a missing user inserted")
+			}
+			if !test.foreignKeys && err != nil {
+				t.Errorf("foreign keys enforced: %v", err)
+			}
+		})
+	}
+}
@@ -0,0 +1,59 @@
+package database
+
+import (
+	"strconv"
+	"strings"
+)
+
+// Dialect is the SQL dialect of a database, which also names the directory of
+// its migrations.
+type Dialect string
+
+const (
+	SQLite   Dialect = "sqlite"
+	Postgres Dialect = "postgres"
+)
+
+// DialectOf returns the dialect of the database dsn: PostgreSQL for a
+// "postgres://" or "postgresql://" URL, SQLite for anything else, which is
+// the path of the database file.
+func DialectOf(dsn string) Dialect {
+	if strings.HasPrefix(dsn, "postgres://") || strings.HasPrefix(dsn, "postgresql://") {
+		return Postgres
+	}
+	return SQLite
+}
+
+// Rebind rewrites the ? placeholders of query, outside of string literals, in
+// the syntax of the dialect: $1, $2… for PostgreSQL.
+func (d Dialect) Rebind(query string) string {
+	if d != Postgres || !strings.Contains(query, "?") {
+		return query
+	}
+
+	var b strings.Builder
+	n := 0
+	quoted := false
+	for _, r := range query {
+		switch {
+		case r == '\'':
+			quoted = !quoted
+		case r == '?' && !quoted:
+			n++
+			b.WriteByte('$')
+			b.WriteString(strconv.Itoa(n))
+			continue
+		}
+		b.WriteRune(r)
+	}
+	return b.String()
+}
+
+// Binary returns expr compared and sorted byte by byte, as SQLite does by
+// default, like the idx_users_username_key_c index of PostgreSQL.
+func (d Dialect) Binary(expr string) string {
+	if d == Postgres {
+		return expr + ` COLLATE "C"`
+	}
+	return expr
+}
@@ -0,0 +1,243 @@
+package database
+
+import (
+	"database/sql"
+	"embed"
+	"fmt"
+	"io/fs"
+	"log"
+	"path"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/adrienchanove/alpha-enigma-api/username"
+)
+
+// Migrations are the files of the migrations directory of each dialect,
+// named "NNNN_name.sql" and applied in order. Both dialects have the same
+// migrations, a schema change adds the next one to each. Never edit an
+// applied migration, add a new one.
+//
+//go:embed migrations/*/*.sql
+var migrationFiles embed.FS
+
+// Migration is an up migration of the schema.
+type Migration struct {
+	Version int
+	Name    string
+	SQL     string
+	// Warnings, set once the migration is applied, describe the data it
+	// could not convert, which needs an administrator.
+	Warnings []string
+	// post runs after SQL, in the same transaction, for the data changes SQL
+	// alone cannot do, and returns the warnings.
+	post postMigration
+}
+
+type postMigration func(tx *sql.Tx, dialect Dialect) ([]string, error)
+
+// postMigrations are run after the SQL of the migration of the same version.
+var postMigrations = map[int]postMigration{
+	8: backfillUsernames,
+}
+
+// Migrations returns the embedded migrations of dialect, in order.
+func Migrations(dialect Dialect) ([]Migration, error) {
+	dir := path.Join("migrations", string(dialect))
+	entries, err := fs.ReadDir(migrationFiles, dir)
+	if err != nil {
+		return nil, err
+	}
+
+	var migrations []Migration
+	for _, entry := range entries {
+		name := strings.TrimSuffix(entry.Name(), ".sql")
+		number, label, ok := strings.Cut(name, "_")
+		version, err := strconv.Atoi(number)
+		if !ok || err != nil || version <= 0 {
+			return nil, fmt.Errorf("invalid migration file name %q, expected NNNN_name.sql", entry.Name())
+		}
+		content, err := migrationFiles.ReadFile(path.Join(dir, entry.Name()))
+		if err != nil {
+			return nil, err
+		}
+		migrations = append(migrations, Migration{Version: version, Name: label, SQL: string(content), post: postMigrations[version]})
+	}
+
+	slices.SortFunc(migrations, func(a, b Migration) int { return a.Version - b.Version })
+	for i, m := range migrations {
+		if m.Version != i+1 {
+			return nil, fmt.Errorf("migration %04d is missing or duplicated", i+1)
+		}
+	}
+	return migrations, nil
+}
+
+// SchemaVersion returns the version of the last migration applied to db, 0
+// when none was.
+func SchemaVersion(db *sql.DB, dialect Dialect) (int, error) {
+	query := "SELECT EXISTS(SELECT 1 FROM sqlite_master WHERE type = 'table' AND name = 'schema_version')"
+	if dialect == Postgres {
+		query = "SELECT to_regclass('schema_version') IS NOT NULL"
+	}
+	var exists bool
+	err := db.QueryRow(query).Scan(&exists)
+	if err != nil || !exists {
+		return 0, err
+	}
+
+	var version int
+	err = db.QueryRow("SELECT COALESCE(MAX(version), 0) FROM schema_version").Scan(&version)
+	return version, err
+}
+
+var createSchemaVersionTable = map[Dialect]string{
+	SQLite: `
+CREATE TABLE IF NOT EXISTS schema_version (
+	version INTEGER PRIMARY KEY,
+	name TEXT NOT NULL,
+	applied_at DATETIME NOT NULL
+);
+`,
+	Postgres: `
+CREATE TABLE IF NOT EXISTS schema_version (
+	version INTEGER PRIMARY KEY,
+	name TEXT NOT NULL,
+	applied_at TIMESTAMPTZ NOT NULL
+);
+`,
+}
+
+// Migrate applies the pending migrations to db, each in its own transaction,
+// and returns them. With dryRun, they are all applied in a single transaction
+// that is rolled back, to check they would succeed and get their warnings.
+func Migrate(db *sql.DB, dialect Dialect, dryRun bool) ([]Migration, error) {
+	migrations, err := Migrations(dialect)
+	if err != nil {
+		return nil, err
+	}
+	current, err := SchemaVersion(db, dialect)
+	if err != nil {
+		return nil, err
+	}
+	if current > len(migrations) {
+		return nil, fmt.Errorf("database schema version %d is newer than this server, which knows %d", current, len(migrations))
+	}
+	pending := migrations[current:]
+	if len(pending) == 0 {
+		return nil, nil
+	}
+
+	if dryRun {
+		tx, err := db.Begin()
+		if err != nil {
+			return nil, err
+		}
+		defer tx.Rollback()
+		for i := range pending {
+			if err := applyMigration(tx, dialect, &pending[i]); err != nil {
+				return nil, err
+			}
+		}
+		return pending, nil
+	}
+
+	for i := range pending {
+		m := &pending[i]
+		tx, err := db.Begin()
+		if err != nil {
+			return nil, err
+		}
+		if err := applyMigration(tx, dialect, m); err != nil {
+			tx.Rollback()
+			return nil, err
+		}
+		if err := tx.Commit(); err != nil {
+			return nil, err
+		}
+		log.Printf("applied migration %04d %s", m.Version, m.Name)
+		for _, warning := range m.Warnings {
+			log.Printf("WARNING: migration %04d %s: %s", m.Version, m.Name, warning)
+		}
+	}
+	return pending, nil
+}
+
+func applyMigration(tx *sql.Tx, dialect Dialect, m *Migration) error {
+	if _, err := tx.Exec(createSchemaVersionTable[dialect]); err != nil {
+		return err
+	}
+	if _, err := tx.Exec(m.SQL); err != nil {
+		return fmt.Errorf("migration %04d %s: %w", m.Version, m.Name, err)
+	}
+	if m.post != nil {
+		warnings, err := m.post(tx, dialect)
+		if err != nil {
+			return fmt.Errorf("migration %04d %s: %w", m.Version, m.Name, err)
+		}
+		m.Warnings = warnings
+	}
+	_, err := tx.Exec(dialect.Rebind("INSERT INTO schema_version (version, name, applied_at) VALUES (?, ?, ?)"), m.Version, m.Name, time.Now())
+	return err
+}
+
+// backfillUsernames fills the username key and skeleton of the users created
+// before they existed. A user whose key or skeleton is already taken by an
+// older one keeps it empty, and a warning names both users: without a key it
+// was only reachable by its exact name, and now cannot get a token until an
+// administrator sorts it out; without a skeleton it is not protected from
+// look-alikes.
+//
+// It calls the live username.Key and username.Skeleton rather than a frozen
+// copy: the stored forms must be those the server computes when it looks a
+// user up. Changing either algorithm therefore needs a new migration
+// recomputing the stored forms, TestKeyAndSkeletonAreStable in the username
+// package fails until it is updated.
+func backfillUsernames(tx *sql.Tx, dialect Dialect) ([]string, error) {
+	rows, err := tx.Query("SELECT id, username FROM users WHERE username IS NOT NULL ORDER BY id")
+	if err != nil {
+		return nil, err
+	}
+	type user struct {
+		id   int
+		name string
+	}
+	var users []user
+	for rows.Next() {
+		var u user
+		if err := rows.Scan(&u.id, &u.name); err != nil {
+			rows.Close()
+			return nil, err
+		}
+		users = append(users, u)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	var warnings []string
+	keys := make(map[string]user)
+	skeletons := make(map[string]user)
+	for _, u := range users {
+		var key, skeleton any
+		if older, taken := keys[username.Key(u.name)]; taken {
+			warnings = append(warnings, fmt.Sprintf("user %d %q has the username of user %d %q ignoring case, it cannot get a token until an administrator changes one of the usernames in the database or deletes one of the accounts", u.id, u.name, older.id, older.name))
+		} else {
+			keys[username.Key(u.name)] = u
+			key = username.Key(u.name)
+		}
+		if older, taken := skeletons[username.Skeleton(u.name)]; taken {
+			warnings = append(warnings, fmt.Sprintf("user %d %q looks like user %d %q, its username is not protected from look-alikes", u.id, u.name, older.id, older.name))
+		} else {
+			skeletons[username.Skeleton(u.name)] = u
+			skeleton = username.Skeleton(u.name)
+		}
+		if _, err := tx.Exec(dialect.Rebind("UPDATE users SET username_key = ?, username_skeleton = ? WHERE id = ?"), key, skeleton, u.id); err != nil {
+			return nil, err
+		}
+	}
+	return warnings, nil
+}
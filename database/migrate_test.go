@@ -0,0 +1,211 @@
+package database
+
+import (
+	"database/sql"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"testing"
+	"time"
+	_ "time/tzdata"
+
+	"github.com/adrienchanove/alpha-enigma-api/username"
+)
+
+// openBaseline opens a copy of testdata/baseline.db, a database created by
+// the server before migrations existed, with the users alice, Alice, bob and
+// b0b, their legacy tokens and a few messages.
+func openBaseline(t *testing.T) *sql.DB {
+	t.Helper()
+	content, err := os.ReadFile("testdata/baseline.db")
+	if err != nil {
+		t.Fatal(err)
+	}
+	path := filepath.Join(t.TempDir(), "baseline.db")
+	if err := os.WriteFile(path, content, 0o600); err != nil {
+		t.Fatal(err)
+	}
+	db, err := Open(Options{DSN: path})
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { db.Close() })
+	return db
+}
+
+func TestMigrateBaseline(t *testing.T) {
+	db := openBaseline(t)
+	migrations, err := Migrations(SQLite)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	wantWarnings := map[int][]string{
+		8: {
+			`user 2 "Alice" has the username of user 1 "alice" ignoring case, it cannot get a token until an administrator changes one of the usernames in the database or deletes one of the accounts`,
+			`user 2 "Alice" looks like user 1 "alice", its username is not protected from look-alikes`,
+			`user 4 "b0b" looks like user 3 "bob", its username is not protected from look-alikes`,
+		},
+	}
+	checkPending := func(pending []Migration) {
+		t.Helper()
+		if len(pending) != len(migrations) {
+			t.Fatalf("%d migrations applied, want %d", len(pending), len(migrations))
+		}
+		for _, m := range pending {
+			if !slices.Equal(m.Warnings, wantWarnings[m.Version]) {
+				t.Errorf("migration %04d warnings %q, want %q", m.Version, m.Warnings, wantWarnings[m.Version])
+			}
+		}
+	}
+
+	// the dry run reports the conflicts and leaves the database untouched
+	pending, err := Migrate(db, SQLite, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	checkPending(pending)
+	if version, err := SchemaVersion(db, SQLite); err != nil || version != 0 {
+		t.Fatalf("schema version %d, %v after the dry run, want 0", version, err)
+	}
+
+	pending, err = Migrate(db, SQLite, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	checkPending(pending)
+	version, err := SchemaVersion(db, SQLite)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if version != len(migrations) {
+		t.Errorf("schema version %d, want %d", version, len(migrations))
+	}
+	if pending, err := Migrate(db, SQLite, false); err != nil || len(pending) != 0 {
+		t.Errorf("migrating again applied %d migrations, %v", len(pending), err)
+	}
+
+	// the conflicting users are kept without the key or skeleton taken
+	rows, err := db.Query("SELECT id, username, username_key, username_skeleton FROM users ORDER BY id")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer rows.Close()
+	want := []string{"1 alice alice allce", "2 Alice <nil> <nil>", "3 bob bob bob", "4 b0b b0b <nil>"}
+	var got []string
+	for rows.Next() {
+		var id int
+		var name string
+		var key, skeleton sql.NullString
+		if err := rows.Scan(&id, &name, &key, &skeleton); err != nil {
+			t.Fatal(err)
+		}
+		got = append(got, fmt.Sprintf("%d %s %s %s", id, name, nullable(key), nullable(skeleton)))
+		// the stored forms are those the server computes at lookup
+		if key.Valid && key.String != username.Key(name) || skeleton.Valid && skeleton.String != username.Skeleton(name) {
+			t.Errorf("user %d %q: key %q and skeleton %q, want %q and %q", id, name, key.String, skeleton.String, username.Key(name), username.Skeleton(name))
+		}
+	}
+	if err := rows.Err(); err != nil {
+		t.Fatal(err)
+	}
+	if !slices.Equal(got, want) {
+		t.Errorf("users %q, want %q", got, want)
+	}
+
+	var messages int
+	if err := db.QueryRow("SELECT COUNT(*) FROM messages").Scan(&messages); err != nil || messages != 3 {
+		t.Errorf("%d messages, %v, want the 3 of the baseline", messages, err)
+	}
+	var legacyTokens int
+	if err := db.QueryRow("SELECT COUNT(current_token) + COUNT(next_token) + COUNT(expiration_time) FROM users").Scan(&legacyTokens); err != nil || legacyTokens != 0 {
+		t.Errorf("%d legacy token values, %v, want them cleared", legacyTokens, err)
+	}
+	var encrypted bool
+	if err := db.QueryRow("SELECT encrypted FROM encryption_state").Scan(&encrypted); err != nil || encrypted {
+		t.Errorf("encrypted %v, %v, want the plaintext baseline not encrypted", encrypted, err)
+	}
+}
+
+func nullable(s sql.NullString) string {
+	if !s.Valid {
+		return "<nil>"
+	}
+	return s.String
+}
+
+func TestMigrateRefusesNewerSchema(t *testing.T) {
+	db := openBaseline(t)
+	if _, err := Migrate(db, SQLite, false); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := db.Exec("INSERT INTO schema_version (version, name, applied_at) VALUES (1000, 'future', CURRENT_TIMESTAMP)"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := Migrate(db, SQLite, false); err == nil {
+		t.Error("migrated a database newer than the server")
+	}
+}
+
+func TestMigrateTimesToUTC(t *testing.T) {
+	db := openBaseline(t)
+	if _, err := Migrate(db, SQLite, false); err != nil {
+		t.Fatal(err)
+	}
+	paris, err := time.LoadLocation("Europe/Paris")
+	if err != nil {
+		t.Fatal(err)
+	}
+	newYork, err := time.LoadLocation("America/New_York")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// times bound in the local zone of the server before migration 0015
+	now := time.Now()
+	revokedBefore := time.Date(2026, 3, 29, 3, 30, 0, 999_500_000, paris)
+	for i, expiresAt := range []time.Time{now.Add(30 * time.Minute).In(newYork), now.Add(-30 * time.Minute).In(paris)} {
+		if _, err := db.Exec("INSERT INTO tokens (token_hash, session_id, user_id, username, issued_at, expires_at) VALUES (?, ?, 1, 'alice', ?, ?)",
+			strconv.Itoa(i), strconv.Itoa(i), expiresAt.Add(-time.Hour), expiresAt); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if _, err := db.Exec("INSERT INTO revoked_user_tokens (user_id, revoked_before, expires_at) VALUES (1, ?, ?)", revokedBefore, now.In(newYork)); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := db.Exec("INSERT INTO audit_events (event, created_at) VALUES ('user.created', ?)", revokedBefore); err != nil {
+		t.Fatal(err)
+	}
+
+	migrations, err := Migrations(SQLite)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := db.Exec(migrations[14].SQL); err != nil {
+		t.Fatal(err)
+	}
+
+	var unexpired int
+	if err := db.QueryRow("SELECT COUNT(*) FROM tokens WHERE expires_at > ?", now.UTC()).Scan(&unexpired); err != nil || unexpired != 1 {
+		t.Errorf("%d unexpired tokens, %v, want 1", unexpired, err)
+	}
+	var createdAt string
+	if err := db.QueryRow("SELECT CAST(created_at AS TEXT) FROM audit_events").Scan(&createdAt); err != nil || createdAt != "2026-03-29 01:30:01.000+00:00" {
+		t.Errorf("audit event created at %s, %v, want %v in UTC", createdAt, err, revokedBefore)
+	}
+	// the audit events are append-only again
+	if _, err := db.Exec("UPDATE audit_events SET details = 'changed'"); err == nil {
+		t.Error("audit event updated")
+	}
+	var stored string
+	var before time.Time
+	if err := db.QueryRow("SELECT CAST(revoked_before AS TEXT), revoked_before FROM revoked_user_tokens").Scan(&stored, &before); err != nil {
+		t.Fatal(err)
+	}
+	// moved a millisecond later, so the revoked tokens stay revoked
+	if stored != "2026-03-29 01:30:01.001+00:00" || before.Before(revokedBefore) || before.Sub(revokedBefore) > 2*time.Millisecond {
+		t.Errorf("revoked before %s, want %v in UTC, up to 2ms later", stored, revokedBefore)
+	}
+}
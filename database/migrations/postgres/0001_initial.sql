@@ -0,0 +1,18 @@
+-- The schema before migrations, kept so the versions match the SQLite ones
+CREATE TABLE users (
+	id INTEGER GENERATED BY DEFAULT AS IDENTITY PRIMARY KEY,
+	username TEXT UNIQUE,
+	public_key TEXT,
+	current_token TEXT,
+	expiration_time TIMESTAMPTZ,
+	next_token TEXT
+);
+
+CREATE TABLE messages (
+	id INTEGER GENERATED BY DEFAULT AS IDENTITY PRIMARY KEY,
+	content TEXT,
+	sender_id INTEGER,
+	receiver_id INTEGER,
+	FOREIGN KEY (sender_id) REFERENCES users(id),
+	FOREIGN KEY (receiver_id) REFERENCES users(id)
+);
@@ -0,0 +1,15 @@
+ALTER TABLE users ADD COLUMN role TEXT NOT NULL DEFAULT 'user';
+ALTER TABLE users ADD COLUMN suspended_at TIMESTAMPTZ;
+
+CREATE TABLE reports (
+	id INTEGER GENERATED BY DEFAULT AS IDENTITY PRIMARY KEY,
+	reporter_id INTEGER,
+	reported_id INTEGER,
+	message_id INTEGER,
+	reason TEXT,
+	created_at TIMESTAMPTZ,
+	resolved_at TIMESTAMPTZ,
+	FOREIGN KEY (reporter_id) REFERENCES users(id),
+	FOREIGN KEY (reported_id) REFERENCES users(id),
+	FOREIGN KEY (message_id) REFERENCES messages(id)
+);
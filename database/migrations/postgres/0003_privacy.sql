@@ -0,0 +1,11 @@
+ALTER TABLE users ADD COLUMN message_policy TEXT NOT NULL DEFAULT 'everyone';
+ALTER TABLE users ADD COLUMN hidden_from_directory BOOLEAN NOT NULL DEFAULT FALSE;
+
+CREATE TABLE blocks (
+	blocker_id INTEGER,
+	blocked_id INTEGER,
+	created_at TIMESTAMPTZ,
+	PRIMARY KEY (blocker_id, blocked_id),
+	FOREIGN KEY (blocker_id) REFERENCES users(id),
+	FOREIGN KEY (blocked_id) REFERENCES users(id)
+);
@@ -0,0 +1,3 @@
+-- Directory listing and search sort and filter on the username, ignoring case,
+-- by byte like SQLite's NOCASE
+CREATE INDEX idx_users_username_nocase ON users ((lower(username) COLLATE "C"));
@@ -0,0 +1,2 @@
+CREATE UNIQUE INDEX idx_users_username_key ON users (username_key);
+CREATE UNIQUE INDEX idx_users_username_skeleton ON users (username_skeleton);
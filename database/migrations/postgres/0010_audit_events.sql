@@ -0,0 +1,21 @@
+-- Audit events are append-only, only the retention pruning deletes them
+CREATE TABLE audit_events (
+	id INTEGER GENERATED BY DEFAULT AS IDENTITY PRIMARY KEY,
+	event TEXT NOT NULL,
+	user_id INTEGER NOT NULL DEFAULT 0,
+	ip TEXT NOT NULL DEFAULT '',
+	user_agent TEXT NOT NULL DEFAULT '',
+	details TEXT NOT NULL DEFAULT '',
+	created_at TIMESTAMPTZ NOT NULL
+);
+CREATE INDEX idx_audit_events_user ON audit_events (user_id, id);
+CREATE INDEX idx_audit_events_created ON audit_events (created_at);
+
+CREATE FUNCTION audit_events_no_update() RETURNS trigger AS $$
+BEGIN
+	RAISE EXCEPTION 'audit events are append-only';
+END;
+$$ LANGUAGE plpgsql;
+
+CREATE TRIGGER audit_events_no_update BEFORE UPDATE ON audit_events
+FOR EACH ROW EXECUTE FUNCTION audit_events_no_update();
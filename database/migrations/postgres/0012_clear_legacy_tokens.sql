@@ -0,0 +1,7 @@
+-- The versions before migrations, whose schema is migration 0001, kept the
+-- tokens of the users in plaintext in the users table: current_token and
+-- next_token, with the expiry of the current one in expiration_time. Tokens
+-- are now kept hashed in the tokens table and nothing reads or writes these
+-- columns: clear the tokens left there, in plaintext in the database and in
+-- its backups.
+UPDATE users SET current_token = NULL, expiration_time = NULL, next_token = NULL;
@@ -0,0 +1,3 @@
+-- The SQLite times are rewritten in UTC, the TIMESTAMPTZ columns of
+-- PostgreSQL already hold instants whatever the zone they were bound in
+SELECT 1;
@@ -0,0 +1,24 @@
+-- Whether the metadata columns, see store.EncryptedColumns, are encrypted.
+-- They are as a whole: once encrypted every value is sealed, so a plaintext
+-- value shaped like a sealed one, such as a bio starting with "enc:", is
+-- never taken for one. The databases encrypted before are told by their
+-- usernames, which cannot hold the colon of the sealed values: run
+-- -reencrypt before this migration on those where the encryption was enabled
+-- but the rows written before were not re-encrypted yet.
+CREATE TABLE encryption_state (
+	encrypted BOOLEAN NOT NULL,
+	-- set by store.Reencrypt, in its transaction, to rewrite the audit events
+	reencrypting BOOLEAN NOT NULL DEFAULT FALSE
+);
+INSERT INTO encryption_state (encrypted) SELECT EXISTS(SELECT 1 FROM users WHERE username LIKE 'enc:%');
+
+-- Audit events are append-only, but for the re-encryption of their values
+CREATE OR REPLACE FUNCTION audit_events_no_update() RETURNS trigger AS $$
+BEGIN
+	IF (SELECT reencrypting FROM encryption_state)
+		AND NEW.id = OLD.id AND NEW.event = OLD.event AND NEW.user_id = OLD.user_id AND NEW.created_at = OLD.created_at THEN
+		RETURN NEW;
+	END IF;
+	RAISE EXCEPTION 'audit events are append-only';
+END;
+$$ LANGUAGE plpgsql;
@@ -0,0 +1,14 @@
+-- The directory is sorted and searched by username key, which holds a blind
+-- index once encrypted: the blind indexes of every prefix of the encrypted
+-- username keys are kept here to search them. Plaintext keys are searched as
+-- a range and have no prefixes here.
+CREATE TABLE username_prefixes (
+	user_id INTEGER NOT NULL REFERENCES users (id),
+	prefix_index TEXT NOT NULL,
+	PRIMARY KEY (prefix_index, user_id)
+);
+CREATE INDEX idx_username_prefixes_user ON username_prefixes (user_id);
+
+-- The keys are compared byte by byte, as SQLite does
+DROP INDEX idx_users_username_nocase;
+CREATE INDEX idx_users_username_key_c ON users (username_key COLLATE "C");
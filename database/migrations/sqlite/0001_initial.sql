@@ -0,0 +1,18 @@
+-- The schema before migrations, databases created then already have it
+CREATE TABLE IF NOT EXISTS users (
+	id INTEGER PRIMARY KEY AUTOINCREMENT,
+	username TEXT UNIQUE,
+	public_key TEXT,
+	current_token TEXT,
+	expiration_time DATETIME,
+	next_token TEXT
+);
+
+CREATE TABLE IF NOT EXISTS messages (
+	id INTEGER PRIMARY KEY AUTOINCREMENT,
+	content TEXT,
+	sender_id INTEGER,
+	receiver_id INTEGER,
+	FOREIGN KEY (sender_id) REFERENCES users(id),
+	FOREIGN KEY (receiver_id) REFERENCES users(id)
+);
@@ -0,0 +1,15 @@
+ALTER TABLE users ADD COLUMN role TEXT NOT NULL DEFAULT 'user';
+ALTER TABLE users ADD COLUMN suspended_at DATETIME;
+
+CREATE TABLE reports (
+	id INTEGER PRIMARY KEY AUTOINCREMENT,
+	reporter_id INTEGER,
+	reported_id INTEGER,
+	message_id INTEGER,
+	reason TEXT,
+	created_at DATETIME,
+	resolved_at DATETIME,
+	FOREIGN KEY (reporter_id) REFERENCES users(id),
+	FOREIGN KEY (reported_id) REFERENCES users(id),
+	FOREIGN KEY (message_id) REFERENCES messages(id)
+);
@@ -0,0 +1,11 @@
+CREATE TABLE contacts (
+	id INTEGER PRIMARY KEY AUTOINCREMENT,
+	requester_id INTEGER,
+	addressee_id INTEGER,
+	status TEXT NOT NULL DEFAULT 'pending',
+	created_at DATETIME,
+	responded_at DATETIME,
+	UNIQUE (requester_id, addressee_id),
+	FOREIGN KEY (requester_id) REFERENCES users(id),
+	FOREIGN KEY (addressee_id) REFERENCES users(id)
+);
@@ -0,0 +1,2 @@
+-- Directory listing and search sort and filter on the username, ignoring case
+CREATE INDEX idx_users_username_nocase ON users (username COLLATE NOCASE);
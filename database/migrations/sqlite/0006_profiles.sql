@@ -0,0 +1,13 @@
+ALTER TABLE users ADD COLUMN display_name TEXT NOT NULL DEFAULT '';
+ALTER TABLE users ADD COLUMN bio TEXT NOT NULL DEFAULT '';
+ALTER TABLE users ADD COLUMN status_text TEXT NOT NULL DEFAULT '';
+ALTER TABLE users ADD COLUMN last_seen_at DATETIME;
+ALTER TABLE users ADD COLUMN show_last_seen BOOLEAN NOT NULL DEFAULT 1;
+
+CREATE TABLE avatars (
+	user_id INTEGER PRIMARY KEY,
+	content_type TEXT,
+	data BLOB,
+	updated_at DATETIME,
+	FOREIGN KEY (user_id) REFERENCES users(id)
+);
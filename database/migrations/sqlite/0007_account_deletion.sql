@@ -0,0 +1,2 @@
+ALTER TABLE users ADD COLUMN deleted_at DATETIME;
+ALTER TABLE messages ADD COLUMN deleted_at DATETIME;
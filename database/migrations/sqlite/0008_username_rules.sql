@@ -0,0 +1,3 @@
+-- Filled in for the existing users by backfillUsernames, before the indexes are created
+ALTER TABLE users ADD COLUMN username_key TEXT;
+ALTER TABLE users ADD COLUMN username_skeleton TEXT;
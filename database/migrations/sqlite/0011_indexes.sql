@@ -0,0 +1,8 @@
+-- Conversations are read by sender and receiver, in order
+CREATE INDEX idx_messages_sender_receiver ON messages (sender_id, receiver_id, id);
+CREATE INDEX idx_messages_receiver_sender ON messages (receiver_id, sender_id, id);
+
+-- The referencing side of the foreign keys not led by a primary or unique key
+CREATE INDEX idx_reports_message ON reports (message_id);
+CREATE INDEX idx_blocks_blocked ON blocks (blocked_id);
+CREATE INDEX idx_contacts_addressee ON contacts (addressee_id);
@@ -0,0 +1,15 @@
+-- Signed tokens revoked before their expiry, kept until they expire
+CREATE TABLE revoked_tokens (
+	token_id TEXT PRIMARY KEY,
+	expires_at DATETIME NOT NULL
+);
+CREATE INDEX idx_revoked_tokens_expires ON revoked_tokens (expires_at);
+
+-- Revocations of the signed tokens of a user issued up to revoked_before,
+-- but except_token_id
+CREATE TABLE revoked_user_tokens (
+	user_id INTEGER PRIMARY KEY,
+	revoked_before DATETIME NOT NULL,
+	except_token_id TEXT NOT NULL DEFAULT '',
+	expires_at DATETIME NOT NULL
+);
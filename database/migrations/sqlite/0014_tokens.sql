@@ -0,0 +1,16 @@
+-- Opaque tokens, by their SHA-256 hash so the database does not hold usable
+-- tokens. Decoy tokens have user 0, hence no foreign key.
+CREATE TABLE tokens (
+	token_hash TEXT PRIMARY KEY,
+	session_id TEXT NOT NULL,
+	user_id INTEGER NOT NULL,
+	username TEXT NOT NULL,
+	device TEXT NOT NULL DEFAULT '',
+	scopes TEXT NOT NULL DEFAULT '',
+	client_ip TEXT NOT NULL DEFAULT '',
+	user_agent TEXT NOT NULL DEFAULT '',
+	issued_at DATETIME NOT NULL,
+	expires_at DATETIME NOT NULL
+);
+CREATE INDEX idx_tokens_user ON tokens (user_id, issued_at);
+CREATE INDEX idx_tokens_expires ON tokens (expires_at);
@@ -0,0 +1,36 @@
+-- The times were bound in the server's local zone, and SQLite compares them
+-- as text: rewrite them in UTC, as they are now bound, to the nearest
+-- millisecond. Values that are not times are left as they are. revoked_before
+-- is moved a millisecond later so the tokens it revoked stay revoked.
+UPDATE users SET
+	suspended_at = COALESCE(strftime('%Y-%m-%d %H:%M:%f+00:00', suspended_at), suspended_at),
+	last_seen_at = COALESCE(strftime('%Y-%m-%d %H:%M:%f+00:00', last_seen_at), last_seen_at),
+	deleted_at = COALESCE(strftime('%Y-%m-%d %H:%M:%f+00:00', deleted_at), deleted_at);
+UPDATE messages SET
+	deleted_at = COALESCE(strftime('%Y-%m-%d %H:%M:%f+00:00', deleted_at), deleted_at);
+UPDATE reports SET
+	created_at = COALESCE(strftime('%Y-%m-%d %H:%M:%f+00:00', created_at), created_at),
+	resolved_at = COALESCE(strftime('%Y-%m-%d %H:%M:%f+00:00', resolved_at), resolved_at);
+UPDATE blocks SET
+	created_at = COALESCE(strftime('%Y-%m-%d %H:%M:%f+00:00', created_at), created_at);
+UPDATE contacts SET
+	created_at = COALESCE(strftime('%Y-%m-%d %H:%M:%f+00:00', created_at), created_at),
+	responded_at = COALESCE(strftime('%Y-%m-%d %H:%M:%f+00:00', responded_at), responded_at);
+UPDATE avatars SET
+	updated_at = COALESCE(strftime('%Y-%m-%d %H:%M:%f+00:00', updated_at), updated_at);
+-- audit events are append-only, but for this rewrite of their times
+DROP TRIGGER audit_events_no_update;
+UPDATE audit_events SET
+	created_at = COALESCE(strftime('%Y-%m-%d %H:%M:%f+00:00', created_at), created_at);
+CREATE TRIGGER audit_events_no_update BEFORE UPDATE ON audit_events
+BEGIN
+	SELECT RAISE(ABORT, 'audit events are append-only');
+END;
+UPDATE tokens SET
+	issued_at = COALESCE(strftime('%Y-%m-%d %H:%M:%f+00:00', issued_at), issued_at),
+	expires_at = COALESCE(strftime('%Y-%m-%d %H:%M:%f+00:00', expires_at), expires_at);
+UPDATE revoked_tokens SET
+	expires_at = COALESCE(strftime('%Y-%m-%d %H:%M:%f+00:00', expires_at), expires_at);
+UPDATE revoked_user_tokens SET
+	revoked_before = COALESCE(strftime('%Y-%m-%d %H:%M:%f+00:00', revoked_before, '+0.001 seconds'), revoked_before),
+	expires_at = COALESCE(strftime('%Y-%m-%d %H:%M:%f+00:00', expires_at), expires_at);
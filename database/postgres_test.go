@@ -0,0 +1,85 @@
+package database
+
+import (
+	"database/sql"
+	"fmt"
+	"net/url"
+	"os"
+	"testing"
+	"time"
+)
+
+// testPostgres returns the options of a new schema in the PostgreSQL database
+// of ENIGMA_TEST_POSTGRES_DSN, a "postgres://" URL, dropped at the end of the
+// test. It skips the test when the variable is not set.
+func testPostgres(t testing.TB) Options {
+	t.Helper()
+	dsn := os.Getenv("ENIGMA_TEST_POSTGRES_DSN")
+	if dsn == "" {
+		t.Skip("ENIGMA_TEST_POSTGRES_DSN is not set")
+	}
+	u, err := url.Parse(dsn)
+	if err != nil || DialectOf(dsn) != Postgres {
+		t.Fatalf("ENIGMA_TEST_POSTGRES_DSN must be a postgres:// URL")
+	}
+
+	db, err := sql.Open("postgres", dsn)
+	if err != nil {
+		t.Fatal(err)
+	}
+	schema := fmt.Sprintf("enigma_test_%d", time.Now().UnixNano())
+	if _, err := db.Exec("CREATE SCHEMA " + schema); err != nil {
+		db.Close()
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		if _, err := db.Exec("DROP SCHEMA " + schema + " CASCADE"); err != nil {
+			t.Error(err)
+		}
+		db.Close()
+	})
+
+	query := u.Query()
+	query.Set("search_path", schema)
+	u.RawQuery = query.Encode()
+	return Options{DSN: u.String()}
+}
+
+func TestMigratePostgres(t *testing.T) {
+	opts := testPostgres(t)
+	db, err := Open(opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	migrations, err := Migrations(Postgres)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if pending, err := Migrate(db, Postgres, true); err != nil || len(pending) != len(migrations) {
+		t.Fatalf("dry run: %d migrations, %v, want %d", len(pending), err, len(migrations))
+	}
+	if version, err := SchemaVersion(db, Postgres); err != nil || version != 0 {
+		t.Fatalf("schema version %d, %v after the dry run, want 0", version, err)
+	}
+	if pending, err := Migrate(db, Postgres, false); err != nil || len(pending) != len(migrations) {
+		t.Fatalf("%d migrations applied, %v, want %d", len(pending), err, len(migrations))
+	}
+	if version, err := SchemaVersion(db, Postgres); err != nil || version != len(migrations) {
+		t.Errorf("schema version %d, %v, want %d", version, err, len(migrations))
+	}
+	if pending, err := Migrate(db, Postgres, false); err != nil || len(pending) != 0 {
+		t.Errorf("migrating again applied %d migrations, %v", len(pending), err)
+	}
+}
+
+func TestRebind(t *testing.T) {
+	query := "SELECT id FROM users WHERE username_key = ? AND bio <> 'why?' AND id > ?"
+	if got := SQLite.Rebind(query); got != query {
+		t.Errorf("SQLite: %s", got)
+	}
+	if got, want := Postgres.Rebind(query), "SELECT id FROM users WHERE username_key = $1 AND bio <> 'why?' AND id > $2"; got != want {
+		t.Errorf("PostgreSQL: %s, want %s", got, want)
+	}
+}
@@ -24,207 +24,217 @@ const docTemplate = `{
     "host": "{{.Host}}",
     "basePath": "{{.BasePath}}",
     "paths": {
-        "/auth/token": {
-            "post": {
+        "/admin/audit": {
+            "get": {
                 "security": [
                     {
-                        "X-User": []
+                        "ApiKeyAuth": []
                     }
                 ],
-                "description": "Request a token for authentication\nTo use the token you need to decrypt it with private key",
-                "consumes": [
-                    "application/json"
-                ],
+                "description": "List the security events of every user and of the server, newest first",
                 "produces": [
                     "application/json"
                 ],
                 "tags": [
-                    "auth"
+                    "admin"
                 ],
-                "summary": "Request a token",
+                "summary": "List all audit events",
                 "parameters": [
                     {
-                        "description": "Authentication request",
-                        "name": "authRequest",
-                        "in": "body",
-                        "required": true,
-                        "schema": {
-                            "$ref": "#/definitions/routes.AuthRequest"
-                        }
+                        "type": "integer",
+                        "description": "Only the events of this user, 0 for events without user",
+                        "name": "userId",
+                        "in": "query"
+                    },
+                    {
+                        "type": "string",
+                        "description": "Only events of this type, e.g. auth.failed",
+                        "name": "event",
+                        "in": "query"
+                    },
+                    {
+                        "type": "integer",
+                        "description": "Number of events, 50 by default and 500 at most",
+                        "name": "limit",
+                        "in": "query"
+                    },
+                    {
+                        "type": "integer",
+                        "description": "Only events older than this event ID",
+                        "name": "before",
+                        "in": "query"
                     }
                 ],
                 "responses": {
                     "200": {
                         "description": "OK",
                         "schema": {
-                            "$ref": "#/definitions/routes.AuthResponse"
+                            "type": "array",
+                            "items": {
+                                "$ref": "#/definitions/routes.AuditEvent"
+                            }
                         }
                     }
                 }
             }
         },
-        "/messages": {
+        "/admin/reports": {
             "get": {
                 "security": [
                     {
                         "ApiKeyAuth": []
-                    },
-                    {
-                        "X-User": []
                     }
                 ],
-                "description": "Get a list of all messages",
-                "consumes": [
-                    "application/json"
-                ],
+                "description": "List abuse reports, the open ones by default",
                 "produces": [
                     "application/json"
                 ],
                 "tags": [
-                    "messages"
+                    "admin"
+                ],
+                "summary": "List abuse reports",
+                "parameters": [
+                    {
+                        "type": "string",
+                        "description": "open, resolved or all",
+                        "name": "status",
+                        "in": "query"
+                    }
                 ],
-                "summary": "Get all messages",
                 "responses": {
                     "200": {
                         "description": "OK",
                         "schema": {
                             "type": "array",
                             "items": {
-                                "$ref": "#/definitions/routes.Message"
+                                "$ref": "#/definitions/routes.Report"
                             }
                         }
                     }
                 }
-            },
+            }
+        },
+        "/admin/reports/{id}/resolve": {
             "post": {
                 "security": [
                     {
                         "ApiKeyAuth": []
-                    },
-                    {
-                        "X-User": []
                     }
                 ],
-                "description": "Create a new message with the input payload",
-                "consumes": [
-                    "application/json"
-                ],
                 "produces": [
                     "application/json"
                 ],
                 "tags": [
-                    "messages"
+                    "admin"
                 ],
-                "summary": "Create a new message",
+                "summary": "Resolve an abuse report",
                 "parameters": [
                     {
-                        "description": "Create message",
-                        "name": "message",
-                        "in": "body",
-                        "required": true,
-                        "schema": {
-                            "$ref": "#/definitions/routes.Message"
-                        }
+                        "type": "integer",
+                        "description": "Report ID",
+                        "name": "id",
+                        "in": "path",
+                        "required": true
                     }
                 ],
+                "responses": {
+                    "204": {
+                        "description": "No Content"
+                    }
+                }
+            }
+        },
+        "/admin/stats": {
+            "get": {
+                "security": [
+                    {
+                        "ApiKeyAuth": []
+                    }
+                ],
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "admin"
+                ],
+                "summary": "Server statistics",
                 "responses": {
                     "200": {
                         "description": "OK",
                         "schema": {
-                            "$ref": "#/definitions/routes.Message"
+                            "$ref": "#/definitions/routes.ServerStats"
                         }
                     }
                 }
             }
         },
-        "/messages/getDiscussions/": {
+        "/admin/users": {
             "get": {
                 "security": [
                     {
                         "ApiKeyAuth": []
-                    },
-                    {
-                        "X-User": []
                     }
                 ],
-                "description": "Return a user list who the user have discussed with",
-                "consumes": [
-                    "application/json"
-                ],
+                "description": "List every account with its role, suspension and active sessions",
                 "produces": [
                     "application/json"
                 ],
                 "tags": [
-                    "messages"
+                    "admin"
                 ],
-                "summary": "Return a list of user who a user speaks to",
+                "summary": "List accounts",
                 "responses": {
                     "200": {
                         "description": "OK",
                         "schema": {
                             "type": "array",
                             "items": {
-                                "$ref": "#/definitions/routes.UserGet"
+                                "$ref": "#/definitions/routes.AdminUser"
                             }
                         }
                     }
                 }
             }
         },
-        "/messages/getMessagesWith/{userId}": {
-            "get": {
+        "/admin/users/{id}": {
+            "delete": {
                 "security": [
                     {
                         "ApiKeyAuth": []
-                    },
-                    {
-                        "X-User": []
                     }
                 ],
-                "description": "get messages with a user",
-                "consumes": [
-                    "application/json"
-                ],
+                "description": "Delete an account like DELETE /users/me does, without challenge, and revoke all its sessions",
                 "produces": [
                     "application/json"
                 ],
                 "tags": [
-                    "messages"
+                    "admin"
                 ],
-                "summary": "get messages with a user",
+                "summary": "Delete an account",
                 "parameters": [
                     {
                         "type": "integer",
                         "description": "User ID",
-                        "name": "userId",
+                        "name": "id",
                         "in": "path",
                         "required": true
                     }
                 ],
                 "responses": {
-                    "200": {
-                        "description": "OK",
-                        "schema": {
-                            "type": "array",
-                            "items": {
-                                "$ref": "#/definitions/routes.Message"
-                            }
-                        }
+                    "204": {
+                        "description": "No Content"
                     }
                 }
             }
         },
-        "/users": {
-            "get": {
+        "/admin/users/{id}/role": {
+            "put": {
                 "security": [
                     {
                         "ApiKeyAuth": []
-                    },
-                    {
-                        "X-User": []
                     }
                 ],
-                "description": "Get a list of all users",
+                "description": "Promote an account to admin or demote it to user",
                 "consumes": [
                     "application/json"
                 ],
@@ -232,75 +242,110 @@ const docTemplate = `{
                     "application/json"
                 ],
                 "tags": [
-                    "users"
+                    "admin"
                 ],
-                "summary": "Get all users",
-                "responses": {
-                    "200": {
-                        "description": "OK",
+                "summary": "Change the role of an account",
+                "parameters": [
+                    {
+                        "type": "integer",
+                        "description": "User ID",
+                        "name": "id",
+                        "in": "path",
+                        "required": true
+                    },
+                    {
+                        "description": "New role",
+                        "name": "role",
+                        "in": "body",
+                        "required": true,
                         "schema": {
-                            "type": "array",
-                            "items": {
-                                "$ref": "#/definitions/routes.UserGet"
-                            }
+                            "$ref": "#/definitions/routes.RoleUpdate"
                         }
                     }
+                ],
+                "responses": {
+                    "204": {
+                        "description": "No Content"
+                    }
                 }
-            },
-            "post": {
-                "description": "Create a new user with the input payload",
-                "consumes": [
-                    "application/json"
+            }
+        },
+        "/admin/users/{id}/sessions": {
+            "delete": {
+                "security": [
+                    {
+                        "ApiKeyAuth": []
+                    }
                 ],
+                "description": "Force the revocation of every session of an account, signed tokens included\nReturns 404 when the account does not exist or is deleted",
                 "produces": [
                     "application/json"
                 ],
                 "tags": [
-                    "users"
+                    "admin"
                 ],
-                "summary": "Create a new user",
+                "summary": "Revoke the sessions of an account",
                 "parameters": [
                     {
-                        "description": "Create user",
-                        "name": "user",
-                        "in": "body",
-                        "required": true,
-                        "schema": {
-                            "$ref": "#/definitions/routes.UserPost"
-                        }
+                        "type": "integer",
+                        "description": "User ID",
+                        "name": "id",
+                        "in": "path",
+                        "required": true
                     }
                 ],
                 "responses": {
-                    "201": {
-                        "description": "Created",
-                        "schema": {
-                            "$ref": "#/definitions/routes.UserGet"
-                        }
+                    "204": {
+                        "description": "No Content"
                     }
                 }
             }
         },
-        "/users/{id}": {
-            "get": {
+        "/admin/users/{id}/suspend": {
+            "post": {
                 "security": [
                     {
                         "ApiKeyAuth": []
-                    },
-                    {
-                        "X-User": []
                     }
                 ],
-                "description": "Get a user by ID",
-                "consumes": [
+                "description": "Suspend an account and revoke all its sessions, it can no longer get a token",
+                "produces": [
                     "application/json"
                 ],
+                "tags": [
+                    "admin"
+                ],
+                "summary": "Suspend an account",
+                "parameters": [
+                    {
+                        "type": "integer",
+                        "description": "User ID",
+                        "name": "id",
+                        "in": "path",
+                        "required": true
+                    }
+                ],
+                "responses": {
+                    "204": {
+                        "description": "No Content"
+                    }
+                }
+            }
+        },
+        "/admin/users/{id}/unsuspend": {
+            "post": {
+                "security": [
+                    {
+                        "ApiKeyAuth": []
+                    }
+                ],
                 "produces": [
                     "application/json"
                 ],
                 "tags": [
-                    "users"
+                    "admin"
                 ],
-                "summary": "Get a user by ID",
+                "summary": "Lift the suspension of an account",
                 "parameters": [
                     {
                         "type": "integer",
@@ -310,40 +355,1298 @@ const docTemplate = `{
                         "required": true
                     }
                 ],
+                "responses": {
+                    "204": {
+                        "description": "No Content"
+                    }
+                }
+            }
+        },
+        "/audit": {
+            "get": {
+                "security": [
+                    {
+                        "ApiKeyAuth": []
+                    }
+                ],
+                "description": "List the security events of the authenticated user, newest first: token issuance, logouts,\nrevoked sessions, failed authentications... Use the id of the last event as before to get the next page",
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "audit"
+                ],
+                "summary": "List your audit events",
+                "parameters": [
+                    {
+                        "type": "integer",
+                        "description": "Number of events, 50 by default and 500 at most",
+                        "name": "limit",
+                        "in": "query"
+                    },
+                    {
+                        "type": "integer",
+                        "description": "Only events older than this event ID",
+                        "name": "before",
+                        "in": "query"
+                    }
+                ],
                 "responses": {
                     "200": {
                         "description": "OK",
                         "schema": {
-                            "$ref": "#/definitions/routes.UserGet"
+                            "type": "array",
+                            "items": {
+                                "$ref": "#/definitions/routes.AuditEvent"
+                            }
                         }
                     }
                 }
             }
-        }
-    },
-    "definitions": {
-        "routes.AuthRequest": {
-            "type": "object",
-            "properties": {
-                "username": {
-                    "type": "string"
-                }
-            }
         },
-        "routes.AuthResponse": {
-            "type": "object",
-            "properties": {
-                "encryptedToken": {
-                    "type": "string"
+        "/auth/logout": {
+            "post": {
+                "security": [
+                    {
+                        "ApiKeyAuth": []
+                    }
+                ],
+                "description": "Revoke the token used to authenticate this request\nRequests authenticated by a client certificate have no token, 400 is returned",
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "auth"
+                ],
+                "summary": "Logout",
+                "responses": {
+                    "204": {
+                        "description": "No Content"
+                    }
                 }
             }
         },
-        "routes.Message": {
-            "type": "object",
-            "properties": {
+        "/auth/sessions": {
+            "get": {
+                "security": [
+                    {
+                        "ApiKeyAuth": []
+                    }
+                ],
+                "description": "List the active sessions of the authenticated user with their issue time and client info\nSigned tokens are self-contained and are not listed",
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "auth"
+                ],
+                "summary": "List active sessions",
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "type": "array",
+                            "items": {
+                                "$ref": "#/definitions/routes.Session"
+                            }
+                        }
+                    }
+                }
+            },
+            "delete": {
+                "security": [
+                    {
+                        "ApiKeyAuth": []
+                    }
+                ],
+                "description": "Revoke every session of the authenticated user, signed tokens and client certificates included\nSet keepCurrent to true to keep the session used by this request",
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "auth"
+                ],
+                "summary": "Revoke all sessions",
+                "parameters": [
+                    {
+                        "type": "boolean",
+                        "description": "Keep the current session",
+                        "name": "keepCurrent",
+                        "in": "query"
+                    }
+                ],
+                "responses": {
+                    "204": {
+                        "description": "No Content"
+                    }
+                }
+            }
+        },
+        "/auth/sessions/{id}": {
+            "delete": {
+                "security": [
+                    {
+                        "ApiKeyAuth": []
+                    }
+                ],
+                "description": "Revoke one of the authenticated user's sessions",
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "auth"
+                ],
+                "summary": "Revoke a session",
+                "parameters": [
+                    {
+                        "type": "string",
+                        "description": "Session ID",
+                        "name": "id",
+                        "in": "path",
+                        "required": true
+                    }
+                ],
+                "responses": {
+                    "204": {
+                        "description": "No Content"
+                    }
+                }
+            }
+        },
+        "/auth/token": {
+            "post": {
+                "description": "Request a token for authentication\nTo use the token you need to decrypt it with private key\nWith tokenType \"jwt\" a signed self-contained token is returned, encryptedKey then holds\nan AES-256 key encrypted with the public key and encryptedToken the token sealed with AES-GCM\n(12 bytes nonce followed by the ciphertext)\nScopes restrict what the token grants access to: messages:read, messages:write, users:read,\nusers:write and admin. Without scopes, all but admin are granted. admin is only granted to administrators\nToken requests are rate limited, 429 is returned with a Retry-After header past the limit. Too many\nrequests for a username lock it out for 15 minutes. Unknown usernames get a token nobody can decrypt",
+                "consumes": [
+                    "application/json"
+                ],
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "auth"
+                ],
+                "summary": "Request a token",
+                "parameters": [
+                    {
+                        "description": "Authentication request",
+                        "name": "authRequest",
+                        "in": "body",
+                        "required": true,
+                        "schema": {
+                            "$ref": "#/definitions/routes.AuthRequest"
+                        }
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "$ref": "#/definitions/routes.AuthResponse"
+                        }
+                    }
+                }
+            }
+        },
+        "/contacts": {
+            "get": {
+                "security": [
+                    {
+                        "ApiKeyAuth": []
+                    }
+                ],
+                "description": "List the accepted contacts of the authenticated user with their public keys",
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "contacts"
+                ],
+                "summary": "List contacts",
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "type": "array",
+                            "items": {
+                                "$ref": "#/definitions/routes.Contact"
+                            }
+                        }
+                    }
+                }
+            }
+        },
+        "/contacts/requests": {
+            "get": {
+                "security": [
+                    {
+                        "ApiKeyAuth": []
+                    }
+                ],
+                "description": "List the pending contact requests sent and received by the authenticated user",
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "contacts"
+                ],
+                "summary": "List pending contact requests",
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "type": "array",
+                            "items": {
+                                "$ref": "#/definitions/routes.ContactRequest"
+                            }
+                        }
+                    }
+                }
+            },
+            "post": {
+                "security": [
+                    {
+                        "ApiKeyAuth": []
+                    }
+                ],
+                "description": "Send a contact request to a user. If they already sent you one, it is accepted instead",
+                "consumes": [
+                    "application/json"
+                ],
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "contacts"
+                ],
+                "summary": "Send a contact request",
+                "parameters": [
+                    {
+                        "description": "Contact request",
+                        "name": "request",
+                        "in": "body",
+                        "required": true,
+                        "schema": {
+                            "$ref": "#/definitions/routes.ContactRequestPost"
+                        }
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "$ref": "#/definitions/routes.ContactRequest"
+                        }
+                    },
+                    "201": {
+                        "description": "Created",
+                        "schema": {
+                            "$ref": "#/definitions/routes.ContactRequest"
+                        }
+                    }
+                }
+            }
+        },
+        "/contacts/requests/{id}": {
+            "delete": {
+                "security": [
+                    {
+                        "ApiKeyAuth": []
+                    }
+                ],
+                "description": "Cancel a contact request sent by the authenticated user",
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "contacts"
+                ],
+                "summary": "Cancel a contact request",
+                "parameters": [
+                    {
+                        "type": "integer",
+                        "description": "Contact request ID",
+                        "name": "id",
+                        "in": "path",
+                        "required": true
+                    }
+                ],
+                "responses": {
+                    "204": {
+                        "description": "No Content"
+                    }
+                }
+            }
+        },
+        "/contacts/requests/{id}/accept": {
+            "post": {
+                "security": [
+                    {
+                        "ApiKeyAuth": []
+                    }
+                ],
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "contacts"
+                ],
+                "summary": "Accept a contact request",
+                "parameters": [
+                    {
+                        "type": "integer",
+                        "description": "Contact request ID",
+                        "name": "id",
+                        "in": "path",
+                        "required": true
+                    }
+                ],
+                "responses": {
+                    "204": {
+                        "description": "No Content"
+                    }
+                }
+            }
+        },
+        "/contacts/requests/{id}/reject": {
+            "post": {
+                "security": [
+                    {
+                        "ApiKeyAuth": []
+                    }
+                ],
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "contacts"
+                ],
+                "summary": "Reject a contact request",
+                "parameters": [
+                    {
+                        "type": "integer",
+                        "description": "Contact request ID",
+                        "name": "id",
+                        "in": "path",
+                        "required": true
+                    }
+                ],
+                "responses": {
+                    "204": {
+                        "description": "No Content"
+                    }
+                }
+            }
+        },
+        "/contacts/{userId}": {
+            "delete": {
+                "security": [
+                    {
+                        "ApiKeyAuth": []
+                    }
+                ],
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "contacts"
+                ],
+                "summary": "Remove a contact",
+                "parameters": [
+                    {
+                        "type": "integer",
+                        "description": "User ID",
+                        "name": "userId",
+                        "in": "path",
+                        "required": true
+                    }
+                ],
+                "responses": {
+                    "204": {
+                        "description": "No Content"
+                    }
+                }
+            }
+        },
+        "/messages": {
+            "get": {
+                "security": [
+                    {
+                        "ApiKeyAuth": []
+                    }
+                ],
+                "description": "Get a list of all messages sent or received by the authenticated user\nMessages exchanged with blocked users are left out",
+                "consumes": [
+                    "application/json"
+                ],
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "messages"
+                ],
+                "summary": "Get all messages",
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "type": "array",
+                            "items": {
+                                "$ref": "#/definitions/routes.Message"
+                            }
+                        }
+                    }
+                }
+            },
+            "post": {
+                "security": [
+                    {
+                        "ApiKeyAuth": []
+                    }
+                ],
+                "description": "Create a new message with the input payload\nThe sender is the authenticated user, senderId can be omitted\nThe message is refused when the receiver blocked you, you blocked them, or their privacy settings\ndo not accept your messages",
+                "consumes": [
+                    "application/json"
+                ],
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "messages"
+                ],
+                "summary": "Create a new message",
+                "parameters": [
+                    {
+                        "description": "Create message",
+                        "name": "message",
+                        "in": "body",
+                        "required": true,
+                        "schema": {
+                            "$ref": "#/definitions/routes.Message"
+                        }
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "$ref": "#/definitions/routes.Message"
+                        }
+                    }
+                }
+            }
+        },
+        "/messages/getDiscussions/": {
+            "get": {
+                "security": [
+                    {
+                        "ApiKeyAuth": []
+                    }
+                ],
+                "description": "Return a user list who the user have discussed with\nBlocked users are left out",
+                "consumes": [
+                    "application/json"
+                ],
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "messages"
+                ],
+                "summary": "Return a list of user who a user speaks to",
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "type": "array",
+                            "items": {
+                                "$ref": "#/definitions/routes.UserGet"
+                            }
+                        }
+                    }
+                }
+            }
+        },
+        "/messages/getMessagesWith/{userId}": {
+            "get": {
+                "security": [
+                    {
+                        "ApiKeyAuth": []
+                    }
+                ],
+                "description": "get messages with a user\nFails with 403 when one of you blocked the other",
+                "consumes": [
+                    "application/json"
+                ],
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "messages"
+                ],
+                "summary": "get messages with a user",
+                "parameters": [
+                    {
+                        "type": "integer",
+                        "description": "User ID",
+                        "name": "userId",
+                        "in": "path",
+                        "required": true
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "type": "array",
+                            "items": {
+                                "$ref": "#/definitions/routes.Message"
+                            }
+                        }
+                    }
+                }
+            }
+        },
+        "/reports": {
+            "post": {
+                "security": [
+                    {
+                        "ApiKeyAuth": []
+                    }
+                ],
+                "description": "Report a user, and optionally one of the messages they sent you, to the administrators",
+                "consumes": [
+                    "application/json"
+                ],
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "reports"
+                ],
+                "summary": "Report abuse",
+                "parameters": [
+                    {
+                        "description": "Create report",
+                        "name": "report",
+                        "in": "body",
+                        "required": true,
+                        "schema": {
+                            "$ref": "#/definitions/routes.ReportPost"
+                        }
+                    }
+                ],
+                "responses": {
+                    "201": {
+                        "description": "Created",
+                        "schema": {
+                            "$ref": "#/definitions/routes.Report"
+                        }
+                    }
+                }
+            }
+        },
+        "/users": {
+            "get": {
+                "security": [
+                    {
+                        "ApiKeyAuth": []
+                    }
+                ],
+                "description": "Get a page of the users directory, sorted by username\nq filters on a case-insensitive username prefix. When there are more users, the X-Next-Cursor\nresponse header holds the cursor of the next page\nUsers hidden from the directory and blocked users are left out",
+                "consumes": [
+                    "application/json"
+                ],
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "users"
+                ],
+                "summary": "Get all users",
+                "parameters": [
+                    {
+                        "type": "string",
+                        "description": "Username prefix",
+                        "name": "q",
+                        "in": "query"
+                    },
+                    {
+                        "type": "integer",
+                        "description": "Page size, 50 by default and 100 at most",
+                        "name": "limit",
+                        "in": "query"
+                    },
+                    {
+                        "type": "string",
+                        "description": "Cursor of the page, from X-Next-Cursor",
+                        "name": "cursor",
+                        "in": "query"
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "type": "array",
+                            "items": {
+                                "$ref": "#/definitions/routes.UserGet"
+                            }
+                        },
+                        "headers": {
+                            "X-Next-Cursor": {
+                                "type": "string",
+                                "description": "Cursor of the next page"
+                            }
+                        }
+                    }
+                }
+            },
+            "post": {
+                "description": "Create a new user with the input payload\nThe username is 3 to 32 letters or digits, with single '.', '_' or '-' between them. It is unique\nignoring case and look-alike characters, a taken or too similar username is refused with 409\nAccount creation is rate limited, and may require a proof of work, see GET /users/challenge",
+                "consumes": [
+                    "application/json"
+                ],
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "users"
+                ],
+                "summary": "Create a new user",
+                "parameters": [
+                    {
+                        "description": "Create user",
+                        "name": "user",
+                        "in": "body",
+                        "required": true,
+                        "schema": {
+                            "$ref": "#/definitions/routes.UserPost"
+                        }
+                    },
+                    {
+                        "type": "string",
+                        "description": "Solved challenge, challenge:nonce",
+                        "name": "X-Proof-Of-Work",
+                        "in": "header"
+                    }
+                ],
+                "responses": {
+                    "201": {
+                        "description": "Created",
+                        "schema": {
+                            "$ref": "#/definitions/routes.UserGet"
+                        }
+                    }
+                }
+            }
+        },
+        "/users/by-username/{username}": {
+            "get": {
+                "security": [
+                    {
+                        "ApiKeyAuth": []
+                    }
+                ],
+                "description": "Get a user, with their public key, by their exact username, ignoring case\nUsers who blocked you are not found",
+                "consumes": [
+                    "application/json"
+                ],
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "users"
+                ],
+                "summary": "Get a user by username",
+                "parameters": [
+                    {
+                        "type": "string",
+                        "description": "Username",
+                        "name": "username",
+                        "in": "path",
+                        "required": true
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "$ref": "#/definitions/routes.UserGet"
+                        }
+                    }
+                }
+            }
+        },
+        "/users/challenge": {
+            "get": {
+                "description": "When the server requires it, find a nonce such that the SHA-256 of \"challenge:nonce\" starts with\nthe given number of zero bits, then send \"challenge:nonce\" in the X-Proof-Of-Work header of POST /users\nwithin 10 minutes. Each challenge can be used once",
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "users"
+                ],
+                "summary": "Get a registration proof of work challenge",
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "$ref": "#/definitions/routes.ProofOfWorkChallenge"
+                        }
+                    }
+                }
+            }
+        },
+        "/users/me": {
+            "get": {
+                "security": [
+                    {
+                        "ApiKeyAuth": []
+                    }
+                ],
+                "description": "Get the profile of the authenticated user",
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "profile"
+                ],
+                "summary": "Get your profile",
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "$ref": "#/definitions/routes.Profile"
+                        }
+                    }
+                }
+            },
+            "delete": {
+                "security": [
+                    {
+                        "ApiKeyAuth": []
+                    }
+                ],
+                "description": "Delete the authenticated user's account, given the decrypted challenge from\nPOST /users/me/deletion-challenge. All sessions are revoked and your profile, contacts and blocks\nare removed. Peers see you as a deleted user, your messages are kept, emptied or removed\ndepending on the server policy",
+                "consumes": [
+                    "application/json"
+                ],
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "account"
+                ],
+                "summary": "Delete your account",
+                "parameters": [
+                    {
+                        "description": "Decrypted challenge",
+                        "name": "deletion",
+                        "in": "body",
+                        "required": true,
+                        "schema": {
+                            "$ref": "#/definitions/routes.AccountDeletion"
+                        }
+                    }
+                ],
+                "responses": {
+                    "204": {
+                        "description": "No Content"
+                    }
+                }
+            },
+            "patch": {
+                "security": [
+                    {
+                        "ApiKeyAuth": []
+                    }
+                ],
+                "description": "Update the profile of the authenticated user, only the given fields are changed\nshowLastSeen set to false hides your last seen time from other users",
+                "consumes": [
+                    "application/json"
+                ],
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "profile"
+                ],
+                "summary": "Update your profile",
+                "parameters": [
+                    {
+                        "description": "Profile fields",
+                        "name": "profile",
+                        "in": "body",
+                        "required": true,
+                        "schema": {
+                            "$ref": "#/definitions/routes.ProfilePatch"
+                        }
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "$ref": "#/definitions/routes.Profile"
+                        }
+                    }
+                }
+            }
+        },
+        "/users/me/avatar": {
+            "put": {
+                "security": [
+                    {
+                        "ApiKeyAuth": []
+                    }
+                ],
+                "description": "Upload the avatar of the authenticated user as the raw request body\nPNG, JPEG, GIF or WebP images up to 256 KiB are accepted",
+                "consumes": [
+                    "image/png",
+                    "image/jpeg",
+                    "image/gif",
+                    "image/webp"
+                ],
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "profile"
+                ],
+                "summary": "Set your avatar",
+                "responses": {
+                    "204": {
+                        "description": "No Content"
+                    }
+                }
+            },
+            "delete": {
+                "security": [
+                    {
+                        "ApiKeyAuth": []
+                    }
+                ],
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "profile"
+                ],
+                "summary": "Remove your avatar",
+                "responses": {
+                    "204": {
+                        "description": "No Content"
+                    }
+                }
+            }
+        },
+        "/users/me/blocks": {
+            "get": {
+                "security": [
+                    {
+                        "ApiKeyAuth": []
+                    }
+                ],
+                "description": "List the users blocked by the authenticated user",
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "privacy"
+                ],
+                "summary": "List blocked users",
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "type": "array",
+                            "items": {
+                                "$ref": "#/definitions/routes.UserGet"
+                            }
+                        }
+                    }
+                }
+            }
+        },
+        "/users/me/blocks/{userId}": {
+            "put": {
+                "security": [
+                    {
+                        "ApiKeyAuth": []
+                    }
+                ],
+                "description": "Block a user: neither of you can message the other, and you no longer see each other\nin the directory, discussions and messages. They are also removed from your contacts",
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "privacy"
+                ],
+                "summary": "Block a user",
+                "parameters": [
+                    {
+                        "type": "integer",
+                        "description": "User ID",
+                        "name": "userId",
+                        "in": "path",
+                        "required": true
+                    }
+                ],
+                "responses": {
+                    "204": {
+                        "description": "No Content"
+                    }
+                }
+            },
+            "delete": {
+                "security": [
+                    {
+                        "ApiKeyAuth": []
+                    }
+                ],
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "privacy"
+                ],
+                "summary": "Unblock a user",
+                "parameters": [
+                    {
+                        "type": "integer",
+                        "description": "User ID",
+                        "name": "userId",
+                        "in": "path",
+                        "required": true
+                    }
+                ],
+                "responses": {
+                    "204": {
+                        "description": "No Content"
+                    }
+                }
+            }
+        },
+        "/users/me/deletion-challenge": {
+            "post": {
+                "security": [
+                    {
+                        "ApiKeyAuth": []
+                    }
+                ],
+                "description": "Get a challenge encrypted with your public key, decrypt it with your private key and send it\nto DELETE /users/me within 5 minutes to delete your account",
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "account"
+                ],
+                "summary": "Request an account deletion challenge",
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "$ref": "#/definitions/routes.DeletionChallengeResponse"
+                        }
+                    }
+                }
+            }
+        },
+        "/users/me/privacy": {
+            "get": {
+                "security": [
+                    {
+                        "ApiKeyAuth": []
+                    }
+                ],
+                "description": "Get the privacy settings of the authenticated user",
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "privacy"
+                ],
+                "summary": "Get privacy settings",
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "$ref": "#/definitions/routes.PrivacySettings"
+                        }
+                    }
+                }
+            },
+            "put": {
+                "security": [
+                    {
+                        "ApiKeyAuth": []
+                    }
+                ],
+                "description": "allowMessagesFrom is \"everyone\", \"discussions\" to only accept messages from users you\nalready sent a message to, or \"contacts\" to only accept messages from your contacts.\nhiddenFromDirectory hides you from the users list",
+                "consumes": [
+                    "application/json"
+                ],
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "privacy"
+                ],
+                "summary": "Update privacy settings",
+                "parameters": [
+                    {
+                        "description": "Privacy settings",
+                        "name": "settings",
+                        "in": "body",
+                        "required": true,
+                        "schema": {
+                            "$ref": "#/definitions/routes.PrivacySettings"
+                        }
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "$ref": "#/definitions/routes.PrivacySettings"
+                        }
+                    }
+                }
+            }
+        },
+        "/users/{id}": {
+            "get": {
+                "security": [
+                    {
+                        "ApiKeyAuth": []
+                    }
+                ],
+                "description": "Get a user by ID, users who blocked you are not found",
+                "consumes": [
+                    "application/json"
+                ],
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "users"
+                ],
+                "summary": "Get a user by ID",
+                "parameters": [
+                    {
+                        "type": "integer",
+                        "description": "User ID",
+                        "name": "id",
+                        "in": "path",
+                        "required": true
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "$ref": "#/definitions/routes.UserGet"
+                        }
+                    }
+                }
+            }
+        },
+        "/users/{id}/avatar": {
+            "get": {
+                "security": [
+                    {
+                        "ApiKeyAuth": []
+                    }
+                ],
+                "description": "Users who blocked you have no avatar",
+                "produces": [
+                    "image/png",
+                    "image/jpeg",
+                    "image/gif",
+                    "image/webp"
+                ],
+                "tags": [
+                    "profile"
+                ],
+                "summary": "Get the avatar of a user",
+                "parameters": [
+                    {
+                        "type": "integer",
+                        "description": "User ID",
+                        "name": "id",
+                        "in": "path",
+                        "required": true
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "type": "file"
+                        }
+                    }
+                }
+            }
+        }
+    },
+    "definitions": {
+        "routes.AccountDeletion": {
+            "type": "object",
+            "properties": {
+                "challenge": {
+                    "type": "string"
+                }
+            }
+        },
+        "routes.AdminUser": {
+            "type": "object",
+            "properties": {
+                "activeSessions": {
+                    "type": "integer"
+                },
+                "id": {
+                    "type": "integer"
+                },
+                "role": {
+                    "type": "string"
+                },
+                "suspendedAt": {
+                    "type": "string"
+                },
+                "username": {
+                    "type": "string"
+                }
+            }
+        },
+        "routes.AuditEvent": {
+            "type": "object",
+            "properties": {
+                "createdAt": {
+                    "type": "string"
+                },
+                "details": {
+                    "type": "string"
+                },
+                "event": {
+                    "type": "string"
+                },
+                "id": {
+                    "type": "integer"
+                },
+                "ip": {
+                    "type": "string"
+                },
+                "userAgent": {
+                    "type": "string"
+                },
+                "userId": {
+                    "type": "integer"
+                }
+            }
+        },
+        "routes.AuthRequest": {
+            "type": "object",
+            "properties": {
+                "device": {
+                    "type": "string"
+                },
+                "scopes": {
+                    "description": "Scopes granted to the token, messages:read, messages:write, users:read and users:write when empty.",
+                    "type": "array",
+                    "items": {
+                        "type": "string"
+                    }
+                },
+                "tokenType": {
+                    "description": "TokenType is \"opaque\" (default) or \"jwt\" for a signed self-contained token.",
+                    "type": "string"
+                },
+                "username": {
+                    "type": "string"
+                }
+            }
+        },
+        "routes.AuthResponse": {
+            "type": "object",
+            "properties": {
+                "encryptedKey": {
+                    "description": "EncryptedKey is only set for jwt tokens, see encryptTokenHybrid.",
+                    "type": "string"
+                },
+                "encryptedToken": {
+                    "type": "string"
+                },
+                "tokenType": {
+                    "type": "string"
+                }
+            }
+        },
+        "routes.Contact": {
+            "type": "object",
+            "properties": {
+                "id": {
+                    "type": "integer"
+                },
+                "publicKey": {
+                    "type": "string"
+                },
+                "since": {
+                    "type": "string"
+                },
+                "username": {
+                    "type": "string"
+                }
+            }
+        },
+        "routes.ContactRequest": {
+            "type": "object",
+            "properties": {
+                "addresseeId": {
+                    "type": "integer"
+                },
+                "createdAt": {
+                    "type": "string"
+                },
+                "id": {
+                    "type": "integer"
+                },
+                "requesterId": {
+                    "type": "integer"
+                },
+                "status": {
+                    "type": "string"
+                }
+            }
+        },
+        "routes.ContactRequestPost": {
+            "type": "object",
+            "properties": {
+                "userId": {
+                    "type": "integer"
+                }
+            }
+        },
+        "routes.DeletionChallengeResponse": {
+            "type": "object",
+            "properties": {
+                "encryptedChallenge": {
+                    "type": "string"
+                },
+                "expiresAt": {
+                    "type": "string"
+                }
+            }
+        },
+        "routes.Message": {
+            "type": "object",
+            "properties": {
                 "content": {
                     "type": "string"
                 },
+                "deleted": {
+                    "description": "Deleted messages belonged to a deleted account and have no content.",
+                    "type": "boolean"
+                },
                 "id": {
                     "type": "integer"
                 },
@@ -355,15 +1658,215 @@ const docTemplate = `{
                 }
             }
         },
+        "routes.PrivacySettings": {
+            "type": "object",
+            "properties": {
+                "allowMessagesFrom": {
+                    "type": "string"
+                },
+                "hiddenFromDirectory": {
+                    "type": "boolean"
+                }
+            }
+        },
+        "routes.Profile": {
+            "type": "object",
+            "properties": {
+                "avatarUrl": {
+                    "type": "string"
+                },
+                "bio": {
+                    "type": "string"
+                },
+                "deleted": {
+                    "description": "Deleted accounts keep their id but have no username nor public key.",
+                    "type": "boolean"
+                },
+                "displayName": {
+                    "type": "string"
+                },
+                "id": {
+                    "type": "integer"
+                },
+                "lastSeenAt": {
+                    "type": "string"
+                },
+                "publicKey": {
+                    "type": "string"
+                },
+                "showLastSeen": {
+                    "type": "boolean"
+                },
+                "statusText": {
+                    "type": "string"
+                },
+                "username": {
+                    "type": "string"
+                }
+            }
+        },
+        "routes.ProfilePatch": {
+            "type": "object",
+            "properties": {
+                "bio": {
+                    "type": "string"
+                },
+                "displayName": {
+                    "type": "string"
+                },
+                "showLastSeen": {
+                    "type": "boolean"
+                },
+                "statusText": {
+                    "type": "string"
+                }
+            }
+        },
+        "routes.ProofOfWorkChallenge": {
+            "type": "object",
+            "properties": {
+                "bits": {
+                    "type": "integer"
+                },
+                "challenge": {
+                    "type": "string"
+                },
+                "expiresAt": {
+                    "type": "string"
+                }
+            }
+        },
+        "routes.Report": {
+            "type": "object",
+            "properties": {
+                "createdAt": {
+                    "type": "string"
+                },
+                "id": {
+                    "type": "integer"
+                },
+                "messageId": {
+                    "type": "integer"
+                },
+                "reason": {
+                    "type": "string"
+                },
+                "reportedId": {
+                    "type": "integer"
+                },
+                "reporterId": {
+                    "type": "integer"
+                },
+                "resolvedAt": {
+                    "type": "string"
+                }
+            }
+        },
+        "routes.ReportPost": {
+            "type": "object",
+            "properties": {
+                "messageId": {
+                    "type": "integer"
+                },
+                "reason": {
+                    "type": "string"
+                },
+                "reportedId": {
+                    "type": "integer"
+                }
+            }
+        },
+        "routes.RoleUpdate": {
+            "type": "object",
+            "properties": {
+                "role": {
+                    "type": "string"
+                }
+            }
+        },
+        "routes.ServerStats": {
+            "type": "object",
+            "properties": {
+                "activeSessions": {
+                    "type": "integer"
+                },
+                "admins": {
+                    "type": "integer"
+                },
+                "messages": {
+                    "type": "integer"
+                },
+                "openReports": {
+                    "type": "integer"
+                },
+                "suspendedUsers": {
+                    "type": "integer"
+                },
+                "users": {
+                    "type": "integer"
+                }
+            }
+        },
+        "routes.Session": {
+            "type": "object",
+            "properties": {
+                "clientIp": {
+                    "type": "string"
+                },
+                "current": {
+                    "type": "boolean"
+                },
+                "device": {
+                    "type": "string"
+                },
+                "expiresAt": {
+                    "type": "string"
+                },
+                "id": {
+                    "type": "string"
+                },
+                "issuedAt": {
+                    "type": "string"
+                },
+                "scopes": {
+                    "type": "array",
+                    "items": {
+                        "type": "string"
+                    }
+                },
+                "userAgent": {
+                    "type": "string"
+                }
+            }
+        },
         "routes.UserGet": {
             "type": "object",
             "properties": {
+                "avatarUrl": {
+                    "type": "string"
+                },
+                "bio": {
+                    "type": "string"
+                },
+                "deleted": {
+                    "description": "Deleted accounts keep their id but have no username nor public key.",
+                    "type": "boolean"
+                },
+                "displayName": {
+                    "type": "string"
+                },
                 "id": {
                     "type": "integer"
                 },
+                "lastSeenAt": {
+                    "type": "string"
+                },
                 "publicKey": {
                     "type": "string"
                 },
+                "statusText": {
+                    "type": "string"
+                },
                 "username": {
                     "type": "string"
                 }
@@ -392,7 +1895,7 @@ const docTemplate = `{
             "in": "header"
         },
         "X-User": {
-            "description": "Type the username to correctly authenticate.",
+            "description": "Optional, kept for backward compatibility. When set it must match the token's user.",
             "type": "apiKey",
             "name": "X-User",
             "in": "header"
@@ -407,7 +1910,7 @@ var SwaggerInfo = &swag.Spec{
 	BasePath:         "/",
 	Schemes:          []string{},
 	Title:            "Enigma chat API",
-	Description:      "Api to serv messaging securly",
+	Description:      "API to serve messaging securely",
 	InfoInstanceName: "swagger",
 	SwaggerTemplate:  docTemplate,
 	LeftDelim:        "{{",
@@ -0,0 +1,221 @@
+// Package jwt implements the signed, self-contained access tokens of the API.
+//
+// Tokens are compact JWTs signed with HMAC-SHA256. The signing key is picked
+// from a Keyring whose first key signs new tokens while the others are only
+// used for verification, so keys can be rotated without invalidating the
+// tokens already issued.
+package jwt
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+)
+
+var (
+	ErrMalformed  = errors.New("malformed token")
+	ErrUnknownKey = errors.New("unknown signing key")
+	ErrSignature  = errors.New("invalid token signature")
+	ErrExpired    = errors.New("token has expired")
+)
+
+// Claims is the payload carried by a token.
+type Claims struct {
+	ID        string `json:"jti"`
+	UserID    int    `json:"uid"`
+	Username  string `json:"sub"`
+	Device    string `json:"dev,omitempty"`
+	Scope     string `json:"scope,omitempty"`
+	IssuedAt  int64  `json:"iat"`
+	ExpiresAt int64  `json:"exp"`
+	// IssuedAtMicro is IssuedAt in microseconds, precise enough to tell a
+	// token issued right after a revocation from the ones it revoked.
+	IssuedAtMicro int64 `json:"iat_us,omitempty"`
+}
+
+// IssueTime returns the time the token was issued, to the microsecond when
+// the token says it.
+func (c Claims) IssueTime() time.Time {
+	if c.IssuedAtMicro != 0 {
+		return time.UnixMicro(c.IssuedAtMicro)
+	}
+	return time.Unix(c.IssuedAt, 0)
+}
+
+type header struct {
+	Algorithm string `json:"alg"`
+	Type      string `json:"typ"`
+	KeyID     string `json:"kid"`
+}
+
+// Key is a server signing key.
+type Key struct {
+	ID     string
+	Secret []byte
+}
+
+// GenerateKey returns a new random signing key.
+func GenerateKey() (Key, error) {
+	secret := make([]byte, 32)
+	if _, err := rand.Read(secret); err != nil {
+		return Key{}, fmt.Errorf("failed to generate signing key: %w", err)
+	}
+	id := make([]byte, 6)
+	if _, err := rand.Read(id); err != nil {
+		return Key{}, fmt.Errorf("failed to generate signing key id: %w", err)
+	}
+	return Key{ID: base64.RawURLEncoding.EncodeToString(id), Secret: secret}, nil
+}
+
+// ParseKeys parses a comma separated list of "id:base64secret" keys.
+func ParseKeys(s string) ([]Key, error) {
+	var keys []Key
+	for _, item := range strings.Split(s, ",") {
+		item = strings.TrimSpace(item)
+		if item == "" {
+			continue
+		}
+		id, encoded, ok := strings.Cut(item, ":")
+		if !ok || id == "" {
+			return nil, fmt.Errorf("invalid signing key %q, expected id:base64secret", item)
+		}
+		secret, err := base64.StdEncoding.DecodeString(encoded)
+		if err != nil {
+			return nil, fmt.Errorf("invalid signing key %q: %w", id, err)
+		}
+		if len(secret) < 32 {
+			return nil, fmt.Errorf("signing key %q must be at least 32 bytes", id)
+		}
+		keys = append(keys, Key{ID: id, Secret: secret})
+	}
+	if len(keys) == 0 {
+		return nil, errors.New("no signing key given")
+	}
+	return keys, nil
+}
+
+// Keyring holds the signing keys. The first key signs new tokens.
+type Keyring struct {
+	mu   sync.RWMutex
+	keys []Key
+}
+
+// NewKeyring returns a keyring using keys[0] as the active key.
+func NewKeyring(keys ...Key) (*Keyring, error) {
+	if len(keys) == 0 {
+		return nil, errors.New("a keyring needs at least one key")
+	}
+	return &Keyring{keys: keys}, nil
+}
+
+// Rotate makes key the active signing key. The previous keys are kept for
+// verification, up to keep of them.
+func (k *Keyring) Rotate(key Key, keep int) {
+	k.mu.Lock()
+	defer k.mu.Unlock()
+
+	keys := append([]Key{key}, k.keys...)
+	if len(keys) > keep+1 {
+		keys = keys[:keep+1]
+	}
+	k.keys = keys
+}
+
+func (k *Keyring) active() Key {
+	k.mu.RLock()
+	defer k.mu.RUnlock()
+
+	return k.keys[0]
+}
+
+func (k *Keyring) lookup(id string) (Key, bool) {
+	k.mu.RLock()
+	defer k.mu.RUnlock()
+
+	for _, key := range k.keys {
+		if key.ID == id {
+			return key, true
+		}
+	}
+	return Key{}, false
+}
+
+// Sign returns the signed token for claims.
+func (k *Keyring) Sign(claims Claims) (string, error) {
+	key := k.active()
+
+	headerJSON, err := json.Marshal(header{Algorithm: "HS256", Type: "JWT", KeyID: key.ID})
+	if err != nil {
+		return "", err
+	}
+	claimsJSON, err := json.Marshal(claims)
+	if err != nil {
+		return "", err
+	}
+
+	signingInput := base64.RawURLEncoding.EncodeToString(headerJSON) + "." + base64.RawURLEncoding.EncodeToString(claimsJSON)
+	return signingInput + "." + base64.RawURLEncoding.EncodeToString(sign(key, signingInput)), nil
+}
+
+// Verify checks the signature and expiry of token and returns its claims.
+func (k *Keyring) Verify(token string, now time.Time) (Claims, error) {
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return Claims{}, ErrMalformed
+	}
+
+	headerJSON, err := base64.RawURLEncoding.DecodeString(parts[0])
+	if err != nil {
+		return Claims{}, ErrMalformed
+	}
+	var h header
+	if err := json.Unmarshal(headerJSON, &h); err != nil || h.Algorithm != "HS256" {
+		return Claims{}, ErrMalformed
+	}
+
+	key, ok := k.lookup(h.KeyID)
+	if !ok {
+		return Claims{}, ErrUnknownKey
+	}
+
+	signature, err := base64.RawURLEncoding.DecodeString(parts[2])
+	if err != nil {
+		return Claims{}, ErrMalformed
+	}
+	if !hmac.Equal(signature, sign(key, parts[0]+"."+parts[1])) {
+		return Claims{}, ErrSignature
+	}
+
+	claimsJSON, err := base64.RawURLEncoding.DecodeString(parts[1])
+	if err != nil {
+		return Claims{}, ErrMalformed
+	}
+	var claims Claims
+	if err := json.Unmarshal(claimsJSON, &claims); err != nil {
+		return Claims{}, ErrMalformed
+	}
+	if now.Unix() >= claims.ExpiresAt {
+		return Claims{}, ErrExpired
+	}
+
+	return claims, nil
+}
+
+// IsToken reports whether token looks like a signed token rather than an
+// opaque one.
+func IsToken(token string) bool {
+	return strings.Count(token, ".") == 2
+}
+
+func sign(key Key, signingInput string) []byte {
+	mac := hmac.New(sha256.New, key.Secret)
+	mac.Write([]byte(signingInput))
+	return mac.Sum(nil)
+}
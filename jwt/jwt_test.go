@@ -0,0 +1,157 @@
+package jwt
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+)
+
+func newKeyring(t *testing.T) *Keyring {
+	t.Helper()
+	key, err := GenerateKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+	keys, err := NewKeyring(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return keys
+}
+
+func testClaims(now time.Time) Claims {
+	return Claims{
+		ID:            "id",
+		UserID:        1,
+		Username:      "alice",
+		Scope:         "messages:read",
+		IssuedAt:      now.Unix(),
+		ExpiresAt:     now.Add(time.Hour).Unix(),
+		IssuedAtMicro: now.UnixMicro(),
+	}
+}
+
+// encode returns the base64url JSON encoding of v, as in a token.
+func encode(t *testing.T, v any) string {
+	t.Helper()
+	content, err := json.Marshal(v)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return base64.RawURLEncoding.EncodeToString(content)
+}
+
+func TestVerify(t *testing.T) {
+	now := time.Now()
+	keys := newKeyring(t)
+	token, err := keys.Sign(testClaims(now))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !IsToken(token) {
+		t.Errorf("%q does not look like a signed token", token)
+	}
+
+	claims, err := keys.Verify(token, now)
+	if err != nil || claims != testClaims(now) {
+		t.Fatalf("claims %+v, %v", claims, err)
+	}
+	if !claims.IssueTime().Equal(time.UnixMicro(now.UnixMicro())) {
+		t.Errorf("issue time %v, want %v to the microsecond", claims.IssueTime(), now)
+	}
+
+	parts := strings.Split(token, ".")
+	kid := keys.active().ID
+	forged := testClaims(now)
+	forged.UserID = 2
+	for name, test := range map[string]struct {
+		token string
+		err   error
+	}{
+		"alg none":            {encode(t, header{Algorithm: "none", Type: "JWT", KeyID: kid}) + "." + parts[1] + ".", ErrMalformed},
+		"alg RS256":           {encode(t, header{Algorithm: "RS256", Type: "JWT", KeyID: kid}) + "." + parts[1] + "." + parts[2], ErrMalformed},
+		"tampered claims":     {parts[0] + "." + encode(t, forged) + "." + parts[2], ErrSignature},
+		"tampered signature":  {parts[0] + "." + parts[1] + "." + base64.RawURLEncoding.EncodeToString(make([]byte, 32)), ErrSignature},
+		"unknown key":         {encode(t, header{Algorithm: "HS256", Type: "JWT", KeyID: "other"}) + "." + parts[1] + "." + parts[2], ErrUnknownKey},
+		"missing signature":   {parts[0] + "." + parts[1], ErrMalformed},
+		"malformed signature": {parts[0] + "." + parts[1] + ".!", ErrMalformed},
+	} {
+		if _, err := keys.Verify(test.token, now); !errors.Is(err, test.err) {
+			t.Errorf("%s: %v, want %v", name, err, test.err)
+		}
+	}
+}
+
+func TestVerifyExpiry(t *testing.T) {
+	now := time.Now()
+	keys := newKeyring(t)
+	token, err := keys.Sign(testClaims(now))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := keys.Verify(token, now.Add(time.Hour-time.Second)); err != nil {
+		t.Errorf("a second before the expiry: %v", err)
+	}
+	for _, at := range []time.Time{now.Add(time.Hour), now.Add(2 * time.Hour)} {
+		if _, err := keys.Verify(token, at); !errors.Is(err, ErrExpired) {
+			t.Errorf("%s after the issue: %v, want ErrExpired", at.Sub(now), err)
+		}
+	}
+}
+
+func TestVerifyAfterRotation(t *testing.T) {
+	now := time.Now()
+	keys := newKeyring(t)
+	first, err := keys.Sign(testClaims(now))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// the previous keys still verify, up to keep of them
+	for i := 0; i < 2; i++ {
+		key, err := GenerateKey()
+		if err != nil {
+			t.Fatal(err)
+		}
+		keys.Rotate(key, 2)
+		if _, err := keys.Verify(first, now); err != nil {
+			t.Fatalf("after %d rotations: %v", i+1, err)
+		}
+	}
+	second, err := keys.Sign(testClaims(now))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Split(second, ".")[0] == strings.Split(first, ".")[0] {
+		t.Error("the rotated key does not sign the new tokens")
+	}
+
+	key, err := GenerateKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+	keys.Rotate(key, 2)
+	if _, err := keys.Verify(first, now); !errors.Is(err, ErrUnknownKey) {
+		t.Errorf("after 3 rotations keeping 2 keys: %v, want ErrUnknownKey", err)
+	}
+	if _, err := keys.Verify(second, now); err != nil {
+		t.Errorf("token of the previous key: %v", err)
+	}
+}
+
+func TestParseKeys(t *testing.T) {
+	secret := base64.StdEncoding.EncodeToString(make([]byte, 32))
+	keys, err := ParseKeys(" a:" + secret + ", b:" + secret + ",")
+	if err != nil || len(keys) != 2 || keys[0].ID != "a" || keys[1].ID != "b" {
+		t.Errorf("keys %+v, %v", keys, err)
+	}
+	for _, invalid := range []string{"", "a", ":" + secret, "a:" + base64.StdEncoding.EncodeToString(make([]byte, 16)), "a:not base64"} {
+		if _, err := ParseKeys(invalid); err == nil {
+			t.Errorf("%q parsed", invalid)
+		}
+	}
+}
@@ -1,14 +1,25 @@
 package main
 
 import (
+	"context"
+	"database/sql"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"maps"
+	"os"
+	"os/signal"
+	"slices"
+	"strings"
+	"syscall"
+
+	"github.com/adrienchanove/alpha-enigma-api/app"
+	"github.com/adrienchanove/alpha-enigma-api/atrest"
+	"github.com/adrienchanove/alpha-enigma-api/config"
 	"github.com/adrienchanove/alpha-enigma-api/database"
-	"github.com/adrienchanove/alpha-enigma-api/routes"
+	"github.com/adrienchanove/alpha-enigma-api/store"
 	"github.com/gin-gonic/gin"
-
-	swaggerFiles "github.com/swaggo/files"
-	ginSwagger "github.com/swaggo/gin-swagger"
-
-	_ "github.com/adrienchanove/alpha-enigma-api/docs"
 )
 
 // @title           Enigma chat API
@@ -32,36 +43,232 @@ import (
 // @securitydefinitions.apikey X-User
 // @in header
 // @name X-User
-// @description Type the username to correctly authenticate.
+// @description Optional, kept for backward compatibility. When set it must match the token's user.
 
 func main() {
-	router := gin.Default()
+	migrateDryRun := flag.Bool("migrate-dry-run", false, "check and list the pending database migrations, then exit")
+	generateKey := flag.Bool("generate-encryption-key", false, "print a new encryption key for ENIGMA_ENCRYPTION_KEYS, then exit")
+	reencrypt := flag.Bool("reencrypt", false, "encrypt the metadata with the first key of ENIGMA_ENCRYPTION_KEYS, then exit")
+	decrypt := flag.Bool("decrypt", false, "decrypt the metadata with ENIGMA_ENCRYPTION_KEYS to turn the encryption off, then exit")
+	encryptionStatus := flag.Bool("encryption-status", false, "count the metadata values by encryption key, then exit")
+	backup := flag.String("backup", "", "back the SQLite database up into a new file, then exit; the server may be running")
+	restore := flag.String("restore", "", "restore the SQLite database from a backup file, then exit; the server must be stopped")
+	flags := config.AddFlags(flag.CommandLine)
+	flag.Parse()
+	if err := checkActionFlags(flag.CommandLine); err != nil {
+		log.Fatal(err)
+	}
 
-	// Ajout de la route pour Swagger UI
-	router.GET("/doc/*any", ginSwagger.WrapHandler(swaggerFiles.Handler))
+	if *generateKey {
+		key, err := atrest.GenerateKey()
+		if err != nil {
+			log.Fatal(err)
+		}
+		fmt.Println(key)
+		return
+	}
 
-	// / redirect to /doc/index.html
-	router.GET("/", func(c *gin.Context) {
-		c.Redirect(301, "/doc/index.html")
-	})
-	// 404
-	router.NoRoute(func(c *gin.Context) {
-		c.JSON(404, gin.H{"code": "PAGE_NOT_FOUND", "message": "Page not found"})
+	cfg, err := flags.Load()
+	if err != nil {
+		log.Fatalf("invalid configuration:\n%v", err)
+	}
+
+	switch {
+	case *migrateDryRun:
+		dryRunMigrations(cfg)
+		return
+	case *reencrypt, *decrypt:
+		reencryptMetadata(cfg, *decrypt)
+		return
+	case *encryptionStatus:
+		printEncryptionStatus(cfg)
+		return
+	case *backup != "":
+		backupDatabase(cfg, *backup)
+		return
+	case *restore != "":
+		restoreDatabase(cfg, *restore)
+		return
+	}
+
+	// gin only prints its debug messages, such as the routes, in debug
+	gin.SetMode(gin.ReleaseMode)
+	if cfg.LogLevel == "debug" {
+		gin.SetMode(gin.DebugMode)
+	}
+	appConfig, err := cfg.App()
+	if err != nil {
+		log.Fatal(err)
+	}
+	server, err := app.New(appConfig)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	// SIGINT or SIGTERM, as sent by docker stop, drain the requests in flight
+	// before the database is closed; a second one exits right away
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	go func() {
+		<-ctx.Done()
+		stop()
+	}()
+	err = server.Run(ctx, cfg.Listen)
+	if closeErr := server.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		log.Fatal(err)
+	}
+	log.Println("stopped")
+}
+
+// actionFlags run an action instead of the server, then exit.
+var actionFlags = []string{"migrate-dry-run", "generate-encryption-key", "reencrypt", "decrypt", "encryption-status", "backup", "restore"}
+
+// actionSettings are the flags the actions read, those of the configuration
+// and the database; the others only configure the server.
+var actionSettings = []string{"config", "database", "log-level"}
+
+// checkActionFlags returns an error when the flags set in fs ask for several
+// actions, or for an action and a server setting it would ignore.
+func checkActionFlags(fs *flag.FlagSet) error {
+	var actions, serverFlags []string
+	fs.Visit(func(f *flag.Flag) {
+		switch {
+		case slices.Contains(actionFlags, f.Name):
+			actions = append(actions, "-"+f.Name)
+		case !slices.Contains(actionSettings, f.Name):
+			serverFlags = append(serverFlags, "-"+f.Name)
+		}
 	})
+	switch {
+	case len(actions) > 1:
+		return fmt.Errorf("%s cannot be combined", strings.Join(actions, " and "))
+	case len(actions) == 1 && len(serverFlags) > 0:
+		return fmt.Errorf("%s runs instead of the server, it cannot be combined with %s", actions[0], strings.Join(serverFlags, ", "))
+	}
+	return nil
+}
+
+// dryRunMigrations prints the migrations the database is missing and the
+// data they could not convert, after checking they apply in a transaction
+// that is rolled back.
+func dryRunMigrations(cfg config.Config) {
+	opts := cfg.DatabaseOptions()
+	db, err := database.Open(opts)
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer db.Close()
 
-	// Initialize the database
-	database.InitDB("./alpha-enigma.db")
-	db := database.DB
+	if err := printPendingMigrations(os.Stdout, db, opts.Dialect()); err != nil {
+		log.Fatal(err)
+	}
+}
+
+func printPendingMigrations(w io.Writer, db *sql.DB, dialect database.Dialect) error {
+	version, err := database.SchemaVersion(db, dialect)
+	if err != nil {
+		return err
+	}
+	pending, err := database.Migrate(db, dialect, true)
+	if err != nil {
+		return err
+	}
+
+	fmt.Fprintf(w, "schema version %d, %d pending migrations\n", version, len(pending))
+	for _, m := range pending {
+		fmt.Fprintf(w, "%04d %s\n", m.Version, m.Name)
+		for _, warning := range m.Warnings {
+			fmt.Fprintf(w, "  WARNING: %s\n", warning)
+		}
+	}
+	return nil
+}
+
+// reencryptMetadata rewrites the metadata with the first encryption key, or
+// in plaintext when decrypt is set, then prints the keys still in use.
+func reencryptMetadata(cfg config.Config, decrypt bool) {
+	keys, err := cfg.EncryptionKeyring()
+	if err != nil {
+		log.Fatal(err)
+	}
+	if keys == nil {
+		log.Fatal("ENIGMA_ENCRYPTION_KEYS must hold the keys in use")
+	}
+	opts := cfg.DatabaseOptions()
+	db, err := database.InitDB(opts)
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer db.Close()
+
+	rewritten, err := store.Reencrypt(context.Background(), db, opts.Dialect(), keys, decrypt)
+	if err != nil {
+		log.Fatal(err)
+	}
+	fmt.Printf("%d rows rewritten\n", rewritten)
+	printKeyUsage(db)
+}
 
-	// public routes
-	routes.SetupAuthRoutes(router, db)
-	routes.SetupPublicUserRoutes(router, db)
+// printEncryptionStatus prints the keys the metadata is encrypted with.
+func printEncryptionStatus(cfg config.Config) {
+	db, err := database.Open(cfg.DatabaseOptions())
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer db.Close()
 
-	// private routes
-	router.Use(routes.AuthMiddleware())
+	printKeyUsage(db)
+}
+
+func printKeyUsage(db *sql.DB) {
+	usage, err := store.KeyUsage(context.Background(), db)
+	if err != nil {
+		log.Fatal(err)
+	}
+	ids := slices.Sorted(maps.Keys(usage))
+	for _, id := range ids {
+		if id == "" {
+			fmt.Printf("plaintext: %d values\n", usage[id])
+		} else {
+			fmt.Printf("key %s: %d values\n", id, usage[id])
+		}
+	}
+}
+
+// backupDatabase backs the database up into the new file path.
+func backupDatabase(cfg config.Config, path string) {
+	opts := cfg.DatabaseOptions()
+	if opts.Dialect() != database.SQLite {
+		log.Fatal(database.ErrBackupUnsupported)
+	}
+	db, err := database.Open(opts)
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer db.Close()
+
+	if err := database.Backup(context.Background(), db, opts.Dialect(), path); err != nil {
+		log.Fatal(err)
+	}
+	fmt.Printf("backed up into %s\n", path)
+}
 
-	routes.SetupUserRoutes(router, db)
-	routes.SetupMessageRoutes(router, db)
+// restoreDatabase replaces the database with the backup at path.
+func restoreDatabase(cfg config.Config, path string) {
+	opts := cfg.DatabaseOptions()
+	if opts.Dialect() != database.SQLite {
+		log.Fatal(database.ErrBackupUnsupported)
+	}
+	db, err := database.Open(opts)
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer db.Close()
 
-	router.Run("localhost:8080")
+	if err := database.Restore(context.Background(), db, opts.Dialect(), path); err != nil {
+		log.Fatal(err)
+	}
+	fmt.Printf("restored from %s\n", path)
 }
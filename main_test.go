@@ -0,0 +1,78 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/adrienchanove/alpha-enigma-api/config"
+	"github.com/adrienchanove/alpha-enigma-api/database"
+)
+
+func TestDryRunMigrationsPrintsConflicts(t *testing.T) {
+	content, err := os.ReadFile("database/testdata/baseline.db")
+	if err != nil {
+		t.Fatal(err)
+	}
+	path := filepath.Join(t.TempDir(), "baseline.db")
+	if err := os.WriteFile(path, content, 0o600); err != nil {
+		t.Fatal(err)
+	}
+	db, err := database.Open(database.Options{DSN: path})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	migrations, err := database.Migrations(database.SQLite)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var out strings.Builder
+	if err := printPendingMigrations(&out, db, database.SQLite); err != nil {
+		t.Fatal(err)
+	}
+	for _, want := range []string{
+		fmt.Sprintf("schema version 0, %d pending migrations\n0001 initial\n", len(migrations)),
+		"0008 username_rules\n" +
+			`  WARNING: user 2 "Alice" has the username of user 1 "alice" ignoring case, it cannot get a token until an administrator changes one of the usernames in the database or deletes one of the accounts` + "\n" +
+			`  WARNING: user 2 "Alice" looks like user 1 "alice", its username is not protected from look-alikes` + "\n" +
+			`  WARNING: user 4 "b0b" looks like user 3 "bob", its username is not protected from look-alikes` + "\n" +
+			"0009 username_rules_indexes\n",
+	} {
+		if !strings.Contains(out.String(), want) {
+			t.Errorf("dry run output:\n%s\nwant:\n%s", out.String(), want)
+		}
+	}
+}
+
+func TestCheckActionFlags(t *testing.T) {
+	for args, valid := range map[string]bool{
+		"":                                    true,
+		"-listen :8080 -swagger":              true,
+		"-backup backup.db":                   true,
+		"-backup backup.db -database test.db": true,
+		"-restore backup.db -config enigma.json -log-level debug": true,
+		"-backup backup.db -listen :8080":                         false,
+		"-restore backup.db -tls-self-signed":                     false,
+		"-reencrypt -swagger":                                     false,
+		"-backup backup.db -restore backup.db":                    false,
+		"-reencrypt -decrypt":                                     false,
+	} {
+		fs := flag.NewFlagSet("test", flag.ContinueOnError)
+		fs.Bool("reencrypt", false, "")
+		fs.Bool("decrypt", false, "")
+		fs.String("backup", "", "")
+		fs.String("restore", "", "")
+		config.AddFlags(fs)
+		if err := fs.Parse(strings.Fields(args)); err != nil {
+			t.Fatal(err)
+		}
+		if err := checkActionFlags(fs); (err == nil) != valid {
+			t.Errorf("%q: %v, want valid %v", args, err, valid)
+		}
+	}
+}
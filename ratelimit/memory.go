@@ -0,0 +1,71 @@
+package ratelimit
+
+import (
+	"context"
+	"math"
+	"sync"
+	"time"
+)
+
+// pruneInterval is how often full buckets are dropped from a MemoryStore.
+const pruneInterval = time.Minute
+
+type bucket struct {
+	tokens    float64
+	updatedAt time.Time
+	// fullAt is when the bucket is full again and can be forgotten.
+	fullAt time.Time
+}
+
+// MemoryStore keeps the buckets in process.
+type MemoryStore struct {
+	mu       sync.Mutex
+	buckets  map[string]*bucket
+	prunedAt time.Time
+}
+
+func NewMemoryStore() *MemoryStore {
+	return &MemoryStore{buckets: make(map[string]*bucket)}
+}
+
+func (s *MemoryStore) Take(ctx context.Context, key string, policy Policy, now time.Time) (Result, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if now.Sub(s.prunedAt) > pruneInterval {
+		for k, b := range s.buckets {
+			if !now.Before(b.fullAt) {
+				delete(s.buckets, k)
+			}
+		}
+		s.prunedAt = now
+	}
+
+	limit := float64(policy.Limit)
+	rate := policy.rate()
+
+	b, ok := s.buckets[key]
+	if !ok {
+		b = &bucket{tokens: limit}
+		s.buckets[key] = b
+	} else {
+		b.tokens = math.Min(limit, b.tokens+now.Sub(b.updatedAt).Seconds()*rate)
+	}
+	b.updatedAt = now
+
+	var result Result
+	if b.tokens >= 1 {
+		b.tokens--
+		result.Allowed = true
+	} else {
+		result.RetryAfter = duration((1 - b.tokens) / rate)
+	}
+	result.Remaining = int(b.tokens)
+	result.Reset = duration((limit - b.tokens) / rate)
+	b.fullAt = now.Add(result.Reset)
+	return result, nil
+}
+
+func duration(seconds float64) time.Duration {
+	return time.Duration(seconds * float64(time.Second))
+}
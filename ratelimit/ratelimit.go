@@ -0,0 +1,159 @@
+// Package ratelimit limits requests with token buckets.
+//
+// Each bucket holds up to Policy.Limit tokens and refills Limit tokens per
+// Period, so a client may burst Limit requests and then keep a steady pace.
+// Buckets are kept by a Store: MemoryStore suits a single instance, several
+// instances need a shared Store.
+package ratelimit
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"math"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Policy is a token bucket configuration.
+type Policy struct {
+	// Name keeps the buckets of each policy apart in a shared store.
+	Name   string
+	Limit  int
+	Period time.Duration
+}
+
+// Validate checks the policy limits something: a name, a positive limit and
+// a period of at least a second.
+func (p Policy) Validate() error {
+	switch {
+	case p.Name == "":
+		return errors.New("rate limit without name")
+	case p.Limit <= 0:
+		return fmt.Errorf("rate limit %s: limit must be a positive number, got %d", p.Name, p.Limit)
+	case p.Period < time.Second:
+		return fmt.Errorf("rate limit %s: period must be a duration of 1s or more, got %s", p.Name, p.Period)
+	}
+	return nil
+}
+
+// rate returns the number of tokens refilled per second.
+func (p Policy) rate() float64 {
+	return float64(p.Limit) / p.Period.Seconds()
+}
+
+func (p Policy) String() string {
+	return fmt.Sprintf("%d;w=%d", p.Limit, int(p.Period/time.Second))
+}
+
+// Result is the state of a bucket after a request was counted.
+type Result struct {
+	Allowed   bool
+	Remaining int
+	// Reset is how long until the bucket is full again.
+	Reset time.Duration
+	// RetryAfter is how long until the next request is allowed, 0 when it is.
+	RetryAfter time.Duration
+}
+
+// Store keeps the buckets.
+type Store interface {
+	// Take counts a request against the bucket of key under policy.
+	Take(ctx context.Context, key string, policy Policy, now time.Time) (Result, error)
+}
+
+// KeyFunc returns the bucket key of a request, requests with an empty key
+// are not limited.
+type KeyFunc func(c *gin.Context) string
+
+// KeyByIP limits each client IP.
+func KeyByIP(c *gin.Context) string {
+	return "ip:" + c.ClientIP()
+}
+
+// KeyGlobal limits all the clients together.
+func KeyGlobal(c *gin.Context) string {
+	return "global"
+}
+
+// LimitFunc is called when a request exceeds policy, with the request key.
+type LimitFunc func(c *gin.Context, policy Policy, key string)
+
+// Middleware answers 429 Too Many Requests with a Retry-After header once
+// the bucket of the request is empty, after calling onLimit if not nil. The
+// RateLimit-Limit, RateLimit-Remaining, RateLimit-Reset and RateLimit-Policy
+// headers describe the bucket. When the store fails, requests are let through.
+func Middleware(store Store, policy Policy, key KeyFunc, onLimit LimitFunc) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		k := key(c)
+		if k == "" {
+			c.Next()
+			return
+		}
+
+		result, err := store.Take(c.Request.Context(), policy.Name+":"+k, policy, time.Now())
+		if err != nil {
+			log.Println(err)
+			c.Next()
+			return
+		}
+
+		c.Header("RateLimit-Limit", strconv.Itoa(policy.Limit))
+		c.Header("RateLimit-Remaining", strconv.Itoa(result.Remaining))
+		c.Header("RateLimit-Reset", strconv.Itoa(seconds(result.Reset)))
+		c.Header("RateLimit-Policy", policy.String())
+		if !result.Allowed {
+			if onLimit != nil {
+				onLimit(c, policy, k)
+			}
+			c.Header("Retry-After", strconv.Itoa(seconds(result.RetryAfter)))
+			c.AbortWithStatusJSON(http.StatusTooManyRequests, gin.H{"error": "too many requests, retry later"})
+			return
+		}
+		c.Next()
+	}
+}
+
+// seconds rounds d up to whole seconds.
+func seconds(d time.Duration) int {
+	return int(math.Ceil(d.Seconds()))
+}
+
+// ParsePolicies parses comma separated "name=limit/period" policies, such as
+// "messages=60/1m,token-ip=10/1m".
+func ParsePolicies(s string) ([]Policy, error) {
+	var policies []Policy
+	for _, entry := range strings.Split(s, ",") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+		name, spec, ok := strings.Cut(entry, "=")
+		limit, period, ok2 := strings.Cut(spec, "/")
+		if !ok || !ok2 || name == "" {
+			return nil, fmt.Errorf("invalid rate limit %q, expected name=limit/period", entry)
+		}
+		n, err := strconv.Atoi(limit)
+		if err != nil {
+			return nil, fmt.Errorf("invalid rate limit %q: limit must be a positive number", entry)
+		}
+		d, err := time.ParseDuration(period)
+		if err != nil {
+			return nil, fmt.Errorf("invalid rate limit %q: period must be a duration of 1s or more", entry)
+		}
+		policy := Policy{Name: name, Limit: n, Period: d}
+		if err := policy.Validate(); err != nil {
+			return nil, err
+		}
+		policies = append(policies, policy)
+	}
+	if len(policies) == 0 {
+		return nil, errors.New("no rate limit given")
+	}
+	return policies, nil
+}
@@ -0,0 +1,137 @@
+package ratelimit
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+func take(t *testing.T, s *MemoryStore, key string, policy Policy, now time.Time) Result {
+	t.Helper()
+	result, err := s.Take(context.Background(), key, policy, now)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return result
+}
+
+func TestBurst(t *testing.T) {
+	s := NewMemoryStore()
+	policy := Policy{Name: "test", Limit: 3, Period: time.Minute}
+	now := time.Now()
+
+	for i := 2; i >= 0; i-- {
+		if result := take(t, s, "a", policy, now); !result.Allowed || result.Remaining != i {
+			t.Fatalf("request %d: %+v, want allowed with %d remaining", 3-i, result, i)
+		}
+	}
+	result := take(t, s, "a", policy, now)
+	if result.Allowed || result.RetryAfter != 20*time.Second || result.Reset != time.Minute {
+		t.Errorf("past the burst: %+v, want denied, retry after 20s and reset in 1m", result)
+	}
+}
+
+func TestRefill(t *testing.T) {
+	s := NewMemoryStore()
+	policy := Policy{Name: "test", Limit: 3, Period: time.Minute}
+	now := time.Now()
+	for i := 0; i < 3; i++ {
+		take(t, s, "a", policy, now)
+	}
+
+	// a token every 20s
+	if result := take(t, s, "a", policy, now.Add(19*time.Second)); result.Allowed {
+		t.Errorf("19s later: %+v, want denied", result)
+	}
+	if result := take(t, s, "a", policy, now.Add(20*time.Second)); !result.Allowed || result.Remaining != 0 {
+		t.Errorf("20s later: %+v, want allowed with 0 remaining", result)
+	}
+	// the bucket refills up to the limit, not beyond
+	later := now.Add(time.Hour)
+	for i := 0; i < 3; i++ {
+		if result := take(t, s, "a", policy, later); !result.Allowed {
+			t.Fatalf("request %d an hour later: %+v, want allowed", i+1, result)
+		}
+	}
+	if result := take(t, s, "a", policy, later); result.Allowed {
+		t.Errorf("an hour later, past the limit: %+v, want denied", result)
+	}
+}
+
+func TestKeysAreIsolated(t *testing.T) {
+	s := NewMemoryStore()
+	policy := Policy{Name: "test", Limit: 1, Period: time.Minute}
+	now := time.Now()
+
+	take(t, s, "a", policy, now)
+	if result := take(t, s, "a", policy, now); result.Allowed {
+		t.Fatalf("second request of a: %+v, want denied", result)
+	}
+	if result := take(t, s, "b", policy, now); !result.Allowed {
+		t.Errorf("first request of b: %+v, want allowed", result)
+	}
+}
+
+func TestMiddleware(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	router := gin.New()
+	var limited []string
+	onLimit := func(c *gin.Context, policy Policy, key string) { limited = append(limited, key) }
+	router.GET("/", Middleware(NewMemoryStore(), Policy{Name: "test", Limit: 1, Period: time.Minute}, KeyByIP, onLimit), func(c *gin.Context) {
+		c.Status(http.StatusNoContent)
+	})
+
+	get := func(ip string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest("GET", "/", nil)
+		req.RemoteAddr = ip + ":1234"
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+		return w
+	}
+	if w := get("192.0.2.1"); w.Code != http.StatusNoContent || w.Header().Get("RateLimit-Policy") != "1;w=60" {
+		t.Fatalf("first request: status %d, policy %q", w.Code, w.Header().Get("RateLimit-Policy"))
+	}
+	if w := get("192.0.2.1"); w.Code != http.StatusTooManyRequests || w.Header().Get("Retry-After") != "60" {
+		t.Errorf("second request: status %d, retry after %q, want 429 after 60s", w.Code, w.Header().Get("Retry-After"))
+	}
+	if w := get("192.0.2.2"); w.Code != http.StatusNoContent {
+		t.Errorf("request of another IP: status %d", w.Code)
+	}
+	if len(limited) != 1 || limited[0] != "ip:192.0.2.1" {
+		t.Errorf("limited keys %v, want [ip:192.0.2.1]", limited)
+	}
+}
+
+func TestPolicyValidate(t *testing.T) {
+	for _, policy := range []Policy{
+		{Limit: 1, Period: time.Minute},
+		{Name: "test", Limit: 0, Period: time.Minute},
+		{Name: "test", Limit: -1, Period: time.Minute},
+		{Name: "test", Limit: 1, Period: 0},
+		{Name: "test", Limit: 1, Period: -time.Minute},
+		{Name: "test", Limit: 1, Period: time.Millisecond},
+	} {
+		if err := policy.Validate(); err == nil {
+			t.Errorf("%+v is valid", policy)
+		}
+	}
+	if err := (Policy{Name: "test", Limit: 1, Period: time.Second}).Validate(); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestParsePolicies(t *testing.T) {
+	policies, err := ParsePolicies(" messages=60/1m, token-ip=10/1h,")
+	if err != nil || len(policies) != 2 || policies[0] != (Policy{Name: "messages", Limit: 60, Period: time.Minute}) || policies[1] != (Policy{Name: "token-ip", Limit: 10, Period: time.Hour}) {
+		t.Errorf("policies %+v, %v", policies, err)
+	}
+	for _, invalid := range []string{"", "messages", "messages=60", "=60/1m", "messages=0/1m", "messages=-1/1m", "messages=x/1m", "messages=60/0s", "messages=60/-1m", "messages=60/1ms"} {
+		if _, err := ParsePolicies(invalid); err == nil {
+			t.Errorf("%q parsed", invalid)
+		}
+	}
+}
@@ -0,0 +1,165 @@
+package routes
+
+import (
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/base64"
+	"fmt"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/adrienchanove/alpha-enigma-api/store"
+	"github.com/gin-gonic/gin"
+)
+
+// What happens to the messages of a deleted account.
+const (
+	DeletedMessagesKeep      = store.DeletedMessagesKeep
+	DeletedMessagesTombstone = store.DeletedMessagesTombstone
+	DeletedMessagesPurge     = store.DeletedMessagesPurge
+)
+
+// checkDeletedMessagesPolicy checks policy is DeletedMessagesKeep,
+// DeletedMessagesTombstone or DeletedMessagesPurge.
+func checkDeletedMessagesPolicy(policy string) error {
+	switch policy {
+	case DeletedMessagesKeep, DeletedMessagesTombstone, DeletedMessagesPurge:
+		return nil
+	default:
+		return fmt.Errorf("invalid deleted messages policy %q, expected keep, tombstone or purge", policy)
+	}
+}
+
+// deletedUserName is shown in place of the name of deleted accounts.
+const deletedUserName = "Deleted user"
+
+// deletionChallengeLifetime is how long a deletion challenge can be answered.
+const deletionChallengeLifetime = 5 * time.Minute
+
+type deletionChallenge struct {
+	challenge string
+	expiresAt time.Time
+}
+
+type DeletionChallengeResponse struct {
+	EncryptedChallenge string    `json:"encryptedChallenge"`
+	ExpiresAt          time.Time `json:"expiresAt"`
+}
+
+type AccountDeletion struct {
+	Challenge string `json:"challenge"`
+}
+
+// consumeDeletionChallenge reports whether challenge is the pending deletion
+// challenge of userId. A challenge can only be tried once.
+func (a *API) consumeDeletionChallenge(userId int, challenge string) bool {
+	a.deletionChallengesMu.Lock()
+	defer a.deletionChallengesMu.Unlock()
+
+	pending, ok := a.deletionChallenges[userId]
+	delete(a.deletionChallenges, userId)
+	if !ok || time.Now().After(pending.expiresAt) {
+		return false
+	}
+	return subtle.ConstantTimeCompare([]byte(pending.challenge), []byte(challenge)) == 1
+}
+
+// requestDeletionChallenge godoc
+// @Summary Request an account deletion challenge
+// @Description Get a challenge encrypted with your public key, decrypt it with your private key and send it
+// @Description to DELETE /users/me within 5 minutes to delete your account
+// @Tags account
+// @Produce json
+// @Success 200 {object} DeletionChallengeResponse
+// @Security ApiKeyAuth
+// @Router /users/me/deletion-challenge [post]
+func (a *API) RequestDeletionChallenge() gin.HandlerFunc {
+	users := a.stores.Users
+	return func(c *gin.Context) {
+		userId := currentUserID(c)
+
+		user, err := users.Get(c.Request.Context(), userId)
+		if err != nil {
+			log.Println(err)
+			c.IndentedJSON(http.StatusInternalServerError, gin.H{"error": "failed to get user public key"})
+			return
+		}
+
+		nonce := make([]byte, 32)
+		if _, err := rand.Read(nonce); err != nil {
+			log.Println(err)
+			c.IndentedJSON(http.StatusInternalServerError, gin.H{"error": "failed to generate challenge"})
+			return
+		}
+		challenge := deletionChallenge{
+			challenge: base64.RawURLEncoding.EncodeToString(nonce),
+			expiresAt: time.Now().Add(deletionChallengeLifetime),
+		}
+
+		encryptedChallenge, err := encryptToken(challenge.challenge, user.PublicKey)
+		if err != nil {
+			log.Println(err)
+			c.IndentedJSON(http.StatusInternalServerError, gin.H{"error": "failed to encrypt challenge"})
+			return
+		}
+
+		a.deletionChallengesMu.Lock()
+		a.deletionChallenges[userId] = challenge
+		a.deletionChallengesMu.Unlock()
+
+		c.IndentedJSON(http.StatusOK, DeletionChallengeResponse{EncryptedChallenge: encryptedChallenge, ExpiresAt: challenge.expiresAt})
+	}
+}
+
+// deleteAccount godoc
+// @Summary Delete your account
+// @Description Delete the authenticated user's account, given the decrypted challenge from
+// @Description POST /users/me/deletion-challenge. All sessions are revoked and your profile, contacts and blocks
+// @Description are removed. Peers see you as a deleted user, your messages are kept, emptied or removed
+// @Description depending on the server policy
+// @Tags account
+// @Accept json
+// @Produce json
+// @Param deletion body AccountDeletion true "Decrypted challenge"
+// @Success 204
+// @Security ApiKeyAuth
+// @Router /users/me [delete]
+func (a *API) DeleteAccount() gin.HandlerFunc {
+	users := a.stores.Users
+	return func(c *gin.Context) {
+		var deletion AccountDeletion
+		if err := c.ShouldBindJSON(&deletion); err != nil {
+			c.IndentedJSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		if deletion.Challenge == "" {
+			c.IndentedJSON(http.StatusBadRequest, gin.H{"error": "challenge is required"})
+			return
+		}
+
+		userId := currentUserID(c)
+		if !a.consumeDeletionChallenge(userId, deletion.Challenge) {
+			c.IndentedJSON(http.StatusForbidden, gin.H{"error": "invalid or expired challenge"})
+			return
+		}
+
+		if err := users.Delete(c.Request.Context(), userId, a.deletedMessagesPolicy, time.Now()); err != nil {
+			log.Println(err)
+			c.IndentedJSON(http.StatusInternalServerError, gin.H{"error": "Failed to delete account"})
+			return
+		}
+		a.revokeAllUserTokens(c.Request.Context(), userId)
+		a.auditEvent(c, "user.deleted", userId, "")
+
+		c.Status(http.StatusNoContent)
+	}
+}
+
+func (a *API) setupAccountRoutes(router *gin.Engine) {
+	accountRoutes := router.Group("/users/me")
+	{
+		accountRoutes.POST("/deletion-challenge", RequireScope(ScopeUsersWrite), a.RequestDeletionChallenge())
+		accountRoutes.DELETE("", RequireScope(ScopeUsersWrite), a.DeleteAccount())
+	}
+}
@@ -0,0 +1,399 @@
+package routes
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/adrienchanove/alpha-enigma-api/store"
+	"github.com/gin-gonic/gin"
+)
+
+// User roles.
+const (
+	RoleUser  = "user"
+	RoleAdmin = "admin"
+)
+
+type AdminUser struct {
+	ID             int        `json:"id"`
+	Username       string     `json:"username"`
+	Role           string     `json:"role"`
+	SuspendedAt    *time.Time `json:"suspendedAt"`
+	ActiveSessions int        `json:"activeSessions"`
+}
+
+type RoleUpdate struct {
+	Role string `json:"role"`
+}
+
+type ServerStats struct {
+	Users          int `json:"users"`
+	Admins         int `json:"admins"`
+	SuspendedUsers int `json:"suspendedUsers"`
+	Messages       int `json:"messages"`
+	ActiveSessions int `json:"activeSessions"`
+	OpenReports    int `json:"openReports"`
+}
+
+// RequireAdmin is a middleware rejecting users who are not administrators.
+// The role is checked on every request so a demotion applies immediately.
+func (a *API) RequireAdmin() gin.HandlerFunc {
+	users := a.stores.Users
+	return func(c *gin.Context) {
+		user, err := users.Get(c.Request.Context(), currentUserID(c))
+		if err != nil && !errors.Is(err, store.ErrNotFound) {
+			log.Println(err)
+			c.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"error": "Failed to get user"})
+			return
+		}
+		if user.Role != RoleAdmin {
+			c.AbortWithStatusJSON(http.StatusForbidden, gin.H{"error": "admin role required"})
+			return
+		}
+
+		c.Next()
+	}
+}
+
+// adminUserID parses the user id path parameter of admin routes, writing the
+// error response when it is invalid.
+func adminUserID(c *gin.Context) (int, bool) {
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil || id <= 0 {
+		c.IndentedJSON(http.StatusBadRequest, gin.H{"error": "Invalid user ID"})
+		return 0, false
+	}
+	if id == currentUserID(c) {
+		c.IndentedJSON(http.StatusBadRequest, gin.H{"error": "you cannot moderate your own account"})
+		return 0, false
+	}
+	return id, true
+}
+
+// adminGetUsers godoc
+// @Summary List accounts
+// @Description List every account with its role, suspension and active sessions
+// @Tags admin
+// @Produce json
+// @Success 200 {array} AdminUser
+// @Security ApiKeyAuth
+// @Router /admin/users [get]
+func (a *API) AdminGetUsers() gin.HandlerFunc {
+	users := a.stores.Users
+	tokens := a.stores.Tokens
+	return func(c *gin.Context) {
+		accounts, err := users.ListAccounts(c.Request.Context())
+		if err != nil {
+			log.Println(err)
+			c.IndentedJSON(http.StatusInternalServerError, gin.H{"error": "Failed to get users"})
+			return
+		}
+
+		sessions, err := tokens.CountByUser(c.Request.Context())
+		if err != nil {
+			log.Println(err)
+			c.IndentedJSON(http.StatusInternalServerError, gin.H{"error": "Failed to get users"})
+			return
+		}
+
+		adminUsers := []AdminUser{}
+		for _, u := range accounts {
+			adminUsers = append(adminUsers, AdminUser{
+				ID:             u.ID,
+				Username:       u.Username,
+				Role:           u.Role,
+				SuspendedAt:    u.SuspendedAt,
+				ActiveSessions: sessions[u.ID],
+			})
+		}
+
+		c.IndentedJSON(http.StatusOK, adminUsers)
+	}
+}
+
+// adminSetRole godoc
+// @Summary Change the role of an account
+// @Description Promote an account to admin or demote it to user
+// @Tags admin
+// @Accept json
+// @Produce json
+// @Param id path int true "User ID"
+// @Param role body RoleUpdate true "New role"
+// @Success 204
+// @Security ApiKeyAuth
+// @Router /admin/users/{id}/role [put]
+func (a *API) AdminSetRole() gin.HandlerFunc {
+	users := a.stores.Users
+	return func(c *gin.Context) {
+		id, ok := adminUserID(c)
+		if !ok {
+			return
+		}
+
+		var update RoleUpdate
+		if err := c.ShouldBindJSON(&update); err != nil {
+			c.IndentedJSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		if update.Role != RoleUser && update.Role != RoleAdmin {
+			c.IndentedJSON(http.StatusBadRequest, gin.H{"error": "role must be user or admin"})
+			return
+		}
+
+		if updateUser(c, users.SetRole(c.Request.Context(), id, update.Role)) {
+			a.auditEvent(c, "admin.role_changed", currentUserID(c), fmt.Sprintf("target=%d role=%s", id, update.Role))
+		}
+	}
+}
+
+// adminSuspendUser godoc
+// @Summary Suspend an account
+// @Description Suspend an account and revoke all its sessions, it can no longer get a token
+// @Tags admin
+// @Produce json
+// @Param id path int true "User ID"
+// @Success 204
+// @Security ApiKeyAuth
+// @Router /admin/users/{id}/suspend [post]
+func (a *API) AdminSuspendUser() gin.HandlerFunc {
+	users := a.stores.Users
+	return func(c *gin.Context) {
+		id, ok := adminUserID(c)
+		if !ok {
+			return
+		}
+
+		if updateUser(c, users.Suspend(c.Request.Context(), id, time.Now())) {
+			a.revokeAllUserTokens(c.Request.Context(), id)
+			a.auditEvent(c, "admin.user_suspended", currentUserID(c), fmt.Sprintf("target=%d", id))
+		}
+	}
+}
+
+// adminUnsuspendUser godoc
+// @Summary Lift the suspension of an account
+// @Tags admin
+// @Produce json
+// @Param id path int true "User ID"
+// @Success 204
+// @Security ApiKeyAuth
+// @Router /admin/users/{id}/unsuspend [post]
+func (a *API) AdminUnsuspendUser() gin.HandlerFunc {
+	users := a.stores.Users
+	return func(c *gin.Context) {
+		id, ok := adminUserID(c)
+		if !ok {
+			return
+		}
+
+		if updateUser(c, users.Unsuspend(c.Request.Context(), id)) {
+			a.auditEvent(c, "admin.user_unsuspended", currentUserID(c), fmt.Sprintf("target=%d", id))
+		}
+	}
+}
+
+// updateUser writes the response of a user update which returned err. It
+// reports whether the user was updated.
+func updateUser(c *gin.Context, err error) bool {
+	if err != nil {
+		if errors.Is(err, store.ErrNotFound) {
+			c.IndentedJSON(http.StatusNotFound, gin.H{"error": "user not found"})
+		} else {
+			log.Println(err)
+			c.IndentedJSON(http.StatusInternalServerError, gin.H{"error": "Failed to update user"})
+		}
+		return false
+	}
+
+	c.Status(http.StatusNoContent)
+	return true
+}
+
+// adminDeleteUser godoc
+// @Summary Delete an account
+// @Description Delete an account like DELETE /users/me does, without challenge, and revoke all its sessions
+// @Tags admin
+// @Produce json
+// @Param id path int true "User ID"
+// @Success 204
+// @Security ApiKeyAuth
+// @Router /admin/users/{id} [delete]
+func (a *API) AdminDeleteUser() gin.HandlerFunc {
+	users := a.stores.Users
+	return func(c *gin.Context) {
+		id, ok := adminUserID(c)
+		if !ok {
+			return
+		}
+
+		if err := users.Delete(c.Request.Context(), id, a.deletedMessagesPolicy, time.Now()); err != nil {
+			if errors.Is(err, store.ErrNotFound) {
+				c.IndentedJSON(http.StatusNotFound, gin.H{"error": "user not found"})
+			} else {
+				log.Println(err)
+				c.IndentedJSON(http.StatusInternalServerError, gin.H{"error": "Failed to delete user"})
+			}
+			return
+		}
+		a.revokeAllUserTokens(c.Request.Context(), id)
+		a.auditEvent(c, "admin.user_deleted", currentUserID(c), fmt.Sprintf("target=%d", id))
+
+		c.Status(http.StatusNoContent)
+	}
+}
+
+// adminRevokeSessions godoc
+// @Summary Revoke the sessions of an account
+// @Description Force the revocation of every session of an account, signed tokens included
+// @Description Returns 404 when the account does not exist or is deleted
+// @Tags admin
+// @Produce json
+// @Param id path int true "User ID"
+// @Success 204
+// @Security ApiKeyAuth
+// @Router /admin/users/{id}/sessions [delete]
+func (a *API) AdminRevokeSessions() gin.HandlerFunc {
+	users := a.stores.Users
+	return func(c *gin.Context) {
+		id, ok := adminUserID(c)
+		if !ok {
+			return
+		}
+
+		exists, err := userExists(c.Request.Context(), users, id)
+		if err != nil {
+			log.Println(err)
+			c.IndentedJSON(http.StatusInternalServerError, gin.H{"error": "Failed to revoke sessions"})
+			return
+		}
+		if !exists {
+			c.IndentedJSON(http.StatusNotFound, gin.H{"error": "user not found"})
+			return
+		}
+
+		a.revokeAllUserTokens(c.Request.Context(), id)
+		a.auditEvent(c, "admin.sessions_revoked", currentUserID(c), fmt.Sprintf("target=%d", id))
+
+		c.Status(http.StatusNoContent)
+	}
+}
+
+// adminGetReports godoc
+// @Summary List abuse reports
+// @Description List abuse reports, the open ones by default
+// @Tags admin
+// @Produce json
+// @Param status query string false "open, resolved or all"
+// @Success 200 {array} Report
+// @Security ApiKeyAuth
+// @Router /admin/reports [get]
+func (a *API) AdminGetReports() gin.HandlerFunc {
+	reports := a.stores.Reports
+	return func(c *gin.Context) {
+		status := c.DefaultQuery("status", store.ReportsOpen)
+		switch status {
+		case store.ReportsOpen, store.ReportsResolved, store.ReportsAll:
+		default:
+			c.IndentedJSON(http.StatusBadRequest, gin.H{"error": "status must be open, resolved or all"})
+			return
+		}
+
+		stored, err := reports.List(c.Request.Context(), status)
+		if err != nil {
+			log.Println(err)
+			c.IndentedJSON(http.StatusInternalServerError, gin.H{"error": "Failed to get reports"})
+			return
+		}
+
+		list := []Report{}
+		for _, r := range stored {
+			list = append(list, newReport(r))
+		}
+
+		c.IndentedJSON(http.StatusOK, list)
+	}
+}
+
+// adminResolveReport godoc
+// @Summary Resolve an abuse report
+// @Tags admin
+// @Produce json
+// @Param id path int true "Report ID"
+// @Success 204
+// @Security ApiKeyAuth
+// @Router /admin/reports/{id}/resolve [post]
+func (a *API) AdminResolveReport() gin.HandlerFunc {
+	reports := a.stores.Reports
+	return func(c *gin.Context) {
+		id, err := strconv.Atoi(c.Param("id"))
+		if err != nil {
+			c.IndentedJSON(http.StatusBadRequest, gin.H{"error": "Invalid report ID"})
+			return
+		}
+
+		if err := reports.Resolve(c.Request.Context(), id, time.Now()); err != nil {
+			if errors.Is(err, store.ErrNotFound) {
+				c.IndentedJSON(http.StatusNotFound, gin.H{"error": "report not found"})
+			} else {
+				log.Println(err)
+				c.IndentedJSON(http.StatusInternalServerError, gin.H{"error": "Failed to resolve report"})
+			}
+			return
+		}
+		a.auditEvent(c, "admin.report_resolved", currentUserID(c), fmt.Sprintf("report=%d", id))
+
+		c.Status(http.StatusNoContent)
+	}
+}
+
+// adminGetStats godoc
+// @Summary Server statistics
+// @Tags admin
+// @Produce json
+// @Success 200 {object} ServerStats
+// @Security ApiKeyAuth
+// @Router /admin/stats [get]
+func (a *API) AdminGetStats() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		ctx := c.Request.Context()
+		var stats ServerStats
+		userCounts, err := a.stores.Users.Count(ctx)
+		if err == nil {
+			stats.Users, stats.Admins, stats.SuspendedUsers = userCounts.Users, userCounts.Admins, userCounts.Suspended
+			stats.Messages, err = a.stores.Messages.Count(ctx)
+		}
+		if err == nil {
+			stats.OpenReports, err = a.stores.Reports.CountOpen(ctx)
+		}
+		if err == nil {
+			stats.ActiveSessions, err = a.stores.Tokens.Count(ctx)
+		}
+		if err != nil {
+			log.Println(err)
+			c.IndentedJSON(http.StatusInternalServerError, gin.H{"error": "Failed to get statistics"})
+			return
+		}
+
+		c.IndentedJSON(http.StatusOK, stats)
+	}
+}
+
+func (a *API) setupAdminRoutes(router *gin.Engine) {
+	adminRoutes := router.Group("/admin", RequireScope(ScopeAdmin), a.RequireAdmin())
+	{
+		adminRoutes.GET("/users", a.AdminGetUsers())
+		adminRoutes.PUT("/users/:id/role", a.AdminSetRole())
+		adminRoutes.POST("/users/:id/suspend", a.AdminSuspendUser())
+		adminRoutes.POST("/users/:id/unsuspend", a.AdminUnsuspendUser())
+		adminRoutes.DELETE("/users/:id", a.AdminDeleteUser())
+		adminRoutes.DELETE("/users/:id/sessions", a.AdminRevokeSessions())
+		adminRoutes.GET("/reports", a.AdminGetReports())
+		adminRoutes.POST("/reports/:id/resolve", a.AdminResolveReport())
+		adminRoutes.GET("/stats", a.AdminGetStats())
+		adminRoutes.GET("/audit", a.AdminGetAuditEvents())
+	}
+}
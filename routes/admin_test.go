@@ -0,0 +1,120 @@
+package routes
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/adrienchanove/alpha-enigma-api/store"
+)
+
+// createAdmin creates an administrator and returns its id and a token
+// granting every scope.
+func (ta *testAPI) createAdmin(name string) (int, string) {
+	ta.t.Helper()
+	id := ta.createUser(name)
+	if err := ta.stores.Users.SetRole(context.Background(), id, RoleAdmin); err != nil {
+		ta.t.Fatal(err)
+	}
+	return id, ta.token(id, append(defaultScopes, ScopeAdmin)...)
+}
+
+func TestAdminGetUsers(t *testing.T) {
+	ta := newTestAPI(t, Config{})
+	admin, token := ta.createAdmin("admin")
+	alice := ta.createUser("alice")
+	ta.token(alice)
+	ta.token(alice, ScopeUsersRead)
+	ta.createUser("bob")
+
+	var users []AdminUser
+	ta.expect(ta.do("GET", "/admin/users", token, ""), http.StatusOK, &users)
+	sessions := make(map[int]int)
+	for _, u := range users {
+		sessions[u.ID] = u.ActiveSessions
+	}
+	if len(users) != 3 || sessions[admin] != 1 || sessions[alice] != 2 || sessions[3] != 0 {
+		t.Errorf("users %+v, want the admin with 1 session, alice with 2 and bob with none", users)
+	}
+}
+
+func TestAdminRevokeSessionsOfMissingUser(t *testing.T) {
+	ta := newTestAPI(t, Config{})
+	_, token := ta.createAdmin("admin")
+	deleted := ta.createUser("bob")
+	if err := ta.stores.Users.Delete(context.Background(), deleted, store.DeletedMessagesKeep, time.Now()); err != nil {
+		t.Fatal(err)
+	}
+	alice := ta.createUser("alice")
+	aliceToken := ta.token(alice)
+
+	ta.expect(ta.do("DELETE", "/admin/users/1000/sessions", token, ""), http.StatusNotFound, nil)
+	ta.expect(ta.do("DELETE", "/admin/users/"+strconv.Itoa(deleted)+"/sessions", token, ""), http.StatusNotFound, nil)
+	ta.expect(ta.do("DELETE", "/admin/users/"+strconv.Itoa(alice)+"/sessions", token, ""), http.StatusNoContent, nil)
+	ta.expect(ta.do("GET", "/users/me", aliceToken, ""), http.StatusUnauthorized, nil)
+}
+
+func TestAdminResolveReportIsAudited(t *testing.T) {
+	ta := newTestAPI(t, Config{})
+	admin, token := ta.createAdmin("admin")
+	alice, bob := ta.createUser("alice"), ta.createUser("bob")
+	report, err := ta.stores.Reports.Create(context.Background(), store.Report{ReporterID: alice, ReportedID: bob, Reason: "spam", CreatedAt: time.Now()})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ta.expect(ta.do("POST", "/admin/reports/"+strconv.Itoa(report)+"/resolve", token, ""), http.StatusNoContent, nil)
+	events, err := ta.stores.Audit.List(context.Background(), store.AuditFilter{Event: "admin.report_resolved", Limit: 10})
+	if err != nil || len(events) != 1 || events[0].UserID != admin || events[0].Details != "report="+strconv.Itoa(report) {
+		t.Errorf("events %+v, %v, want the resolution by the admin", events, err)
+	}
+}
+
+func TestModeratedUsersDoNotSeeTheAdmin(t *testing.T) {
+	ta := newTestAPI(t, Config{})
+	admin, token := ta.createAdmin("admin")
+	alice := ta.createUser("alice")
+	target := "/admin/users/" + strconv.Itoa(alice)
+
+	for _, action := range []struct{ method, path, body string }{
+		{"PUT", target + "/role", `{"role": "admin"}`},
+		{"PUT", target + "/role", `{"role": "user"}`},
+		{"POST", target + "/suspend", ""},
+		{"POST", target + "/unsuspend", ""},
+		{"DELETE", target + "/sessions", ""},
+	} {
+		req := httptest.NewRequest(action.method, action.path, strings.NewReader(action.body))
+		req.Header.Set("Content-Type", "application/json")
+		req.Header.Set("Authorization", "Bearer "+token)
+		req.Header.Set("User-Agent", "admin-browser")
+		req.RemoteAddr = "203.0.113.7:1234"
+		w := httptest.NewRecorder()
+		ta.router.ServeHTTP(w, req)
+		ta.expect(w, http.StatusNoContent, nil)
+	}
+
+	var events []AuditEvent
+	ta.expect(ta.do("GET", "/audit", ta.token(alice), ""), http.StatusOK, &events)
+	for _, event := range events {
+		if event.IP == "203.0.113.7" || event.UserAgent == "admin-browser" || strings.HasPrefix(event.Event, "admin.") {
+			t.Errorf("alice sees the event %+v of the admin", event)
+		}
+	}
+
+	ta.expect(ta.do("GET", "/audit?limit=10", token, ""), http.StatusOK, &events)
+	var recorded []string
+	for _, event := range events {
+		if event.UserID != admin || event.IP != "203.0.113.7" || !strings.Contains(event.Details, "target="+strconv.Itoa(alice)) {
+			t.Errorf("event %+v, want an event of the admin targeting alice", event)
+		}
+		recorded = append(recorded, event.Event)
+	}
+	want := "admin.sessions_revoked admin.user_unsuspended admin.user_suspended admin.role_changed admin.role_changed"
+	if got := strings.Join(recorded, " "); got != want {
+		t.Errorf("admin events %s, want %s", got, want)
+	}
+}
@@ -0,0 +1,134 @@
+package routes
+
+import (
+	"errors"
+	"sync"
+	"time"
+
+	"github.com/adrienchanove/alpha-enigma-api/jwt"
+	"github.com/adrienchanove/alpha-enigma-api/ratelimit"
+	"github.com/adrienchanove/alpha-enigma-api/store"
+	"github.com/gin-gonic/gin"
+)
+
+// Config holds the settings of the API, its zero value uses the defaults.
+type Config struct {
+	// DeletedMessagesPolicy is what happens to the messages of deleted
+	// accounts: DeletedMessagesKeep, DeletedMessagesTombstone (the default)
+	// or DeletedMessagesPurge.
+	DeletedMessagesPolicy string
+	// ProofOfWorkBits is the difficulty, in bits, of the proof of work
+	// required to create an account. 0 disables it.
+	ProofOfWorkBits int
+	// RateLimits override the default rate limit policies, by name.
+	RateLimits []ratelimit.Policy
+	// RateLimitStore keeps the rate limit buckets, in memory when nil. Give
+	// several instances the same store to share the limits.
+	RateLimitStore ratelimit.Store
+	// SigningKeys sign and verify the self-contained tokens, which are
+	// disabled when nil.
+	SigningKeys *jwt.Keyring
+	// TokenLifetime and SignedTokenLifetime are how long the opaque and the
+	// signed tokens stay valid, DefaultTokenLifetime when 0.
+	TokenLifetime       time.Duration
+	SignedTokenLifetime time.Duration
+	// ClientCertificates authenticates the requests sending no bearer token
+	// but a client certificate verified by the TLS server, as the user named
+	// by the certificate's subject common name.
+	ClientCertificates bool
+}
+
+// API serves the routes of one instance of the server. It holds everything
+// the handlers share, so several instances can run in the same process.
+type API struct {
+	stores *store.Stores
+
+	deletedMessagesPolicy string
+	proofOfWorkBits       int
+	rateLimits            map[string]ratelimit.Policy
+	rateLimitStore        ratelimit.Store
+	signingKeys           *jwt.Keyring
+	tokenLifetime         time.Duration
+	signedTokenLifetime   time.Duration
+	clientCertificates    bool
+
+	tokenLockouts         map[string]time.Time
+	tokenLockoutsPrunedAt time.Time
+	tokenLockoutsMu       sync.Mutex
+
+	decoyKeyBits   int
+	decoyKeyBitsAt time.Time
+	decoyKeySizeMu sync.Mutex
+
+	deletionChallenges   map[int]deletionChallenge
+	deletionChallengesMu sync.Mutex
+
+	powChallenges   map[string]ProofOfWorkChallenge
+	powChallengesMu sync.Mutex
+}
+
+// New returns the API serving stores with config.
+func New(stores *store.Stores, config Config) (*API, error) {
+	if config.DeletedMessagesPolicy == "" {
+		config.DeletedMessagesPolicy = DeletedMessagesTombstone
+	}
+	if err := checkDeletedMessagesPolicy(config.DeletedMessagesPolicy); err != nil {
+		return nil, err
+	}
+	if err := checkProofOfWorkBits(config.ProofOfWorkBits); err != nil {
+		return nil, err
+	}
+	limits, err := rateLimits(config.RateLimits)
+	if err != nil {
+		return nil, err
+	}
+	if config.RateLimitStore == nil {
+		config.RateLimitStore = ratelimit.NewMemoryStore()
+	}
+	for _, lifetime := range []*time.Duration{&config.TokenLifetime, &config.SignedTokenLifetime} {
+		if *lifetime < 0 {
+			return nil, errors.New("token lifetimes must be positive")
+		}
+		if *lifetime == 0 {
+			*lifetime = DefaultTokenLifetime
+		}
+	}
+
+	return &API{
+		stores:                stores,
+		deletedMessagesPolicy: config.DeletedMessagesPolicy,
+		proofOfWorkBits:       config.ProofOfWorkBits,
+		rateLimits:            limits,
+		rateLimitStore:        config.RateLimitStore,
+		signingKeys:           config.SigningKeys,
+		tokenLifetime:         config.TokenLifetime,
+		signedTokenLifetime:   config.SignedTokenLifetime,
+		clientCertificates:    config.ClientCertificates,
+		tokenLockouts:         make(map[string]time.Time),
+		deletionChallenges:    make(map[int]deletionChallenge),
+		powChallenges:         make(map[string]ProofOfWorkChallenge),
+	}, nil
+}
+
+// Register sets up the routes on router: the public ones, then the
+// authentication middlewares, which apply to every route registered after
+// them, then the private ones.
+func (a *API) Register(router *gin.Engine) {
+	// public routes
+	a.setupAuthRoutes(router)
+	a.setupPublicUserRoutes(router)
+
+	// private routes
+	router.Use(a.AuthMiddleware(), a.LimitAuthenticatedRequests())
+
+	a.setupSessionRoutes(router)
+	a.setupUserRoutes(router)
+	a.setupProfileRoutes(router)
+	a.setupAccountRoutes(router)
+	a.setupAuditRoutes(router)
+	a.setupPrivacyRoutes(router)
+	a.setupContactRoutes(router)
+	a.setupMessageRoutes(router)
+	a.setupReportRoutes(router)
+	a.setupAdminRoutes(router)
+}
@@ -0,0 +1,145 @@
+package routes
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/json"
+	"encoding/pem"
+	"io"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/adrienchanove/alpha-enigma-api/store"
+	"github.com/adrienchanove/alpha-enigma-api/username"
+	"github.com/gin-gonic/gin"
+)
+
+func init() {
+	gin.SetMode(gin.TestMode)
+}
+
+// testAPI serves the routes on the in-memory stores.
+type testAPI struct {
+	t      *testing.T
+	stores *store.Stores
+	api    *API
+	router *gin.Engine
+}
+
+func newTestAPI(t *testing.T, config Config) *testAPI {
+	t.Helper()
+	stores := store.NewMemory()
+	api, err := New(stores, config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	router := gin.New()
+	api.Register(router)
+	return &testAPI{t: t, stores: stores, api: api, router: router}
+}
+
+// createUser creates an account and returns its id.
+func (ta *testAPI) createUser(name string) int {
+	ta.t.Helper()
+	id, err := ta.stores.Users.Create(context.Background(), store.NewUser{
+		Username:         name,
+		UsernameKey:      username.Key(name),
+		UsernameSkeleton: username.Skeleton(name),
+		PublicKey:        testPublicKey(ta.t),
+	})
+	if err != nil {
+		ta.t.Fatal(err)
+	}
+	return id
+}
+
+// token saves an opaque token of the user granting scopes, the default ones
+// when none, and returns it.
+func (ta *testAPI) token(userID int, scopes ...string) string {
+	ta.t.Helper()
+	user, err := ta.stores.Users.Get(context.Background(), userID)
+	if err != nil {
+		ta.t.Fatal(err)
+	}
+	if len(scopes) == 0 {
+		scopes = defaultScopes
+	}
+	token := "token-" + user.Username + "-" + strings.Join(scopes, "-")
+	err = ta.stores.Tokens.Save(context.Background(), store.Token{
+		Token:     token,
+		SessionID: "session-" + token,
+		UserID:    userID,
+		Username:  user.Username,
+		Scopes:    scopes,
+		IssuedAt:  time.Now(),
+		ExpiresAt: time.Now().Add(time.Hour),
+	})
+	if err != nil {
+		ta.t.Fatal(err)
+	}
+	return token
+}
+
+// do sends the request with the token, if not empty, and returns the
+// response.
+func (ta *testAPI) do(method string, path string, token string, body string) *httptest.ResponseRecorder {
+	ta.t.Helper()
+	var reader io.Reader
+	if body != "" {
+		reader = strings.NewReader(body)
+	}
+	req := httptest.NewRequest(method, path, reader)
+	if body != "" {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	if token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+	w := httptest.NewRecorder()
+	ta.router.ServeHTTP(w, req)
+	return w
+}
+
+// expect checks the status of the response, and decodes its body into v when
+// not nil.
+func (ta *testAPI) expect(w *httptest.ResponseRecorder, status int, v any) {
+	ta.t.Helper()
+	if w.Code != status {
+		ta.t.Fatalf("status %d, want %d: %s", w.Code, status, w.Body)
+	}
+	if v != nil {
+		if err := json.Unmarshal(w.Body.Bytes(), v); err != nil {
+			ta.t.Fatal(err)
+		}
+	}
+}
+
+var (
+	testKeyOnce sync.Once
+	testKey     *rsa.PrivateKey
+)
+
+// testPrivateKey returns the RSA key of the test users, generated once.
+func testPrivateKey(t *testing.T) *rsa.PrivateKey {
+	testKeyOnce.Do(func() {
+		var err error
+		if testKey, err = rsa.GenerateKey(rand.Reader, 2048); err != nil {
+			t.Fatal(err)
+		}
+	})
+	return testKey
+}
+
+// testPublicKey returns the PEM public key of the test users.
+func testPublicKey(t *testing.T) string {
+	der, err := x509.MarshalPKIXPublicKey(&testPrivateKey(t).PublicKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: der}))
+}
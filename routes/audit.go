@@ -0,0 +1,179 @@
+package routes
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/adrienchanove/alpha-enigma-api/ratelimit"
+	"github.com/adrienchanove/alpha-enigma-api/store"
+	"github.com/gin-gonic/gin"
+)
+
+// AuditEvent is a security relevant event: logins, token issuance, failed
+// authentications, tripped limits, moderation... UserID is 0 when the event
+// is not tied to a known user, such as a failed authentication.
+type AuditEvent struct {
+	ID        int       `json:"id"`
+	Event     string    `json:"event"`
+	UserID    int       `json:"userId"`
+	IP        string    `json:"ip"`
+	UserAgent string    `json:"userAgent"`
+	Details   string    `json:"details"`
+	CreatedAt time.Time `json:"createdAt"`
+}
+
+// Paging of the audit events lists.
+const (
+	defaultAuditLimit = 50
+	maxAuditLimit     = 500
+)
+
+// failedAuthAuditPolicy bounds the failed authentications recorded per IP, so
+// a flood of bad tokens does not flood the audit trail.
+var failedAuthAuditPolicy = ratelimit.Policy{Name: "audit-auth-failed", Limit: 10, Period: time.Minute}
+
+// recordAuditEvent appends event to the audit trail.
+func (a *API) recordAuditEvent(event AuditEvent) {
+	err := a.stores.Audit.Append(context.Background(), store.AuditEvent{
+		Event:     event.Event,
+		UserID:    event.UserID,
+		IP:        event.IP,
+		UserAgent: event.UserAgent,
+		Details:   event.Details,
+		CreatedAt: time.Now(),
+	})
+	if err != nil {
+		log.Println(err)
+	}
+}
+
+// auditEvent records an event caused by the request.
+// userId is 0 when the event is not tied to a known user.
+func (a *API) auditEvent(c *gin.Context, event string, userId int, details string) {
+	a.recordAuditEvent(AuditEvent{
+		Event:     event,
+		UserID:    userId,
+		IP:        c.ClientIP(),
+		UserAgent: c.Request.UserAgent(),
+		Details:   details,
+	})
+}
+
+// auditFailedAuth records a failed authentication, up to
+// failedAuthAuditPolicy per IP.
+func (a *API) auditFailedAuth(c *gin.Context, userId int, reason string) {
+	result, err := a.rateLimitStore.Take(c.Request.Context(), failedAuthAuditPolicy.Name+":"+c.ClientIP(), failedAuthAuditPolicy, time.Now())
+	if err == nil && result.Allowed {
+		a.auditEvent(c, "auth.failed", userId, "reason="+reason)
+	}
+}
+
+// auditTokenIssued records a token issuance.
+func (a *API) auditTokenIssued(c *gin.Context, userId int, tokenType string, sessionID string, scopes []string) {
+	a.auditEvent(c, "auth.token_issued", userId, fmt.Sprintf("type=%s session=%s scopes=%s", tokenType, sessionID, formatScopes(scopes)))
+}
+
+// AuditSystemEvent records an event that is not caused by a request, such as
+// a signing key rotation.
+func (a *API) AuditSystemEvent(event string, details string) {
+	a.recordAuditEvent(AuditEvent{Event: event, Details: details})
+}
+
+// queryAuditEvents lists the audit events matching filter, newest first,
+// paged with the limit and before (an event id) query parameters.
+func queryAuditEvents(c *gin.Context, audit store.AuditStore, filter store.AuditFilter) {
+	filter.Limit = defaultAuditLimit
+	if l := c.Query("limit"); l != "" {
+		n, err := strconv.Atoi(l)
+		if err != nil || n <= 0 || n > maxAuditLimit {
+			c.IndentedJSON(http.StatusBadRequest, gin.H{"error": "limit must be between 1 and " + strconv.Itoa(maxAuditLimit)})
+			return
+		}
+		filter.Limit = n
+	}
+	if b := c.Query("before"); b != "" {
+		before, err := strconv.Atoi(b)
+		if err != nil {
+			c.IndentedJSON(http.StatusBadRequest, gin.H{"error": "Invalid before event ID"})
+			return
+		}
+		filter.Before = before
+	}
+
+	stored, err := audit.List(c.Request.Context(), filter)
+	if err != nil {
+		log.Println(err)
+		c.IndentedJSON(http.StatusInternalServerError, gin.H{"error": "Failed to get audit events"})
+		return
+	}
+
+	events := []AuditEvent{}
+	for _, e := range stored {
+		events = append(events, AuditEvent{
+			ID:        e.ID,
+			Event:     e.Event,
+			UserID:    e.UserID,
+			IP:        e.IP,
+			UserAgent: e.UserAgent,
+			Details:   e.Details,
+			CreatedAt: e.CreatedAt,
+		})
+	}
+
+	c.IndentedJSON(http.StatusOK, events)
+}
+
+// getAuditEvents godoc
+// @Summary List your audit events
+// @Description List the security events of the authenticated user, newest first: token issuance, logouts,
+// @Description revoked sessions, failed authentications... Use the id of the last event as before to get the next page
+// @Tags audit
+// @Produce json
+// @Param limit query int false "Number of events, 50 by default and 500 at most"
+// @Param before query int false "Only events older than this event ID"
+// @Success 200 {array} AuditEvent
+// @Security ApiKeyAuth
+// @Router /audit [get]
+func (a *API) GetAuditEvents() gin.HandlerFunc {
+	audit := a.stores.Audit
+	return func(c *gin.Context) {
+		userId := currentUserID(c)
+		queryAuditEvents(c, audit, store.AuditFilter{UserID: &userId})
+	}
+}
+
+// adminGetAuditEvents godoc
+// @Summary List all audit events
+// @Description List the security events of every user and of the server, newest first
+// @Tags admin
+// @Produce json
+// @Param userId query int false "Only the events of this user, 0 for events without user"
+// @Param event query string false "Only events of this type, e.g. auth.failed"
+// @Param limit query int false "Number of events, 50 by default and 500 at most"
+// @Param before query int false "Only events older than this event ID"
+// @Success 200 {array} AuditEvent
+// @Security ApiKeyAuth
+// @Router /admin/audit [get]
+func (a *API) AdminGetAuditEvents() gin.HandlerFunc {
+	audit := a.stores.Audit
+	return func(c *gin.Context) {
+		filter := store.AuditFilter{Event: c.Query("event")}
+		if u := c.Query("userId"); u != "" {
+			userId, err := strconv.Atoi(u)
+			if err != nil {
+				c.IndentedJSON(http.StatusBadRequest, gin.H{"error": "Invalid user ID"})
+				return
+			}
+			filter.UserID = &userId
+		}
+		queryAuditEvents(c, audit, filter)
+	}
+}
+
+func (a *API) setupAuditRoutes(router *gin.Engine) {
+	router.GET("/audit", RequireScope(ScopeUsersRead), a.GetAuditEvents())
+}
@@ -1,38 +1,86 @@
 package routes
 
 import (
+	"context"
 	"crypto/rand"
 	"crypto/rsa"
 	"crypto/sha256"
 	"crypto/x509"
-	"database/sql"
 	"encoding/base64"
 	"encoding/pem"
 	"errors"
 	"fmt"
 	"log"
 	"net/http"
+	"strconv"
 	"time"
 
+	"github.com/adrienchanove/alpha-enigma-api/jwt"
+	"github.com/adrienchanove/alpha-enigma-api/ratelimit"
+	"github.com/adrienchanove/alpha-enigma-api/store"
+	"github.com/adrienchanove/alpha-enigma-api/username"
 	"github.com/gin-gonic/gin"
 	"github.com/google/uuid"
 )
 
 type AuthRequest struct {
 	Username string `json:"username"`
+	// TokenType is "opaque" (default) or "jwt" for a signed self-contained token.
+	TokenType string `json:"tokenType"`
+	Device    string `json:"device"`
+	// Scopes granted to the token, messages:read, messages:write, users:read and users:write when empty.
+	Scopes []string `json:"scopes"`
 }
 
 type AuthResponse struct {
 	EncryptedToken string `json:"encryptedToken"`
+	// EncryptedKey is only set for jwt tokens, see encryptTokenHybrid.
+	EncryptedKey string `json:"encryptedKey,omitempty"`
+	TokenType    string `json:"tokenType"`
 }
 
+const (
+	tokenTypeOpaque = "opaque"
+	tokenTypeJWT    = "jwt"
+)
+
 type TokenData struct {
 	Token     string    `json:"token"`
+	SessionID string    `json:"sessionId"`
 	Timestamp time.Time `json:"timestamp"`
+	UserID    int       `json:"userId"`
 	Username  string    `json:"username"`
+	Device    string    `json:"device"`
+	Scopes    []string  `json:"scopes"`
+	ClientIP  string    `json:"clientIp"`
+	UserAgent string    `json:"userAgent"`
+	// Signed tokens are self-contained and never stored in the TokenStore.
+	Signed bool `json:"signed"`
+	// Certificate is set when the request was authenticated by a client
+	// certificate instead of a token.
+	Certificate bool `json:"certificate"`
 }
 
-var tokenStorage = make(map[string]TokenData)
+// Session is the public view of an issued token, without the secret itself.
+type Session struct {
+	ID        string    `json:"id"`
+	IssuedAt  time.Time `json:"issuedAt"`
+	ExpiresAt time.Time `json:"expiresAt"`
+	Device    string    `json:"device"`
+	Scopes    []string  `json:"scopes"`
+	ClientIP  string    `json:"clientIp"`
+	UserAgent string    `json:"userAgent"`
+	Current   bool      `json:"current"`
+}
+
+// DefaultTokenLifetime is how long an issued token stays valid by default.
+const DefaultTokenLifetime = time.Hour
+
+// Gin context keys set by AuthMiddleware for authenticated requests.
+const (
+	contextTokenKey  = "token"
+	contextUserIDKey = "userId"
+)
 
 // generateToken generates a random token.
 func generateToken() (string, error) {
@@ -41,23 +89,33 @@ func generateToken() (string, error) {
 	return newToken, nil
 }
 
-// encryptToken encrypts the token with the user's public key.
-func encryptToken(token string, publicKeyPEM string) (string, error) {
+// parsePublicKey decodes a PEM-encoded RSA public key.
+func parsePublicKey(publicKeyPEM string) (*rsa.PublicKey, error) {
 	// Decode the PEM-encoded public key
 	block, _ := pem.Decode([]byte(publicKeyPEM))
 	if block == nil {
-		return "", fmt.Errorf("failed to decode PEM block containing public key")
+		return nil, fmt.Errorf("failed to decode PEM block containing public key")
 	}
 
 	// Parse the public key
 	pub, err := x509.ParsePKIXPublicKey(block.Bytes)
 	if err != nil {
-		return "", fmt.Errorf("failed to parse public key: %w", err)
+		return nil, fmt.Errorf("failed to parse public key: %w", err)
 	}
 
 	rsaPub, ok := pub.(*rsa.PublicKey)
 	if !ok {
-		return "", errors.New("public key is not an RSA key")
+		return nil, errors.New("public key is not an RSA key")
+	}
+
+	return rsaPub, nil
+}
+
+// encryptToken encrypts the token with the user's public key.
+func encryptToken(token string, publicKeyPEM string) (string, error) {
+	rsaPub, err := parsePublicKey(publicKeyPEM)
+	if err != nil {
+		return "", err
 	}
 
 	// Encrypt the token
@@ -72,36 +130,112 @@ func encryptToken(token string, publicKeyPEM string) (string, error) {
 	return encryptedToken, nil
 }
 
-// verifyToken verifies the token.
-func verifyToken(token string) (string, bool) {
-	tokenData, ok := tokenStorage[token]
-	if !ok {
-		return "", false
+// verifyToken verifies an opaque token.
+func verifyToken(ctx context.Context, tokens store.TokenStore, token string) (TokenData, bool) {
+	t, err := tokens.Get(ctx, token)
+	if err != nil {
+		if !errors.Is(err, store.ErrNotFound) {
+			log.Println(err)
+		}
+		return TokenData{}, false
+	}
+	return TokenData{
+		Token:     t.Token,
+		SessionID: t.SessionID,
+		Timestamp: t.IssuedAt,
+		UserID:    t.UserID,
+		Username:  t.Username,
+		Device:    t.Device,
+		Scopes:    t.Scopes,
+		ClientIP:  t.ClientIP,
+		UserAgent: t.UserAgent,
+	}, true
+}
+
+// userRevocationExpiry returns until when the revocation of every session of
+// a user, made at now, is kept: while the signed tokens issued before are
+// valid, and the client certificates when they are enabled.
+func (a *API) userRevocationExpiry(now time.Time) time.Time {
+	if a.clientCertificates {
+		return now.Add(certificateRevocationLifetime)
+	}
+	return now.Add(a.signedTokenLifetime)
+}
+
+// revokeAllUserTokens revokes every token of userID, signed tokens and client
+// certificates included.
+func (a *API) revokeAllUserTokens(ctx context.Context, userID int) {
+	now := time.Now()
+	if err := a.stores.Revocations.RevokeUser(ctx, userID, now, "", a.userRevocationExpiry(now)); err != nil {
+		log.Println(err)
 	}
-	// Check if the token has expired (e.g., after 1 hour)
-	if time.Since(tokenData.Timestamp) > time.Hour {
-		delete(tokenStorage, token)
-		return "", false
+	if _, err := a.stores.Tokens.DeleteUserTokens(ctx, userID, ""); err != nil {
+		log.Println(err)
+	}
+}
+
+// userSessions lists the active sessions of userID, flagging currentSession.
+func userSessions(ctx context.Context, tokens store.TokenStore, userID int, currentSession string) ([]Session, error) {
+	userTokens, err := tokens.UserTokens(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+
+	sessions := []Session{}
+	for _, t := range userTokens {
+		sessions = append(sessions, Session{
+			ID:        t.SessionID,
+			IssuedAt:  t.IssuedAt,
+			ExpiresAt: t.ExpiresAt,
+			Device:    t.Device,
+			Scopes:    t.Scopes,
+			ClientIP:  t.ClientIP,
+			UserAgent: t.UserAgent,
+			Current:   t.SessionID == currentSession,
+		})
+	}
+	return sessions, nil
+}
+
+func (a *API) getUserFromToken(ctx context.Context, token string) (TokenData, bool) {
+	if jwt.IsToken(token) {
+		return a.verifySignedToken(ctx, token)
 	}
-	return tokenData.Username, true
+	return verifyToken(ctx, a.stores.Tokens, token)
+}
+
+// currentToken returns the token used to authenticate the request.
+func currentToken(c *gin.Context) TokenData {
+	tokenData, _ := c.Get(contextTokenKey)
+	td, _ := tokenData.(TokenData)
+	return td
 }
 
-func getUserFromToken(token string) (string, bool) {
-	return verifyToken(token)
+// currentUserID returns the id of the user authenticated by AuthMiddleware.
+func currentUserID(c *gin.Context) int {
+	return c.GetInt(contextUserIDKey)
 }
 
 // requestToken godoc
 // @Summary Request a token
 // @Description Request a token for authentication
 // @Description To use the token you need to decrypt it with private key
+// @Description With tokenType "jwt" a signed self-contained token is returned, encryptedKey then holds
+// @Description an AES-256 key encrypted with the public key and encryptedToken the token sealed with AES-GCM
+// @Description (12 bytes nonce followed by the ciphertext)
+// @Description Scopes restrict what the token grants access to: messages:read, messages:write, users:read,
+// @Description users:write and admin. Without scopes, all but admin are granted. admin is only granted to administrators
+// @Description Token requests are rate limited, 429 is returned with a Retry-After header past the limit. Too many
+// @Description requests for a username lock it out for 15 minutes. Unknown usernames get a token nobody can decrypt
 // @Tags auth
 // @Accept json
 // @Produce json
 // @Param authRequest body AuthRequest true "Authentication request"
 // @Success 200 {object} AuthResponse
-// @Security X-User
 // @Router /auth/token [post]
-func RequestToken(db *sql.DB) gin.HandlerFunc {
+func (a *API) RequestToken() gin.HandlerFunc {
+	users := a.stores.Users
+	tokens := a.stores.Tokens
 	return func(c *gin.Context) {
 		var authRequest AuthRequest
 		if err := c.ShouldBindJSON(&authRequest); err != nil {
@@ -114,15 +248,55 @@ func RequestToken(db *sql.DB) gin.HandlerFunc {
 			return
 		}
 
-		// Get the user's public key from the database
-		var publicKeyPEM string
-		err := db.QueryRow("SELECT public_key FROM users WHERE username = ?", authRequest.Username).Scan(&publicKeyPEM)
+		switch authRequest.TokenType {
+		case "", tokenTypeOpaque:
+		case tokenTypeJWT:
+			if a.signingKeys == nil {
+				c.IndentedJSON(http.StatusBadRequest, gin.H{"error": "signed tokens are not enabled"})
+				return
+			}
+		default:
+			c.IndentedJSON(http.StatusBadRequest, gin.H{"error": "tokenType must be opaque or jwt"})
+			return
+		}
+
+		usernameKey := username.Key(authRequest.Username)
+		if !a.allowTokenRequest(c, usernameKey) {
+			return
+		}
+
+		// Get the user's id, public key and role
+		user, err := users.GetByUsernameKey(c.Request.Context(), usernameKey)
+		if err != nil && !errors.Is(err, store.ErrNotFound) {
+			log.Println(err)
+			c.IndentedJSON(http.StatusInternalServerError, gin.H{"error": "failed to get user public key"})
+			return
+		}
+		// Unknown and suspended users get the same answer as the others
+		decoy := err != nil || user.SuspendedAt != nil
+		role := user.Role
+		if decoy {
+			role = RoleUser
+		}
+
+		scopes, err := grantScopes(authRequest.Scopes, role)
 		if err != nil {
-			if errors.Is(err, sql.ErrNoRows) {
-				c.IndentedJSON(http.StatusNotFound, gin.H{"error": "user not found"})
+			if errors.Is(err, errScopeNotAllowed) {
+				c.IndentedJSON(http.StatusForbidden, gin.H{"error": err.Error()})
 			} else {
-				log.Println(err)
-				c.IndentedJSON(http.StatusInternalServerError, gin.H{"error": "failed to get user public key"})
+				c.IndentedJSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			}
+			return
+		}
+
+		if decoy {
+			a.issueDecoyToken(c, authRequest, scopes, user)
+			return
+		}
+
+		if authRequest.TokenType == tokenTypeJWT {
+			if sessionID, ok := a.issueSignedToken(c, user.ID, user.Username, authRequest.Device, scopes, user.PublicKey); ok {
+				a.auditTokenIssued(c, user.ID, tokenTypeJWT, sessionID, scopes)
 			}
 			return
 		}
@@ -136,61 +310,247 @@ func RequestToken(db *sql.DB) gin.HandlerFunc {
 		}
 
 		// Encrypt the token with the user's public key
-		encryptedToken, err := encryptToken(token, publicKeyPEM)
+		encryptedToken, err := encryptToken(token, user.PublicKey)
 		if err != nil {
 			log.Println(err)
 			c.IndentedJSON(http.StatusInternalServerError, gin.H{"error": "failed to encrypt token"})
 			return
 		}
 
-		// Store the token in the storage
-		tokenStorage[token] = TokenData{Token: token, Timestamp: time.Now(), Username: authRequest.Username}
+		// Store the token
+		now := time.Now()
+		sessionID := uuid.New().String()
+		err = tokens.Save(c.Request.Context(), store.Token{
+			Token:     token,
+			SessionID: sessionID,
+			UserID:    user.ID,
+			Username:  user.Username,
+			Device:    authRequest.Device,
+			Scopes:    scopes,
+			ClientIP:  c.ClientIP(),
+			UserAgent: c.Request.UserAgent(),
+			IssuedAt:  now,
+			ExpiresAt: now.Add(a.tokenLifetime),
+		})
+		if err != nil {
+			log.Println(err)
+			c.IndentedJSON(http.StatusInternalServerError, gin.H{"error": "failed to store token"})
+			return
+		}
+		a.auditTokenIssued(c, user.ID, tokenTypeOpaque, sessionID, scopes)
 
-		c.IndentedJSON(http.StatusOK, AuthResponse{EncryptedToken: encryptedToken})
+		c.IndentedJSON(http.StatusOK, AuthResponse{EncryptedToken: encryptedToken, TokenType: tokenTypeOpaque})
 	}
 }
 
 // AuthMiddleware is a middleware to check for valid tokens.
-func AuthMiddleware() gin.HandlerFunc {
+// It also rejects the tokens of suspended and deleted accounts.
+func (a *API) AuthMiddleware() gin.HandlerFunc {
+	users := a.stores.Users
 	return func(c *gin.Context) {
+		var tokenData TokenData
 		authHeader := c.GetHeader("Authorization")
-		if authHeader == "" {
+		switch {
+		case authHeader == "" && a.clientCertificates && clientCertificate(c) != nil:
+			var ok bool
+			if tokenData, ok = a.authenticateClientCertificate(c); !ok {
+				return
+			}
+		case authHeader == "":
 			c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"error": "authorization header is required"})
 			return
+		default:
+			// Check if the header is in the correct format
+			if len(authHeader) < 7 || authHeader[:7] != "Bearer " {
+				a.auditFailedAuth(c, 0, "invalid_header")
+				c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"error": "invalid authorization header format"})
+				return
+			}
+
+			token := authHeader[7:]
+			var ok bool
+			if tokenData, ok = a.getUserFromToken(c.Request.Context(), token); !ok {
+				a.auditFailedAuth(c, 0, "invalid_token")
+				c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"error": "invalid token"})
+				return
+			}
 		}
 
-		// Check if the header is in the correct format
-		if len(authHeader) < 7 || authHeader[:7] != "Bearer " {
-			c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"error": "invalid authorization header format"})
+		// X-User is optional and only kept for backward compatibility,
+		// the token alone identifies the user.
+		usernameHeader := c.GetHeader("X-User")
+		if usernameHeader != "" && usernameHeader != tokenData.Username {
+			a.auditFailedAuth(c, tokenData.UserID, "user_mismatch")
+			c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"error": "invalid user for this token"})
 			return
 		}
 
-		token := authHeader[7:]
-		usernameToken, ok := getUserFromToken(token)
-		if !ok {
+		user, err := users.Get(c.Request.Context(), tokenData.UserID)
+		if err != nil && !errors.Is(err, store.ErrNotFound) {
+			log.Println(err)
+			c.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"error": "failed to get user"})
+			return
+		}
+		if err != nil || user.DeletedAt != nil {
+			a.auditFailedAuth(c, 0, "unknown_user")
 			c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"error": "invalid token"})
 			return
 		}
+		if user.SuspendedAt != nil {
+			a.auditFailedAuth(c, tokenData.UserID, "suspended")
+			c.AbortWithStatusJSON(http.StatusForbidden, gin.H{"error": "account suspended"})
+			return
+		}
 
-		usernameHeader := c.GetHeader("X-User")
-		if usernameHeader == "" {
-			c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"error": "X-User header is required"})
+		if err := users.TouchLastSeen(c.Request.Context(), user.ID, time.Now(), lastSeenResolution); err != nil {
+			log.Println(err)
+		}
+
+		c.Set(contextTokenKey, tokenData)
+		c.Set(contextUserIDKey, tokenData.UserID)
+
+		c.Next()
+	}
+}
+
+// logout godoc
+// @Summary Logout
+// @Description Revoke the token used to authenticate this request
+// @Description Requests authenticated by a client certificate have no token, 400 is returned
+// @Tags auth
+// @Produce json
+// @Success 204
+// @Security ApiKeyAuth
+// @Router /auth/logout [post]
+func (a *API) Logout() gin.HandlerFunc {
+	tokens := a.stores.Tokens
+	return func(c *gin.Context) {
+		tokenData := currentToken(c)
+		if tokenData.Certificate {
+			c.IndentedJSON(http.StatusBadRequest, gin.H{"error": "requests authenticated by a client certificate have no token to revoke"})
 			return
 		}
+		var err error
+		if tokenData.Signed {
+			err = a.stores.Revocations.RevokeToken(c.Request.Context(), tokenData.SessionID, tokenData.Timestamp.Add(a.signedTokenLifetime))
+		} else {
+			err = tokens.Delete(c.Request.Context(), tokenData.Token)
+		}
+		if err != nil {
+			log.Println(err)
+			c.IndentedJSON(http.StatusInternalServerError, gin.H{"error": "failed to revoke token"})
+			return
+		}
+		a.auditEvent(c, "auth.logout", tokenData.UserID, "session="+tokenData.SessionID)
 
-		if usernameToken != usernameHeader {
-			c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"error": "invalid user for this token"})
+		c.Status(http.StatusNoContent)
+	}
+}
+
+// getSessions godoc
+// @Summary List active sessions
+// @Description List the active sessions of the authenticated user with their issue time and client info
+// @Description Signed tokens are self-contained and are not listed
+// @Tags auth
+// @Produce json
+// @Success 200 {array} Session
+// @Security ApiKeyAuth
+// @Router /auth/sessions [get]
+func (a *API) GetSessions() gin.HandlerFunc {
+	tokens := a.stores.Tokens
+	return func(c *gin.Context) {
+		sessions, err := userSessions(c.Request.Context(), tokens, currentUserID(c), currentToken(c).SessionID)
+		if err != nil {
+			log.Println(err)
+			c.IndentedJSON(http.StatusInternalServerError, gin.H{"error": "failed to get sessions"})
 			return
 		}
 
-		c.Next()
+		c.IndentedJSON(http.StatusOK, sessions)
+	}
+}
+
+// revokeSession godoc
+// @Summary Revoke a session
+// @Description Revoke one of the authenticated user's sessions
+// @Tags auth
+// @Produce json
+// @Param id path string true "Session ID"
+// @Success 204
+// @Security ApiKeyAuth
+// @Router /auth/sessions/{id} [delete]
+func (a *API) RevokeSession() gin.HandlerFunc {
+	tokens := a.stores.Tokens
+	return func(c *gin.Context) {
+		if err := tokens.DeleteSession(c.Request.Context(), currentUserID(c), c.Param("id")); err != nil {
+			if errors.Is(err, store.ErrNotFound) {
+				c.IndentedJSON(http.StatusNotFound, gin.H{"error": "session not found"})
+			} else {
+				log.Println(err)
+				c.IndentedJSON(http.StatusInternalServerError, gin.H{"error": "failed to revoke session"})
+			}
+			return
+		}
+		a.auditEvent(c, "auth.session_revoked", currentUserID(c), "session="+c.Param("id"))
+
+		c.Status(http.StatusNoContent)
+	}
+}
+
+// revokeSessions godoc
+// @Summary Revoke all sessions
+// @Description Revoke every session of the authenticated user, signed tokens and client certificates included
+// @Description Set keepCurrent to true to keep the session used by this request
+// @Tags auth
+// @Produce json
+// @Param keepCurrent query bool false "Keep the current session"
+// @Success 204
+// @Security ApiKeyAuth
+// @Router /auth/sessions [delete]
+func (a *API) RevokeSessions() gin.HandlerFunc {
+	tokens := a.stores.Tokens
+	return func(c *gin.Context) {
+		tokenData := currentToken(c)
+
+		var keepToken, keepSession string
+		if c.Query("keepCurrent") == "true" {
+			if tokenData.Signed || tokenData.Certificate {
+				keepSession = tokenData.SessionID
+			} else {
+				keepToken = tokenData.Token
+			}
+		}
+		if _, err := tokens.DeleteUserTokens(c.Request.Context(), currentUserID(c), keepToken); err != nil {
+			log.Println(err)
+			c.IndentedJSON(http.StatusInternalServerError, gin.H{"error": "failed to revoke sessions"})
+			return
+		}
+		now := time.Now()
+		if err := a.stores.Revocations.RevokeUser(c.Request.Context(), currentUserID(c), now, keepSession, a.userRevocationExpiry(now)); err != nil {
+			log.Println(err)
+			c.IndentedJSON(http.StatusInternalServerError, gin.H{"error": "failed to revoke sessions"})
+			return
+		}
+		a.auditEvent(c, "auth.sessions_revoked", currentUserID(c), "keepCurrent="+strconv.FormatBool(keepToken != "" || keepSession != ""))
+
+		c.Status(http.StatusNoContent)
+	}
+}
+
+func (a *API) setupAuthRoutes(router *gin.Engine) {
+	authRoutes := router.Group("/auth")
+	{
+		authRoutes.POST("/token", a.limitRequests("token-ip", ratelimit.KeyByIP), a.limitRequests("token", ratelimit.KeyGlobal), a.RequestToken())
 	}
 }
 
-func SetupAuthRoutes(router *gin.Engine, db *sql.DB) {
+func (a *API) setupSessionRoutes(router *gin.Engine) {
 	authRoutes := router.Group("/auth")
 	{
-		authRoutes.POST("/token", RequestToken(db))
+		authRoutes.POST("/logout", a.Logout())
+		authRoutes.GET("/sessions", RequireScope(ScopeUsersRead), a.GetSessions())
+		authRoutes.DELETE("/sessions", RequireScope(ScopeUsersWrite), a.RevokeSessions())
+		authRoutes.DELETE("/sessions/:id", RequireScope(ScopeUsersWrite), a.RevokeSession())
 	}
 }
 
@@ -0,0 +1,135 @@
+package routes
+
+import (
+	"context"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/adrienchanove/alpha-enigma-api/jwt"
+)
+
+func TestSessionRoutesRequireScopes(t *testing.T) {
+	ta := newTestAPI(t, Config{})
+	alice := ta.createUser("alice")
+	full := ta.token(alice)
+	readMessages := ta.token(alice, ScopeMessagesRead)
+	readUsers := ta.token(alice, ScopeUsersRead)
+
+	ta.expect(ta.do("GET", "/auth/sessions", readMessages, ""), http.StatusForbidden, nil)
+	ta.expect(ta.do("DELETE", "/auth/sessions/session-"+full, readMessages, ""), http.StatusForbidden, nil)
+	ta.expect(ta.do("DELETE", "/auth/sessions", readMessages, ""), http.StatusForbidden, nil)
+	ta.expect(ta.do("DELETE", "/auth/sessions", readUsers, ""), http.StatusForbidden, nil)
+
+	var sessions []Session
+	ta.expect(ta.do("GET", "/auth/sessions", readUsers, ""), http.StatusOK, &sessions)
+	if len(sessions) != 3 {
+		t.Fatalf("%d sessions, want 3", len(sessions))
+	}
+
+	ta.expect(ta.do("DELETE", "/auth/sessions/session-"+readMessages, full, ""), http.StatusNoContent, nil)
+	// any token may log itself out
+	ta.expect(ta.do("POST", "/auth/logout", readUsers, ""), http.StatusNoContent, nil)
+	ta.expect(ta.do("GET", "/auth/sessions", full, ""), http.StatusOK, &sessions)
+	if len(sessions) != 1 || !sessions[0].Current {
+		t.Errorf("sessions after revocations: %+v, want only the current one", sessions)
+	}
+}
+
+// requestToken requests a token at POST /auth/token and decrypts it with the
+// key of the test users.
+func (ta *testAPI) requestToken(body string) string {
+	ta.t.Helper()
+	var response AuthResponse
+	ta.expect(ta.do("POST", "/auth/token", "", body), http.StatusOK, &response)
+
+	sealed, err := base64.StdEncoding.DecodeString(response.EncryptedToken)
+	if err != nil {
+		ta.t.Fatal(err)
+	}
+	key := testPrivateKey(ta.t)
+	if response.EncryptedKey == "" {
+		token, err := rsa.DecryptOAEP(sha256.New(), nil, key, sealed, nil)
+		if err != nil {
+			ta.t.Fatal(err)
+		}
+		return string(token)
+	}
+
+	encryptedKey, err := base64.StdEncoding.DecodeString(response.EncryptedKey)
+	if err != nil {
+		ta.t.Fatal(err)
+	}
+	aesKey, err := rsa.DecryptOAEP(sha256.New(), nil, key, encryptedKey, nil)
+	if err != nil {
+		ta.t.Fatal(err)
+	}
+	block, err := aes.NewCipher(aesKey)
+	if err != nil {
+		ta.t.Fatal(err)
+	}
+	gcm, err := cipher.NewGCM(block)
+	if err != nil {
+		ta.t.Fatal(err)
+	}
+	token, err := gcm.Open(nil, sealed[:gcm.NonceSize()], sealed[gcm.NonceSize():], nil)
+	if err != nil {
+		ta.t.Fatal(err)
+	}
+	return string(token)
+}
+
+func TestTokensKeepTheUsernameAsRegistered(t *testing.T) {
+	key, err := jwt.GenerateKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+	keys, err := jwt.NewKeyring(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ta := newTestAPI(t, Config{SigningKeys: keys})
+	ta.createUser("alice")
+
+	for _, tokenType := range []string{tokenTypeOpaque, tokenTypeJWT} {
+		token := ta.requestToken(`{"username": "ALICE", "tokenType": "` + tokenType + `"}`)
+
+		req := httptest.NewRequest("GET", "/users/me", nil)
+		req.Header.Set("Authorization", "Bearer "+token)
+		req.Header.Set("X-User", "alice")
+		w := httptest.NewRecorder()
+		ta.router.ServeHTTP(w, req)
+		ta.expect(w, http.StatusOK, nil)
+
+		tokenData, ok := ta.api.getUserFromToken(context.Background(), token)
+		if !ok || tokenData.Username != "alice" {
+			t.Errorf("%s token: username %q, want alice", tokenType, tokenData.Username)
+		}
+	}
+}
+
+func TestOpaqueTokenLifecycle(t *testing.T) {
+	ta := newTestAPI(t, Config{})
+	ta.createUser("alice")
+	first := ta.requestToken(`{"username": "alice", "device": "laptop"}`)
+	second := ta.requestToken(`{"username": "alice", "device": "phone"}`)
+
+	ta.expect(ta.do("GET", "/users/me", first, ""), http.StatusOK, nil)
+	var sessions []Session
+	ta.expect(ta.do("GET", "/auth/sessions", first, ""), http.StatusOK, &sessions)
+	if len(sessions) != 2 || sessions[0].Device != "laptop" || !sessions[0].Current || sessions[1].Device != "phone" || sessions[1].Current {
+		t.Fatalf("sessions %+v, want the laptop one current then the phone one", sessions)
+	}
+
+	ta.expect(ta.do("DELETE", "/auth/sessions?keepCurrent=true", first, ""), http.StatusNoContent, nil)
+	ta.expect(ta.do("GET", "/users/me", second, ""), http.StatusUnauthorized, nil)
+	ta.expect(ta.do("GET", "/users/me", first, ""), http.StatusOK, nil)
+
+	ta.expect(ta.do("POST", "/auth/logout", first, ""), http.StatusNoContent, nil)
+	ta.expect(ta.do("GET", "/users/me", first, ""), http.StatusUnauthorized, nil)
+}
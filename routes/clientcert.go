@@ -0,0 +1,76 @@
+package routes
+
+import (
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/hex"
+	"errors"
+	"log"
+	"net/http"
+	"slices"
+	"time"
+
+	"github.com/adrienchanove/alpha-enigma-api/store"
+	"github.com/adrienchanove/alpha-enigma-api/username"
+	"github.com/gin-gonic/gin"
+)
+
+// clientCertificate returns the client certificate of the request verified by
+// the TLS server, nil when there is none.
+func clientCertificate(c *gin.Context) *x509.Certificate {
+	if c.Request.TLS == nil || len(c.Request.TLS.VerifiedChains) == 0 {
+		return nil
+	}
+	return c.Request.TLS.VerifiedChains[0][0]
+}
+
+// certificateRevocationLifetime is how long the revocations of every session
+// of a user are kept when client certificates are enabled: the certificates
+// issued before stay valid much longer than the tokens.
+const certificateRevocationLifetime = 10 * 365 * 24 * time.Hour
+
+// authenticateClientCertificate returns the session of the user named by the
+// subject common name of the client certificate, or aborts the request. It
+// grants the default scopes, the admin scope still requires a token.
+func (a *API) authenticateClientCertificate(c *gin.Context) (TokenData, bool) {
+	cert := clientCertificate(c)
+	user, err := a.stores.Users.GetByUsernameKey(c.Request.Context(), username.Key(cert.Subject.CommonName))
+	if err != nil && !errors.Is(err, store.ErrNotFound) {
+		log.Println(err)
+		c.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"error": "failed to get user"})
+		return TokenData{}, false
+	}
+	if err != nil {
+		a.auditFailedAuth(c, 0, "unknown_certificate_subject")
+		c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"error": "no user for this client certificate"})
+		return TokenData{}, false
+	}
+
+	// the session is the certificate, for the rate limits and the audit log,
+	// revoked like the tokens: the certificates issued before the revocation
+	// of every session of the user are cut off
+	fingerprint := sha256.Sum256(cert.Raw)
+	sessionID := "cert:" + hex.EncodeToString(fingerprint[:16])
+	revoked, err := a.stores.Revocations.IsRevoked(c.Request.Context(), sessionID, user.ID, cert.NotBefore)
+	if err != nil {
+		log.Println(err)
+		c.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"error": "failed to check the revocations"})
+		return TokenData{}, false
+	}
+	if revoked {
+		a.auditFailedAuth(c, user.ID, "revoked_certificate")
+		c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"error": "client certificate revoked"})
+		return TokenData{}, false
+	}
+
+	return TokenData{
+		SessionID:   sessionID,
+		Timestamp:   cert.NotBefore,
+		UserID:      user.ID,
+		Username:    user.Username,
+		Scopes:      slices.Clone(defaultScopes),
+		ClientIP:    c.ClientIP(),
+		UserAgent:   c.Request.UserAgent(),
+		Certificate: true,
+	}, true
+}
@@ -0,0 +1,63 @@
+package routes
+
+import (
+	"context"
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/hex"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+// testCertificate returns a client certificate of name, as verified by the
+// TLS server, issued at notBefore.
+func testCertificate(name string, notBefore time.Time) *x509.Certificate {
+	return &x509.Certificate{
+		Raw:       []byte(name + notBefore.String()),
+		Subject:   pkix.Name{CommonName: name},
+		NotBefore: notBefore,
+		NotAfter:  notBefore.Add(365 * 24 * time.Hour),
+	}
+}
+
+// doWithCertificate sends the request authenticated by the client
+// certificate, and returns the response.
+func (ta *testAPI) doWithCertificate(method string, path string, cert *x509.Certificate) *httptest.ResponseRecorder {
+	ta.t.Helper()
+	req := httptest.NewRequest(method, path, nil)
+	req.TLS = &tls.ConnectionState{VerifiedChains: [][]*x509.Certificate{{cert}}}
+	w := httptest.NewRecorder()
+	ta.router.ServeHTTP(w, req)
+	return w
+}
+
+func TestClientCertificateRevocation(t *testing.T) {
+	ta := newTestAPI(t, Config{ClientCertificates: true})
+	ta.createUser("alice")
+	ta.createUser("bob")
+	issued := time.Now().Add(-time.Hour)
+	alice, bob := testCertificate("alice", issued), testCertificate("bob", issued)
+
+	ta.expect(ta.doWithCertificate("GET", "/auth/sessions", alice), http.StatusOK, nil)
+	ta.expect(ta.doWithCertificate("GET", "/auth/sessions", testCertificate("carol", issued)), http.StatusUnauthorized, nil)
+
+	// revoking every session cuts off the certificates issued before, but
+	// the current one when kept
+	ta.expect(ta.doWithCertificate("DELETE", "/auth/sessions?keepCurrent=true", bob), http.StatusNoContent, nil)
+	ta.expect(ta.doWithCertificate("GET", "/auth/sessions", bob), http.StatusOK, nil)
+	ta.expect(ta.doWithCertificate("DELETE", "/auth/sessions", alice), http.StatusNoContent, nil)
+	ta.expect(ta.doWithCertificate("GET", "/auth/sessions", alice), http.StatusUnauthorized, nil)
+	ta.expect(ta.doWithCertificate("GET", "/auth/sessions", testCertificate("alice", time.Now().Add(time.Second))), http.StatusOK, nil)
+
+	// and the revocation of the session, the certificate
+	fingerprint := sha256.Sum256(bob.Raw)
+	expires := time.Now().Add(time.Hour)
+	if err := ta.stores.Revocations.RevokeToken(context.Background(), "cert:"+hex.EncodeToString(fingerprint[:16]), expires); err != nil {
+		t.Fatal(err)
+	}
+	ta.expect(ta.doWithCertificate("GET", "/auth/sessions", bob), http.StatusUnauthorized, nil)
+}
@@ -0,0 +1,325 @@
+package routes
+
+import (
+	"context"
+	"errors"
+	"log"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/adrienchanove/alpha-enigma-api/store"
+	"github.com/gin-gonic/gin"
+)
+
+// Contact request statuses.
+const (
+	ContactPending  = store.ContactPending
+	ContactAccepted = store.ContactAccepted
+)
+
+type Contact struct {
+	ID        int       `json:"id"`
+	Username  string    `json:"username"`
+	PublicKey string    `json:"publicKey"`
+	Since     time.Time `json:"since"`
+}
+
+type ContactRequest struct {
+	ID          int       `json:"id"`
+	RequesterId int       `json:"requesterId"`
+	AddresseeId int       `json:"addresseeId"`
+	Status      string    `json:"status"`
+	CreatedAt   time.Time `json:"createdAt"`
+}
+
+type ContactRequestPost struct {
+	UserId int `json:"userId"`
+}
+
+func newContactRequest(contact store.Contact) ContactRequest {
+	return ContactRequest{
+		ID:          contact.ID,
+		RequesterId: contact.RequesterID,
+		AddresseeId: contact.AddresseeID,
+		Status:      contact.Status,
+		CreatedAt:   contact.CreatedAt,
+	}
+}
+
+// getContacts godoc
+// @Summary List contacts
+// @Description List the accepted contacts of the authenticated user with their public keys
+// @Tags contacts
+// @Produce json
+// @Success 200 {array} Contact
+// @Security ApiKeyAuth
+// @Router /contacts [get]
+func (a *API) GetContacts() gin.HandlerFunc {
+	contacts := a.stores.Contacts
+	return func(c *gin.Context) {
+		accepted, err := contacts.ListAccepted(c.Request.Context(), currentUserID(c))
+		if err != nil {
+			log.Println(err)
+			c.IndentedJSON(http.StatusInternalServerError, gin.H{"error": "Failed to get contacts"})
+			return
+		}
+
+		list := []Contact{}
+		for _, contact := range accepted {
+			list = append(list, Contact{
+				ID:        contact.User.ID,
+				Username:  contact.User.Username,
+				PublicKey: contact.User.PublicKey,
+				Since:     contact.Since,
+			})
+		}
+
+		c.IndentedJSON(http.StatusOK, list)
+	}
+}
+
+// deleteContact godoc
+// @Summary Remove a contact
+// @Tags contacts
+// @Produce json
+// @Param userId path int true "User ID"
+// @Success 204
+// @Security ApiKeyAuth
+// @Router /contacts/{userId} [delete]
+func (a *API) DeleteContact() gin.HandlerFunc {
+	contacts := a.stores.Contacts
+	return func(c *gin.Context) {
+		otherId, err := strconv.Atoi(c.Param("userId"))
+		if err != nil {
+			c.IndentedJSON(http.StatusBadRequest, gin.H{"error": "Invalid user ID"})
+			return
+		}
+
+		if err := contacts.RemoveAccepted(c.Request.Context(), currentUserID(c), otherId); err != nil {
+			if errors.Is(err, store.ErrNotFound) {
+				c.IndentedJSON(http.StatusNotFound, gin.H{"error": "contact not found"})
+			} else {
+				log.Println(err)
+				c.IndentedJSON(http.StatusInternalServerError, gin.H{"error": "Failed to remove contact"})
+			}
+			return
+		}
+
+		c.Status(http.StatusNoContent)
+	}
+}
+
+// getContactRequests godoc
+// @Summary List pending contact requests
+// @Description List the pending contact requests sent and received by the authenticated user
+// @Tags contacts
+// @Produce json
+// @Success 200 {array} ContactRequest
+// @Security ApiKeyAuth
+// @Router /contacts/requests [get]
+func (a *API) GetContactRequests() gin.HandlerFunc {
+	contacts := a.stores.Contacts
+	return func(c *gin.Context) {
+		pending, err := contacts.ListPending(c.Request.Context(), currentUserID(c))
+		if err != nil {
+			log.Println(err)
+			c.IndentedJSON(http.StatusInternalServerError, gin.H{"error": "Failed to get contact requests"})
+			return
+		}
+
+		requests := []ContactRequest{}
+		for _, contact := range pending {
+			requests = append(requests, newContactRequest(contact))
+		}
+
+		c.IndentedJSON(http.StatusOK, requests)
+	}
+}
+
+// setContactRequest godoc
+// @Summary Send a contact request
+// @Description Send a contact request to a user. If they already sent you one, it is accepted instead
+// @Tags contacts
+// @Accept json
+// @Produce json
+// @Param request body ContactRequestPost true "Contact request"
+// @Success 201 {object} ContactRequest
+// @Success 200 {object} ContactRequest
+// @Security ApiKeyAuth
+// @Router /contacts/requests [post]
+func (a *API) SetContactRequest() gin.HandlerFunc {
+	users := a.stores.Users
+	contacts := a.stores.Contacts
+	return func(c *gin.Context) {
+		var newRequest ContactRequestPost
+		if err := c.ShouldBindJSON(&newRequest); err != nil {
+			c.IndentedJSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		if newRequest.UserId <= 0 {
+			c.IndentedJSON(http.StatusBadRequest, gin.H{"error": "userId must be a positive integer"})
+			return
+		}
+		userId := currentUserID(c)
+		if newRequest.UserId == userId {
+			c.IndentedJSON(http.StatusBadRequest, gin.H{"error": "you cannot add yourself as a contact"})
+			return
+		}
+
+		exists, err := userExists(c.Request.Context(), users, newRequest.UserId)
+		if err != nil {
+			log.Println(err)
+			c.IndentedJSON(http.StatusInternalServerError, gin.H{"error": "Failed to send contact request"})
+			return
+		}
+		if !exists {
+			c.IndentedJSON(http.StatusNotFound, gin.H{"error": "user not found"})
+			return
+		}
+
+		blocked, err := users.IsBlocked(c.Request.Context(), userId, newRequest.UserId)
+		if err != nil {
+			log.Println(err)
+			c.IndentedJSON(http.StatusInternalServerError, gin.H{"error": "Failed to send contact request"})
+			return
+		}
+		if blocked {
+			c.IndentedJSON(http.StatusForbidden, gin.H{"error": "this user does not accept your contact requests"})
+			return
+		}
+
+		// An existing request in either direction
+		existing, err := contacts.Find(c.Request.Context(), userId, newRequest.UserId)
+		if err != nil && !errors.Is(err, store.ErrNotFound) {
+			log.Println(err)
+			c.IndentedJSON(http.StatusInternalServerError, gin.H{"error": "Failed to send contact request"})
+			return
+		}
+		if err == nil {
+			switch {
+			case existing.Status == ContactAccepted:
+				c.IndentedJSON(http.StatusConflict, gin.H{"error": "already a contact"})
+			case existing.RequesterID == userId:
+				c.IndentedJSON(http.StatusConflict, gin.H{"error": "contact request already sent"})
+			default:
+				// They asked first, accept their request
+				if err := contacts.Accept(c.Request.Context(), existing.ID, userId, time.Now()); err != nil {
+					log.Println(err)
+					c.IndentedJSON(http.StatusInternalServerError, gin.H{"error": "Failed to send contact request"})
+					return
+				}
+				existing.Status = ContactAccepted
+				c.IndentedJSON(http.StatusOK, newContactRequest(existing))
+			}
+			return
+		}
+
+		request := ContactRequest{
+			RequesterId: userId,
+			AddresseeId: newRequest.UserId,
+			Status:      ContactPending,
+			CreatedAt:   time.Now(),
+		}
+		request.ID, err = contacts.Create(c.Request.Context(), store.Contact{
+			RequesterID: request.RequesterId,
+			AddresseeID: request.AddresseeId,
+			Status:      request.Status,
+			CreatedAt:   request.CreatedAt,
+		})
+		if err != nil {
+			log.Println(err)
+			c.IndentedJSON(http.StatusInternalServerError, gin.H{"error": "Failed to send contact request"})
+			return
+		}
+
+		c.IndentedJSON(http.StatusCreated, request)
+	}
+}
+
+// acceptContactRequest godoc
+// @Summary Accept a contact request
+// @Tags contacts
+// @Produce json
+// @Param id path int true "Contact request ID"
+// @Success 204
+// @Security ApiKeyAuth
+// @Router /contacts/requests/{id}/accept [post]
+func (a *API) AcceptContactRequest() gin.HandlerFunc {
+	contacts := a.stores.Contacts
+	return func(c *gin.Context) {
+		answerContactRequest(c, func(ctx context.Context, id int) error {
+			return contacts.Accept(ctx, id, currentUserID(c), time.Now())
+		})
+	}
+}
+
+// rejectContactRequest godoc
+// @Summary Reject a contact request
+// @Tags contacts
+// @Produce json
+// @Param id path int true "Contact request ID"
+// @Success 204
+// @Security ApiKeyAuth
+// @Router /contacts/requests/{id}/reject [post]
+func (a *API) RejectContactRequest() gin.HandlerFunc {
+	contacts := a.stores.Contacts
+	return func(c *gin.Context) {
+		answerContactRequest(c, func(ctx context.Context, id int) error {
+			return contacts.Reject(ctx, id, currentUserID(c))
+		})
+	}
+}
+
+// cancelContactRequest godoc
+// @Summary Cancel a contact request
+// @Description Cancel a contact request sent by the authenticated user
+// @Tags contacts
+// @Produce json
+// @Param id path int true "Contact request ID"
+// @Success 204
+// @Security ApiKeyAuth
+// @Router /contacts/requests/{id} [delete]
+func (a *API) CancelContactRequest() gin.HandlerFunc {
+	contacts := a.stores.Contacts
+	return func(c *gin.Context) {
+		answerContactRequest(c, func(ctx context.Context, id int) error {
+			return contacts.Cancel(ctx, id, currentUserID(c))
+		})
+	}
+}
+
+// answerContactRequest calls answer with the id of the pending contact request
+// and writes the response.
+func answerContactRequest(c *gin.Context, answer func(ctx context.Context, id int) error) {
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.IndentedJSON(http.StatusBadRequest, gin.H{"error": "Invalid contact request ID"})
+		return
+	}
+
+	if err := answer(c.Request.Context(), id); err != nil {
+		if errors.Is(err, store.ErrNotFound) {
+			c.IndentedJSON(http.StatusNotFound, gin.H{"error": "contact request not found"})
+		} else {
+			log.Println(err)
+			c.IndentedJSON(http.StatusInternalServerError, gin.H{"error": "Failed to update contact request"})
+		}
+		return
+	}
+
+	c.Status(http.StatusNoContent)
+}
+
+func (a *API) setupContactRoutes(router *gin.Engine) {
+	contactRoutes := router.Group("/contacts")
+	{
+		contactRoutes.GET("/", RequireScope(ScopeUsersRead), a.GetContacts())
+		contactRoutes.DELETE("/:userId", RequireScope(ScopeUsersWrite), a.DeleteContact())
+		contactRoutes.GET("/requests", RequireScope(ScopeUsersRead), a.GetContactRequests())
+		contactRoutes.POST("/requests", RequireScope(ScopeUsersWrite), a.SetContactRequest())
+		contactRoutes.POST("/requests/:id/accept", RequireScope(ScopeUsersWrite), a.AcceptContactRequest())
+		contactRoutes.POST("/requests/:id/reject", RequireScope(ScopeUsersWrite), a.RejectContactRequest())
+		contactRoutes.DELETE("/requests/:id", RequireScope(ScopeUsersWrite), a.CancelContactRequest())
+	}
+}
@@ -1,14 +1,14 @@
 package routes
 
 import (
-	"database/sql"
 	"errors"
 	"log"
 	"net/http"
-	"strconv"
-
 	"slices"
+	"strconv"
+	"time"
 
+	"github.com/adrienchanove/alpha-enigma-api/store"
 	"github.com/gin-gonic/gin"
 )
 
@@ -17,60 +17,76 @@ type Message struct {
 	Content    string `json:"content"`
 	SenderId   int    `json:"senderId"`
 	ReceiverId int    `json:"receiverId"`
+	// Deleted messages belonged to a deleted account and have no content.
+	Deleted bool `json:"deleted,omitempty"`
+}
+
+func newMessage(m store.Message) Message {
+	return Message{
+		ID:         m.ID,
+		Content:    m.Content,
+		SenderId:   m.SenderID,
+		ReceiverId: m.ReceiverID,
+		Deleted:    m.DeletedAt != nil,
+	}
 }
 
 // getMessages godoc
 // @Summary Get all messages
-// @Description Get a list of all messages
+// @Description Get a list of all messages sent or received by the authenticated user
+// @Description Messages exchanged with blocked users are left out
 // @Tags messages
 // @Accept json
 // @Produce json
 // @Success 200 {array} Message
 // @Security ApiKeyAuth
-// @Security X-User
 // @Router /messages [get]
-func GetMessages(db *sql.DB) gin.HandlerFunc {
+func (a *API) GetMessages() gin.HandlerFunc {
+	users := a.stores.Users
+	messages := a.stores.Messages
 	return func(c *gin.Context) {
-		rows, err := db.Query("SELECT id, content, sender_id, receiver_id FROM messages")
+		userId := currentUserID(c)
+
+		stored, err := messages.ListForUser(c.Request.Context(), userId)
 		if err != nil {
 			log.Println(err)
 			c.IndentedJSON(http.StatusInternalServerError, gin.H{"error": "Failed to get messages"})
 			return
 		}
-		defer rows.Close()
 
-		var messages []Message
-		for rows.Next() {
-			var m Message
-			if err := rows.Scan(&m.ID, &m.Content, &m.SenderId, &m.ReceiverId); err != nil {
-				log.Println(err)
-				c.IndentedJSON(http.StatusInternalServerError, gin.H{"error": "Failed to get messages"})
-				return
-			}
-			messages = append(messages, m)
+		blockedIds, err := users.BlockedUserIDs(c.Request.Context(), userId)
+		if err != nil {
+			log.Println(err)
+			c.IndentedJSON(http.StatusInternalServerError, gin.H{"error": "Failed to get messages"})
+			return
 		}
 
-		if len(messages) == 0 {
-			c.IndentedJSON(http.StatusOK, []Message{})
-		} else {
-			c.IndentedJSON(http.StatusOK, messages)
+		list := []Message{}
+		for _, m := range stored {
+			if slices.Contains(blockedIds, m.SenderID) || slices.Contains(blockedIds, m.ReceiverID) {
+				continue
+			}
+			list = append(list, newMessage(m))
 		}
 
+		c.IndentedJSON(http.StatusOK, list)
 	}
 }
 
 // setMessage godoc
 // @Summary Create a new message
 // @Description Create a new message with the input payload
+// @Description The sender is the authenticated user, senderId can be omitted
+// @Description The message is refused when the receiver blocked you, you blocked them, or their privacy settings
+// @Description do not accept your messages
 // @Tags messages
 // @Accept json
 // @Produce json
 // @Param message body Message true "Create message"
 // @Success 200 {object} Message
 // @Security ApiKeyAuth
-// @Security X-User
 // @Router /messages [post]
-func SetMessage(db *sql.DB) gin.HandlerFunc {
+func (a *API) SetMessage() gin.HandlerFunc {
 	return func(c *gin.Context) {
 		var newMessage Message
 		if err := c.ShouldBindJSON(&newMessage); err != nil {
@@ -81,30 +97,46 @@ func SetMessage(db *sql.DB) gin.HandlerFunc {
 			c.IndentedJSON(http.StatusBadRequest, gin.H{"error": "content is required"})
 			return
 		}
-		// Check if senderId and receiverId are valid
-		if newMessage.SenderId <= 0 {
-			c.IndentedJSON(http.StatusBadRequest, gin.H{"error": "senderId must be a positive integer"})
+		// The sender is always the authenticated user
+		userId := currentUserID(c)
+		if newMessage.SenderId != 0 && newMessage.SenderId != userId {
+			c.IndentedJSON(http.StatusForbidden, gin.H{"error": "senderId must be the authenticated user"})
 			return
 		}
+		newMessage.SenderId = userId
+
+		// Check if receiverId is valid
 		if newMessage.ReceiverId <= 0 {
 			c.IndentedJSON(http.StatusBadRequest, gin.H{"error": "receiverId must be a positive integer"})
 			return
 		}
 
-		result, err := db.Exec("INSERT INTO messages (content, sender_id, receiver_id) VALUES (?, ?, ?)", newMessage.Content, newMessage.SenderId, newMessage.ReceiverId)
+		// Check the receiver accepts messages from the sender
+		allowed, err := canMessage(c.Request.Context(), a.stores, newMessage.SenderId, newMessage.ReceiverId)
 		if err != nil {
-			log.Println(err)
-			c.IndentedJSON(http.StatusInternalServerError, gin.H{"error": "Failed to create message"})
+			if errors.Is(err, store.ErrNotFound) {
+				c.IndentedJSON(http.StatusNotFound, gin.H{"error": "receiver not found"})
+			} else {
+				log.Println(err)
+				c.IndentedJSON(http.StatusInternalServerError, gin.H{"error": "Failed to create message"})
+			}
+			return
+		}
+		if !allowed {
+			c.IndentedJSON(http.StatusForbidden, gin.H{"error": "this user does not accept your messages"})
 			return
 		}
 
-		id, err := result.LastInsertId()
+		newMessage.ID, err = a.stores.Messages.Create(c.Request.Context(), store.Message{
+			Content:    newMessage.Content,
+			SenderID:   newMessage.SenderId,
+			ReceiverID: newMessage.ReceiverId,
+		})
 		if err != nil {
 			log.Println(err)
 			c.IndentedJSON(http.StatusInternalServerError, gin.H{"error": "Failed to create message"})
 			return
 		}
-		newMessage.ID = int(id)
 
 		c.IndentedJSON(http.StatusCreated, newMessage)
 	}
@@ -113,99 +145,71 @@ func SetMessage(db *sql.DB) gin.HandlerFunc {
 // getDiscussions godoc
 // @Summary Return a list of user who a user speaks to
 // @Description Return a user list who the user have discussed with
+// @Description Blocked users are left out
 // @Tags messages
 // @Accept json
 // @Produce json
 // @Success 200 {array} UserGet
 // @Security ApiKeyAuth
-// @Security X-User
 // @Router /messages/getDiscussions/ [get]
-func GetDiscussions(db *sql.DB) gin.HandlerFunc {
+func (a *API) GetDiscussions() gin.HandlerFunc {
+	users := a.stores.Users
+	messages := a.stores.Messages
 	return func(c *gin.Context) {
-		username := c.GetHeader("X-User")
-		if username == "" {
-			c.IndentedJSON(http.StatusBadRequest, gin.H{"error": "X-User header is required"})
-			return
-		}
-		var userId int
-		var err error
-		userId, err = getUserIDByUsername(db, username)
+		userId := currentUserID(c)
+
+		peers, err := messages.Peers(c.Request.Context(), userId)
 		if err != nil {
-			c.IndentedJSON(http.StatusBadRequest, gin.H{"error": "user not found"})
+			log.Println(err)
+			c.IndentedJSON(http.StatusInternalServerError, gin.H{"error": "Failed to get discussions"})
 			return
 		}
 
-		if userId <= 0 {
-			c.IndentedJSON(http.StatusBadRequest, gin.H{"error": "userId must be a positive integer"})
-			return
-		}
-		rows, err := db.Query("SELECT DISTINCT receiver_id, sender_id FROM messages WHERE sender_id = ? or receiver_id = ?", userId, userId)
+		blockedIds, err := users.BlockedUserIDs(c.Request.Context(), userId)
 		if err != nil {
 			log.Println(err)
 			c.IndentedJSON(http.StatusInternalServerError, gin.H{"error": "Failed to get discussions"})
 			return
 		}
-		defer rows.Close()
-
-		// userIds est une liste de int
-		var userIds []int
-
-		// length rows
-		for rows.Next() {
-			var u int
-			var u2 int
-			if err := rows.Scan(&u, &u2); err != nil {
-				log.Println(err)
-			}
 
-			if !slices.Contains(userIds, u) && u != userId {
-				userIds = append(userIds, u)
+		discussions := []UserGet{}
+		for _, peerId := range peers {
+			if peerId == userId || slices.Contains(blockedIds, peerId) {
+				continue
 			}
 
-			if !slices.Contains(userIds, u2) && u2 != userId {
-				userIds = append(userIds, u2)
-			}
-
-		}
-
-		var users []UserGet
-
-		for _, userId := range userIds {
-			var u UserGet
-
-			err := db.QueryRow("SELECT id, username FROM users WHERE id = ?", userId).Scan(&u.ID, &u.Username)
+			peer, err := users.Get(c.Request.Context(), peerId)
 			if err != nil {
-				if err == sql.ErrNoRows {
-					continue
+				if errors.Is(err, store.ErrNotFound) {
+					// Removed without leaving a tombstone, still show the discussion
+					peer = store.User{ID: peerId, DeletedAt: &time.Time{}}
 				} else {
 					log.Println(err)
 					c.IndentedJSON(http.StatusInternalServerError, gin.H{"error": "Failed to get user"})
 					return
 				}
 			}
-			users = append(users, u)
+			discussions = append(discussions, newProfile(peer, false).UserGet)
 		}
 
-		if len(users) == 0 {
-			c.IndentedJSON(http.StatusOK, []UserGet{})
-		} else {
-			c.IndentedJSON(http.StatusOK, users)
-		}
+		c.IndentedJSON(http.StatusOK, discussions)
 	}
 }
 
 // getMessageWith godoc
 // @Summary get messages with a user
 // @Description get messages with a user
+// @Description Fails with 403 when one of you blocked the other
 // @Tags messages
 // @Accept json
 // @Produce json
 // @Param userId path int true "User ID"
 // @Success 200 {array} Message
 // @Security ApiKeyAuth
-// @Security X-User
 // @Router /messages/getMessagesWith/{userId} [get]
-func GetMessagesWith(db *sql.DB) gin.HandlerFunc {
+func (a *API) GetMessagesWith() gin.HandlerFunc {
+	users := a.stores.Users
+	messages := a.stores.Messages
 	return func(c *gin.Context) {
 		foreignUserIdStr := c.Param("userId")
 		foreignUserId, err := strconv.Atoi(foreignUserIdStr)
@@ -217,65 +221,42 @@ func GetMessagesWith(db *sql.DB) gin.HandlerFunc {
 			c.IndentedJSON(http.StatusBadRequest, gin.H{"error": "userId must be a positive integer"})
 			return
 		}
-		username := c.GetHeader("X-User")
-		if username == "" {
-			c.IndentedJSON(http.StatusBadRequest, gin.H{"error": "X-User header is required"})
+		userId := currentUserID(c)
+
+		blocked, err := users.IsBlocked(c.Request.Context(), userId, foreignUserId)
+		if err != nil {
+			log.Println(err)
+			c.IndentedJSON(http.StatusInternalServerError, gin.H{"error": "Failed to get messages"})
 			return
 		}
-		userId, err := getUserIDByUsername(db, username)
-		if err != nil {
-			c.IndentedJSON(http.StatusBadRequest, gin.H{"error": "user not found"})
+		if blocked {
+			c.IndentedJSON(http.StatusForbidden, gin.H{"error": "user is blocked"})
 			return
 		}
 
-		rows, err := db.Query("SELECT id, content, sender_id, receiver_id FROM messages WHERE (sender_id = ? AND receiver_id = ?) OR (sender_id = ? AND receiver_id = ?)", userId, foreignUserId, foreignUserId, userId)
+		stored, err := messages.ListBetween(c.Request.Context(), userId, foreignUserId)
 		if err != nil {
 			log.Println(err)
 			c.IndentedJSON(http.StatusInternalServerError, gin.H{"error": "Failed to get messages"})
 			return
 		}
-		defer rows.Close()
 
-		var messages []Message
-		for rows.Next() {
-			var m Message
-			if err := rows.Scan(&m.ID, &m.Content, &m.SenderId, &m.ReceiverId); err != nil {
-				log.Println(err)
-				c.IndentedJSON(http.StatusInternalServerError, gin.H{"error": "Failed to get messages"})
-				return
-			}
-			messages = append(messages, m)
+		list := []Message{}
+		for _, m := range stored {
+			list = append(list, newMessage(m))
 		}
 
-		if len(messages) == 0 {
-			c.IndentedJSON(http.StatusOK, []Message{})
-		} else {
-			c.IndentedJSON(http.StatusOK, messages)
-		}
+		c.IndentedJSON(http.StatusOK, list)
 	}
 }
 
-func SetupMessageRoutes(router *gin.Engine, db *sql.DB) {
+func (a *API) setupMessageRoutes(router *gin.Engine) {
 	messageRoutes := router.Group("/messages")
 	{
-		messageRoutes.GET("/", GetMessages(db))
-		messageRoutes.POST("/", SetMessage(db))
-		messageRoutes.GET("/getDiscussions/", GetDiscussions(db))
-		messageRoutes.GET("/getMessagesWith/:userId", GetMessagesWith(db))
-
-	}
-}
+		messageRoutes.GET("/", RequireScope(ScopeMessagesRead), a.GetMessages())
+		messageRoutes.POST("/", RequireScope(ScopeMessagesWrite), a.limitRequests("messages", keyByUser), a.SetMessage())
+		messageRoutes.GET("/getDiscussions/", RequireScope(ScopeMessagesRead), a.GetDiscussions())
+		messageRoutes.GET("/getMessagesWith/:userId", RequireScope(ScopeMessagesRead), a.GetMessagesWith())
 
-// getUserIDByUsername
-func getUserIDByUsername(db *sql.DB, username string) (int, error) {
-	var userId int
-	err := db.QueryRow("SELECT id FROM users WHERE username = ?", username).Scan(&userId)
-	if err != nil {
-		if err == sql.ErrNoRows {
-			return 0, errors.New("user not found")
-		} else {
-			return 0, err
-		}
 	}
-	return userId, nil
 }
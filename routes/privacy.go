@@ -0,0 +1,233 @@
+package routes
+
+import (
+	"context"
+	"errors"
+	"log"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/adrienchanove/alpha-enigma-api/store"
+	"github.com/gin-gonic/gin"
+)
+
+// Who may send messages to a user.
+const (
+	MessagePolicyEveryone = "everyone"
+	// MessagePolicyDiscussions only accepts messages from users the
+	// recipient already sent a message to.
+	MessagePolicyDiscussions = "discussions"
+	// MessagePolicyContacts only accepts messages from accepted contacts.
+	MessagePolicyContacts = "contacts"
+)
+
+var messagePolicies = []string{MessagePolicyEveryone, MessagePolicyDiscussions, MessagePolicyContacts}
+
+type PrivacySettings struct {
+	AllowMessagesFrom   string `json:"allowMessagesFrom"`
+	HiddenFromDirectory bool   `json:"hiddenFromDirectory"`
+}
+
+// canMessage reports whether senderId may send a message to receiverId,
+// according to their blocks and the receiver's privacy settings. It returns
+// store.ErrNotFound when the receiver does not exist.
+func canMessage(ctx context.Context, stores *store.Stores, senderId int, receiverId int) (bool, error) {
+	receiver, err := stores.Users.Get(ctx, receiverId)
+	if err != nil {
+		return false, err
+	}
+	if receiver.DeletedAt != nil {
+		return false, store.ErrNotFound
+	}
+
+	blocked, err := stores.Users.IsBlocked(ctx, senderId, receiverId)
+	if err != nil || blocked {
+		return false, err
+	}
+
+	switch receiver.MessagePolicy {
+	case MessagePolicyDiscussions:
+		return stores.Messages.HasSent(ctx, receiverId, senderId)
+	case MessagePolicyContacts:
+		return stores.Contacts.AreContacts(ctx, senderId, receiverId)
+	default:
+		return true, nil
+	}
+}
+
+// getBlocks godoc
+// @Summary List blocked users
+// @Description List the users blocked by the authenticated user
+// @Tags privacy
+// @Produce json
+// @Success 200 {array} UserGet
+// @Security ApiKeyAuth
+// @Router /users/me/blocks [get]
+func (a *API) GetBlocks() gin.HandlerFunc {
+	users := a.stores.Users
+	return func(c *gin.Context) {
+		blocked, err := users.ListBlocked(c.Request.Context(), currentUserID(c))
+		if err != nil {
+			log.Println(err)
+			c.IndentedJSON(http.StatusInternalServerError, gin.H{"error": "Failed to get blocked users"})
+			return
+		}
+
+		c.IndentedJSON(http.StatusOK, newUserGets(blocked))
+	}
+}
+
+// blockUser godoc
+// @Summary Block a user
+// @Description Block a user: neither of you can message the other, and you no longer see each other
+// @Description in the directory, discussions and messages. They are also removed from your contacts
+// @Tags privacy
+// @Produce json
+// @Param userId path int true "User ID"
+// @Success 204
+// @Security ApiKeyAuth
+// @Router /users/me/blocks/{userId} [put]
+func (a *API) BlockUser() gin.HandlerFunc {
+	users := a.stores.Users
+	contacts := a.stores.Contacts
+	return func(c *gin.Context) {
+		blockedId, err := strconv.Atoi(c.Param("userId"))
+		if err != nil || blockedId <= 0 {
+			c.IndentedJSON(http.StatusBadRequest, gin.H{"error": "Invalid user ID"})
+			return
+		}
+		userId := currentUserID(c)
+		if blockedId == userId {
+			c.IndentedJSON(http.StatusBadRequest, gin.H{"error": "you cannot block yourself"})
+			return
+		}
+
+		exists, err := userExists(c.Request.Context(), users, blockedId)
+		if err != nil {
+			log.Println(err)
+			c.IndentedJSON(http.StatusInternalServerError, gin.H{"error": "Failed to block user"})
+			return
+		}
+		if !exists {
+			c.IndentedJSON(http.StatusNotFound, gin.H{"error": "user not found"})
+			return
+		}
+
+		if err := users.Block(c.Request.Context(), userId, blockedId, time.Now()); err != nil {
+			log.Println(err)
+			c.IndentedJSON(http.StatusInternalServerError, gin.H{"error": "Failed to block user"})
+			return
+		}
+
+		if err := contacts.Remove(c.Request.Context(), userId, blockedId); err != nil {
+			log.Println(err)
+			c.IndentedJSON(http.StatusInternalServerError, gin.H{"error": "Failed to block user"})
+			return
+		}
+
+		c.Status(http.StatusNoContent)
+	}
+}
+
+// unblockUser godoc
+// @Summary Unblock a user
+// @Tags privacy
+// @Produce json
+// @Param userId path int true "User ID"
+// @Success 204
+// @Security ApiKeyAuth
+// @Router /users/me/blocks/{userId} [delete]
+func (a *API) UnblockUser() gin.HandlerFunc {
+	users := a.stores.Users
+	return func(c *gin.Context) {
+		blockedId, err := strconv.Atoi(c.Param("userId"))
+		if err != nil {
+			c.IndentedJSON(http.StatusBadRequest, gin.H{"error": "Invalid user ID"})
+			return
+		}
+
+		if err := users.Unblock(c.Request.Context(), currentUserID(c), blockedId); err != nil {
+			if errors.Is(err, store.ErrNotFound) {
+				c.IndentedJSON(http.StatusNotFound, gin.H{"error": "user is not blocked"})
+			} else {
+				log.Println(err)
+				c.IndentedJSON(http.StatusInternalServerError, gin.H{"error": "Failed to unblock user"})
+			}
+			return
+		}
+
+		c.Status(http.StatusNoContent)
+	}
+}
+
+// getPrivacy godoc
+// @Summary Get privacy settings
+// @Description Get the privacy settings of the authenticated user
+// @Tags privacy
+// @Produce json
+// @Success 200 {object} PrivacySettings
+// @Security ApiKeyAuth
+// @Router /users/me/privacy [get]
+func (a *API) GetPrivacy() gin.HandlerFunc {
+	users := a.stores.Users
+	return func(c *gin.Context) {
+		user, err := users.Get(c.Request.Context(), currentUserID(c))
+		if err != nil {
+			log.Println(err)
+			c.IndentedJSON(http.StatusInternalServerError, gin.H{"error": "Failed to get privacy settings"})
+			return
+		}
+
+		c.IndentedJSON(http.StatusOK, PrivacySettings{AllowMessagesFrom: user.MessagePolicy, HiddenFromDirectory: user.HiddenFromDirectory})
+	}
+}
+
+// setPrivacy godoc
+// @Summary Update privacy settings
+// @Description allowMessagesFrom is "everyone", "discussions" to only accept messages from users you
+// @Description already sent a message to, or "contacts" to only accept messages from your contacts.
+// @Description hiddenFromDirectory hides you from the users list
+// @Tags privacy
+// @Accept json
+// @Produce json
+// @Param settings body PrivacySettings true "Privacy settings"
+// @Success 200 {object} PrivacySettings
+// @Security ApiKeyAuth
+// @Router /users/me/privacy [put]
+func (a *API) SetPrivacy() gin.HandlerFunc {
+	users := a.stores.Users
+	return func(c *gin.Context) {
+		var settings PrivacySettings
+		if err := c.ShouldBindJSON(&settings); err != nil {
+			c.IndentedJSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		if !slices.Contains(messagePolicies, settings.AllowMessagesFrom) {
+			c.IndentedJSON(http.StatusBadRequest, gin.H{"error": "allowMessagesFrom must be one of " + strings.Join(messagePolicies, ", ")})
+			return
+		}
+
+		err := users.SetPrivacy(c.Request.Context(), currentUserID(c), settings.AllowMessagesFrom, settings.HiddenFromDirectory)
+		if err != nil {
+			log.Println(err)
+			c.IndentedJSON(http.StatusInternalServerError, gin.H{"error": "Failed to update privacy settings"})
+			return
+		}
+
+		c.IndentedJSON(http.StatusOK, settings)
+	}
+}
+
+func (a *API) setupPrivacyRoutes(router *gin.Engine) {
+	privacyRoutes := router.Group("/users/me")
+	{
+		privacyRoutes.GET("/blocks", RequireScope(ScopeUsersRead), a.GetBlocks())
+		privacyRoutes.PUT("/blocks/:userId", RequireScope(ScopeUsersWrite), a.BlockUser())
+		privacyRoutes.DELETE("/blocks/:userId", RequireScope(ScopeUsersWrite), a.UnblockUser())
+		privacyRoutes.GET("/privacy", RequireScope(ScopeUsersRead), a.GetPrivacy())
+		privacyRoutes.PUT("/privacy", RequireScope(ScopeUsersWrite), a.SetPrivacy())
+	}
+}
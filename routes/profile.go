@@ -0,0 +1,286 @@
+package routes
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"slices"
+	"strconv"
+	"time"
+	"unicode/utf8"
+
+	"github.com/adrienchanove/alpha-enigma-api/store"
+	"github.com/gin-gonic/gin"
+)
+
+// Profile fields limits, in characters.
+const (
+	maxDisplayNameLength = 64
+	maxBioLength         = 500
+	maxStatusTextLength  = 140
+)
+
+// maxAvatarSize is the largest accepted avatar image, in bytes.
+const maxAvatarSize = 256 << 10
+
+var avatarContentTypes = []string{"image/png", "image/jpeg", "image/gif", "image/webp"}
+
+// lastSeenResolution is how often the last seen time of a user is updated.
+const lastSeenResolution = time.Minute
+
+type Profile struct {
+	UserGet
+	ShowLastSeen bool `json:"showLastSeen"`
+}
+
+type ProfilePatch struct {
+	DisplayName  *string `json:"displayName"`
+	Bio          *string `json:"bio"`
+	StatusText   *string `json:"statusText"`
+	ShowLastSeen *bool   `json:"showLastSeen"`
+}
+
+// newProfile returns the public view of u. The last seen time is only
+// filled in when the user shows it, unless showHidden is set.
+func newProfile(u store.User, showHidden bool) Profile {
+	p := Profile{
+		UserGet: UserGet{
+			ID:          u.ID,
+			Username:    u.Username,
+			PublicKey:   u.PublicKey,
+			DisplayName: u.DisplayName,
+			Bio:         u.Bio,
+			StatusText:  u.StatusText,
+			Deleted:     u.DeletedAt != nil,
+		},
+		ShowLastSeen: u.ShowLastSeen,
+	}
+	if p.Deleted {
+		p.DisplayName = deletedUserName
+	}
+	if u.LastSeenAt != nil && (u.ShowLastSeen || showHidden) {
+		p.LastSeenAt = u.LastSeenAt
+	}
+	if u.HasAvatar {
+		p.AvatarURL = fmt.Sprintf("/users/%d/avatar", u.ID)
+	}
+	return p
+}
+
+// newUserGets returns the public views of users.
+func newUserGets(users []store.User) []UserGet {
+	userGets := make([]UserGet, 0, len(users))
+	for _, u := range users {
+		userGets = append(userGets, newProfile(u, false).UserGet)
+	}
+	return userGets
+}
+
+// checkLength returns an error when value is longer than max characters.
+func checkLength(field string, value *string, max int) error {
+	if value != nil && utf8.RuneCountInString(*value) > max {
+		return fmt.Errorf("%s must be at most %d characters", field, max)
+	}
+	return nil
+}
+
+// getProfile godoc
+// @Summary Get your profile
+// @Description Get the profile of the authenticated user
+// @Tags profile
+// @Produce json
+// @Success 200 {object} Profile
+// @Security ApiKeyAuth
+// @Router /users/me [get]
+func (a *API) GetProfile() gin.HandlerFunc {
+	users := a.stores.Users
+	return func(c *gin.Context) {
+		user, err := users.Get(c.Request.Context(), currentUserID(c))
+		if err != nil {
+			log.Println(err)
+			c.IndentedJSON(http.StatusInternalServerError, gin.H{"error": "Failed to get profile"})
+			return
+		}
+
+		c.IndentedJSON(http.StatusOK, newProfile(user, true))
+	}
+}
+
+// updateProfile godoc
+// @Summary Update your profile
+// @Description Update the profile of the authenticated user, only the given fields are changed
+// @Description showLastSeen set to false hides your last seen time from other users
+// @Tags profile
+// @Accept json
+// @Produce json
+// @Param profile body ProfilePatch true "Profile fields"
+// @Success 200 {object} Profile
+// @Security ApiKeyAuth
+// @Router /users/me [patch]
+func (a *API) UpdateProfile() gin.HandlerFunc {
+	users := a.stores.Users
+	return func(c *gin.Context) {
+		var patch ProfilePatch
+		if err := c.ShouldBindJSON(&patch); err != nil {
+			c.IndentedJSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		for _, err := range []error{
+			checkLength("displayName", patch.DisplayName, maxDisplayNameLength),
+			checkLength("bio", patch.Bio, maxBioLength),
+			checkLength("statusText", patch.StatusText, maxStatusTextLength),
+		} {
+			if err != nil {
+				c.IndentedJSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+				return
+			}
+		}
+
+		userId := currentUserID(c)
+		err := users.UpdateProfile(c.Request.Context(), userId, store.ProfileUpdate{
+			DisplayName:  patch.DisplayName,
+			Bio:          patch.Bio,
+			StatusText:   patch.StatusText,
+			ShowLastSeen: patch.ShowLastSeen,
+		})
+		if err != nil {
+			log.Println(err)
+			c.IndentedJSON(http.StatusInternalServerError, gin.H{"error": "Failed to update profile"})
+			return
+		}
+
+		user, err := users.Get(c.Request.Context(), userId)
+		if err != nil {
+			log.Println(err)
+			c.IndentedJSON(http.StatusInternalServerError, gin.H{"error": "Failed to get profile"})
+			return
+		}
+
+		c.IndentedJSON(http.StatusOK, newProfile(user, true))
+	}
+}
+
+// setAvatar godoc
+// @Summary Set your avatar
+// @Description Upload the avatar of the authenticated user as the raw request body
+// @Description PNG, JPEG, GIF or WebP images up to 256 KiB are accepted
+// @Tags profile
+// @Accept image/png,image/jpeg,image/gif,image/webp
+// @Produce json
+// @Success 204
+// @Security ApiKeyAuth
+// @Router /users/me/avatar [put]
+func (a *API) SetAvatar() gin.HandlerFunc {
+	users := a.stores.Users
+	return func(c *gin.Context) {
+		data, err := io.ReadAll(io.LimitReader(c.Request.Body, maxAvatarSize+1))
+		if err != nil {
+			c.IndentedJSON(http.StatusBadRequest, gin.H{"error": "failed to read avatar"})
+			return
+		}
+		if len(data) == 0 {
+			c.IndentedJSON(http.StatusBadRequest, gin.H{"error": "avatar is required"})
+			return
+		}
+		if len(data) > maxAvatarSize {
+			c.IndentedJSON(http.StatusRequestEntityTooLarge, gin.H{"error": "avatar must be at most 256 KiB"})
+			return
+		}
+
+		// Trust the content, not the declared type
+		contentType := http.DetectContentType(data)
+		if !slices.Contains(avatarContentTypes, contentType) {
+			c.IndentedJSON(http.StatusUnsupportedMediaType, gin.H{"error": "avatar must be a PNG, JPEG, GIF or WebP image"})
+			return
+		}
+
+		err = users.SetAvatar(c.Request.Context(), currentUserID(c), store.Avatar{ContentType: contentType, Data: data, UpdatedAt: time.Now()})
+		if err != nil {
+			log.Println(err)
+			c.IndentedJSON(http.StatusInternalServerError, gin.H{"error": "Failed to set avatar"})
+			return
+		}
+
+		c.Status(http.StatusNoContent)
+	}
+}
+
+// deleteAvatar godoc
+// @Summary Remove your avatar
+// @Tags profile
+// @Produce json
+// @Success 204
+// @Security ApiKeyAuth
+// @Router /users/me/avatar [delete]
+func (a *API) DeleteAvatar() gin.HandlerFunc {
+	users := a.stores.Users
+	return func(c *gin.Context) {
+		if err := users.DeleteAvatar(c.Request.Context(), currentUserID(c)); err != nil {
+			log.Println(err)
+			c.IndentedJSON(http.StatusInternalServerError, gin.H{"error": "Failed to remove avatar"})
+			return
+		}
+
+		c.Status(http.StatusNoContent)
+	}
+}
+
+// getAvatar godoc
+// @Summary Get the avatar of a user
+// @Description Users who blocked you have no avatar
+// @Tags profile
+// @Produce image/png,image/jpeg,image/gif,image/webp
+// @Param id path int true "User ID"
+// @Success 200 {file} binary
+// @Security ApiKeyAuth
+// @Router /users/{id}/avatar [get]
+func (a *API) GetAvatar() gin.HandlerFunc {
+	users := a.stores.Users
+	return func(c *gin.Context) {
+		id, err := strconv.Atoi(c.Param("id"))
+		if err != nil {
+			c.IndentedJSON(http.StatusBadRequest, gin.H{"error": "Invalid user ID"})
+			return
+		}
+
+		// Users who blocked you have no avatar
+		blocked, err := users.Blocks(c.Request.Context(), id, currentUserID(c))
+		if err != nil {
+			log.Println(err)
+			c.IndentedJSON(http.StatusInternalServerError, gin.H{"error": "Failed to get avatar"})
+			return
+		}
+		if blocked {
+			c.IndentedJSON(http.StatusNotFound, gin.H{"error": "avatar not found"})
+			return
+		}
+
+		avatar, err := users.GetAvatar(c.Request.Context(), id)
+		if err != nil {
+			if errors.Is(err, store.ErrNotFound) {
+				c.IndentedJSON(http.StatusNotFound, gin.H{"error": "avatar not found"})
+			} else {
+				log.Println(err)
+				c.IndentedJSON(http.StatusInternalServerError, gin.H{"error": "Failed to get avatar"})
+			}
+			return
+		}
+
+		c.Header("Last-Modified", avatar.UpdatedAt.UTC().Format(http.TimeFormat))
+		c.Header("Cache-Control", "private, max-age=300")
+		c.Data(http.StatusOK, avatar.ContentType, avatar.Data)
+	}
+}
+
+func (a *API) setupProfileRoutes(router *gin.Engine) {
+	profileRoutes := router.Group("/users")
+	{
+		profileRoutes.GET("/me", RequireScope(ScopeUsersRead), a.GetProfile())
+		profileRoutes.PATCH("/me", RequireScope(ScopeUsersWrite), a.UpdateProfile())
+		profileRoutes.PUT("/me/avatar", RequireScope(ScopeUsersWrite), a.SetAvatar())
+		profileRoutes.DELETE("/me/avatar", RequireScope(ScopeUsersWrite), a.DeleteAvatar())
+		profileRoutes.GET("/:id/avatar", RequireScope(ScopeUsersRead), a.GetAvatar())
+	}
+}
@@ -0,0 +1,79 @@
+package routes
+
+import (
+	"context"
+	"net/http"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+// pngHeader is the signature http.DetectContentType recognises as a PNG.
+const pngHeader = "\x89PNG\r\n\x1a\n"
+
+func TestProfileLengthLimits(t *testing.T) {
+	ta := newTestAPI(t, Config{})
+	token := ta.token(ta.createUser("alice"))
+
+	for field, max := range map[string]int{"displayName": maxDisplayNameLength, "bio": maxBioLength, "statusText": maxStatusTextLength} {
+		// limits are in characters, not bytes
+		ta.expect(ta.do("PATCH", "/users/me", token, `{"`+field+`": "`+strings.Repeat("é", max)+`"}`), http.StatusOK, nil)
+		ta.expect(ta.do("PATCH", "/users/me", token, `{"`+field+`": "`+strings.Repeat("é", max+1)+`"}`), http.StatusBadRequest, nil)
+	}
+}
+
+func TestHiddenLastSeen(t *testing.T) {
+	ta := newTestAPI(t, Config{})
+	alice, bob := ta.createUser("alice"), ta.createUser("bob")
+	aliceToken, bobToken := ta.token(alice), ta.token(bob)
+	if err := ta.stores.Users.TouchLastSeen(context.Background(), alice, time.Now(), lastSeenResolution); err != nil {
+		t.Fatal(err)
+	}
+
+	var seen UserGet
+	ta.expect(ta.do("GET", "/users/"+strconv.Itoa(alice), bobToken, ""), http.StatusOK, &seen)
+	if seen.LastSeenAt == nil {
+		t.Fatal("last seen time not shown by default")
+	}
+
+	var profile Profile
+	ta.expect(ta.do("PATCH", "/users/me", aliceToken, `{"showLastSeen": false}`), http.StatusOK, &profile)
+	if profile.ShowLastSeen || profile.LastSeenAt == nil {
+		t.Errorf("own profile %+v, want the hidden last seen time", profile)
+	}
+	for _, path := range []string{"/users/" + strconv.Itoa(alice), "/users/by-username/alice"} {
+		var hidden UserGet
+		ta.expect(ta.do("GET", path, bobToken, ""), http.StatusOK, &hidden)
+		if hidden.LastSeenAt != nil {
+			t.Errorf("%s: hidden last seen time shown to bob", path)
+		}
+	}
+	var page []UserGet
+	ta.expect(ta.do("GET", "/users/?q=alice", bobToken, ""), http.StatusOK, &page)
+	if len(page) != 1 || page[0].LastSeenAt != nil {
+		t.Errorf("directory %+v, want alice without last seen time", page)
+	}
+}
+
+func TestAvatarUpload(t *testing.T) {
+	ta := newTestAPI(t, Config{})
+	alice := ta.createUser("alice")
+	token := ta.token(alice)
+
+	ta.expect(ta.do("PUT", "/users/me/avatar", token, pngHeader+strings.Repeat("x", maxAvatarSize+1-len(pngHeader))), http.StatusRequestEntityTooLarge, nil)
+	// the content is sniffed, whatever the declared type
+	ta.expect(ta.do("PUT", "/users/me/avatar", token, `{"not": "an image"}`), http.StatusUnsupportedMediaType, nil)
+	ta.expect(ta.do("PUT", "/users/me/avatar", token, "<svg xmlns=\"http://www.w3.org/2000/svg\"></svg>"), http.StatusUnsupportedMediaType, nil)
+
+	avatar := pngHeader + strings.Repeat("x", maxAvatarSize-len(pngHeader))
+	ta.expect(ta.do("PUT", "/users/me/avatar", token, avatar), http.StatusNoContent, nil)
+	w := ta.do("GET", "/users/"+strconv.Itoa(alice)+"/avatar", ta.token(ta.createUser("bob")), "")
+	ta.expect(w, http.StatusOK, nil)
+	if w.Header().Get("Content-Type") != "image/png" || w.Body.String() != avatar {
+		t.Errorf("avatar of type %s and %d bytes, want the uploaded PNG", w.Header().Get("Content-Type"), w.Body.Len())
+	}
+
+	ta.expect(ta.do("DELETE", "/users/me/avatar", token, ""), http.StatusNoContent, nil)
+	ta.expect(ta.do("GET", "/users/"+strconv.Itoa(alice)+"/avatar", token, ""), http.StatusNotFound, nil)
+}
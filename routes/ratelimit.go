@@ -0,0 +1,87 @@
+package routes
+
+import (
+	"fmt"
+	"maps"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/adrienchanove/alpha-enigma-api/ratelimit"
+	"github.com/gin-gonic/gin"
+)
+
+// defaultRateLimits are the rate limits applied to each route group, by name,
+// unless Config.RateLimits overrides them.
+var defaultRateLimits = map[string]ratelimit.Policy{
+	"registration-ip": {Limit: 5, Period: time.Hour},
+	"registration":    {Limit: 200, Period: time.Hour},
+	"challenge-ip":    {Limit: 30, Period: time.Hour},
+	"token-ip":        {Limit: 30, Period: time.Minute},
+	"token":           {Limit: 1000, Period: time.Minute},
+	"token-user":      {Limit: 10, Period: 15 * time.Minute},
+	"token-username":  {Limit: 100, Period: 15 * time.Minute},
+	"messages":        {Limit: 60, Period: time.Minute},
+	"api":             {Limit: 600, Period: time.Minute},
+}
+
+// rateLimits returns the default rate limits, overridden by policies.
+func rateLimits(policies []ratelimit.Policy) (map[string]ratelimit.Policy, error) {
+	limits := maps.Clone(defaultRateLimits)
+	for _, policy := range policies {
+		if _, ok := limits[policy.Name]; !ok {
+			return nil, fmt.Errorf("unknown rate limit %q, expected one of %s", policy.Name, strings.Join(slices.Sorted(maps.Keys(defaultRateLimits)), ", "))
+		}
+		if err := policy.Validate(); err != nil {
+			return nil, err
+		}
+		limits[policy.Name] = policy
+	}
+	return limits, nil
+}
+
+// CheckRateLimits checks policies override known rate limits with valid ones.
+func CheckRateLimits(policies []ratelimit.Policy) error {
+	_, err := rateLimits(policies)
+	return err
+}
+
+// keyByUser limits each authenticated user.
+func keyByUser(c *gin.Context) string {
+	if userId := currentUserID(c); userId != 0 {
+		return "user:" + strconv.Itoa(userId)
+	}
+	return ""
+}
+
+// keyByToken limits each token, so every session of a user has its own limit.
+func keyByToken(c *gin.Context) string {
+	if sessionID := currentToken(c).SessionID; sessionID != "" {
+		return "token:" + sessionID
+	}
+	return ""
+}
+
+// limitRequests applies the named rate limit policy to the requests, keyed by key.
+func (a *API) limitRequests(name string, key ratelimit.KeyFunc) gin.HandlerFunc {
+	policy := a.rateLimits[name]
+	policy.Name = name
+	return ratelimit.Middleware(a.rateLimitStore, policy, key, a.auditRateLimit)
+}
+
+// auditRateLimit records that a client exceeded a rate limit, once per period
+// so a flood does not flood the audit trail too.
+func (a *API) auditRateLimit(c *gin.Context, policy ratelimit.Policy, key string) {
+	once := ratelimit.Policy{Name: "audit-" + policy.Name, Limit: 1, Period: policy.Period}
+	result, err := a.rateLimitStore.Take(c.Request.Context(), once.Name+":"+key, once, time.Now())
+	if err == nil && result.Allowed {
+		a.auditEvent(c, "ratelimit.exceeded", currentUserID(c), "policy="+policy.Name+" key="+key)
+	}
+}
+
+// LimitAuthenticatedRequests applies the "api" rate limit to each token. It
+// must be used after AuthMiddleware.
+func (a *API) LimitAuthenticatedRequests() gin.HandlerFunc {
+	return a.limitRequests("api", keyByToken)
+}
@@ -0,0 +1,26 @@
+package routes
+
+import (
+	"testing"
+	"time"
+
+	"github.com/adrienchanove/alpha-enigma-api/ratelimit"
+)
+
+func TestRateLimits(t *testing.T) {
+	limits, err := rateLimits([]ratelimit.Policy{{Name: "messages", Limit: 30, Period: time.Minute}})
+	if err != nil || limits["messages"].Limit != 30 || limits["api"] != defaultRateLimits["api"] {
+		t.Errorf("limits %+v, %v", limits, err)
+	}
+
+	for _, policy := range []ratelimit.Policy{
+		{Name: "unknown", Limit: 1, Period: time.Minute},
+		{Name: "messages", Limit: 0, Period: time.Minute},
+		{Name: "messages", Limit: -1, Period: time.Minute},
+		{Name: "messages", Limit: 1, Period: 0},
+	} {
+		if _, err := New(nil, Config{RateLimits: []ratelimit.Policy{policy}}); err == nil {
+			t.Errorf("rate limit %+v accepted", policy)
+		}
+	}
+}
@@ -0,0 +1,142 @@
+package routes
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"fmt"
+	"math/bits"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// maxProofOfWorkBits keeps the proof of work solvable by a browser.
+const maxProofOfWorkBits = 32
+
+// proofOfWorkLifetime is how long a proof of work challenge can be solved.
+const proofOfWorkLifetime = 10 * time.Minute
+
+// maxPendingChallenges bounds the memory used by unsolved challenges.
+const maxPendingChallenges = 100000
+
+type ProofOfWorkChallenge struct {
+	Challenge string    `json:"challenge"`
+	Bits      int       `json:"bits"`
+	ExpiresAt time.Time `json:"expiresAt"`
+}
+
+// checkProofOfWorkBits checks the proof of work difficulty, in bits, keeps it
+// solvable. 0 disables the proof of work.
+func checkProofOfWorkBits(difficulty int) error {
+	if difficulty < 0 || difficulty > maxProofOfWorkBits {
+		return fmt.Errorf("proof of work difficulty must be between 0 and %d bits", maxProofOfWorkBits)
+	}
+	return nil
+}
+
+// leadingZeroBits counts the leading zero bits of hash.
+func leadingZeroBits(hash []byte) int {
+	n := 0
+	for _, b := range hash {
+		if b != 0 {
+			return n + bits.LeadingZeros8(b)
+		}
+		n += 8
+	}
+	return n
+}
+
+// consumeProofOfWork reports whether proof, "challenge:nonce", solves a
+// pending challenge. A challenge can only be used once.
+func (a *API) consumeProofOfWork(proof string, now time.Time) bool {
+	challenge, _, ok := strings.Cut(proof, ":")
+	if !ok {
+		return false
+	}
+
+	a.powChallengesMu.Lock()
+	pending, ok := a.powChallenges[challenge]
+	delete(a.powChallenges, challenge)
+	a.powChallengesMu.Unlock()
+	if !ok || now.After(pending.ExpiresAt) {
+		return false
+	}
+
+	hash := sha256.Sum256([]byte(proof))
+	return leadingZeroBits(hash[:]) >= pending.Bits
+}
+
+// getRegistrationChallenge godoc
+// @Summary Get a registration proof of work challenge
+// @Description When the server requires it, find a nonce such that the SHA-256 of "challenge:nonce" starts with
+// @Description the given number of zero bits, then send "challenge:nonce" in the X-Proof-Of-Work header of POST /users
+// @Description within 10 minutes. Each challenge can be used once
+// @Tags users
+// @Produce json
+// @Success 200 {object} ProofOfWorkChallenge
+// @Router /users/challenge [get]
+func (a *API) GetRegistrationChallenge() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if a.proofOfWorkBits == 0 {
+			c.IndentedJSON(http.StatusNotFound, gin.H{"error": "proof of work is not required"})
+			return
+		}
+
+		nonce := make([]byte, 16)
+		if _, err := rand.Read(nonce); err != nil {
+			c.IndentedJSON(http.StatusInternalServerError, gin.H{"error": "failed to generate challenge"})
+			return
+		}
+		now := time.Now()
+		challenge := ProofOfWorkChallenge{
+			Challenge: base64.RawURLEncoding.EncodeToString(nonce),
+			Bits:      a.proofOfWorkBits,
+			ExpiresAt: now.Add(proofOfWorkLifetime),
+		}
+
+		a.powChallengesMu.Lock()
+		for k, pending := range a.powChallenges {
+			if now.After(pending.ExpiresAt) {
+				delete(a.powChallenges, k)
+			}
+		}
+		full := len(a.powChallenges) >= maxPendingChallenges
+		if !full {
+			a.powChallenges[challenge.Challenge] = challenge
+		}
+		a.powChallengesMu.Unlock()
+		if full {
+			c.Header("Retry-After", strconv.Itoa(int(proofOfWorkLifetime/time.Second)))
+			c.IndentedJSON(http.StatusServiceUnavailable, gin.H{"error": "too many pending challenges, retry later"})
+			return
+		}
+
+		c.IndentedJSON(http.StatusOK, challenge)
+	}
+}
+
+// RequireProofOfWork rejects requests without a solved challenge in the
+// X-Proof-Of-Work header, when a proof of work is required.
+func (a *API) RequireProofOfWork() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if a.proofOfWorkBits == 0 {
+			c.Next()
+			return
+		}
+
+		proof := c.GetHeader("X-Proof-Of-Work")
+		if proof == "" {
+			c.AbortWithStatusJSON(http.StatusPreconditionRequired, gin.H{"error": "proof of work is required, see GET /users/challenge"})
+			return
+		}
+		if !a.consumeProofOfWork(proof, time.Now()) {
+			c.AbortWithStatusJSON(http.StatusForbidden, gin.H{"error": "invalid or expired proof of work"})
+			return
+		}
+		c.Next()
+	}
+}
@@ -0,0 +1,102 @@
+package routes
+
+import (
+	"crypto/sha256"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/adrienchanove/alpha-enigma-api/ratelimit"
+)
+
+// solve returns the first "challenge:nonce" whose hash has, when enough is
+// true, at least bits leading zero bits, or fewer otherwise.
+func solve(challenge string, bits int, enough bool) string {
+	for nonce := 0; ; nonce++ {
+		proof := challenge + ":" + strconv.Itoa(nonce)
+		hash := sha256.Sum256([]byte(proof))
+		if (leadingZeroBits(hash[:]) >= bits) == enough {
+			return proof
+		}
+	}
+}
+
+// register sends the registration of name with the proof of work, if not
+// empty, and returns the response.
+func (ta *testAPI) register(name string, proof string) *httptest.ResponseRecorder {
+	ta.t.Helper()
+	body, err := json.Marshal(UserPost{Username: name, PublicKey: testPublicKey(ta.t)})
+	if err != nil {
+		ta.t.Fatal(err)
+	}
+	req := httptest.NewRequest("POST", "/users/", strings.NewReader(string(body)))
+	req.Header.Set("Content-Type", "application/json")
+	if proof != "" {
+		req.Header.Set("X-Proof-Of-Work", proof)
+	}
+	w := httptest.NewRecorder()
+	ta.router.ServeHTTP(w, req)
+	return w
+}
+
+func TestProofOfWorkDisabled(t *testing.T) {
+	ta := newTestAPI(t, Config{})
+	ta.expect(ta.do("GET", "/users/challenge", "", ""), http.StatusNotFound, nil)
+	ta.expect(ta.register("alice", ""), http.StatusCreated, nil)
+}
+
+func TestProofOfWork(t *testing.T) {
+	ta := newTestAPI(t, Config{
+		ProofOfWorkBits: 8,
+		RateLimits:      []ratelimit.Policy{{Name: "registration-ip", Limit: 100, Period: time.Hour}},
+	})
+
+	var challenge ProofOfWorkChallenge
+	ta.expect(ta.do("GET", "/users/challenge", "", ""), http.StatusOK, &challenge)
+	if challenge.Bits != 8 || challenge.Challenge == "" || time.Until(challenge.ExpiresAt) > proofOfWorkLifetime {
+		t.Fatalf("challenge %+v", challenge)
+	}
+
+	ta.expect(ta.register("alice", ""), http.StatusPreconditionRequired, nil)
+	proof := solve(challenge.Challenge, challenge.Bits, true)
+	ta.expect(ta.register("alice", proof), http.StatusCreated, nil)
+	// a challenge is used once
+	ta.expect(ta.register("bob", proof), http.StatusForbidden, nil)
+
+	for name, proof := range map[string]func(ProofOfWorkChallenge) string{
+		"too few zero bits": func(challenge ProofOfWorkChallenge) string {
+			return solve(challenge.Challenge, challenge.Bits, false)
+		},
+		"unknown challenge": func(challenge ProofOfWorkChallenge) string {
+			return solve(challenge.Challenge+"x", challenge.Bits, true)
+		},
+		"without nonce": func(challenge ProofOfWorkChallenge) string {
+			return challenge.Challenge
+		},
+		"expired": func(challenge ProofOfWorkChallenge) string {
+			ta.api.powChallengesMu.Lock()
+			challenge.ExpiresAt = time.Now().Add(-time.Second)
+			ta.api.powChallenges[challenge.Challenge] = challenge
+			ta.api.powChallengesMu.Unlock()
+			return solve(challenge.Challenge, challenge.Bits, true)
+		},
+	} {
+		var challenge ProofOfWorkChallenge
+		ta.expect(ta.do("GET", "/users/challenge", "", ""), http.StatusOK, &challenge)
+		if w := ta.register("bob", proof(challenge)); w.Code != http.StatusForbidden {
+			t.Errorf("%s: status %d, want %d", name, w.Code, http.StatusForbidden)
+		}
+	}
+}
+
+func TestProofOfWorkDifficulty(t *testing.T) {
+	for _, bits := range []int{-1, maxProofOfWorkBits + 1} {
+		if _, err := New(nil, Config{ProofOfWorkBits: bits}); err == nil {
+			t.Errorf("difficulty of %d bits accepted", bits)
+		}
+	}
+}
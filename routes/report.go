@@ -0,0 +1,129 @@
+package routes
+
+import (
+	"errors"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/adrienchanove/alpha-enigma-api/store"
+	"github.com/gin-gonic/gin"
+)
+
+type Report struct {
+	ID         int        `json:"id"`
+	ReporterId int        `json:"reporterId"`
+	ReportedId int        `json:"reportedId"`
+	MessageId  *int       `json:"messageId"`
+	Reason     string     `json:"reason"`
+	CreatedAt  time.Time  `json:"createdAt"`
+	ResolvedAt *time.Time `json:"resolvedAt"`
+}
+
+func newReport(r store.Report) Report {
+	return Report{
+		ID:         r.ID,
+		ReporterId: r.ReporterID,
+		ReportedId: r.ReportedID,
+		MessageId:  r.MessageID,
+		Reason:     r.Reason,
+		CreatedAt:  r.CreatedAt,
+		ResolvedAt: r.ResolvedAt,
+	}
+}
+
+type ReportPost struct {
+	ReportedId int    `json:"reportedId"`
+	MessageId  int    `json:"messageId"`
+	Reason     string `json:"reason"`
+}
+
+// setReport godoc
+// @Summary Report abuse
+// @Description Report a user, and optionally one of the messages they sent you, to the administrators
+// @Tags reports
+// @Accept json
+// @Produce json
+// @Param report body ReportPost true "Create report"
+// @Success 201 {object} Report
+// @Security ApiKeyAuth
+// @Router /reports [post]
+func (a *API) SetReport() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var newReport ReportPost
+		if err := c.ShouldBindJSON(&newReport); err != nil {
+			c.IndentedJSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		if newReport.ReportedId <= 0 {
+			c.IndentedJSON(http.StatusBadRequest, gin.H{"error": "reportedId must be a positive integer"})
+			return
+		}
+		if newReport.Reason == "" {
+			c.IndentedJSON(http.StatusBadRequest, gin.H{"error": "reason is required"})
+			return
+		}
+
+		userId := currentUserID(c)
+		if newReport.ReportedId == userId {
+			c.IndentedJSON(http.StatusBadRequest, gin.H{"error": "you cannot report yourself"})
+			return
+		}
+
+		// Deleted users can still be reported for the messages they left
+		if _, err := a.stores.Users.Get(c.Request.Context(), newReport.ReportedId); err != nil {
+			if errors.Is(err, store.ErrNotFound) {
+				c.IndentedJSON(http.StatusNotFound, gin.H{"error": "user not found"})
+			} else {
+				log.Println(err)
+				c.IndentedJSON(http.StatusInternalServerError, gin.H{"error": "Failed to create report"})
+			}
+			return
+		}
+
+		report := Report{
+			ReporterId: userId,
+			ReportedId: newReport.ReportedId,
+			Reason:     newReport.Reason,
+			CreatedAt:  time.Now(),
+		}
+
+		// A reported message must have been sent by the reported user to the reporter
+		if newReport.MessageId != 0 {
+			message, err := a.stores.Messages.Get(c.Request.Context(), newReport.MessageId)
+			if err != nil && !errors.Is(err, store.ErrNotFound) {
+				log.Println(err)
+				c.IndentedJSON(http.StatusInternalServerError, gin.H{"error": "Failed to create report"})
+				return
+			}
+			if err != nil || message.SenderID != newReport.ReportedId || message.ReceiverID != userId {
+				c.IndentedJSON(http.StatusNotFound, gin.H{"error": "message not found"})
+				return
+			}
+			report.MessageId = &newReport.MessageId
+		}
+
+		var err error
+		report.ID, err = a.stores.Reports.Create(c.Request.Context(), store.Report{
+			ReporterID: report.ReporterId,
+			ReportedID: report.ReportedId,
+			MessageID:  report.MessageId,
+			Reason:     report.Reason,
+			CreatedAt:  report.CreatedAt,
+		})
+		if err != nil {
+			log.Println(err)
+			c.IndentedJSON(http.StatusInternalServerError, gin.H{"error": "Failed to create report"})
+			return
+		}
+
+		c.IndentedJSON(http.StatusCreated, report)
+	}
+}
+
+func (a *API) setupReportRoutes(router *gin.Engine) {
+	reportRoutes := router.Group("/reports")
+	{
+		reportRoutes.POST("/", RequireScope(ScopeMessagesWrite), a.SetReport())
+	}
+}
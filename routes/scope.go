@@ -0,0 +1,75 @@
+package routes
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"slices"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Scopes grantable to a token.
+const (
+	ScopeMessagesRead  = "messages:read"
+	ScopeMessagesWrite = "messages:write"
+	ScopeUsersRead     = "users:read"
+	ScopeUsersWrite    = "users:write"
+	ScopeAdmin         = "admin"
+)
+
+var knownScopes = []string{ScopeMessagesRead, ScopeMessagesWrite, ScopeUsersRead, ScopeUsersWrite, ScopeAdmin}
+
+// defaultScopes are granted when a token request does not list any scope.
+var defaultScopes = []string{ScopeMessagesRead, ScopeMessagesWrite, ScopeUsersRead, ScopeUsersWrite}
+
+// errScopeNotAllowed is returned by grantScopes when the user may not get a
+// requested scope.
+var errScopeNotAllowed = errors.New("scope not allowed")
+
+// grantScopes validates the scopes requested for a new token by a user with
+// role and returns the scopes to grant.
+func grantScopes(requested []string, role string) ([]string, error) {
+	if len(requested) == 0 {
+		return slices.Clone(defaultScopes), nil
+	}
+
+	var scopes []string
+	for _, scope := range requested {
+		if !slices.Contains(knownScopes, scope) {
+			return nil, fmt.Errorf("unknown scope %s", scope)
+		}
+		if scope == ScopeAdmin && role != RoleAdmin {
+			return nil, fmt.Errorf("%w: %s requires the admin role", errScopeNotAllowed, scope)
+		}
+		if !slices.Contains(scopes, scope) {
+			scopes = append(scopes, scope)
+		}
+	}
+	return scopes, nil
+}
+
+// RequireScope is a middleware rejecting tokens which were not granted scope.
+// It must run after AuthMiddleware.
+func RequireScope(scope string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if !slices.Contains(currentToken(c).Scopes, scope) {
+			c.Header("WWW-Authenticate", fmt.Sprintf(`Bearer error="insufficient_scope", scope="%s"`, scope))
+			c.AbortWithStatusJSON(http.StatusForbidden, gin.H{"error": "token lacks the " + scope + " scope"})
+			return
+		}
+
+		c.Next()
+	}
+}
+
+// formatScopes joins scopes the way they are carried in signed tokens.
+func formatScopes(scopes []string) string {
+	return strings.Join(scopes, " ")
+}
+
+// parseScopes splits the scope claim of a signed token.
+func parseScopes(scope string) []string {
+	return strings.Fields(scope)
+}
@@ -0,0 +1,118 @@
+package routes
+
+import (
+	"context"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"fmt"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/adrienchanove/alpha-enigma-api/jwt"
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+)
+
+// issueSignedToken writes a signed token for the user to the response, and
+// returns its id.
+func (a *API) issueSignedToken(c *gin.Context, userID int, username string, device string, scopes []string, publicKeyPEM string) (string, bool) {
+	now := time.Now()
+	id := uuid.New().String()
+	token, err := a.signingKeys.Sign(jwt.Claims{
+		ID:            id,
+		UserID:        userID,
+		Username:      username,
+		Device:        device,
+		Scope:         formatScopes(scopes),
+		IssuedAt:      now.Unix(),
+		ExpiresAt:     now.Add(a.signedTokenLifetime).Unix(),
+		IssuedAtMicro: now.UnixMicro(),
+	})
+	if err != nil {
+		log.Println(err)
+		c.IndentedJSON(http.StatusInternalServerError, gin.H{"error": "failed to generate token"})
+		return "", false
+	}
+
+	encryptedToken, encryptedKey, err := encryptTokenHybrid(token, publicKeyPEM)
+	if err != nil {
+		log.Println(err)
+		c.IndentedJSON(http.StatusInternalServerError, gin.H{"error": "failed to encrypt token"})
+		return "", false
+	}
+
+	c.IndentedJSON(http.StatusOK, AuthResponse{EncryptedToken: encryptedToken, EncryptedKey: encryptedKey, TokenType: tokenTypeJWT})
+	return id, true
+}
+
+// verifySignedToken checks a signed token, and that it was not revoked.
+func (a *API) verifySignedToken(ctx context.Context, token string) (TokenData, bool) {
+	if a.signingKeys == nil {
+		return TokenData{}, false
+	}
+
+	claims, err := a.signingKeys.Verify(token, time.Now())
+	if err != nil {
+		return TokenData{}, false
+	}
+	revoked, err := a.stores.Revocations.IsRevoked(ctx, claims.ID, claims.UserID, claims.IssueTime())
+	if err != nil {
+		log.Println(err)
+	}
+	if err != nil || revoked {
+		return TokenData{}, false
+	}
+
+	return TokenData{
+		Token:     token,
+		SessionID: claims.ID,
+		Timestamp: claims.IssueTime(),
+		UserID:    claims.UserID,
+		Username:  claims.Username,
+		Device:    claims.Device,
+		Scopes:    parseScopes(claims.Scope),
+		Signed:    true,
+	}, true
+}
+
+// encryptTokenHybrid encrypts a token too long for RSA-OAEP: the token is
+// sealed with a random AES-256-GCM key which is encrypted with the user's
+// public key. Both values are base64 encoded, the sealed token is prefixed
+// with its nonce.
+func encryptTokenHybrid(token string, publicKeyPEM string) (string, string, error) {
+	rsaPub, err := parsePublicKey(publicKeyPEM)
+	if err != nil {
+		return "", "", err
+	}
+
+	key := make([]byte, 32)
+	if _, err := rand.Read(key); err != nil {
+		return "", "", fmt.Errorf("failed to generate token key: %w", err)
+	}
+
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return "", "", err
+	}
+	gcm, err := cipher.NewGCM(block)
+	if err != nil {
+		return "", "", err
+	}
+	nonce := make([]byte, gcm.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return "", "", fmt.Errorf("failed to generate token nonce: %w", err)
+	}
+	sealedToken := gcm.Seal(nonce, nonce, []byte(token), nil)
+
+	encryptedKey, err := rsa.EncryptOAEP(sha256.New(), rand.Reader, rsaPub, key, nil)
+	if err != nil {
+		return "", "", fmt.Errorf("failed to encrypt token key: %w", err)
+	}
+
+	return base64.StdEncoding.EncodeToString(sealedToken), base64.StdEncoding.EncodeToString(encryptedKey), nil
+}
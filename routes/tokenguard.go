@@ -0,0 +1,236 @@
+package routes
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/pem"
+	"log"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/adrienchanove/alpha-enigma-api/store"
+	"github.com/adrienchanove/alpha-enigma-api/username"
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+)
+
+// tokenLockoutDuration is how long token requests for a username are refused
+// once its "token-user" or "token-username" rate limit is exceeded.
+const tokenLockoutDuration = 15 * time.Minute
+
+// maxTokenLockouts bounds the memory used by the token lockouts, and
+// tokenLockoutsPruneInterval is how often the expired ones are dropped.
+const (
+	maxTokenLockouts           = 100000
+	tokenLockoutsPruneInterval = time.Minute
+)
+
+// decoyKeySizeRefresh is how often the size of the decoy keys is computed
+// again from the keys of the users.
+const decoyKeySizeRefresh = 10 * time.Minute
+
+// defaultDecoyKeySize is the size of the decoy keys while no user is registered.
+const defaultDecoyKeySize = 2048
+
+// decoyPublicKeys are the public keys, by size in bits, encrypting the tokens
+// answered for unknown users. Their private keys are dropped once generated,
+// so nobody can decrypt them.
+var (
+	decoyPublicKeys   = make(map[int]string)
+	decoyPublicKeysMu sync.Mutex
+)
+
+func getDecoyPublicKey(bits int) (string, error) {
+	decoyPublicKeysMu.Lock()
+	defer decoyPublicKeysMu.Unlock()
+	if key, ok := decoyPublicKeys[bits]; ok {
+		return key, nil
+	}
+	key, err := rsa.GenerateKey(rand.Reader, bits)
+	if err != nil {
+		return "", err
+	}
+	der, err := x509.MarshalPKIXPublicKey(&key.PublicKey)
+	if err != nil {
+		return "", err
+	}
+	decoyPublicKeys[bits] = string(pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: der}))
+	return decoyPublicKeys[bits], nil
+}
+
+// decoyKeySize returns the most common size of the users keys, so the decoy
+// tokens have the length of most real ones.
+func (a *API) decoyKeySize(ctx context.Context) int {
+	a.decoyKeySizeMu.Lock()
+	defer a.decoyKeySizeMu.Unlock()
+	now := time.Now()
+	if a.decoyKeyBits != 0 && now.Before(a.decoyKeyBitsAt.Add(decoyKeySizeRefresh)) {
+		return a.decoyKeyBits
+	}
+
+	users, err := a.stores.Users.ListAccounts(ctx)
+	if err != nil {
+		log.Println(err)
+		if a.decoyKeyBits != 0 {
+			return a.decoyKeyBits
+		}
+		return defaultDecoyKeySize
+	}
+	sizes := make(map[int]int)
+	bits := defaultDecoyKeySize
+	for _, user := range users {
+		key, err := parsePublicKey(user.PublicKey)
+		if err != nil {
+			continue
+		}
+		size := key.N.BitLen()
+		sizes[size]++
+		if sizes[size] > sizes[bits] || sizes[size] == sizes[bits] && size < bits {
+			bits = size
+		}
+	}
+	a.decoyKeyBits, a.decoyKeyBitsAt = bits, now
+	return bits
+}
+
+// allowTokenRequest throttles token requests per username, known or not,
+// and IP with the "token-user" rate limit, and per username from any IP with
+// the higher "token-username" one. Once a limit is exceeded, its key is locked
+// out for tokenLockoutDuration. Keying by IP first keeps a single client from
+// locking the user out, the per username limit catches guesses spread over
+// many IPs. It writes the 429 response when the request is refused.
+func (a *API) allowTokenRequest(c *gin.Context, usernameKey string) bool {
+	now := time.Now()
+	limits := []struct{ policy, key string }{
+		{"token-user", "token-user:" + usernameKey + " " + c.ClientIP()},
+		{"token-username", "token-username:" + usernameKey},
+	}
+
+	a.tokenLockoutsMu.Lock()
+	var lockedUntil time.Time
+	for _, limit := range limits {
+		if until, ok := a.tokenLockouts[limit.key]; ok && now.Before(until) && until.After(lockedUntil) {
+			lockedUntil = until
+		}
+	}
+	a.tokenLockoutsMu.Unlock()
+
+	if lockedUntil.IsZero() {
+		for _, limit := range limits {
+			policy := a.rateLimits[limit.policy]
+			policy.Name = limit.policy
+			result, err := a.rateLimitStore.Take(c.Request.Context(), limit.key, policy, now)
+			if err != nil {
+				log.Println(err)
+				continue
+			}
+			if !result.Allowed {
+				lockedUntil = now.Add(tokenLockoutDuration)
+				a.lockOutTokenRequests(limit.key, lockedUntil, now)
+				a.auditEvent(c, "auth.token_lockout", 0, "username="+usernameKey+" policy="+limit.policy)
+				break
+			}
+		}
+		if lockedUntil.IsZero() {
+			return true
+		}
+	}
+
+	c.Header("Retry-After", strconv.Itoa(int((lockedUntil.Sub(now)+time.Second-1)/time.Second)))
+	c.IndentedJSON(http.StatusTooManyRequests, gin.H{"error": "too many token requests for this username, retry later"})
+	return false
+}
+
+// lockOutTokenRequests records the lockout of key until the given time. The
+// expired lockouts are dropped at most every tokenLockoutsPruneInterval, and
+// past maxTokenLockouts no lockout is recorded: the rate limit alone then
+// throttles the key.
+func (a *API) lockOutTokenRequests(key string, until time.Time, now time.Time) {
+	a.tokenLockoutsMu.Lock()
+	defer a.tokenLockoutsMu.Unlock()
+	if now.Sub(a.tokenLockoutsPrunedAt) > tokenLockoutsPruneInterval || len(a.tokenLockouts) >= maxTokenLockouts {
+		for k, lockedUntil := range a.tokenLockouts {
+			if !now.Before(lockedUntil) {
+				delete(a.tokenLockouts, k)
+			}
+		}
+		a.tokenLockoutsPrunedAt = now
+	}
+	if len(a.tokenLockouts) < maxTokenLockouts {
+		a.tokenLockouts[key] = until
+	}
+}
+
+// issueDecoyToken answers like a token was issued, with a token nobody can
+// use, so the response does not tell whether the user exists. The token of a
+// suspended user is encrypted with their key, the token of an unknown user
+// with a decoy key of the usual size, and the work done, store writes
+// included, is the same as for a real token.
+func (a *API) issueDecoyToken(c *gin.Context, authRequest AuthRequest, scopes []string, user store.User) {
+	usernameKey := username.Key(authRequest.Username)
+	publicKey := user.PublicKey
+	if user.ID == 0 {
+		var err error
+		if publicKey, err = getDecoyPublicKey(a.decoyKeySize(c.Request.Context())); err != nil {
+			log.Println(err)
+			c.IndentedJSON(http.StatusInternalServerError, gin.H{"error": "failed to encrypt token"})
+			return
+		}
+	}
+
+	if authRequest.TokenType == tokenTypeJWT {
+		// User 0 does not exist, the token would be refused anyway
+		if _, ok := a.issueSignedToken(c, 0, authRequest.Username, authRequest.Device, scopes, publicKey); ok {
+			a.auditDecoyToken(c, user, usernameKey)
+		}
+		return
+	}
+
+	token, err := generateToken()
+	if err != nil {
+		log.Println(err)
+		c.IndentedJSON(http.StatusInternalServerError, gin.H{"error": "failed to generate token"})
+		return
+	}
+	encryptedToken, err := encryptToken(token, publicKey)
+	if err != nil {
+		log.Println(err)
+		c.IndentedJSON(http.StatusInternalServerError, gin.H{"error": "failed to encrypt token"})
+		return
+	}
+
+	// Stored like a real token, but already expired
+	now := time.Now()
+	err = a.stores.Tokens.Save(c.Request.Context(), store.Token{
+		Token:     token,
+		SessionID: uuid.New().String(),
+		Username:  authRequest.Username,
+		Device:    authRequest.Device,
+		Scopes:    scopes,
+		ClientIP:  c.ClientIP(),
+		UserAgent: c.Request.UserAgent(),
+		IssuedAt:  now,
+		ExpiresAt: now,
+	})
+	if err != nil {
+		log.Println(err)
+		c.IndentedJSON(http.StatusInternalServerError, gin.H{"error": "failed to store token"})
+		return
+	}
+	a.auditDecoyToken(c, user, usernameKey)
+
+	c.IndentedJSON(http.StatusOK, AuthResponse{EncryptedToken: encryptedToken, TokenType: tokenTypeOpaque})
+}
+
+// auditDecoyToken records a token request of a suspended or unknown user.
+func (a *API) auditDecoyToken(c *gin.Context, user store.User, usernameKey string) {
+	if user.ID != 0 {
+		a.auditEvent(c, "auth.token_suspended", user.ID, "username="+usernameKey)
+	} else {
+		a.auditEvent(c, "auth.token_unknown_user", 0, "username="+usernameKey)
+	}
+}
@@ -0,0 +1,166 @@
+package routes
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/pem"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/adrienchanove/alpha-enigma-api/ratelimit"
+	"github.com/adrienchanove/alpha-enigma-api/store"
+	"github.com/adrienchanove/alpha-enigma-api/username"
+)
+
+func TestDecoyTokensLookReal(t *testing.T) {
+	ta := newTestAPI(t, Config{})
+
+	// most users have 1024 bits keys
+	key, err := rsa.GenerateKey(rand.Reader, 1024)
+	if err != nil {
+		t.Fatal(err)
+	}
+	der, err := x509.MarshalPKIXPublicKey(&key.PublicKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, name := range []string{"bob", "carol"} {
+		_, err := ta.stores.Users.Create(context.Background(), store.NewUser{
+			Username:         name,
+			UsernameKey:      username.Key(name),
+			UsernameSkeleton: username.Skeleton(name),
+			PublicKey:        string(pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: der})),
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	ta.createUser("alice")
+
+	var response AuthResponse
+	ta.expect(ta.do("POST", "/auth/token", "", `{"username": "nobody"}`), http.StatusOK, &response)
+	if encrypted, _ := base64.StdEncoding.DecodeString(response.EncryptedToken); len(encrypted) != 128 {
+		t.Errorf("decoy token of %d bytes, want the 128 of the usual keys", len(encrypted))
+	}
+
+	// the token of a suspended user is encrypted with their key, and unusable
+	id := ta.createUser("dave")
+	if err := ta.stores.Users.Suspend(context.Background(), id, time.Now()); err != nil {
+		t.Fatal(err)
+	}
+	token := ta.requestToken(`{"username": "dave"}`)
+
+	// decoys are stored like real tokens, already expired
+	if n, err := ta.stores.Tokens.DeleteExpired(context.Background()); err != nil || n != 2 {
+		t.Errorf("%d expired tokens, %v, want the 2 decoys", n, err)
+	}
+	if _, ok := ta.api.getUserFromToken(context.Background(), token); ok {
+		t.Error("the token of a suspended user is valid")
+	}
+	events, err := ta.stores.Audit.List(context.Background(), store.AuditFilter{Limit: 10})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var recorded []string
+	for _, event := range events {
+		recorded = append(recorded, event.Event)
+	}
+	if got := strings.Join(recorded, " "); got != "auth.token_suspended auth.token_unknown_user" {
+		t.Errorf("audit events %s", got)
+	}
+}
+
+// requestTokenFrom requests a token for name from ip and returns the status.
+func (ta *testAPI) requestTokenFrom(name string, ip string) int {
+	ta.t.Helper()
+	req := httptest.NewRequest("POST", "/auth/token", strings.NewReader(`{"username": "`+name+`"}`))
+	req.Header.Set("Content-Type", "application/json")
+	req.RemoteAddr = ip + ":1234"
+	w := httptest.NewRecorder()
+	ta.router.ServeHTTP(w, req)
+	return w.Code
+}
+
+func TestTokenLockoutIsPerIP(t *testing.T) {
+	limits, err := ratelimit.ParsePolicies("token-user=2/1h")
+	if err != nil {
+		t.Fatal(err)
+	}
+	ta := newTestAPI(t, Config{RateLimits: limits})
+	ta.createUser("alice")
+
+	for i := 0; i < 2; i++ {
+		if code := ta.requestTokenFrom("alice", "192.0.2.1"); code != http.StatusOK {
+			t.Fatalf("request %d: status %d", i, code)
+		}
+	}
+	if code := ta.requestTokenFrom("alice", "192.0.2.1"); code != http.StatusTooManyRequests {
+		t.Errorf("request past the limit: status %d, want %d", code, http.StatusTooManyRequests)
+	}
+	if code := ta.requestTokenFrom("alice", "198.51.100.1"); code != http.StatusOK {
+		t.Errorf("request from another IP: status %d, the user is locked out", code)
+	}
+}
+
+func TestTokenLockoutPerUsername(t *testing.T) {
+	limits, err := ratelimit.ParsePolicies("token-user=2/1h,token-username=4/1h")
+	if err != nil {
+		t.Fatal(err)
+	}
+	ta := newTestAPI(t, Config{RateLimits: limits})
+	ta.createUser("alice")
+
+	// requests spread over IPs, each under the per IP limit
+	for i := 0; i < 4; i++ {
+		if code := ta.requestTokenFrom("alice", "192.0.2."+strconv.Itoa(i)); code != http.StatusOK {
+			t.Fatalf("request %d: status %d", i, code)
+		}
+	}
+	for _, ip := range []string{"192.0.2.10", "198.51.100.1"} {
+		if code := ta.requestTokenFrom("alice", ip); code != http.StatusTooManyRequests {
+			t.Errorf("request from %s past the username limit: status %d, want %d", ip, code, http.StatusTooManyRequests)
+		}
+	}
+	if code := ta.requestTokenFrom("bob", "192.0.2.10"); code != http.StatusOK {
+		t.Errorf("request for another username: status %d", code)
+	}
+
+	events, err := ta.stores.Audit.List(context.Background(), store.AuditFilter{Event: "auth.token_lockout", Limit: 10})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(events) != 1 || events[0].Details != "username=alice policy=token-username" {
+		t.Errorf("lockout events %+v, want one of the username limit", events)
+	}
+}
+
+func TestTokenLockoutsAreBounded(t *testing.T) {
+	ta := newTestAPI(t, Config{})
+	now := time.Now()
+	for i := 0; i < maxTokenLockouts; i++ {
+		ta.api.lockOutTokenRequests("key"+strconv.Itoa(i), now.Add(time.Duration(i%2)*time.Minute), now)
+	}
+	if len(ta.api.tokenLockouts) != maxTokenLockouts {
+		t.Fatalf("%d lockouts, want %d", len(ta.api.tokenLockouts), maxTokenLockouts)
+	}
+
+	// the expired lockouts make room for new ones
+	ta.api.lockOutTokenRequests("new", now.Add(time.Minute), now)
+	if len(ta.api.tokenLockouts) != maxTokenLockouts/2+1 {
+		t.Errorf("%d lockouts, want the %d unexpired ones", len(ta.api.tokenLockouts), maxTokenLockouts/2+1)
+	}
+	for i := 0; len(ta.api.tokenLockouts) < maxTokenLockouts; i++ {
+		ta.api.lockOutTokenRequests("more"+strconv.Itoa(i), now.Add(time.Minute), now)
+	}
+	ta.api.lockOutTokenRequests("over", now.Add(time.Minute), now)
+	if _, ok := ta.api.tokenLockouts["over"]; ok || len(ta.api.tokenLockouts) != maxTokenLockouts {
+		t.Errorf("%d lockouts, want at most %d", len(ta.api.tokenLockouts), maxTokenLockouts)
+	}
+}
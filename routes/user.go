@@ -1,18 +1,31 @@
 package routes
 
 import (
-	"database/sql"
+	"context"
+	"encoding/base64"
+	"errors"
 	"log"
 	"net/http"
 	"strconv"
+	"time"
 
+	"github.com/adrienchanove/alpha-enigma-api/ratelimit"
+	"github.com/adrienchanove/alpha-enigma-api/store"
+	"github.com/adrienchanove/alpha-enigma-api/username"
 	"github.com/gin-gonic/gin"
 )
 
 type UserGet struct {
-	ID        int    `json:"id"`
-	Username  string `json:"username"`
-	PublicKey string `json:"publicKey"`
+	ID          int        `json:"id"`
+	Username    string     `json:"username"`
+	PublicKey   string     `json:"publicKey"`
+	DisplayName string     `json:"displayName"`
+	Bio         string     `json:"bio"`
+	StatusText  string     `json:"statusText"`
+	AvatarURL   string     `json:"avatarUrl,omitempty"`
+	LastSeenAt  *time.Time `json:"lastSeenAt,omitempty"`
+	// Deleted accounts keep their id but have no username nor public key.
+	Deleted bool `json:"deleted,omitempty"`
 }
 
 type UserPost struct {
@@ -21,51 +34,166 @@ type UserPost struct {
 	PublicKey string `json:"publicKey"`
 }
 
+// Page sizes of the users directory.
+const (
+	defaultUsersLimit = 50
+	maxUsersLimit     = 100
+)
+
+// encodeUsersCursor returns the cursor pointing after the given user in the
+// directory order. It holds only the user id: the store finds the place of
+// the user in the directory from it.
+func encodeUsersCursor(u UserGet) string {
+	return base64.RawURLEncoding.EncodeToString([]byte(strconv.Itoa(u.ID)))
+}
+
+// decodeUsersCursor returns the id of the last user of the previous page.
+func decodeUsersCursor(cursor string) (int, error) {
+	decoded, err := base64.RawURLEncoding.DecodeString(cursor)
+	if err != nil {
+		return 0, err
+	}
+	id, err := strconv.Atoi(string(decoded))
+	if err != nil || id <= 0 || strconv.Itoa(id) != string(decoded) {
+		return 0, errors.New("invalid cursor")
+	}
+	return id, nil
+}
+
+// userExists reports whether the user exists and is not deleted.
+func userExists(ctx context.Context, users store.UserStore, id int) (bool, error) {
+	user, err := users.Get(ctx, id)
+	if errors.Is(err, store.ErrNotFound) {
+		return false, nil
+	}
+	return err == nil && user.DeletedAt == nil, err
+}
+
 // getUsers godoc
 // @Summary Get all users
-// @Description Get a list of all users
+// @Description Get a page of the users directory, sorted by username
+// @Description q filters on a case-insensitive username prefix. When there are more users, the X-Next-Cursor
+// @Description response header holds the cursor of the next page
+// @Description Users hidden from the directory and blocked users are left out
 // @Tags users
 // @Accept json
 // @Produce json
+// @Param q query string false "Username prefix"
+// @Param limit query int false "Page size, 50 by default and 100 at most"
+// @Param cursor query string false "Cursor of the page, from X-Next-Cursor"
 // @Success 200 {array} UserGet
+// @Header 200 {string} X-Next-Cursor "Cursor of the next page"
 // @Security ApiKeyAuth
-// @Security X-User
 // @Router /users [get]
-func GetUsers(db *sql.DB) gin.HandlerFunc {
+func (a *API) GetUsers() gin.HandlerFunc {
+	users := a.stores.Users
 	return func(c *gin.Context) {
-		rows, err := db.Query("SELECT id, username FROM users")
+		userId := currentUserID(c)
+
+		limit := defaultUsersLimit
+		if limitStr := c.Query("limit"); limitStr != "" {
+			var err error
+			limit, err = strconv.Atoi(limitStr)
+			if err != nil || limit <= 0 || limit > maxUsersLimit {
+				c.IndentedJSON(http.StatusBadRequest, gin.H{"error": "limit must be between 1 and " + strconv.Itoa(maxUsersLimit)})
+				return
+			}
+		}
+
+		// One more user than the page size tells whether there is a next page
+		opts := store.ListUsersOptions{ViewerID: userId, Prefix: username.Key(c.Query("q")), Limit: limit + 1}
+		if cursor := c.Query("cursor"); cursor != "" {
+			// The cursor must point at a user still in the directory
+			id, err := decodeUsersCursor(cursor)
+			var exists bool
+			if err == nil {
+				exists, err = userExists(c.Request.Context(), users, id)
+				if err != nil {
+					log.Println(err)
+					c.IndentedJSON(http.StatusInternalServerError, gin.H{"error": "Failed to get users"})
+					return
+				}
+			}
+			if !exists {
+				c.IndentedJSON(http.StatusBadRequest, gin.H{"error": "invalid cursor"})
+				return
+			}
+			opts.AfterID = id
+		}
+
+		page, err := users.List(c.Request.Context(), opts)
 		if err != nil {
 			log.Println(err)
 			c.IndentedJSON(http.StatusInternalServerError, gin.H{"error": "Failed to get users"})
 			return
 		}
-		defer rows.Close()
+		userGets := newUserGets(page)
+
+		if len(userGets) > limit {
+			userGets = userGets[:limit]
+			c.Header("X-Next-Cursor", encodeUsersCursor(userGets[limit-1]))
+		}
 
-		var users []UserGet
-		for rows.Next() {
-			var u UserGet
-			if err := rows.Scan(&u.ID, &u.Username); err != nil {
+		c.IndentedJSON(http.StatusOK, userGets)
+	}
+}
+
+// getUserByUsername godoc
+// @Summary Get a user by username
+// @Description Get a user, with their public key, by their exact username, ignoring case
+// @Description Users who blocked you are not found
+// @Tags users
+// @Accept json
+// @Produce json
+// @Param username path string true "Username"
+// @Success 200 {object} UserGet
+// @Security ApiKeyAuth
+// @Router /users/by-username/{username} [get]
+func (a *API) GetUserByUsername() gin.HandlerFunc {
+	users := a.stores.Users
+	return func(c *gin.Context) {
+		user, err := users.GetByUsernameKey(c.Request.Context(), username.Key(c.Param("username")))
+		if err != nil {
+			if errors.Is(err, store.ErrNotFound) {
+				c.IndentedJSON(http.StatusNotFound, gin.H{"error": "user not found"})
+			} else {
 				log.Println(err)
-				c.IndentedJSON(http.StatusInternalServerError, gin.H{"error": "Failed to get users"})
-				return
+				c.IndentedJSON(http.StatusInternalServerError, gin.H{"error": "Failed to get user"})
 			}
-			users = append(users, u)
+			return
+		}
+
+		// Users who blocked you are not found
+		blocked, err := users.Blocks(c.Request.Context(), user.ID, currentUserID(c))
+		if err != nil {
+			log.Println(err)
+			c.IndentedJSON(http.StatusInternalServerError, gin.H{"error": "Failed to get user"})
+			return
+		}
+		if blocked {
+			c.IndentedJSON(http.StatusNotFound, gin.H{"error": "user not found"})
+			return
 		}
 
-		c.IndentedJSON(http.StatusOK, users)
+		c.JSON(http.StatusOK, newProfile(user, false).UserGet)
 	}
 }
 
 // setUser godoc
 // @Summary Create a new user
 // @Description Create a new user with the input payload
+// @Description The username is 3 to 32 letters or digits, with single '.', '_' or '-' between them. It is unique
+// @Description ignoring case and look-alike characters, a taken or too similar username is refused with 409
 // @Tags users
 // @Accept json
 // @Produce json
+// @Description Account creation is rate limited, and may require a proof of work, see GET /users/challenge
 // @Param user body UserPost true "Create user"
+// @Param X-Proof-Of-Work header string false "Solved challenge, challenge:nonce"
 // @Success 201 {object} UserGet
 // @Router /users [post]
-func SetUser(db *sql.DB) gin.HandlerFunc {
+func (a *API) SetUser() gin.HandlerFunc {
+	users := a.stores.Users
 	return func(c *gin.Context) {
 		var newUser UserPost
 		if err := c.ShouldBindJSON(&newUser); err != nil {
@@ -81,21 +209,51 @@ func SetUser(db *sql.DB) gin.HandlerFunc {
 			return
 		}
 
-		result, err := db.Exec("INSERT INTO users (username, public_key) VALUES (?, ?)", newUser.Username, newUser.PublicKey)
+		if _, err := parsePublicKey(newUser.PublicKey); err != nil {
+			c.IndentedJSON(http.StatusBadRequest, gin.H{"error": "publicKey must be a PEM encoded RSA public key"})
+			return
+		}
 
+		name, err := username.Normalize(newUser.Username)
+		if err != nil {
+			c.IndentedJSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		newUser.Username = name
+		key, skeleton := username.Key(name), username.Skeleton(name)
+
+		taken, lookalike, err := users.UsernameTaken(c.Request.Context(), key, skeleton)
 		if err != nil {
 			log.Println(err)
 			c.IndentedJSON(http.StatusInternalServerError, gin.H{"error": "Failed to create user"})
 			return
 		}
+		if taken {
+			c.IndentedJSON(http.StatusConflict, gin.H{"error": "username is already taken"})
+			return
+		}
+		if lookalike {
+			c.IndentedJSON(http.StatusConflict, gin.H{"error": "username is too similar to an existing one"})
+			return
+		}
 
-		id, err := result.LastInsertId()
+		newUser.ID, err = users.Create(c.Request.Context(), store.NewUser{
+			Username:         name,
+			UsernameKey:      key,
+			UsernameSkeleton: skeleton,
+			PublicKey:        newUser.PublicKey,
+		})
 		if err != nil {
+			// Registered concurrently
+			if errors.Is(err, store.ErrConflict) {
+				c.IndentedJSON(http.StatusConflict, gin.H{"error": "username is already taken"})
+				return
+			}
 			log.Println(err)
 			c.IndentedJSON(http.StatusInternalServerError, gin.H{"error": "Failed to create user"})
 			return
 		}
-		newUser.ID = int(id)
+		a.auditEvent(c, "user.created", newUser.ID, "username="+name)
 
 		c.IndentedJSON(http.StatusCreated, newUser)
 	}
@@ -103,16 +261,16 @@ func SetUser(db *sql.DB) gin.HandlerFunc {
 
 // GetUserById godoc
 // @Summary Get a user by ID
-// @Description Get a user by ID
+// @Description Get a user by ID, users who blocked you are not found
 // @Tags users
 // @Accept json
 // @Produce json
 // @Param id path int true "User ID"
 // @Success 200 {object} UserGet
 // @Security ApiKeyAuth
-// @Security X-User
 // @Router /users/{id} [get]
-func GetUserById(db *sql.DB) gin.HandlerFunc {
+func (a *API) GetUserById() gin.HandlerFunc {
+	users := a.stores.Users
 	return func(c *gin.Context) {
 		inputId := c.Param("id")
 
@@ -122,10 +280,9 @@ func GetUserById(db *sql.DB) gin.HandlerFunc {
 			return
 		}
 
-		var user UserGet
-		err = db.QueryRow("SELECT id, username FROM users WHERE id = ?", id).Scan(&user.ID, &user.Username)
+		user, err := users.Get(c.Request.Context(), id)
 		if err != nil {
-			if err == sql.ErrNoRows {
+			if errors.Is(err, store.ErrNotFound) {
 				c.IndentedJSON(http.StatusNotFound, gin.H{"error": "user not found"})
 			} else {
 				log.Println(err)
@@ -134,23 +291,36 @@ func GetUserById(db *sql.DB) gin.HandlerFunc {
 			return
 		}
 
-		c.JSON(http.StatusOK, user)
+		// Users who blocked you are not found
+		blocked, err := users.Blocks(c.Request.Context(), user.ID, currentUserID(c))
+		if err != nil {
+			log.Println(err)
+			c.IndentedJSON(http.StatusInternalServerError, gin.H{"error": "Failed to get user"})
+			return
+		}
+		if blocked {
+			c.IndentedJSON(http.StatusNotFound, gin.H{"error": "user not found"})
+			return
+		}
+
+		c.JSON(http.StatusOK, newProfile(user, false).UserGet)
 	}
 }
 
-func SetupUserRoutes(router *gin.Engine, db *sql.DB) {
+func (a *API) setupUserRoutes(router *gin.Engine) {
 	userRoutes := router.Group("/users")
 	{
-		userRoutes.GET("/", GetUsers(db))
-		userRoutes.GET("/:id", GetUserById(db))
+		userRoutes.GET("/", RequireScope(ScopeUsersRead), a.GetUsers())
+		userRoutes.GET("/:id", RequireScope(ScopeUsersRead), a.GetUserById())
+		userRoutes.GET("/by-username/:username", RequireScope(ScopeUsersRead), a.GetUserByUsername())
 
 	}
 }
 
-
-func SetupPublicUserRoutes(router *gin.Engine, db *sql.DB) {
+func (a *API) setupPublicUserRoutes(router *gin.Engine) {
 	userRoutes := router.Group("/users")
 	{
-		userRoutes.POST("/", SetUser(db))
+		userRoutes.GET("/challenge", a.limitRequests("challenge-ip", ratelimit.KeyByIP), a.GetRegistrationChallenge())
+		userRoutes.POST("/", a.limitRequests("registration-ip", ratelimit.KeyByIP), a.limitRequests("registration", ratelimit.KeyGlobal), a.RequireProofOfWork(), a.SetUser())
 	}
-}
\ No newline at end of file
+}
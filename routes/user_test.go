@@ -0,0 +1,119 @@
+package routes
+
+import (
+	"context"
+	"encoding/base64"
+	"net/http"
+	"net/url"
+	"slices"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/adrienchanove/alpha-enigma-api/store"
+)
+
+func TestBlockedUsersAreNotFound(t *testing.T) {
+	ta := newTestAPI(t, Config{})
+	alice := ta.createUser("alice")
+	mallory := ta.createUser("mallory")
+	carol := ta.createUser("carol")
+	if err := ta.stores.Users.Block(context.Background(), alice, mallory, time.Now()); err != nil {
+		t.Fatal(err)
+	}
+	if err := ta.stores.Users.SetAvatar(context.Background(), alice, store.Avatar{ContentType: "image/png", Data: []byte("png"), UpdatedAt: time.Now()}); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, path := range []string{
+		"/users/" + strconv.Itoa(alice),
+		"/users/by-username/alice",
+		"/users/" + strconv.Itoa(alice) + "/avatar",
+	} {
+		ta.expect(ta.do("GET", path, ta.token(mallory), ""), http.StatusNotFound, nil)
+		ta.expect(ta.do("GET", path, ta.token(carol), ""), http.StatusOK, nil)
+	}
+}
+
+func TestUsersPagination(t *testing.T) {
+	ta := newTestAPI(t, Config{})
+	for _, name := range []string{"dave", "Bob", "carol", "alice", "erin"} {
+		ta.createUser(name)
+	}
+	token := ta.token(ta.createUser("albert"))
+
+	var names []string
+	cursors := 0
+	for path := "/users/?limit=2"; path != ""; cursors++ {
+		w := ta.do("GET", path, token, "")
+		var page []UserGet
+		ta.expect(w, http.StatusOK, &page)
+		for _, u := range page {
+			names = append(names, u.Username)
+		}
+		path = ""
+		if cursor := w.Header().Get("X-Next-Cursor"); cursor != "" {
+			id, err := decodeUsersCursor(cursor)
+			if err != nil || id != page[len(page)-1].ID {
+				t.Fatalf("cursor %q decoded to %d, %v, want the id %d of the last user", cursor, id, err, page[len(page)-1].ID)
+			}
+			path = "/users/?limit=2&cursor=" + cursor
+		}
+	}
+	if want := []string{"albert", "alice", "Bob", "carol", "dave", "erin"}; !slices.Equal(names, want) || cursors != 3 {
+		t.Errorf("%d pages of %v, want 3 of %v", cursors, names, want)
+	}
+
+	var page []UserGet
+	ta.expect(ta.do("GET", "/users/?q=AL&limit=1&cursor="+encodeUsersCursor(UserGet{ID: 6}), token, ""), http.StatusOK, &page)
+	if len(page) != 1 || page[0].Username != "alice" {
+		t.Errorf("users starting with al after albert: %+v", page)
+	}
+}
+
+func TestUsersInvalidCursor(t *testing.T) {
+	ta := newTestAPI(t, Config{})
+	alice := ta.createUser("alice")
+	deleted := ta.createUser("bob")
+	if err := ta.stores.Users.Delete(context.Background(), deleted, store.DeletedMessagesKeep, time.Now()); err != nil {
+		t.Fatal(err)
+	}
+	token := ta.token(alice)
+
+	for _, cursor := range []string{
+		"not-base64!",
+		base64.RawURLEncoding.EncodeToString([]byte("1:alice")),
+		base64.RawURLEncoding.EncodeToString([]byte("01")),
+		base64.RawURLEncoding.EncodeToString([]byte("-1")),
+		encodeUsersCursor(UserGet{ID: 1000}),
+		encodeUsersCursor(UserGet{ID: deleted}),
+	} {
+		w := ta.do("GET", "/users/?cursor="+url.QueryEscape(cursor), token, "")
+		if w.Code != http.StatusBadRequest {
+			t.Errorf("cursor %q: status %d, want 400", cursor, w.Code)
+		}
+	}
+}
+
+func TestDirectoryLeavesOutBlockedUsers(t *testing.T) {
+	ta := newTestAPI(t, Config{})
+	alice, mallory := ta.createUser("alice"), ta.createUser("mallory")
+	ta.createUser("carol")
+	if err := ta.stores.Users.Block(context.Background(), alice, mallory, time.Now()); err != nil {
+		t.Fatal(err)
+	}
+
+	// both the blocker and the blocked user lose sight of each other
+	for viewer, want := range map[int][]string{alice: {"alice", "carol"}, mallory: {"carol", "mallory"}} {
+		var page []UserGet
+		ta.expect(ta.do("GET", "/users/", ta.token(viewer), ""), http.StatusOK, &page)
+		var names []string
+		for _, u := range page {
+			names = append(names, u.Username)
+		}
+		if !slices.Equal(names, want) {
+			t.Errorf("directory of user %d: %v, want %v", viewer, names, want)
+		}
+	}
+	ta.expect(ta.do("GET", "/users/by-username/mallory", ta.token(alice), ""), http.StatusOK, nil)
+}
@@ -0,0 +1,71 @@
+package store
+
+import (
+	"sync"
+	"time"
+)
+
+// memory holds the records of the in-memory stores, which share it so the
+// users, messages and contacts stay consistent, like in a database.
+type memory struct {
+	mu sync.Mutex
+
+	users    map[int]*memoryUser
+	avatars  map[int]Avatar
+	blocks   map[[2]int]time.Time
+	messages []Message
+	contacts []Contact
+	reports  []Report
+	audit    []AuditEvent
+
+	lastUserID, lastMessageID, lastContactID, lastReportID, lastAuditID int
+}
+
+type memoryUser struct {
+	User
+	usernameKey      string
+	usernameSkeleton string
+}
+
+// NewMemory returns stores keeping everything in memory, to run the routes
+// without a database. Nothing is persisted.
+func NewMemory() *Stores {
+	m := &memory{
+		users:   make(map[int]*memoryUser),
+		avatars: make(map[int]Avatar),
+		blocks:  make(map[[2]int]time.Time),
+	}
+	return &Stores{
+		Users:       &memoryUsers{m},
+		Messages:    &memoryMessages{m},
+		Contacts:    &memoryContacts{m},
+		Reports:     &memoryReports{m},
+		Audit:       &memoryAudit{m},
+		Tokens:      NewMemoryTokenStore(),
+		Revocations: NewMemoryRevocationStore(),
+	}
+}
+
+// user returns a copy of the user id, with its avatar flag. m.mu must be held.
+func (m *memory) user(id int) (User, bool) {
+	u, ok := m.users[id]
+	if !ok {
+		return User{}, false
+	}
+	user := u.User
+	_, user.HasAvatar = m.avatars[id]
+	return user, true
+}
+
+// isBlocked reports whether one of the two users blocks the other. m.mu must
+// be held.
+func (m *memory) isBlocked(id int, otherID int) bool {
+	_, blocks := m.blocks[[2]int{id, otherID}]
+	_, blocked := m.blocks[[2]int{otherID, id}]
+	return blocks || blocked
+}
+
+// samePair reports whether a and b are, in either order, the users c and d.
+func samePair(a, b, c, d int) bool {
+	return (a == c && b == d) || (a == d && b == c)
+}
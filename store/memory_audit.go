@@ -0,0 +1,49 @@
+package store
+
+import (
+	"context"
+	"slices"
+	"time"
+)
+
+type memoryAudit struct {
+	*memory
+}
+
+func (s *memoryAudit) Append(ctx context.Context, event AuditEvent) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.lastAuditID++
+	event.ID = s.lastAuditID
+	s.audit = append(s.audit, event)
+	return nil
+}
+
+func (s *memoryAudit) List(ctx context.Context, filter AuditFilter) ([]AuditEvent, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var events []AuditEvent
+	for _, e := range slices.Backward(s.audit) {
+		if len(events) == filter.Limit {
+			break
+		}
+		if (filter.UserID != nil && e.UserID != *filter.UserID) ||
+			(filter.Event != "" && e.Event != filter.Event) ||
+			(filter.Before != 0 && e.ID >= filter.Before) {
+			continue
+		}
+		events = append(events, e)
+	}
+	return events, nil
+}
+
+func (s *memoryAudit) Prune(ctx context.Context, before time.Time) (int64, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	count := len(s.audit)
+	s.audit = slices.DeleteFunc(s.audit, func(e AuditEvent) bool { return e.CreatedAt.Before(before) })
+	return int64(count - len(s.audit)), nil
+}
@@ -0,0 +1,151 @@
+package store
+
+import (
+	"context"
+	"slices"
+	"strings"
+	"time"
+)
+
+type memoryContacts struct {
+	*memory
+}
+
+func (s *memoryContacts) Create(ctx context.Context, contact Contact) (int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if slices.ContainsFunc(s.contacts, func(c Contact) bool {
+		return c.RequesterID == contact.RequesterID && c.AddresseeID == contact.AddresseeID
+	}) {
+		return 0, ErrConflict
+	}
+	s.lastContactID++
+	contact.ID = s.lastContactID
+	s.contacts = append(s.contacts, contact)
+	return contact.ID, nil
+}
+
+func (s *memoryContacts) Find(ctx context.Context, userID int, otherID int) (Contact, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	i := slices.IndexFunc(s.contacts, func(c Contact) bool { return samePair(c.RequesterID, c.AddresseeID, userID, otherID) })
+	if i < 0 {
+		return Contact{}, ErrNotFound
+	}
+	return s.contacts[i], nil
+}
+
+// pending returns the index of the pending request id matching keep, or -1.
+// m.mu must be held.
+func (s *memoryContacts) pending(id int, keep func(c Contact) bool) int {
+	return slices.IndexFunc(s.contacts, func(c Contact) bool {
+		return c.ID == id && c.Status == ContactPending && keep(c)
+	})
+}
+
+func (s *memoryContacts) Accept(ctx context.Context, id int, addresseeID int, at time.Time) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	i := s.pending(id, func(c Contact) bool { return c.AddresseeID == addresseeID })
+	if i < 0 {
+		return ErrNotFound
+	}
+	s.contacts[i].Status = ContactAccepted
+	s.contacts[i].RespondedAt = &at
+	return nil
+}
+
+func (s *memoryContacts) Reject(ctx context.Context, id int, addresseeID int) error {
+	return s.removePending(id, func(c Contact) bool { return c.AddresseeID == addresseeID })
+}
+
+func (s *memoryContacts) Cancel(ctx context.Context, id int, requesterID int) error {
+	return s.removePending(id, func(c Contact) bool { return c.RequesterID == requesterID })
+}
+
+func (s *memoryContacts) removePending(id int, keep func(c Contact) bool) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	i := s.pending(id, keep)
+	if i < 0 {
+		return ErrNotFound
+	}
+	s.contacts = slices.Delete(s.contacts, i, i+1)
+	return nil
+}
+
+func (s *memoryContacts) AreContacts(ctx context.Context, userID int, otherID int) (bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return slices.ContainsFunc(s.contacts, func(c Contact) bool {
+		return c.Status == ContactAccepted && samePair(c.RequesterID, c.AddresseeID, userID, otherID)
+	}), nil
+}
+
+func (s *memoryContacts) Remove(ctx context.Context, userID int, otherID int) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.contacts = slices.DeleteFunc(s.contacts, func(c Contact) bool { return samePair(c.RequesterID, c.AddresseeID, userID, otherID) })
+	return nil
+}
+
+func (s *memoryContacts) RemoveAccepted(ctx context.Context, userID int, otherID int) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	count := len(s.contacts)
+	s.contacts = slices.DeleteFunc(s.contacts, func(c Contact) bool {
+		return c.Status == ContactAccepted && samePair(c.RequesterID, c.AddresseeID, userID, otherID)
+	})
+	if len(s.contacts) == count {
+		return ErrNotFound
+	}
+	return nil
+}
+
+func (s *memoryContacts) ListAccepted(ctx context.Context, userID int) ([]ContactUser, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var contacts []ContactUser
+	for _, c := range s.contacts {
+		if c.Status != ContactAccepted || (c.RequesterID != userID && c.AddresseeID != userID) {
+			continue
+		}
+		otherID := c.RequesterID
+		if otherID == userID {
+			otherID = c.AddresseeID
+		}
+		u, ok := s.user(otherID)
+		if !ok {
+			continue
+		}
+		contact := ContactUser{User: u}
+		if c.RespondedAt != nil {
+			contact.Since = *c.RespondedAt
+		}
+		contacts = append(contacts, contact)
+	}
+	slices.SortFunc(contacts, func(a, b ContactUser) int { return strings.Compare(a.User.Username, b.User.Username) })
+	return contacts, nil
+}
+
+func (s *memoryContacts) ListPending(ctx context.Context, userID int) ([]Contact, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var contacts []Contact
+	for _, c := range s.contacts {
+		if c.Status == ContactPending && (c.RequesterID == userID || c.AddresseeID == userID) {
+			contacts = append(contacts, c)
+		}
+	}
+	slices.SortStableFunc(contacts, func(a, b Contact) int { return a.CreatedAt.Compare(b.CreatedAt) })
+	return contacts, nil
+}
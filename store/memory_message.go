@@ -0,0 +1,91 @@
+package store
+
+import (
+	"context"
+	"slices"
+)
+
+type memoryMessages struct {
+	*memory
+}
+
+// filter returns the messages matching keep, by id. m.mu must be held.
+func (s *memoryMessages) filter(keep func(m Message) bool) []Message {
+	var messages []Message
+	for _, m := range s.messages {
+		if keep(m) {
+			messages = append(messages, m)
+		}
+	}
+	return messages
+}
+
+func (s *memoryMessages) Create(ctx context.Context, m Message) (int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.lastMessageID++
+	m.ID = s.lastMessageID
+	s.messages = append(s.messages, m)
+	return m.ID, nil
+}
+
+func (s *memoryMessages) Get(ctx context.Context, id int) (Message, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	i := slices.IndexFunc(s.messages, func(m Message) bool { return m.ID == id })
+	if i < 0 {
+		return Message{}, ErrNotFound
+	}
+	return s.messages[i], nil
+}
+
+func (s *memoryMessages) ListForUser(ctx context.Context, userID int) ([]Message, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.filter(func(m Message) bool { return m.SenderID == userID || m.ReceiverID == userID }), nil
+}
+
+func (s *memoryMessages) ListBetween(ctx context.Context, userID int, otherID int) ([]Message, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.filter(func(m Message) bool { return samePair(m.SenderID, m.ReceiverID, userID, otherID) }), nil
+}
+
+func (s *memoryMessages) Peers(ctx context.Context, userID int) ([]int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var peers []int
+	for _, m := range s.messages {
+		peer := m.SenderID
+		switch userID {
+		case m.SenderID:
+			peer = m.ReceiverID
+		case m.ReceiverID:
+		default:
+			continue
+		}
+		if !slices.Contains(peers, peer) {
+			peers = append(peers, peer)
+		}
+	}
+	return peers, nil
+}
+
+func (s *memoryMessages) HasSent(ctx context.Context, senderID int, receiverID int) (bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return slices.ContainsFunc(s.messages, func(m Message) bool { return m.SenderID == senderID && m.ReceiverID == receiverID }), nil
+}
+
+func (s *memoryMessages) Count(ctx context.Context) (int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return len(s.filter(func(m Message) bool { return m.DeletedAt == nil })), nil
+}
@@ -0,0 +1,73 @@
+package store
+
+import (
+	"context"
+	"fmt"
+	"slices"
+	"time"
+)
+
+type memoryReports struct {
+	*memory
+}
+
+func (s *memoryReports) Create(ctx context.Context, r Report) (int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.lastReportID++
+	r.ID = s.lastReportID
+	s.reports = append(s.reports, r)
+	return r.ID, nil
+}
+
+func (s *memoryReports) List(ctx context.Context, status string) ([]Report, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var reports []Report
+	for _, r := range s.reports {
+		switch status {
+		case ReportsOpen:
+			if r.ResolvedAt != nil {
+				continue
+			}
+		case ReportsResolved:
+			if r.ResolvedAt == nil {
+				continue
+			}
+		case ReportsAll:
+		default:
+			return nil, fmt.Errorf("invalid report status %q", status)
+		}
+		reports = append(reports, r)
+	}
+	return reports, nil
+}
+
+func (s *memoryReports) Resolve(ctx context.Context, id int, at time.Time) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	i := slices.IndexFunc(s.reports, func(r Report) bool { return r.ID == id })
+	if i < 0 {
+		return ErrNotFound
+	}
+	if s.reports[i].ResolvedAt == nil {
+		s.reports[i].ResolvedAt = &at
+	}
+	return nil
+}
+
+func (s *memoryReports) CountOpen(ctx context.Context) (int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	count := 0
+	for _, r := range s.reports {
+		if r.ResolvedAt == nil {
+			count++
+		}
+	}
+	return count, nil
+}
@@ -0,0 +1,375 @@
+package store
+
+import (
+	"cmp"
+	"context"
+	"slices"
+	"strings"
+	"time"
+)
+
+type memoryUsers struct {
+	*memory
+}
+
+func (s *memoryUsers) Create(ctx context.Context, u NewUser) (int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for _, other := range s.users {
+		if other.usernameKey == u.UsernameKey || other.usernameSkeleton == u.UsernameSkeleton {
+			return 0, ErrConflict
+		}
+	}
+	s.lastUserID++
+	s.users[s.lastUserID] = &memoryUser{
+		User: User{
+			ID:            s.lastUserID,
+			Username:      u.Username,
+			PublicKey:     u.PublicKey,
+			Role:          "user",
+			ShowLastSeen:  true,
+			MessagePolicy: "everyone",
+		},
+		usernameKey:      u.UsernameKey,
+		usernameSkeleton: u.UsernameSkeleton,
+	}
+	return s.lastUserID, nil
+}
+
+func (s *memoryUsers) UsernameTaken(ctx context.Context, key string, skeleton string) (bool, bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var taken, lookalike bool
+	for _, u := range s.users {
+		taken = taken || (key != "" && u.usernameKey == key)
+		lookalike = lookalike || (skeleton != "" && u.usernameSkeleton == skeleton)
+	}
+	return taken, lookalike, nil
+}
+
+func (s *memoryUsers) Get(ctx context.Context, id int) (User, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	u, ok := s.user(id)
+	if !ok {
+		return User{}, ErrNotFound
+	}
+	return u, nil
+}
+
+func (s *memoryUsers) GetByUsernameKey(ctx context.Context, key string) (User, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for id, u := range s.users {
+		if key != "" && u.usernameKey == key {
+			user, _ := s.user(id)
+			return user, nil
+		}
+	}
+	return User{}, ErrNotFound
+}
+
+// compareUsernames orders the users like the directory does: by username
+// key, then by id. m.mu must be held.
+func (s *memoryUsers) compareUsernames(a User, b User) int {
+	if c := strings.Compare(s.users[a.ID].usernameKey, s.users[b.ID].usernameKey); c != 0 {
+		return c
+	}
+	return cmp.Compare(a.ID, b.ID)
+}
+
+// sortedUsers returns the users matching keep, by id. m.mu must be held.
+func (s *memoryUsers) sortedUsers(keep func(u User) bool) []User {
+	var users []User
+	for id := range s.users {
+		u, _ := s.user(id)
+		if keep(u) {
+			users = append(users, u)
+		}
+	}
+	slices.SortFunc(users, func(a, b User) int { return cmp.Compare(a.ID, b.ID) })
+	return users
+}
+
+func (s *memoryUsers) List(ctx context.Context, opts ListUsersOptions) ([]User, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var after User
+	if opts.AfterID != 0 {
+		var ok bool
+		if after, ok = s.user(opts.AfterID); !ok {
+			return nil, nil
+		}
+	}
+	users := s.sortedUsers(func(u User) bool {
+		switch {
+		case u.DeletedAt != nil, u.HiddenFromDirectory && u.ID != opts.ViewerID, s.isBlocked(opts.ViewerID, u.ID):
+			return false
+		case !strings.HasPrefix(s.users[u.ID].usernameKey, opts.Prefix):
+			return false
+		case opts.AfterID != 0 && s.compareUsernames(u, after) <= 0:
+			return false
+		}
+		return true
+	})
+	slices.SortFunc(users, s.compareUsernames)
+	if len(users) > opts.Limit {
+		users = users[:opts.Limit]
+	}
+	return users, nil
+}
+
+func (s *memoryUsers) ListAccounts(ctx context.Context) ([]User, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.sortedUsers(func(u User) bool { return u.DeletedAt == nil }), nil
+}
+
+func (s *memoryUsers) Count(ctx context.Context) (UserCounts, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var counts UserCounts
+	for _, u := range s.users {
+		if u.DeletedAt != nil {
+			continue
+		}
+		counts.Users++
+		if u.Role == "admin" {
+			counts.Admins++
+		}
+		if u.SuspendedAt != nil {
+			counts.Suspended++
+		}
+	}
+	return counts, nil
+}
+
+// update calls f on the user id, or returns ErrNotFound.
+func (s *memoryUsers) update(id int, f func(u *memoryUser)) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	u, ok := s.users[id]
+	if !ok {
+		return ErrNotFound
+	}
+	f(u)
+	return nil
+}
+
+func (s *memoryUsers) SetRole(ctx context.Context, id int, role string) error {
+	return s.update(id, func(u *memoryUser) { u.Role = role })
+}
+
+func (s *memoryUsers) Suspend(ctx context.Context, id int, at time.Time) error {
+	return s.update(id, func(u *memoryUser) {
+		if u.SuspendedAt == nil {
+			u.SuspendedAt = &at
+		}
+	})
+}
+
+func (s *memoryUsers) Unsuspend(ctx context.Context, id int) error {
+	return s.update(id, func(u *memoryUser) { u.SuspendedAt = nil })
+}
+
+func (s *memoryUsers) Delete(ctx context.Context, id int, messagesPolicy string, at time.Time) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	u, ok := s.users[id]
+	if !ok || u.DeletedAt != nil {
+		return ErrNotFound
+	}
+	*u = memoryUser{User: User{
+		ID:                  id,
+		Role:                "user",
+		ShowLastSeen:        u.ShowLastSeen,
+		MessagePolicy:       u.MessagePolicy,
+		HiddenFromDirectory: true,
+		SuspendedAt:         u.SuspendedAt,
+		DeletedAt:           &at,
+	}}
+
+	delete(s.avatars, id)
+	for pair := range s.blocks {
+		if pair[0] == id || pair[1] == id {
+			delete(s.blocks, pair)
+		}
+	}
+	s.contacts = slices.DeleteFunc(s.contacts, func(c Contact) bool {
+		return c.RequesterID == id || c.AddresseeID == id
+	})
+
+	involved := func(m Message) bool { return m.SenderID == id || m.ReceiverID == id }
+	switch messagesPolicy {
+	case DeletedMessagesTombstone:
+		for i, m := range s.messages {
+			if involved(m) {
+				s.messages[i].Content = ""
+				s.messages[i].DeletedAt = &at
+			}
+		}
+	case DeletedMessagesPurge:
+		for i, r := range s.reports {
+			if r.MessageID == nil {
+				continue
+			}
+			if j := slices.IndexFunc(s.messages, func(m Message) bool { return m.ID == *r.MessageID }); j >= 0 && involved(s.messages[j]) {
+				s.reports[i].MessageID = nil
+			}
+		}
+		s.messages = slices.DeleteFunc(s.messages, involved)
+	}
+	return nil
+}
+
+func (s *memoryUsers) UpdateProfile(ctx context.Context, id int, update ProfileUpdate) error {
+	return s.update(id, func(u *memoryUser) {
+		if update.DisplayName != nil {
+			u.DisplayName = *update.DisplayName
+		}
+		if update.Bio != nil {
+			u.Bio = *update.Bio
+		}
+		if update.StatusText != nil {
+			u.StatusText = *update.StatusText
+		}
+		if update.ShowLastSeen != nil {
+			u.ShowLastSeen = *update.ShowLastSeen
+		}
+	})
+}
+
+func (s *memoryUsers) TouchLastSeen(ctx context.Context, id int, now time.Time, resolution time.Duration) error {
+	err := s.update(id, func(u *memoryUser) {
+		if u.LastSeenAt == nil || u.LastSeenAt.Before(now.Add(-resolution)) {
+			u.LastSeenAt = &now
+		}
+	})
+	if err == ErrNotFound {
+		return nil
+	}
+	return err
+}
+
+func (s *memoryUsers) SetPrivacy(ctx context.Context, id int, messagePolicy string, hiddenFromDirectory bool) error {
+	return s.update(id, func(u *memoryUser) {
+		u.MessagePolicy = messagePolicy
+		u.HiddenFromDirectory = hiddenFromDirectory
+	})
+}
+
+func (s *memoryUsers) SetAvatar(ctx context.Context, id int, avatar Avatar) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	avatar.Data = slices.Clone(avatar.Data)
+	s.avatars[id] = avatar
+	return nil
+}
+
+func (s *memoryUsers) DeleteAvatar(ctx context.Context, id int) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	delete(s.avatars, id)
+	return nil
+}
+
+func (s *memoryUsers) GetAvatar(ctx context.Context, id int) (Avatar, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	avatar, ok := s.avatars[id]
+	if !ok {
+		return Avatar{}, ErrNotFound
+	}
+	return avatar, nil
+}
+
+func (s *memoryUsers) Block(ctx context.Context, blockerID int, blockedID int, at time.Time) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, ok := s.blocks[[2]int{blockerID, blockedID}]; !ok {
+		s.blocks[[2]int{blockerID, blockedID}] = at
+	}
+	return nil
+}
+
+func (s *memoryUsers) Unblock(ctx context.Context, blockerID int, blockedID int) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, ok := s.blocks[[2]int{blockerID, blockedID}]; !ok {
+		return ErrNotFound
+	}
+	delete(s.blocks, [2]int{blockerID, blockedID})
+	return nil
+}
+
+func (s *memoryUsers) Blocks(ctx context.Context, blockerID int, blockedID int) (bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	_, ok := s.blocks[[2]int{blockerID, blockedID}]
+	return ok, nil
+}
+
+func (s *memoryUsers) IsBlocked(ctx context.Context, id int, otherID int) (bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.isBlocked(id, otherID), nil
+}
+
+func (s *memoryUsers) BlockedUserIDs(ctx context.Context, id int) ([]int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var ids []int
+	for pair := range s.blocks {
+		switch id {
+		case pair[0]:
+			ids = append(ids, pair[1])
+		case pair[1]:
+			ids = append(ids, pair[0])
+		}
+	}
+	slices.Sort(ids)
+	return slices.Compact(ids), nil
+}
+
+func (s *memoryUsers) ListBlocked(ctx context.Context, blockerID int) ([]User, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	type block struct {
+		user User
+		at   time.Time
+	}
+	var blocks []block
+	for pair, at := range s.blocks {
+		if pair[0] != blockerID {
+			continue
+		}
+		if u, ok := s.user(pair[1]); ok {
+			blocks = append(blocks, block{u, at})
+		}
+	}
+	slices.SortFunc(blocks, func(a, b block) int { return a.at.Compare(b.at) })
+
+	users := make([]User, 0, len(blocks))
+	for _, b := range blocks {
+		users = append(users, b.user)
+	}
+	return users, nil
+}
@@ -0,0 +1,153 @@
+package store
+
+import (
+	"context"
+	"errors"
+	"slices"
+	"testing"
+	"time"
+)
+
+func messageIDs(messages []Message) []int {
+	var ids []int
+	for _, m := range messages {
+		ids = append(ids, m.ID)
+	}
+	return ids
+}
+
+func TestMessages(t *testing.T) {
+	eachStores(t, func(t *testing.T, stores *Stores) {
+		ctx := context.Background()
+		ids := createUsers(t, stores.Users, "alice", "bob", "carol")
+		alice, bob, carol := ids[0], ids[1], ids[2]
+
+		var sent []int
+		for _, m := range []Message{
+			{Content: "hello bob", SenderID: alice, ReceiverID: bob},
+			{Content: "hello carol", SenderID: alice, ReceiverID: carol},
+			{Content: "hi alice", SenderID: bob, ReceiverID: alice},
+		} {
+			id, err := stores.Messages.Create(ctx, m)
+			if err != nil {
+				t.Fatal(err)
+			}
+			sent = append(sent, id)
+		}
+
+		if m, err := stores.Messages.Get(ctx, sent[0]); err != nil || m.Content != "hello bob" || m.SenderID != alice || m.ReceiverID != bob {
+			t.Errorf("message %+v, %v", m, err)
+		}
+		if _, err := stores.Messages.Get(ctx, 1000); !errors.Is(err, ErrNotFound) {
+			t.Errorf("unknown message: %v, want ErrNotFound", err)
+		}
+		if messages, err := stores.Messages.ListBetween(ctx, bob, alice); err != nil || !slices.Equal(messageIDs(messages), []int{sent[0], sent[2]}) {
+			t.Errorf("conversation of alice and bob %v, %v", messageIDs(messages), err)
+		}
+		if messages, err := stores.Messages.ListForUser(ctx, alice); err != nil || !slices.Equal(messageIDs(messages), sent) {
+			t.Errorf("messages of alice %v, %v", messageIDs(messages), err)
+		}
+		if peers, err := stores.Messages.Peers(ctx, alice); err != nil || !slices.Equal(peers, []int{bob, carol}) {
+			t.Errorf("peers of alice %v, %v", peers, err)
+		}
+		if sentTo, err := stores.Messages.HasSent(ctx, carol, alice); err != nil || sentTo {
+			t.Errorf("carol sent to alice: %v, %v", sentTo, err)
+		}
+
+		// tombstoned messages stay in the conversations but are not counted
+		if err := stores.Users.Delete(ctx, carol, DeletedMessagesTombstone, time.Now()); err != nil {
+			t.Fatal(err)
+		}
+		if m, err := stores.Messages.Get(ctx, sent[1]); err != nil || m.Content != "" || m.DeletedAt == nil {
+			t.Errorf("message to a deleted user %+v, %v", m, err)
+		}
+		if count, err := stores.Messages.Count(ctx); err != nil || count != 2 {
+			t.Errorf("count %d, %v, want 2", count, err)
+		}
+	})
+}
+
+func TestContacts(t *testing.T) {
+	eachStores(t, func(t *testing.T, stores *Stores) {
+		ctx := context.Background()
+		contacts := stores.Contacts
+		ids := createUsers(t, stores.Users, "alice", "bob", "carol")
+		alice, bob, carol := ids[0], ids[1], ids[2]
+		now := time.Now()
+
+		request, err := contacts.Create(ctx, Contact{RequesterID: alice, AddresseeID: bob, Status: ContactPending, CreatedAt: now})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := contacts.Create(ctx, Contact{RequesterID: carol, AddresseeID: alice, Status: ContactPending, CreatedAt: now.Add(time.Second)}); err != nil {
+			t.Fatal(err)
+		}
+		if pending, err := contacts.ListPending(ctx, alice); err != nil || len(pending) != 2 || pending[0].ID != request {
+			t.Errorf("pending requests of alice %+v, %v", pending, err)
+		}
+		if err := contacts.Accept(ctx, request, alice, now); !errors.Is(err, ErrNotFound) {
+			t.Errorf("accepting its own request: %v, want ErrNotFound", err)
+		}
+		if err := contacts.Accept(ctx, request, bob, now); err != nil {
+			t.Fatal(err)
+		}
+
+		if found, err := contacts.Find(ctx, bob, alice); err != nil || found.ID != request || found.Status != ContactAccepted || found.RespondedAt == nil {
+			t.Errorf("contact of alice and bob %+v, %v", found, err)
+		}
+		if accepted, err := contacts.AreContacts(ctx, bob, alice); err != nil || !accepted {
+			t.Errorf("alice and bob contacts: %v, %v", accepted, err)
+		}
+		list, err := contacts.ListAccepted(ctx, alice)
+		if err != nil || len(list) != 1 || list[0].User.Username != "bob" {
+			t.Errorf("contacts of alice %+v, %v", list, err)
+		}
+
+		if err := contacts.RemoveAccepted(ctx, alice, bob); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := contacts.Find(ctx, alice, bob); !errors.Is(err, ErrNotFound) {
+			t.Errorf("removed contact: %v, want ErrNotFound", err)
+		}
+	})
+}
+
+func TestReportsAndAudit(t *testing.T) {
+	eachStores(t, func(t *testing.T, stores *Stores) {
+		ctx := context.Background()
+		ids := createUsers(t, stores.Users, "alice", "bob")
+		alice, bob := ids[0], ids[1]
+		now := time.Now()
+
+		report, err := stores.Reports.Create(ctx, Report{ReporterID: alice, ReportedID: bob, Reason: "spam", CreatedAt: now})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if open, err := stores.Reports.List(ctx, ReportsOpen); err != nil || len(open) != 1 || open[0].Reason != "spam" {
+			t.Errorf("open reports %+v, %v", open, err)
+		}
+		if err := stores.Reports.Resolve(ctx, report, now); err != nil {
+			t.Fatal(err)
+		}
+		if count, err := stores.Reports.CountOpen(ctx); err != nil || count != 0 {
+			t.Errorf("%d open reports, %v, want 0", count, err)
+		}
+
+		for i, event := range []AuditEvent{
+			{Event: "auth.token_issued", UserID: alice, IP: "192.0.2.1", CreatedAt: now.Add(-48 * time.Hour)},
+			{Event: "auth.failed", IP: "192.0.2.2", CreatedAt: now},
+			{Event: "auth.token_issued", UserID: alice, IP: "192.0.2.1", Details: "type=opaque", CreatedAt: now},
+		} {
+			if err := stores.Audit.Append(ctx, event); err != nil {
+				t.Fatalf("event %d: %v", i, err)
+			}
+		}
+		events, err := stores.Audit.List(ctx, AuditFilter{UserID: &alice, Event: "auth.token_issued", Limit: 1})
+		if err != nil || len(events) != 1 || events[0].Details != "type=opaque" || events[0].IP != "192.0.2.1" {
+			t.Errorf("newest event of alice %+v, %v", events, err)
+		}
+		if pruned, err := stores.Audit.Prune(ctx, now.Add(-24*time.Hour)); err != nil || pruned != 1 {
+			t.Errorf("pruned %d events, %v, want 1", pruned, err)
+		}
+	})
+}
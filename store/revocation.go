@@ -0,0 +1,74 @@
+package store
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+type userRevocation struct {
+	before    time.Time
+	except    string
+	expiresAt time.Time
+}
+
+// MemoryRevocationStore keeps the revocations in memory, they are lost on
+// restart and not shared between instances. Entries are dropped once the
+// tokens they cover have expired, so it stays small.
+type MemoryRevocationStore struct {
+	mu     sync.Mutex
+	tokens map[string]time.Time
+	users  map[int]userRevocation
+}
+
+// NewMemoryRevocationStore returns an empty MemoryRevocationStore.
+func NewMemoryRevocationStore() *MemoryRevocationStore {
+	return &MemoryRevocationStore{
+		tokens: make(map[string]time.Time),
+		users:  make(map[int]userRevocation),
+	}
+}
+
+func (s *MemoryRevocationStore) RevokeToken(ctx context.Context, id string, expiresAt time.Time) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.prune(time.Now())
+	s.tokens[id] = expiresAt
+	return nil
+}
+
+func (s *MemoryRevocationStore) RevokeUser(ctx context.Context, userID int, before time.Time, except string, expiresAt time.Time) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.prune(time.Now())
+	s.users[userID] = userRevocation{before: before, except: except, expiresAt: expiresAt}
+	return nil
+}
+
+func (s *MemoryRevocationStore) IsRevoked(ctx context.Context, id string, userID int, issuedAt time.Time) (bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, ok := s.tokens[id]; ok {
+		return true, nil
+	}
+	revocation, ok := s.users[userID]
+	return ok && id != revocation.except && !issuedAt.After(revocation.before), nil
+}
+
+// prune drops the entries whose tokens have all expired. It is called on
+// every revocation, s.mu must be held.
+func (s *MemoryRevocationStore) prune(now time.Time) {
+	for id, expiresAt := range s.tokens {
+		if now.After(expiresAt) {
+			delete(s.tokens, id)
+		}
+	}
+	for userID, revocation := range s.users {
+		if now.After(revocation.expiresAt) {
+			delete(s.users, userID)
+		}
+	}
+}
@@ -0,0 +1,113 @@
+package store
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestRevocations(t *testing.T) {
+	eachStores(t, func(t *testing.T, stores *Stores) {
+		ctx := context.Background()
+		revocations := stores.Revocations
+		now := time.Now()
+		expiresAt := now.Add(time.Hour)
+
+		if err := revocations.RevokeToken(ctx, "logged-out", expiresAt); err != nil {
+			t.Fatal(err)
+		}
+		if err := revocations.RevokeUser(ctx, 1, now, "kept", expiresAt); err != nil {
+			t.Fatal(err)
+		}
+
+		for _, test := range []struct {
+			name     string
+			id       string
+			userID   int
+			issuedAt time.Time
+			revoked  bool
+		}{
+			{"revoked token", "logged-out", 2, now, true},
+			{"other token", "other", 2, now, false},
+			{"issued before the user revocation", "old", 1, now.Add(-time.Minute), true},
+			{"issued at the user revocation", "old", 1, now, true},
+			{"kept token", "kept", 1, now.Add(-time.Minute), false},
+			// same second as the revocation, the client authenticating again
+			{"issued right after the user revocation", "new", 1, now.Add(time.Microsecond), false},
+		} {
+			revoked, err := revocations.IsRevoked(ctx, test.id, test.userID, test.issuedAt)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if revoked != test.revoked {
+				t.Errorf("%s: revoked = %v, want %v", test.name, revoked, test.revoked)
+			}
+		}
+
+		// a later revocation replaces the previous one
+		later := now.Add(time.Second)
+		if err := revocations.RevokeUser(ctx, 1, later, "", later.Add(time.Hour)); err != nil {
+			t.Fatal(err)
+		}
+		if revoked, err := revocations.IsRevoked(ctx, "kept", 1, now.Add(-time.Minute)); err != nil || !revoked {
+			t.Errorf("kept token after a second revocation: revoked = %v, %v, want true", revoked, err)
+		}
+	})
+}
+
+func TestRevocationsExpire(t *testing.T) {
+	eachStores(t, func(t *testing.T, stores *Stores) {
+		ctx := context.Background()
+		revocations := stores.Revocations
+		past := time.Now().Add(-time.Minute)
+
+		if err := revocations.RevokeToken(ctx, "expired", past); err != nil {
+			t.Fatal(err)
+		}
+		// revoking prunes the expired revocations
+		if err := revocations.RevokeToken(ctx, "other", time.Now().Add(time.Hour)); err != nil {
+			t.Fatal(err)
+		}
+		if revoked, err := revocations.IsRevoked(ctx, "expired", 1, past); err != nil || revoked {
+			t.Errorf("expired revocation: revoked = %v, %v, want false", revoked, err)
+		}
+	})
+}
+
+func TestRevocationsExpireAcrossZones(t *testing.T) {
+	inZone(t, "Europe/Paris")
+	newYork, tokyo := loadZone(t, "America/New_York"), loadZone(t, "Asia/Tokyo")
+	eachStores(t, func(t *testing.T, stores *Stores) {
+		ctx := context.Background()
+		revocations := stores.Revocations
+		now := time.Now()
+
+		if err := revocations.RevokeToken(ctx, "unexpired", now.Add(30*time.Minute).In(newYork)); err != nil {
+			t.Fatal(err)
+		}
+		if err := revocations.RevokeUser(ctx, 1, now.In(newYork), "", now.Add(30*time.Minute).In(newYork)); err != nil {
+			t.Fatal(err)
+		}
+		if err := revocations.RevokeToken(ctx, "expired", now.Add(-30*time.Minute).In(tokyo)); err != nil {
+			t.Fatal(err)
+		}
+		// revoking prunes the expired revocations
+		if err := revocations.RevokeToken(ctx, "other", now.Add(time.Hour)); err != nil {
+			t.Fatal(err)
+		}
+
+		for _, test := range []struct {
+			id      string
+			userID  int
+			revoked bool
+		}{
+			{"unexpired", 2, true},
+			{"expired", 2, false},
+			{"of user 1", 1, true},
+		} {
+			if revoked, err := revocations.IsRevoked(ctx, test.id, test.userID, now.Add(-time.Minute)); err != nil || revoked != test.revoked {
+				t.Errorf("%s: revoked = %v, %v, want %v", test.id, revoked, err, test.revoked)
+			}
+		}
+	})
+}
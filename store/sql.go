@@ -0,0 +1,187 @@
+package store
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/adrienchanove/alpha-enigma-api/atrest"
+	"github.com/adrienchanove/alpha-enigma-api/database"
+)
+
+// NewSQL returns the stores backed by the migrated database db, SQLite or
+// PostgreSQL as given by dialect.
+//
+// keys encrypts the metadata columns, see EncryptedColumns: it must not be
+// nil once the database is encrypted, and must be nil until it is, see
+// EncryptionState and Reencrypt.
+func NewSQL(db *sql.DB, dialect database.Dialect, keys *atrest.Keyring) *Stores {
+	conn := sqlDB{db, dialect, keys}
+	return &Stores{
+		Users:       &sqlUsers{conn},
+		Messages:    &sqlMessages{conn},
+		Contacts:    &sqlContacts{conn},
+		Reports:     &sqlReports{conn},
+		Audit:       &sqlAudit{conn},
+		Tokens:      &sqlTokens{conn},
+		Revocations: &sqlRevocations{conn},
+	}
+}
+
+// sqlDB runs the queries of the stores, written with ? placeholders and in
+// the SQL both dialects understand, rebinding them for the dialect and
+// binding the times in UTC. It encrypts the metadata columns when keys is not
+// nil.
+type sqlDB struct {
+	*sql.DB
+	dialect database.Dialect
+	keys    *atrest.Keyring
+}
+
+func (db sqlDB) ExecContext(ctx context.Context, query string, args ...any) (sql.Result, error) {
+	return db.DB.ExecContext(ctx, db.dialect.Rebind(query), utc(args)...)
+}
+
+func (db sqlDB) QueryContext(ctx context.Context, query string, args ...any) (*sql.Rows, error) {
+	return db.DB.QueryContext(ctx, db.dialect.Rebind(query), utc(args)...)
+}
+
+func (db sqlDB) QueryRowContext(ctx context.Context, query string, args ...any) *sql.Row {
+	return db.DB.QueryRowContext(ctx, db.dialect.Rebind(query), utc(args)...)
+}
+
+// utc returns args with their times in UTC. SQLite keeps the times as text in
+// the zone they were bound in and compares them as text, which only orders
+// them when they all are in the same zone: the server's local zone would not
+// do across a change of its offset, or of the server's zone.
+func utc(args []any) []any {
+	var converted []any
+	for i, arg := range args {
+		var t time.Time
+		switch arg := arg.(type) {
+		case time.Time:
+			t = arg
+		case *time.Time:
+			if arg == nil {
+				continue
+			}
+			t = *arg
+		default:
+			continue
+		}
+		if converted == nil {
+			converted = slices.Clone(args)
+		}
+		converted[i] = t.UTC()
+	}
+	if converted == nil {
+		return args
+	}
+	return converted
+}
+
+type rowScanner interface {
+	Scan(dest ...any) error
+}
+
+// notFound turns sql.ErrNoRows into ErrNotFound.
+func notFound(err error) error {
+	if errors.Is(err, sql.ErrNoRows) {
+		return ErrNotFound
+	}
+	return err
+}
+
+// execOne runs an update of a single record, returning ErrNotFound when no
+// record matched.
+func execOne(ctx context.Context, db sqlDB, query string, args ...any) error {
+	result, err := db.ExecContext(ctx, query, args...)
+	if err != nil {
+		return err
+	}
+	updated, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if updated == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
+
+// insert runs an INSERT and returns the id of the new row.
+func insert(ctx context.Context, db sqlDB, query string, args ...any) (int, error) {
+	var id int
+	err := db.QueryRowContext(ctx, query+" RETURNING id", args...).Scan(&id)
+	if database.IsUniqueViolation(err) {
+		return 0, ErrConflict
+	}
+	return id, err
+}
+
+// exists runs a SELECT EXISTS query.
+func exists(ctx context.Context, db sqlDB, query string, args ...any) (bool, error) {
+	var found bool
+	err := db.QueryRowContext(ctx, query, args...).Scan(&found)
+	return found, err
+}
+
+// nullTime returns a pointer to the time, nil when t is NULL.
+func nullTime(t sql.NullTime) *time.Time {
+	if !t.Valid {
+		return nil
+	}
+	return &t.Time
+}
+
+// seal returns value as stored in the encrypted column, "table.column".
+func (db sqlDB) seal(column string, value string) (string, error) {
+	if db.keys == nil {
+		return value, nil
+	}
+	return db.keys.Seal(column, value)
+}
+
+// sealOptional seals value when it is not nil.
+func (db sqlDB) sealOptional(column string, value *string) (*string, error) {
+	if value == nil {
+		return nil, nil
+	}
+	sealed, err := db.seal(column, *value)
+	return &sealed, err
+}
+
+// open decrypts, in place, a value read from the encrypted column.
+func (db sqlDB) open(column string, value *string) error {
+	if db.keys == nil || *value == "" {
+		return nil
+	}
+	var err error
+	*value, err = db.keys.Open(column, *value)
+	return err
+}
+
+// index returns value as stored in the indexed column.
+func (db sqlDB) index(column string, value string) string {
+	if db.keys == nil {
+		return value
+	}
+	return db.keys.Index(column, value)
+}
+
+// lookup returns the condition matching value in the indexed column: its
+// blind index with any key, or itself when the column is not encrypted.
+func (db sqlDB) lookup(column string, value string) (string, []any) {
+	args := []any{value}
+	if db.keys != nil {
+		args = nil
+		for _, index := range db.keys.Indexes(column, value) {
+			args = append(args, index)
+		}
+	}
+	_, name, _ := strings.Cut(column, ".")
+	return name + " IN (?" + strings.Repeat(", ?", len(args)-1) + ")", args
+}
@@ -0,0 +1,72 @@
+package store
+
+import (
+	"context"
+	"time"
+)
+
+type sqlAudit struct {
+	db sqlDB
+}
+
+func (s *sqlAudit) Append(ctx context.Context, event AuditEvent) error {
+	var err error
+	if event.IP, err = s.db.seal("audit_events.ip", event.IP); err != nil {
+		return err
+	}
+	if event.UserAgent, err = s.db.seal("audit_events.user_agent", event.UserAgent); err != nil {
+		return err
+	}
+	if event.Details, err = s.db.seal("audit_events.details", event.Details); err != nil {
+		return err
+	}
+	_, err = s.db.ExecContext(ctx, "INSERT INTO audit_events (event, user_id, ip, user_agent, details, created_at) VALUES (?, ?, ?, ?, ?, ?)",
+		event.Event, event.UserID, event.IP, event.UserAgent, event.Details, event.CreatedAt)
+	return err
+}
+
+func (s *sqlAudit) List(ctx context.Context, filter AuditFilter) ([]AuditEvent, error) {
+	query := "SELECT id, event, user_id, ip, user_agent, details, created_at FROM audit_events WHERE 1 = 1"
+	var args []any
+	if filter.UserID != nil {
+		query += " AND user_id = ?"
+		args = append(args, *filter.UserID)
+	}
+	if filter.Event != "" {
+		query += " AND event = ?"
+		args = append(args, filter.Event)
+	}
+	if filter.Before != 0 {
+		query += " AND id < ?"
+		args = append(args, filter.Before)
+	}
+
+	rows, err := s.db.QueryContext(ctx, query+" ORDER BY id DESC LIMIT ?", append(args, filter.Limit)...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var events []AuditEvent
+	for rows.Next() {
+		var e AuditEvent
+		if err := rows.Scan(&e.ID, &e.Event, &e.UserID, &e.IP, &e.UserAgent, &e.Details, &e.CreatedAt); err != nil {
+			return nil, err
+		}
+		for column, value := range map[string]*string{"audit_events.ip": &e.IP, "audit_events.user_agent": &e.UserAgent, "audit_events.details": &e.Details} {
+			if err := s.db.open(column, value); err != nil {
+				return nil, err
+			}
+		}
+		events = append(events, e)
+	}
+	return events, rows.Err()
+}
+
+func (s *sqlAudit) Prune(ctx context.Context, before time.Time) (int64, error) {
+	result, err := s.db.ExecContext(ctx, "DELETE FROM audit_events WHERE created_at < ?", before)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected()
+}
@@ -0,0 +1,110 @@
+package store
+
+import (
+	"context"
+	"database/sql"
+	"slices"
+	"strings"
+	"time"
+)
+
+type sqlContacts struct {
+	db sqlDB
+}
+
+const contactColumns = "contacts.id, contacts.requester_id, contacts.addressee_id, contacts.status, contacts.created_at, contacts.responded_at"
+
+func scanContact(row rowScanner) (Contact, error) {
+	var c Contact
+	var respondedAt sql.NullTime
+	if err := row.Scan(&c.ID, &c.RequesterID, &c.AddresseeID, &c.Status, &c.CreatedAt, &respondedAt); err != nil {
+		return Contact{}, err
+	}
+	c.RespondedAt = nullTime(respondedAt)
+	return c, nil
+}
+
+func (s *sqlContacts) Create(ctx context.Context, contact Contact) (int, error) {
+	return insert(ctx, s.db, "INSERT INTO contacts (requester_id, addressee_id, status, created_at, responded_at) VALUES (?, ?, ?, ?, ?)",
+		contact.RequesterID, contact.AddresseeID, contact.Status, contact.CreatedAt, contact.RespondedAt)
+}
+
+func (s *sqlContacts) Find(ctx context.Context, userID int, otherID int) (Contact, error) {
+	c, err := scanContact(s.db.QueryRowContext(ctx, "SELECT "+contactColumns+" FROM contacts WHERE (requester_id = ? AND addressee_id = ?) OR (requester_id = ? AND addressee_id = ?)",
+		userID, otherID, otherID, userID))
+	return c, notFound(err)
+}
+
+func (s *sqlContacts) Accept(ctx context.Context, id int, addresseeID int, at time.Time) error {
+	return execOne(ctx, s.db, "UPDATE contacts SET status = ?, responded_at = ? WHERE id = ? AND addressee_id = ? AND status = ?", ContactAccepted, at, id, addresseeID, ContactPending)
+}
+
+func (s *sqlContacts) Reject(ctx context.Context, id int, addresseeID int) error {
+	return execOne(ctx, s.db, "DELETE FROM contacts WHERE id = ? AND addressee_id = ? AND status = ?", id, addresseeID, ContactPending)
+}
+
+func (s *sqlContacts) Cancel(ctx context.Context, id int, requesterID int) error {
+	return execOne(ctx, s.db, "DELETE FROM contacts WHERE id = ? AND requester_id = ? AND status = ?", id, requesterID, ContactPending)
+}
+
+func (s *sqlContacts) AreContacts(ctx context.Context, userID int, otherID int) (bool, error) {
+	return exists(ctx, s.db, "SELECT EXISTS(SELECT 1 FROM contacts WHERE status = ? AND ((requester_id = ? AND addressee_id = ?) OR (requester_id = ? AND addressee_id = ?)))",
+		ContactAccepted, userID, otherID, otherID, userID)
+}
+
+func (s *sqlContacts) Remove(ctx context.Context, userID int, otherID int) error {
+	_, err := s.db.ExecContext(ctx, "DELETE FROM contacts WHERE (requester_id = ? AND addressee_id = ?) OR (requester_id = ? AND addressee_id = ?)", userID, otherID, otherID, userID)
+	return err
+}
+
+func (s *sqlContacts) RemoveAccepted(ctx context.Context, userID int, otherID int) error {
+	return execOne(ctx, s.db, "DELETE FROM contacts WHERE status = ? AND ((requester_id = ? AND addressee_id = ?) OR (requester_id = ? AND addressee_id = ?))",
+		ContactAccepted, userID, otherID, otherID, userID)
+}
+
+func (s *sqlContacts) ListAccepted(ctx context.Context, userID int) ([]ContactUser, error) {
+	rows, err := s.db.QueryContext(ctx, `SELECT `+userColumns+`, contacts.responded_at FROM contacts
+		JOIN users ON users.id = CASE WHEN contacts.requester_id = ? THEN contacts.addressee_id ELSE contacts.requester_id END
+		WHERE contacts.status = ? AND (contacts.requester_id = ? OR contacts.addressee_id = ?)
+		ORDER BY users.username`, userID, ContactAccepted, userID, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var contacts []ContactUser
+	for rows.Next() {
+		var contact ContactUser
+		var err error
+		if contact.User, err = s.db.scanUser(rows, &contact.Since); err != nil {
+			return nil, err
+		}
+		contacts = append(contacts, contact)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	if s.db.keys != nil {
+		// the encrypted usernames are sorted once decrypted
+		slices.SortStableFunc(contacts, func(a, b ContactUser) int { return strings.Compare(a.User.Username, b.User.Username) })
+	}
+	return contacts, nil
+}
+
+func (s *sqlContacts) ListPending(ctx context.Context, userID int) ([]Contact, error) {
+	rows, err := s.db.QueryContext(ctx, "SELECT "+contactColumns+" FROM contacts WHERE status = ? AND (requester_id = ? OR addressee_id = ?) ORDER BY created_at", ContactPending, userID, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var contacts []Contact
+	for rows.Next() {
+		c, err := scanContact(rows)
+		if err != nil {
+			return nil, err
+		}
+		contacts = append(contacts, c)
+	}
+	return contacts, rows.Err()
+}
@@ -0,0 +1,376 @@
+package store
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"slices"
+	"strings"
+
+	"github.com/adrienchanove/alpha-enigma-api/atrest"
+	"github.com/adrienchanove/alpha-enigma-api/database"
+	"github.com/adrienchanove/alpha-enigma-api/username"
+)
+
+// EncryptedColumns are the columns the SQL stores encrypt, as "table.column".
+// The username keys and prefixes hold blind indexes, the others sealed
+// values. The ids stay in plaintext for the queries, and so does the social
+// graph: who messages, adds, blocks or reports whom, but without the names,
+// profiles and public keys the ids refer to.
+var EncryptedColumns = []string{
+	"users.username", "users.username_key", "users.username_skeleton", "users.public_key",
+	"users.display_name", "users.bio", "users.status_text",
+	"username_prefixes.prefix_index",
+	"avatars.data",
+	"reports.reason",
+	"audit_events.ip", "audit_events.user_agent", "audit_events.details",
+	"tokens.username", "tokens.device", "tokens.client_ip", "tokens.user_agent",
+}
+
+// auditColumns are the sealed columns of the audit_events table.
+var auditColumns = []string{"audit_events.ip", "audit_events.user_agent", "audit_events.details"}
+
+type rowQuerier interface {
+	QueryRowContext(ctx context.Context, query string, args ...any) *sql.Row
+}
+
+// EncryptionState reports whether the EncryptedColumns of db are encrypted.
+// They are as a whole, by Reencrypt: the stores need the keys once they are,
+// and must not have any before.
+func EncryptionState(ctx context.Context, db *sql.DB) (bool, error) {
+	return encryptionState(ctx, db)
+}
+
+func encryptionState(ctx context.Context, db rowQuerier) (bool, error) {
+	var encrypted bool
+	err := db.QueryRowContext(ctx, "SELECT encrypted FROM encryption_state").Scan(&encrypted)
+	return encrypted, err
+}
+
+// KeyUsage counts the values of EncryptedColumns by the id of the key they
+// are encrypted with, "" for the plaintext ones, which are all of them until
+// the database is encrypted. A key can be dropped once it encrypts nothing.
+func KeyUsage(ctx context.Context, db *sql.DB) (map[string]int, error) {
+	encrypted, err := EncryptionState(ctx, db)
+	if err != nil {
+		return nil, err
+	}
+	usage := make(map[string]int)
+	for _, column := range EncryptedColumns {
+		table, name, _ := strings.Cut(column, ".")
+		rows, err := db.QueryContext(ctx, "SELECT "+name+" FROM "+table+" WHERE "+name+" IS NOT NULL")
+		if err != nil {
+			return nil, err
+		}
+		for rows.Next() {
+			var value []byte
+			if err := rows.Scan(&value); err != nil {
+				rows.Close()
+				return nil, err
+			}
+			switch {
+			case len(value) == 0:
+			case encrypted:
+				usage[atrest.KeyIDBytes(value)]++
+			default:
+				usage[""]++
+			}
+		}
+		rows.Close()
+		if err := rows.Err(); err != nil {
+			return nil, err
+		}
+	}
+	return usage, nil
+}
+
+// reencryption rewrites the encrypted columns in a transaction.
+type reencryption struct {
+	tx      *sql.Tx
+	dialect database.Dialect
+	keys    *atrest.Keyring
+	// encrypted tells the columns are encrypted, decrypt writes them back in
+	// plaintext
+	encrypted, decrypt bool
+	rewritten          int
+}
+
+// Reencrypt encrypts the EncryptedColumns with the active key of keys, then
+// rewrites with it the values encrypted with another key, or decrypts them
+// all when decrypt is set, to turn the encryption off. keys must hold every
+// key in use. It returns the number of rows rewritten.
+//
+// The audit events, append-only otherwise, are rewritten too so no key
+// outlives a rotation.
+func Reencrypt(ctx context.Context, db *sql.DB, dialect database.Dialect, keys *atrest.Keyring, decrypt bool) (int, error) {
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+
+	encrypted, err := encryptionState(ctx, tx)
+	if err != nil || decrypt && !encrypted {
+		return 0, err
+	}
+	// lets the audit_events_no_update trigger through, until the commit
+	if _, err := tx.ExecContext(ctx, "UPDATE encryption_state SET reencrypting = TRUE"); err != nil {
+		return 0, err
+	}
+
+	r := &reencryption{tx: tx, dialect: dialect, keys: keys, encrypted: encrypted, decrypt: decrypt}
+	if err := r.users(ctx); err != nil {
+		return 0, err
+	}
+	if err := r.avatars(ctx); err != nil {
+		return 0, err
+	}
+	if err := r.rows(ctx, "reports", "id", []string{"reports.reason"}); err != nil {
+		return 0, err
+	}
+	if err := r.rows(ctx, "audit_events", "id", auditColumns); err != nil {
+		return 0, err
+	}
+	if err := r.rows(ctx, "tokens", "token_hash", tokenColumns); err != nil {
+		return 0, err
+	}
+
+	if _, err := tx.ExecContext(ctx, dialect.Rebind("UPDATE encryption_state SET encrypted = ?, reencrypting = FALSE"), !decrypt); err != nil {
+		return 0, err
+	}
+	return r.rewritten, tx.Commit()
+}
+
+// stale reports whether value must be rewritten.
+func (r *reencryption) stale(value string) bool {
+	return value != "" && r.staleKey(atrest.KeyID(value))
+}
+
+// staleBytes is stale for binary values.
+func (r *reencryption) staleBytes(data []byte) bool {
+	return len(data) > 0 && r.staleKey(atrest.KeyIDBytes(data))
+}
+
+// staleKey reports whether a value which is not empty, and is encrypted with
+// keyID once the columns are, must be rewritten.
+func (r *reencryption) staleKey(keyID string) bool {
+	if r.decrypt || !r.encrypted {
+		return true
+	}
+	return keyID != r.keys.ActiveID()
+}
+
+func (r *reencryption) open(column string, value string) (string, error) {
+	if !r.encrypted {
+		return value, nil
+	}
+	return r.keys.Open(column, value)
+}
+
+func (r *reencryption) openBytes(column string, data []byte) ([]byte, error) {
+	if !r.encrypted {
+		return data, nil
+	}
+	return r.keys.OpenBytes(column, data)
+}
+
+func (r *reencryption) seal(column string, value string) (string, error) {
+	if r.decrypt {
+		return value, nil
+	}
+	return r.keys.Seal(column, value)
+}
+
+func (r *reencryption) index(column string, value string) string {
+	if r.decrypt {
+		return value
+	}
+	return r.keys.Index(column, value)
+}
+
+func (r *reencryption) exec(ctx context.Context, query string, args ...any) error {
+	if _, err := r.tx.ExecContext(ctx, r.dialect.Rebind(query), args...); err != nil {
+		return err
+	}
+	r.rewritten++
+	return nil
+}
+
+// users rewrites the users with a stale value, their username keys and
+// prefixes derived again from their username since blind indexes cannot be
+// decrypted. The keys left empty, by deleted users and by the username
+// conflicts of migration 0008, stay empty: deriving them would break their
+// unique index.
+func (r *reencryption) users(ctx context.Context) error {
+	type user struct {
+		id            int
+		key, skeleton string
+		values        []string
+	}
+	// the sealed columns, in the order they are selected after the id and the
+	// username keys
+	columns := []string{"users.username", "users.public_key", "users.display_name", "users.bio", "users.status_text"}
+
+	rows, err := r.tx.QueryContext(ctx, `SELECT id, COALESCE(username_key, ''), COALESCE(username_skeleton, ''),
+		COALESCE(username, ''), COALESCE(public_key, ''), display_name, bio, status_text FROM users`)
+	if err != nil {
+		return err
+	}
+	var users []user
+	for rows.Next() {
+		u := user{values: make([]string, len(columns))}
+		dest := []any{&u.id, &u.key, &u.skeleton}
+		for i := range u.values {
+			dest = append(dest, &u.values[i])
+		}
+		if err := rows.Scan(dest...); err != nil {
+			rows.Close()
+			return err
+		}
+		if slices.ContainsFunc(append([]string{u.key, u.skeleton}, u.values...), r.stale) {
+			users = append(users, u)
+		}
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	var prefixKeys *atrest.Keyring
+	if !r.decrypt {
+		prefixKeys = r.keys
+	}
+	for _, u := range users {
+		args := make([]any, 0, len(columns)+3)
+		var key string
+		for i, value := range u.values {
+			plain, err := r.open(columns[i], value)
+			if err != nil {
+				return fmt.Errorf("user %d: %w", u.id, err)
+			}
+			if i == 0 {
+				var keyIndex, skeletonIndex any
+				if u.key != "" {
+					key = username.Key(plain)
+					keyIndex = r.index("users.username_key", key)
+				}
+				if u.skeleton != "" {
+					skeletonIndex = r.index("users.username_skeleton", username.Skeleton(plain))
+				}
+				args = append(args, keyIndex, skeletonIndex)
+			}
+			sealed, err := r.seal(columns[i], plain)
+			if err != nil {
+				return err
+			}
+			args = append(args, sealed)
+		}
+		err := r.exec(ctx, `UPDATE users SET username_key = ?, username_skeleton = ?, username = NULLIF(?, ''),
+			public_key = ?, display_name = ?, bio = ?, status_text = ? WHERE id = ?`, append(args, u.id)...)
+		if err == nil {
+			_, err = r.tx.ExecContext(ctx, r.dialect.Rebind("DELETE FROM username_prefixes WHERE user_id = ?"), u.id)
+		}
+		if err == nil && key != "" {
+			err = insertUsernamePrefixes(ctx, r.tx, r.dialect, prefixKeys, u.id, key)
+		}
+		if err != nil {
+			return fmt.Errorf("user %d: %w", u.id, err)
+		}
+	}
+	return nil
+}
+
+// avatars rewrites the stale avatars.
+func (r *reencryption) avatars(ctx context.Context) error {
+	rows, err := r.tx.QueryContext(ctx, "SELECT user_id, data FROM avatars")
+	if err != nil {
+		return err
+	}
+	avatars := make(map[int][]byte)
+	for rows.Next() {
+		var userID int
+		var data []byte
+		if err := rows.Scan(&userID, &data); err != nil {
+			rows.Close()
+			return err
+		}
+		if r.staleBytes(data) {
+			avatars[userID] = data
+		}
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	for userID, data := range avatars {
+		data, err := r.openBytes("avatars.data", data)
+		if err == nil && !r.decrypt {
+			data, err = r.keys.SealBytes("avatars.data", data)
+		}
+		if err == nil {
+			err = r.exec(ctx, "UPDATE avatars SET data = ? WHERE user_id = ?", data, userID)
+		}
+		if err != nil {
+			return fmt.Errorf("avatar of user %d: %w", userID, err)
+		}
+	}
+	return nil
+}
+
+// rows rewrites the rows of table with a stale value in columns, its sealed
+// columns as "table.column", the rows being identified by their key column.
+func (r *reencryption) rows(ctx context.Context, table string, key string, columns []string) error {
+	names := make([]string, len(columns))
+	for i, column := range columns {
+		_, names[i], _ = strings.Cut(column, ".")
+	}
+	rows, err := r.tx.QueryContext(ctx, "SELECT "+key+", "+strings.Join(names, ", ")+" FROM "+table)
+	if err != nil {
+		return err
+	}
+	stale := make(map[string][]sql.NullString)
+	for rows.Next() {
+		var id string
+		values := make([]sql.NullString, len(columns))
+		dest := []any{&id}
+		for i := range values {
+			dest = append(dest, &values[i])
+		}
+		if err := rows.Scan(dest...); err != nil {
+			rows.Close()
+			return err
+		}
+		if slices.ContainsFunc(values, func(value sql.NullString) bool { return r.stale(value.String) }) {
+			stale[id] = values
+		}
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	// the ids are cut short, the token hashes are not meant to be logged
+	for id, values := range stale {
+		args := make([]any, 0, len(values)+1)
+		for i, value := range values {
+			if !value.Valid {
+				args = append(args, nil)
+				continue
+			}
+			plain, err := r.open(columns[i], value.String)
+			if err == nil {
+				value.String, err = r.seal(columns[i], plain)
+			}
+			if err != nil {
+				return fmt.Errorf("%s %.8s: %w", table, id, err)
+			}
+			args = append(args, value.String)
+		}
+		err := r.exec(ctx, "UPDATE "+table+" SET "+strings.Join(names, " = ?, ")+" = ? WHERE "+key+" = ?", append(args, id)...)
+		if err != nil {
+			return fmt.Errorf("%s %.8s: %w", table, id, err)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,269 @@
+package store
+
+import (
+	"context"
+	"database/sql"
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/adrienchanove/alpha-enigma-api/atrest"
+	"github.com/adrienchanove/alpha-enigma-api/database"
+	"github.com/adrienchanove/alpha-enigma-api/username"
+)
+
+// TestReencryptKeepsUsernameConflicts encrypts the baseline database of the
+// database package, whose migration leaves the username key of Alice, taken
+// by alice, and the skeletons of Alice and b0b, taken by alice and bob, empty.
+func TestReencryptKeepsUsernameConflicts(t *testing.T) {
+	content, err := os.ReadFile("../database/testdata/baseline.db")
+	if err != nil {
+		t.Fatal(err)
+	}
+	opts := database.Options{DSN: filepath.Join(t.TempDir(), "baseline.db")}
+	if err := os.WriteFile(opts.DSN, content, 0o600); err != nil {
+		t.Fatal(err)
+	}
+	db, err := database.InitDB(opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	key, err := atrest.GenerateKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+	keys, err := atrest.NewKeyring(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ctx := context.Background()
+	for _, decrypt := range []bool{false, true} {
+		if _, err := Reencrypt(ctx, db, opts.Dialect(), keys, decrypt); err != nil {
+			t.Fatalf("decrypt %v: %v", decrypt, err)
+		}
+		storeKeys := keys
+		if decrypt {
+			storeKeys = nil
+		}
+		users := NewSQL(db, opts.Dialect(), storeKeys).Users
+
+		for name, id := range map[string]int{"alice": 1, "bob": 3, "b0b": 4} {
+			if user, err := users.GetByUsernameKey(ctx, username.Key(name)); err != nil || user.ID != id {
+				t.Errorf("decrypt %v: %s is user %d, %v, want %d", decrypt, name, user.ID, err, id)
+			}
+		}
+		if user, err := users.Get(ctx, 2); err != nil || user.Username != "Alice" {
+			t.Errorf("decrypt %v: user 2 %+v, %v", decrypt, user, err)
+		}
+		var emptyKeys, emptySkeletons int
+		err := db.QueryRow("SELECT COUNT(*) - COUNT(username_key), COUNT(*) - COUNT(username_skeleton) FROM users").Scan(&emptyKeys, &emptySkeletons)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if emptyKeys != 1 || emptySkeletons != 2 {
+			t.Errorf("decrypt %v: %d empty username keys and %d skeletons, want 1 and 2", decrypt, emptyKeys, emptySkeletons)
+		}
+	}
+}
+
+// initTestDB returns the migrated database of opts, closed at the end of the
+// test.
+func initTestDB(t *testing.T, opts database.Options) *sql.DB {
+	t.Helper()
+	db, err := database.InitDB(opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { db.Close() })
+	return db
+}
+
+func newTestKeyring(t *testing.T, keys ...atrest.Key) *atrest.Keyring {
+	t.Helper()
+	keyring, err := atrest.NewKeyring(keys...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return keyring
+}
+
+func generateTestKey(t *testing.T) atrest.Key {
+	t.Helper()
+	key, err := atrest.GenerateKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return key
+}
+
+// TestEncryptionKeepsValuesShapedLikeSealedOnes stores values shaped like
+// sealed ones, which are plaintext values all the same, before and once the
+// database is encrypted.
+func TestEncryptionKeepsValuesShapedLikeSealedOnes(t *testing.T) {
+	ctx := context.Background()
+	opts := testSQLite(t)
+	db := initTestDB(t, opts)
+	key := generateTestKey(t)
+	keys := newTestKeyring(t, key)
+	forged := "enc:" + key.ID + ":AAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAA"
+
+	stores := NewSQL(db, opts.Dialect(), nil)
+	alice := createUsers(t, stores.Users, "alice")[0]
+	if err := stores.Users.UpdateProfile(ctx, alice, ProfileUpdate{Bio: &forged}); err != nil {
+		t.Fatal(err)
+	}
+	if err := stores.Audit.Append(ctx, AuditEvent{Event: "test", UserID: alice, Details: "enc:x:y", CreatedAt: time.Now()}); err != nil {
+		t.Fatal(err)
+	}
+	if usage, err := KeyUsage(ctx, db); err != nil || usage[key.ID] != 0 {
+		t.Errorf("usage %v, %v before the encryption, want plaintext values only", usage, err)
+	}
+
+	check := func(stores *Stores, bio string) {
+		t.Helper()
+		if user, err := stores.Users.Get(ctx, alice); err != nil || user.Bio != bio {
+			t.Errorf("bio %q, %v, want %q", user.Bio, err, bio)
+		}
+		if events, err := stores.Audit.List(ctx, AuditFilter{Limit: 1}); err != nil || len(events) != 1 || events[0].Details != "enc:x:y" {
+			t.Errorf("audit events %+v, %v", events, err)
+		}
+	}
+	if _, err := Reencrypt(ctx, db, opts.Dialect(), keys, false); err != nil {
+		t.Fatal(err)
+	}
+	stores = NewSQL(db, opts.Dialect(), keys)
+	check(stores, forged)
+
+	forged = "enc:" + key.ID + ":BBBBBBBBBBBBBBBBBBBBBBBBBBBBBBBBBBBBBBBB"
+	if err := stores.Users.UpdateProfile(ctx, alice, ProfileUpdate{Bio: &forged}); err != nil {
+		t.Fatal(err)
+	}
+	check(stores, forged)
+
+	if _, err := Reencrypt(ctx, db, opts.Dialect(), keys, true); err != nil {
+		t.Fatal(err)
+	}
+	check(NewSQL(db, opts.Dialect(), nil), forged)
+}
+
+// TestKeyRetirement rotates the key of an encrypted database, then reads it
+// with the new key only.
+func TestKeyRetirement(t *testing.T) {
+	ctx := context.Background()
+	opts := testSQLite(t)
+	db := initTestDB(t, opts)
+	oldKey, newKey := generateTestKey(t), generateTestKey(t)
+	if _, err := Reencrypt(ctx, db, opts.Dialect(), newTestKeyring(t, oldKey), false); err != nil {
+		t.Fatal(err)
+	}
+
+	stores := NewSQL(db, opts.Dialect(), newTestKeyring(t, oldKey))
+	ids := createUsers(t, stores.Users, "alice", "albert", "bob")
+	alice, bob := ids[0], ids[2]
+	now := time.Now()
+	bio := "hello"
+	if err := stores.Users.UpdateProfile(ctx, alice, ProfileUpdate{Bio: &bio}); err != nil {
+		t.Fatal(err)
+	}
+	if err := stores.Users.SetAvatar(ctx, alice, Avatar{ContentType: "image/png", Data: []byte("png"), UpdatedAt: now}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := stores.Reports.Create(ctx, Report{ReporterID: alice, ReportedID: bob, Reason: "spam", CreatedAt: now}); err != nil {
+		t.Fatal(err)
+	}
+	if err := stores.Audit.Append(ctx, AuditEvent{Event: "test", UserID: alice, IP: "192.0.2.1", UserAgent: "client", Details: "details", CreatedAt: now}); err != nil {
+		t.Fatal(err)
+	}
+	err := stores.Tokens.Save(ctx, Token{Token: "token", SessionID: "s1", UserID: alice, Username: "alice", IssuedAt: now, ExpiresAt: now.Add(time.Hour)})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := Reencrypt(ctx, db, opts.Dialect(), newTestKeyring(t, newKey, oldKey), false); err != nil {
+		t.Fatal(err)
+	}
+	usage, err := KeyUsage(ctx, db)
+	if err != nil || len(usage) != 1 || usage[newKey.ID] == 0 {
+		t.Fatalf("usage %v, %v, want the new key only", usage, err)
+	}
+
+	stores = NewSQL(db, opts.Dialect(), newTestKeyring(t, newKey))
+	if user, err := stores.Users.GetByUsernameKey(ctx, username.Key("alice")); err != nil || user.ID != alice || user.Bio != "hello" {
+		t.Errorf("alice %+v, %v", user, err)
+	}
+	if avatar, err := stores.Users.GetAvatar(ctx, alice); err != nil || string(avatar.Data) != "png" {
+		t.Errorf("avatar %q, %v", avatar.Data, err)
+	}
+	if page, err := stores.Users.List(ctx, ListUsersOptions{Prefix: username.Key("al"), Limit: 10}); err != nil || len(page) != 2 {
+		t.Errorf("users starting with al: %v, %v", usernames(page), err)
+	}
+	if reports, err := stores.Reports.List(ctx, ReportsOpen); err != nil || len(reports) != 1 || reports[0].Reason != "spam" {
+		t.Errorf("reports %+v, %v", reports, err)
+	}
+	events, err := stores.Audit.List(ctx, AuditFilter{Limit: 1})
+	if err != nil || len(events) != 1 || events[0].IP != "192.0.2.1" || events[0].UserAgent != "client" || events[0].Details != "details" {
+		t.Errorf("audit events %+v, %v", events, err)
+	}
+	if token, err := stores.Tokens.Get(ctx, "token"); err != nil || token.Username != "alice" {
+		t.Errorf("token %+v, %v", token, err)
+	}
+
+	// the audit events are append-only again
+	if _, err := db.Exec("UPDATE audit_events SET details = ''"); err == nil {
+		t.Error("audit events updated after the re-encryption")
+	}
+}
+
+// TestEncryptedDirectory pages through the directory and searches it once
+// the usernames are encrypted.
+func TestEncryptedDirectory(t *testing.T) {
+	ctx := context.Background()
+	opts := testSQLite(t)
+	db := initTestDB(t, opts)
+	keys := newTestKeyring(t, generateTestKey(t))
+	if _, err := Reencrypt(ctx, db, opts.Dialect(), keys, false); err != nil {
+		t.Fatal(err)
+	}
+	users := NewSQL(db, opts.Dialect(), keys).Users
+	names := []string{"carol", "Alice", "bob", "albert", "Straße"}
+	ids := createUsers(t, users, names...)
+
+	var listed []string
+	list := ListUsersOptions{Limit: 2}
+	for {
+		page, err := users.List(ctx, list)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(page) == 0 {
+			break
+		}
+		listed = append(listed, usernames(page)...)
+		list.AfterID = page[len(page)-1].ID
+	}
+	slices.Sort(listed)
+	if want := slices.Sorted(slices.Values(names)); !slices.Equal(listed, want) {
+		t.Errorf("directory %v, want every user once", listed)
+	}
+
+	for prefix, want := range map[string][]string{"AL": {"Alice", "albert"}, "alice": {"Alice"}, "STRASS": {"Straße"}, "x": nil} {
+		page, err := users.List(ctx, ListUsersOptions{Prefix: username.Key(prefix), Limit: 10})
+		got := usernames(page)
+		slices.Sort(got)
+		if err != nil || !slices.Equal(got, want) {
+			t.Errorf("users starting with %s: %v, %v, want %v", prefix, got, err, want)
+		}
+	}
+
+	if err := users.Delete(ctx, ids[1], DeletedMessagesKeep, time.Now()); err != nil {
+		t.Fatal(err)
+	}
+	var prefixes int
+	if err := db.QueryRow("SELECT COUNT(*) FROM username_prefixes WHERE user_id = ?", ids[1]).Scan(&prefixes); err != nil || prefixes != 0 {
+		t.Errorf("%d prefixes of a deleted user, %v", prefixes, err)
+	}
+}
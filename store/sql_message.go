@@ -0,0 +1,88 @@
+package store
+
+import (
+	"context"
+	"database/sql"
+)
+
+type sqlMessages struct {
+	db sqlDB
+}
+
+const messageColumns = "id, COALESCE(content, ''), sender_id, receiver_id, deleted_at"
+
+func scanMessage(row rowScanner) (Message, error) {
+	var m Message
+	var deletedAt sql.NullTime
+	if err := row.Scan(&m.ID, &m.Content, &m.SenderID, &m.ReceiverID, &deletedAt); err != nil {
+		return Message{}, err
+	}
+	m.DeletedAt = nullTime(deletedAt)
+	return m, nil
+}
+
+func (s *sqlMessages) queryMessages(ctx context.Context, query string, args ...any) ([]Message, error) {
+	rows, err := s.db.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var messages []Message
+	for rows.Next() {
+		m, err := scanMessage(rows)
+		if err != nil {
+			return nil, err
+		}
+		messages = append(messages, m)
+	}
+	return messages, rows.Err()
+}
+
+func (s *sqlMessages) Create(ctx context.Context, m Message) (int, error) {
+	return insert(ctx, s.db, "INSERT INTO messages (content, sender_id, receiver_id) VALUES (?, ?, ?)", m.Content, m.SenderID, m.ReceiverID)
+}
+
+func (s *sqlMessages) Get(ctx context.Context, id int) (Message, error) {
+	m, err := scanMessage(s.db.QueryRowContext(ctx, "SELECT "+messageColumns+" FROM messages WHERE id = ?", id))
+	return m, notFound(err)
+}
+
+func (s *sqlMessages) ListForUser(ctx context.Context, userID int) ([]Message, error) {
+	return s.queryMessages(ctx, "SELECT "+messageColumns+" FROM messages WHERE sender_id = ? OR receiver_id = ? ORDER BY id", userID, userID)
+}
+
+func (s *sqlMessages) ListBetween(ctx context.Context, userID int, otherID int) ([]Message, error) {
+	return s.queryMessages(ctx, "SELECT "+messageColumns+" FROM messages WHERE (sender_id = ? AND receiver_id = ?) OR (sender_id = ? AND receiver_id = ?) ORDER BY id",
+		userID, otherID, otherID, userID)
+}
+
+func (s *sqlMessages) Peers(ctx context.Context, userID int) ([]int, error) {
+	rows, err := s.db.QueryContext(ctx, `SELECT CASE WHEN sender_id = ? THEN receiver_id ELSE sender_id END AS peer FROM messages
+		WHERE sender_id = ? OR receiver_id = ?
+		GROUP BY peer ORDER BY MIN(id)`, userID, userID, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var peers []int
+	for rows.Next() {
+		var peer int
+		if err := rows.Scan(&peer); err != nil {
+			return nil, err
+		}
+		peers = append(peers, peer)
+	}
+	return peers, rows.Err()
+}
+
+func (s *sqlMessages) HasSent(ctx context.Context, senderID int, receiverID int) (bool, error) {
+	return exists(ctx, s.db, "SELECT EXISTS(SELECT 1 FROM messages WHERE sender_id = ? AND receiver_id = ?)", senderID, receiverID)
+}
+
+func (s *sqlMessages) Count(ctx context.Context) (int, error) {
+	var count int
+	err := s.db.QueryRowContext(ctx, "SELECT COUNT(*) FROM messages WHERE deleted_at IS NULL").Scan(&count)
+	return count, err
+}
@@ -0,0 +1,109 @@
+package store
+
+import (
+	"context"
+	"database/sql"
+	"flag"
+	"math/rand"
+	"strconv"
+	"testing"
+
+	"github.com/adrienchanove/alpha-enigma-api/database"
+)
+
+// The size of the database seeded by BenchmarkMessageQueries, e.g. for a
+// million messages without the message indexes:
+//
+//	go test ./store -run '^$' -bench MessageQueries -args -bench-messages 1000000 -bench-drop-indexes
+var (
+	benchUsers        = flag.Int("bench-users", 1000, "number of users seeded by the message benchmarks")
+	benchMessages     = flag.Int("bench-messages", 100000, "number of messages seeded by the message benchmarks")
+	benchConversation = flag.Int("bench-conversation", 10000, "number of the messages exchanged by the two users whose conversation the message benchmarks read")
+	benchDropIndexes  = flag.Bool("bench-drop-indexes", false, "drop the message indexes before running the message benchmarks")
+)
+
+// BenchmarkMessageQueries times the conversation queries on a SQLite
+// database seeded with -bench-messages messages.
+func BenchmarkMessageQueries(b *testing.B) {
+	if *benchUsers < 5 || *benchMessages < *benchConversation {
+		b.Fatal("at least 5 users and as many messages as the conversation are needed")
+	}
+	opts := testSQLite(b)
+	db, err := database.InitDB(opts)
+	if err != nil {
+		b.Fatal(err)
+	}
+	defer db.Close()
+	if err := seedMessages(db, opts.Dialect(), *benchUsers, *benchMessages, *benchConversation); err != nil {
+		b.Fatal(err)
+	}
+	if *benchDropIndexes {
+		for _, index := range []string{"idx_messages_sender_receiver", "idx_messages_receiver_sender"} {
+			if _, err := db.Exec("DROP INDEX " + index); err != nil {
+				b.Fatal(err)
+			}
+		}
+	}
+
+	// users 1 and 2 have the long conversation, user 3 only random messages
+	ctx := context.Background()
+	messages := NewSQL(db, opts.Dialect(), nil).Messages
+	for _, query := range []struct {
+		name string
+		run  func() error
+	}{
+		{"ListBetween/conversation", func() error { _, err := messages.ListBetween(ctx, 1, 2); return err }},
+		{"ListBetween/random", func() error { _, err := messages.ListBetween(ctx, 3, 4); return err }},
+		{"ListForUser/conversation", func() error { _, err := messages.ListForUser(ctx, 1); return err }},
+		{"ListForUser/random", func() error { _, err := messages.ListForUser(ctx, 3); return err }},
+		{"Peers/conversation", func() error { _, err := messages.Peers(ctx, 1); return err }},
+		{"Peers/random", func() error { _, err := messages.Peers(ctx, 3); return err }},
+		{"HasSent", func() error { _, err := messages.HasSent(ctx, 3, 4); return err }},
+	} {
+		b.Run(query.name, func(b *testing.B) {
+			for i := 0; i < b.N; i++ {
+				if err := query.run(); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
+
+// seedMessages inserts the users, then the messages: conversation of them
+// exchanged by users 1 and 2, the others between random users.
+func seedMessages(db *sql.DB, dialect database.Dialect, users int, messages int, conversation int) error {
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	insertUser, err := tx.Prepare(dialect.Rebind("INSERT INTO users (username, username_key, username_skeleton) VALUES (?, ?, ?)"))
+	if err != nil {
+		return err
+	}
+	for i := 1; i <= users; i++ {
+		name := "user" + strconv.Itoa(i)
+		if _, err := insertUser.Exec(name, name, name); err != nil {
+			return err
+		}
+	}
+
+	insertMessage, err := tx.Prepare(dialect.Rebind("INSERT INTO messages (content, sender_id, receiver_id) VALUES (?, ?, ?)"))
+	if err != nil {
+		return err
+	}
+	random := rand.New(rand.NewSource(1))
+	for i := 0; i < messages; i++ {
+		sender, receiver := 1+i%2, 2-i%2
+		if i >= conversation {
+			sender = 1 + random.Intn(users)
+			receiver = 1 + (sender+random.Intn(users-1))%users
+		}
+		if _, err := insertMessage.Exec("bench", sender, receiver); err != nil {
+			return err
+		}
+	}
+	return tx.Commit()
+}
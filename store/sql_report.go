@@ -0,0 +1,70 @@
+package store
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"time"
+)
+
+type sqlReports struct {
+	db sqlDB
+}
+
+func (s *sqlReports) Create(ctx context.Context, r Report) (int, error) {
+	reason, err := s.db.seal("reports.reason", r.Reason)
+	if err != nil {
+		return 0, err
+	}
+	return insert(ctx, s.db, "INSERT INTO reports (reporter_id, reported_id, message_id, reason, created_at) VALUES (?, ?, ?, ?, ?)",
+		r.ReporterID, r.ReportedID, r.MessageID, reason, r.CreatedAt)
+}
+
+func (s *sqlReports) List(ctx context.Context, status string) ([]Report, error) {
+	query := "SELECT id, reporter_id, reported_id, message_id, reason, created_at, resolved_at FROM reports"
+	switch status {
+	case ReportsOpen:
+		query += " WHERE resolved_at IS NULL"
+	case ReportsResolved:
+		query += " WHERE resolved_at IS NOT NULL"
+	case ReportsAll:
+	default:
+		return nil, fmt.Errorf("invalid report status %q", status)
+	}
+
+	rows, err := s.db.QueryContext(ctx, query+" ORDER BY id")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var reports []Report
+	for rows.Next() {
+		var r Report
+		var messageID sql.NullInt64
+		var resolvedAt sql.NullTime
+		if err := rows.Scan(&r.ID, &r.ReporterID, &r.ReportedID, &messageID, &r.Reason, &r.CreatedAt, &resolvedAt); err != nil {
+			return nil, err
+		}
+		if err := s.db.open("reports.reason", &r.Reason); err != nil {
+			return nil, err
+		}
+		if messageID.Valid {
+			id := int(messageID.Int64)
+			r.MessageID = &id
+		}
+		r.ResolvedAt = nullTime(resolvedAt)
+		reports = append(reports, r)
+	}
+	return reports, rows.Err()
+}
+
+func (s *sqlReports) Resolve(ctx context.Context, id int, at time.Time) error {
+	return execOne(ctx, s.db, "UPDATE reports SET resolved_at = COALESCE(resolved_at, ?) WHERE id = ?", at, id)
+}
+
+func (s *sqlReports) CountOpen(ctx context.Context) (int, error) {
+	var count int
+	err := s.db.QueryRowContext(ctx, "SELECT COUNT(*) FROM reports WHERE resolved_at IS NULL").Scan(&count)
+	return count, err
+}
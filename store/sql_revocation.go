@@ -0,0 +1,60 @@
+package store
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"time"
+)
+
+type sqlRevocations struct {
+	db sqlDB
+}
+
+func (s *sqlRevocations) RevokeToken(ctx context.Context, id string, expiresAt time.Time) error {
+	if err := s.prune(ctx); err != nil {
+		return err
+	}
+	_, err := s.db.ExecContext(ctx, "INSERT INTO revoked_tokens (token_id, expires_at) VALUES (?, ?) ON CONFLICT (token_id) DO NOTHING",
+		id, expiresAt)
+	return err
+}
+
+func (s *sqlRevocations) RevokeUser(ctx context.Context, userID int, before time.Time, except string, expiresAt time.Time) error {
+	if err := s.prune(ctx); err != nil {
+		return err
+	}
+	_, err := s.db.ExecContext(ctx, `INSERT INTO revoked_user_tokens (user_id, revoked_before, except_token_id, expires_at) VALUES (?, ?, ?, ?)
+		ON CONFLICT (user_id) DO UPDATE SET revoked_before = excluded.revoked_before, except_token_id = excluded.except_token_id, expires_at = excluded.expires_at`,
+		userID, before, except, expiresAt)
+	return err
+}
+
+func (s *sqlRevocations) IsRevoked(ctx context.Context, id string, userID int, issuedAt time.Time) (bool, error) {
+	revoked, err := exists(ctx, s.db, "SELECT EXISTS(SELECT 1 FROM revoked_tokens WHERE token_id = ?)", id)
+	if err != nil || revoked {
+		return revoked, err
+	}
+
+	// compared here rather than in SQL, SQLite compares the times as text
+	var before time.Time
+	var except string
+	err = s.db.QueryRowContext(ctx, "SELECT revoked_before, except_token_id FROM revoked_user_tokens WHERE user_id = ?", userID).Scan(&before, &except)
+	if errors.Is(err, sql.ErrNoRows) {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	return id != except && !issuedAt.After(before), nil
+}
+
+// prune removes the revocations whose tokens have all expired.
+func (s *sqlRevocations) prune(ctx context.Context) error {
+	now := time.Now()
+	if _, err := s.db.ExecContext(ctx, "DELETE FROM revoked_tokens WHERE expires_at < ?", now); err != nil {
+		return err
+	}
+	_, err := s.db.ExecContext(ctx, "DELETE FROM revoked_user_tokens WHERE expires_at < ?", now)
+	return err
+}
@@ -0,0 +1,137 @@
+package store
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"strings"
+	"time"
+)
+
+// sqlTokens keeps the SHA-256 hash of the tokens, not the tokens: the tokens
+// returned by UserTokens have no Token.
+type sqlTokens struct {
+	db sqlDB
+}
+
+// tokenColumns are the sealed columns of the tokens table.
+var tokenColumns = []string{"tokens.username", "tokens.device", "tokens.client_ip", "tokens.user_agent"}
+
+func hashToken(token string) string {
+	hash := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(hash[:])
+}
+
+func (s *sqlTokens) Save(ctx context.Context, token Token) error {
+	values := []string{token.Username, token.Device, token.ClientIP, token.UserAgent}
+	for i, column := range tokenColumns {
+		var err error
+		if values[i], err = s.db.seal(column, values[i]); err != nil {
+			return err
+		}
+	}
+	_, err := s.db.ExecContext(ctx, `INSERT INTO tokens (token_hash, session_id, user_id, username, device, scopes, client_ip, user_agent, issued_at, expires_at)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`,
+		hashToken(token.Token), token.SessionID, token.UserID, values[0], values[1], strings.Join(token.Scopes, " "), values[2], values[3],
+		token.IssuedAt, token.ExpiresAt)
+	return err
+}
+
+const selectToken = "SELECT session_id, user_id, username, device, scopes, client_ip, user_agent, issued_at, expires_at FROM tokens"
+
+func (s *sqlTokens) scan(row rowScanner) (Token, error) {
+	var t Token
+	var scopes string
+	if err := row.Scan(&t.SessionID, &t.UserID, &t.Username, &t.Device, &scopes, &t.ClientIP, &t.UserAgent, &t.IssuedAt, &t.ExpiresAt); err != nil {
+		return Token{}, err
+	}
+	t.Scopes = strings.Fields(scopes)
+	for i, value := range []*string{&t.Username, &t.Device, &t.ClientIP, &t.UserAgent} {
+		if err := s.db.open(tokenColumns[i], value); err != nil {
+			return Token{}, err
+		}
+	}
+	return t, nil
+}
+
+func (s *sqlTokens) Get(ctx context.Context, token string) (Token, error) {
+	t, err := s.scan(s.db.QueryRowContext(ctx, selectToken+" WHERE token_hash = ?", hashToken(token)))
+	if err != nil {
+		return Token{}, notFound(err)
+	}
+	// compared here rather than in SQL, SQLite compares the times as text
+	if !time.Now().Before(t.ExpiresAt) {
+		return Token{}, ErrNotFound
+	}
+	t.Token = token
+	return t, nil
+}
+
+func (s *sqlTokens) Delete(ctx context.Context, token string) error {
+	_, err := s.db.ExecContext(ctx, "DELETE FROM tokens WHERE token_hash = ?", hashToken(token))
+	return err
+}
+
+func (s *sqlTokens) DeleteSession(ctx context.Context, userID int, sessionID string) error {
+	return execOne(ctx, s.db, "DELETE FROM tokens WHERE user_id = ? AND session_id = ?", userID, sessionID)
+}
+
+func (s *sqlTokens) DeleteUserTokens(ctx context.Context, userID int, keep string) (int, error) {
+	result, err := s.db.ExecContext(ctx, "DELETE FROM tokens WHERE user_id = ? AND token_hash <> ?", userID, hashToken(keep))
+	if err != nil {
+		return 0, err
+	}
+	deleted, err := result.RowsAffected()
+	return int(deleted), err
+}
+
+func (s *sqlTokens) UserTokens(ctx context.Context, userID int) ([]Token, error) {
+	rows, err := s.db.QueryContext(ctx, selectToken+" WHERE user_id = ? AND expires_at > ? ORDER BY issued_at", userID, time.Now())
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var tokens []Token
+	for rows.Next() {
+		t, err := s.scan(rows)
+		if err != nil {
+			return nil, err
+		}
+		tokens = append(tokens, t)
+	}
+	return tokens, rows.Err()
+}
+
+func (s *sqlTokens) Count(ctx context.Context) (int, error) {
+	var count int
+	err := s.db.QueryRowContext(ctx, "SELECT COUNT(*) FROM tokens WHERE expires_at > ?", time.Now()).Scan(&count)
+	return count, err
+}
+
+func (s *sqlTokens) CountByUser(ctx context.Context) (map[int]int, error) {
+	rows, err := s.db.QueryContext(ctx, "SELECT user_id, COUNT(*) FROM tokens WHERE expires_at > ? GROUP BY user_id", time.Now())
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	counts := make(map[int]int)
+	for rows.Next() {
+		var userID, count int
+		if err := rows.Scan(&userID, &count); err != nil {
+			return nil, err
+		}
+		counts[userID] = count
+	}
+	return counts, rows.Err()
+}
+
+func (s *sqlTokens) DeleteExpired(ctx context.Context) (int, error) {
+	result, err := s.db.ExecContext(ctx, "DELETE FROM tokens WHERE expires_at <= ?", time.Now())
+	if err != nil {
+		return 0, err
+	}
+	deleted, err := result.RowsAffected()
+	return int(deleted), err
+}
@@ -0,0 +1,358 @@
+package store
+
+import (
+	"context"
+	"database/sql"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"github.com/adrienchanove/alpha-enigma-api/atrest"
+	"github.com/adrienchanove/alpha-enigma-api/database"
+)
+
+type sqlUsers struct {
+	db sqlDB
+}
+
+// userColumns are the users columns scanned by scanUser.
+const userColumns = `users.id, COALESCE(users.username, ''), COALESCE(users.public_key, ''), users.role,
+	users.display_name, users.bio, users.status_text, users.last_seen_at, users.show_last_seen,
+	EXISTS(SELECT 1 FROM avatars WHERE avatars.user_id = users.id),
+	users.message_policy, users.hidden_from_directory, users.suspended_at, users.deleted_at`
+
+// scanUser scans a row selecting userColumns, followed by the columns scanned
+// into extra, decrypting the user.
+func (db sqlDB) scanUser(row rowScanner, extra ...any) (User, error) {
+	var u User
+	var lastSeenAt, suspendedAt, deletedAt sql.NullTime
+	dest := []any{&u.ID, &u.Username, &u.PublicKey, &u.Role,
+		&u.DisplayName, &u.Bio, &u.StatusText, &lastSeenAt, &u.ShowLastSeen,
+		&u.HasAvatar,
+		&u.MessagePolicy, &u.HiddenFromDirectory, &suspendedAt, &deletedAt}
+	if err := row.Scan(append(dest, extra...)...); err != nil {
+		return User{}, err
+	}
+	u.LastSeenAt = nullTime(lastSeenAt)
+	u.SuspendedAt = nullTime(suspendedAt)
+	u.DeletedAt = nullTime(deletedAt)
+
+	for column, value := range map[string]*string{
+		"users.username":     &u.Username,
+		"users.public_key":   &u.PublicKey,
+		"users.display_name": &u.DisplayName,
+		"users.bio":          &u.Bio,
+		"users.status_text":  &u.StatusText,
+	} {
+		if err := db.open(column, value); err != nil {
+			return User{}, err
+		}
+	}
+	return u, nil
+}
+
+func (s *sqlUsers) queryUsers(ctx context.Context, query string, args ...any) ([]User, error) {
+	rows, err := s.db.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var users []User
+	for rows.Next() {
+		u, err := s.db.scanUser(rows)
+		if err != nil {
+			return nil, err
+		}
+		users = append(users, u)
+	}
+	return users, rows.Err()
+}
+
+func (s *sqlUsers) Create(ctx context.Context, u NewUser) (int, error) {
+	name, err := s.db.seal("users.username", u.Username)
+	if err != nil {
+		return 0, err
+	}
+	publicKey, err := s.db.seal("users.public_key", u.PublicKey)
+	if err != nil {
+		return 0, err
+	}
+
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+
+	var id int
+	err = tx.QueryRowContext(ctx, s.db.dialect.Rebind("INSERT INTO users (username, username_key, username_skeleton, public_key) VALUES (?, ?, ?, ?) RETURNING id"),
+		name, s.db.index("users.username_key", u.UsernameKey), s.db.index("users.username_skeleton", u.UsernameSkeleton), publicKey).Scan(&id)
+	if database.IsUniqueViolation(err) {
+		return 0, ErrConflict
+	}
+	if err != nil {
+		return 0, err
+	}
+	if err := insertUsernamePrefixes(ctx, tx, s.db.dialect, s.db.keys, id, u.UsernameKey); err != nil {
+		return 0, err
+	}
+	return id, tx.Commit()
+}
+
+// usernamePrefixes returns the prefixes of the username key, of one rune and
+// more.
+func usernamePrefixes(key string) []string {
+	var prefixes []string
+	for i := range key {
+		if i > 0 {
+			prefixes = append(prefixes, key[:i])
+		}
+	}
+	if key != "" {
+		prefixes = append(prefixes, key)
+	}
+	return prefixes
+}
+
+// insertUsernamePrefixes indexes the prefixes of the username key of the user
+// in username_prefixes, to search the encrypted keys. Nothing is indexed when
+// keys is nil: the plaintext keys are searched as a range.
+func insertUsernamePrefixes(ctx context.Context, tx *sql.Tx, dialect database.Dialect, keys *atrest.Keyring, id int, key string) error {
+	prefixes := usernamePrefixes(key)
+	if keys == nil || len(prefixes) == 0 {
+		return nil
+	}
+	args := make([]any, 0, 2*len(prefixes))
+	for _, prefix := range prefixes {
+		args = append(args, id, keys.Index("username_prefixes.prefix_index", prefix))
+	}
+	_, err := tx.ExecContext(ctx, dialect.Rebind("INSERT INTO username_prefixes (user_id, prefix_index) VALUES (?, ?)"+strings.Repeat(", (?, ?)", len(prefixes)-1)), args...)
+	return err
+}
+
+func (s *sqlUsers) UsernameTaken(ctx context.Context, key string, skeleton string) (bool, bool, error) {
+	keyMatch, keyArgs := s.db.lookup("users.username_key", key)
+	skeletonMatch, skeletonArgs := s.db.lookup("users.username_skeleton", skeleton)
+	var taken, lookalike bool
+	err := s.db.QueryRowContext(ctx, "SELECT EXISTS(SELECT 1 FROM users WHERE "+keyMatch+"), EXISTS(SELECT 1 FROM users WHERE "+skeletonMatch+")",
+		append(keyArgs, skeletonArgs...)...).Scan(&taken, &lookalike)
+	return taken, lookalike, err
+}
+
+func (s *sqlUsers) Get(ctx context.Context, id int) (User, error) {
+	u, err := s.db.scanUser(s.db.QueryRowContext(ctx, "SELECT "+userColumns+" FROM users WHERE id = ?", id))
+	return u, notFound(err)
+}
+
+func (s *sqlUsers) GetByUsernameKey(ctx context.Context, key string) (User, error) {
+	match, args := s.db.lookup("users.username_key", key)
+	u, err := s.db.scanUser(s.db.QueryRowContext(ctx, "SELECT "+userColumns+" FROM users WHERE "+match, args...))
+	return u, notFound(err)
+}
+
+// prefixUpperBound returns the smallest string greater than every string
+// starting with prefix, to turn a prefix search into an indexed range.
+func prefixUpperBound(prefix string) string {
+	return prefix + string(utf8.MaxRune)
+}
+
+func (s *sqlUsers) List(ctx context.Context, opts ListUsersOptions) ([]User, error) {
+	key := s.db.dialect.Binary("username_key")
+	query := `SELECT ` + userColumns + ` FROM users
+		WHERE (NOT hidden_from_directory OR id = ?) AND deleted_at IS NULL AND username_key IS NOT NULL
+		AND id NOT IN (SELECT blocked_id FROM blocks WHERE blocker_id = ? UNION SELECT blocker_id FROM blocks WHERE blocked_id = ?)`
+	args := []any{opts.ViewerID, opts.ViewerID, opts.ViewerID}
+
+	switch {
+	case opts.Prefix == "":
+	case s.db.keys == nil:
+		query += " AND " + key + " >= ? AND " + key + " < ?"
+		args = append(args, opts.Prefix, prefixUpperBound(opts.Prefix))
+	default:
+		match, matchArgs := s.db.lookup("username_prefixes.prefix_index", opts.Prefix)
+		query += " AND id IN (SELECT user_id FROM username_prefixes WHERE " + match + ")"
+		args = append(args, matchArgs...)
+	}
+	if opts.AfterID != 0 {
+		after := "(SELECT " + key + " FROM users WHERE id = ?)"
+		query += " AND (" + key + " > " + after + " OR (" + key + " = " + after + " AND id > ?))"
+		args = append(args, opts.AfterID, opts.AfterID, opts.AfterID)
+	}
+
+	query += " ORDER BY " + key + ", id LIMIT ?"
+	args = append(args, opts.Limit)
+
+	return s.queryUsers(ctx, query, args...)
+}
+
+func (s *sqlUsers) ListAccounts(ctx context.Context) ([]User, error) {
+	return s.queryUsers(ctx, "SELECT "+userColumns+" FROM users WHERE deleted_at IS NULL ORDER BY id")
+}
+
+func (s *sqlUsers) Count(ctx context.Context) (UserCounts, error) {
+	var counts UserCounts
+	err := s.db.QueryRowContext(ctx, `SELECT
+		COUNT(*),
+		COUNT(CASE WHEN role = 'admin' THEN 1 END),
+		COUNT(suspended_at)
+		FROM users WHERE deleted_at IS NULL`).Scan(&counts.Users, &counts.Admins, &counts.Suspended)
+	return counts, err
+}
+
+func (s *sqlUsers) SetRole(ctx context.Context, id int, role string) error {
+	return execOne(ctx, s.db, "UPDATE users SET role = ? WHERE id = ?", role, id)
+}
+
+func (s *sqlUsers) Suspend(ctx context.Context, id int, at time.Time) error {
+	return execOne(ctx, s.db, "UPDATE users SET suspended_at = COALESCE(suspended_at, ?) WHERE id = ?", at, id)
+}
+
+func (s *sqlUsers) Unsuspend(ctx context.Context, id int) error {
+	return execOne(ctx, s.db, "UPDATE users SET suspended_at = NULL WHERE id = ?", id)
+}
+
+func (s *sqlUsers) Delete(ctx context.Context, id int, messagesPolicy string, at time.Time) error {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	rebind := s.db.dialect.Rebind
+	result, err := tx.ExecContext(ctx, rebind(`UPDATE users SET
+		username = NULL, username_key = NULL, username_skeleton = NULL, public_key = '',
+		role = 'user', display_name = '', bio = '', status_text = '', last_seen_at = NULL,
+		hidden_from_directory = TRUE, deleted_at = ?
+		WHERE id = ? AND deleted_at IS NULL`), at.UTC(), id)
+	if err != nil {
+		return err
+	}
+	deleted, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if deleted == 0 {
+		return ErrNotFound
+	}
+
+	for _, query := range []string{
+		"DELETE FROM avatars WHERE user_id = ?",
+		"DELETE FROM username_prefixes WHERE user_id = ?",
+		"DELETE FROM blocks WHERE blocker_id = ? OR blocked_id = ?",
+		"DELETE FROM contacts WHERE requester_id = ? OR addressee_id = ?",
+	} {
+		if _, err := tx.ExecContext(ctx, rebind(query), id, id); err != nil {
+			return err
+		}
+	}
+
+	switch messagesPolicy {
+	case DeletedMessagesTombstone:
+		_, err = tx.ExecContext(ctx, rebind("UPDATE messages SET content = '', deleted_at = ? WHERE sender_id = ? OR receiver_id = ?"), at.UTC(), id, id)
+	case DeletedMessagesPurge:
+		_, err = tx.ExecContext(ctx, rebind("UPDATE reports SET message_id = NULL WHERE message_id IN (SELECT id FROM messages WHERE sender_id = ? OR receiver_id = ?)"), id, id)
+		if err == nil {
+			_, err = tx.ExecContext(ctx, rebind("DELETE FROM messages WHERE sender_id = ? OR receiver_id = ?"), id, id)
+		}
+	}
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+func (s *sqlUsers) UpdateProfile(ctx context.Context, id int, update ProfileUpdate) error {
+	var err error
+	if update.DisplayName, err = s.db.sealOptional("users.display_name", update.DisplayName); err != nil {
+		return err
+	}
+	if update.Bio, err = s.db.sealOptional("users.bio", update.Bio); err != nil {
+		return err
+	}
+	if update.StatusText, err = s.db.sealOptional("users.status_text", update.StatusText); err != nil {
+		return err
+	}
+	return execOne(ctx, s.db, `UPDATE users SET
+		display_name = COALESCE(?, display_name),
+		bio = COALESCE(?, bio),
+		status_text = COALESCE(?, status_text),
+		show_last_seen = COALESCE(?, show_last_seen)
+		WHERE id = ?`, update.DisplayName, update.Bio, update.StatusText, update.ShowLastSeen, id)
+}
+
+func (s *sqlUsers) TouchLastSeen(ctx context.Context, id int, now time.Time, resolution time.Duration) error {
+	_, err := s.db.ExecContext(ctx, "UPDATE users SET last_seen_at = ? WHERE id = ? AND (last_seen_at IS NULL OR last_seen_at < ?)", now, id, now.Add(-resolution))
+	return err
+}
+
+func (s *sqlUsers) SetPrivacy(ctx context.Context, id int, messagePolicy string, hiddenFromDirectory bool) error {
+	return execOne(ctx, s.db, "UPDATE users SET message_policy = ?, hidden_from_directory = ? WHERE id = ?", messagePolicy, hiddenFromDirectory, id)
+}
+
+func (s *sqlUsers) SetAvatar(ctx context.Context, id int, avatar Avatar) error {
+	if s.db.keys != nil {
+		var err error
+		if avatar.Data, err = s.db.keys.SealBytes("avatars.data", avatar.Data); err != nil {
+			return err
+		}
+	}
+	_, err := s.db.ExecContext(ctx, `INSERT INTO avatars (user_id, content_type, data, updated_at) VALUES (?, ?, ?, ?)
+		ON CONFLICT (user_id) DO UPDATE SET content_type = excluded.content_type, data = excluded.data, updated_at = excluded.updated_at`,
+		id, avatar.ContentType, avatar.Data, avatar.UpdatedAt)
+	return err
+}
+
+func (s *sqlUsers) DeleteAvatar(ctx context.Context, id int) error {
+	_, err := s.db.ExecContext(ctx, "DELETE FROM avatars WHERE user_id = ?", id)
+	return err
+}
+
+func (s *sqlUsers) GetAvatar(ctx context.Context, id int) (Avatar, error) {
+	var avatar Avatar
+	err := s.db.QueryRowContext(ctx, "SELECT content_type, data, updated_at FROM avatars WHERE user_id = ?", id).Scan(&avatar.ContentType, &avatar.Data, &avatar.UpdatedAt)
+	if err == nil && s.db.keys != nil {
+		avatar.Data, err = s.db.keys.OpenBytes("avatars.data", avatar.Data)
+	}
+	return avatar, notFound(err)
+}
+
+func (s *sqlUsers) Block(ctx context.Context, blockerID int, blockedID int, at time.Time) error {
+	_, err := s.db.ExecContext(ctx, "INSERT INTO blocks (blocker_id, blocked_id, created_at) VALUES (?, ?, ?) ON CONFLICT DO NOTHING", blockerID, blockedID, at)
+	return err
+}
+
+func (s *sqlUsers) Unblock(ctx context.Context, blockerID int, blockedID int) error {
+	return execOne(ctx, s.db, "DELETE FROM blocks WHERE blocker_id = ? AND blocked_id = ?", blockerID, blockedID)
+}
+
+func (s *sqlUsers) Blocks(ctx context.Context, blockerID int, blockedID int) (bool, error) {
+	return exists(ctx, s.db, "SELECT EXISTS(SELECT 1 FROM blocks WHERE blocker_id = ? AND blocked_id = ?)", blockerID, blockedID)
+}
+
+func (s *sqlUsers) IsBlocked(ctx context.Context, id int, otherID int) (bool, error) {
+	return exists(ctx, s.db, "SELECT EXISTS(SELECT 1 FROM blocks WHERE (blocker_id = ? AND blocked_id = ?) OR (blocker_id = ? AND blocked_id = ?))", id, otherID, otherID, id)
+}
+
+func (s *sqlUsers) BlockedUserIDs(ctx context.Context, id int) ([]int, error) {
+	rows, err := s.db.QueryContext(ctx, "SELECT blocked_id FROM blocks WHERE blocker_id = ? UNION SELECT blocker_id FROM blocks WHERE blocked_id = ?", id, id)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var ids []int
+	for rows.Next() {
+		var blockedID int
+		if err := rows.Scan(&blockedID); err != nil {
+			return nil, err
+		}
+		ids = append(ids, blockedID)
+	}
+	return ids, rows.Err()
+}
+
+func (s *sqlUsers) ListBlocked(ctx context.Context, blockerID int) ([]User, error) {
+	return s.queryUsers(ctx, "SELECT "+userColumns+" FROM blocks JOIN users ON users.id = blocks.blocked_id WHERE blocks.blocker_id = ? ORDER BY blocks.created_at", blockerID)
+}
@@ -0,0 +1,348 @@
+// Package store keeps the SQL out of the HTTP handlers: the handlers depend
+// on the store interfaces, implemented on SQLite or PostgreSQL by NewSQL and
+// in memory by NewMemory.
+//
+// Methods returning a single record return ErrNotFound when there is none,
+// and updates of a missing record return ErrNotFound too.
+package store
+
+import (
+	"context"
+	"errors"
+	"time"
+)
+
+var (
+	// ErrNotFound is returned when the record does not exist.
+	ErrNotFound = errors.New("not found")
+	// ErrConflict is returned when a record breaks a uniqueness rule.
+	ErrConflict = errors.New("conflict")
+)
+
+// User is an account. Deleted accounts are tombstones: they keep their id,
+// but have no username nor public key.
+type User struct {
+	ID          int
+	Username    string
+	PublicKey   string
+	Role        string
+	DisplayName string
+	Bio         string
+	StatusText  string
+	LastSeenAt  *time.Time
+	// ShowLastSeen tells whether the user shows LastSeenAt to other users.
+	ShowLastSeen        bool
+	HasAvatar           bool
+	MessagePolicy       string
+	HiddenFromDirectory bool
+	SuspendedAt         *time.Time
+	DeletedAt           *time.Time
+}
+
+// NewUser is an account to create, with its username already normalized.
+type NewUser struct {
+	Username string
+	// UsernameKey and UsernameSkeleton are unique, see package username.
+	UsernameKey      string
+	UsernameSkeleton string
+	PublicKey        string
+}
+
+// ProfileUpdate holds the profile fields to change, nil fields are kept.
+type ProfileUpdate struct {
+	DisplayName  *string
+	Bio          *string
+	StatusText   *string
+	ShowLastSeen *bool
+}
+
+// Avatar is the profile picture of a user.
+type Avatar struct {
+	ContentType string
+	Data        []byte
+	UpdatedAt   time.Time
+}
+
+// ListUsersOptions selects a page of the users directory, sorted by username
+// key, then by id. Once the usernames are encrypted the SQL stores sort them
+// by the blind index of the key instead, an order which only keeps the pages
+// stable, and search them by the blind indexes of the prefixes of the keys,
+// which tell which users share a prefix. The users migration 0008 left
+// without a key are not listed.
+type ListUsersOptions struct {
+	// ViewerID is the user browsing the directory. Users hidden from the
+	// directory, but the viewer, and users blocking or blocked by the viewer
+	// are left out.
+	ViewerID int
+	// Prefix keeps the users whose username key, see username.Key, starts
+	// with it.
+	Prefix string
+	// AfterID, when not 0, starts the page after this user. The page is empty
+	// when the user does not exist.
+	AfterID int
+	Limit   int
+}
+
+// UserCounts counts the accounts which are not deleted.
+type UserCounts struct {
+	Users     int
+	Admins    int
+	Suspended int
+}
+
+// What happens to the messages of a deleted account, see UserStore.Delete.
+const (
+	// DeletedMessagesKeep leaves the messages untouched.
+	DeletedMessagesKeep = "keep"
+	// DeletedMessagesTombstone empties the messages sent and received by the
+	// account but keeps them, flagged as deleted, in the conversations.
+	DeletedMessagesTombstone = "tombstone"
+	// DeletedMessagesPurge removes the messages sent and received by the account.
+	DeletedMessagesPurge = "purge"
+)
+
+// UserStore keeps the accounts, their profile, privacy settings and blocks.
+type UserStore interface {
+	// Create creates the account and returns its ID. It returns ErrConflict
+	// when the username key or skeleton is taken.
+	Create(ctx context.Context, u NewUser) (int, error)
+	// UsernameTaken reports whether an account has the username key, and
+	// whether one has the username skeleton.
+	UsernameTaken(ctx context.Context, key string, skeleton string) (taken bool, lookalike bool, err error)
+	// Get returns the account, deleted or not.
+	Get(ctx context.Context, id int) (User, error)
+	// GetByUsernameKey returns the account with the username key.
+	GetByUsernameKey(ctx context.Context, key string) (User, error)
+	// List returns a page of the users directory.
+	List(ctx context.Context, opts ListUsersOptions) ([]User, error)
+	// ListAccounts returns every account which is not deleted, by id.
+	ListAccounts(ctx context.Context) ([]User, error)
+	Count(ctx context.Context) (UserCounts, error)
+
+	SetRole(ctx context.Context, id int, role string) error
+	// Suspend suspends the account, keeping the time of an ongoing suspension.
+	Suspend(ctx context.Context, id int, at time.Time) error
+	Unsuspend(ctx context.Context, id int) error
+	// Delete turns the account into a tombstone: everything identifying the
+	// user, their avatar, contacts and blocks are removed, and their messages
+	// follow messagesPolicy, one of the DeletedMessages constants. It returns
+	// ErrNotFound when the account does not exist or is already deleted.
+	Delete(ctx context.Context, id int, messagesPolicy string, at time.Time) error
+
+	UpdateProfile(ctx context.Context, id int, update ProfileUpdate) error
+	// TouchLastSeen sets the last seen time of the user to now, unless it is
+	// more recent than resolution.
+	TouchLastSeen(ctx context.Context, id int, now time.Time, resolution time.Duration) error
+	SetPrivacy(ctx context.Context, id int, messagePolicy string, hiddenFromDirectory bool) error
+
+	SetAvatar(ctx context.Context, id int, avatar Avatar) error
+	DeleteAvatar(ctx context.Context, id int) error
+	GetAvatar(ctx context.Context, id int) (Avatar, error)
+
+	// Block is a no-op when blockerID already blocks blockedID.
+	Block(ctx context.Context, blockerID int, blockedID int, at time.Time) error
+	Unblock(ctx context.Context, blockerID int, blockedID int) error
+	// Blocks reports whether blockerID blocks blockedID.
+	Blocks(ctx context.Context, blockerID int, blockedID int) (bool, error)
+	// IsBlocked reports whether one of the two users blocks the other.
+	IsBlocked(ctx context.Context, id int, otherID int) (bool, error)
+	// BlockedUserIDs returns the users id blocks or is blocked by.
+	BlockedUserIDs(ctx context.Context, id int) ([]int, error)
+	// ListBlocked returns the users blocked by blockerID, oldest block first.
+	ListBlocked(ctx context.Context, blockerID int) ([]User, error)
+}
+
+// Message is a message between two users. Deleted messages belonged to a
+// deleted account and have no content.
+type Message struct {
+	ID         int
+	Content    string
+	SenderID   int
+	ReceiverID int
+	DeletedAt  *time.Time
+}
+
+// MessageStore keeps the messages. Lists are sorted by id.
+type MessageStore interface {
+	// Create stores the message and returns its ID.
+	Create(ctx context.Context, m Message) (int, error)
+	Get(ctx context.Context, id int) (Message, error)
+	// ListForUser returns the messages sent or received by the user.
+	ListForUser(ctx context.Context, userID int) ([]Message, error)
+	// ListBetween returns the messages exchanged by the two users.
+	ListBetween(ctx context.Context, userID int, otherID int) ([]Message, error)
+	// Peers returns the users the user exchanged messages with, by first message.
+	Peers(ctx context.Context, userID int) ([]int, error)
+	// HasSent reports whether senderID sent a message to receiverID.
+	HasSent(ctx context.Context, senderID int, receiverID int) (bool, error)
+	// Count counts the messages which are not deleted.
+	Count(ctx context.Context) (int, error)
+}
+
+// Contact request statuses.
+const (
+	ContactPending  = "pending"
+	ContactAccepted = "accepted"
+)
+
+// Contact is a contact request, accepted or not.
+type Contact struct {
+	ID          int
+	RequesterID int
+	AddresseeID int
+	Status      string
+	CreatedAt   time.Time
+	RespondedAt *time.Time
+}
+
+// ContactUser is an accepted contact of a user.
+type ContactUser struct {
+	User  User
+	Since time.Time
+}
+
+// ContactStore keeps the contacts and the contact requests.
+type ContactStore interface {
+	// Create stores a contact request and returns its ID.
+	Create(ctx context.Context, contact Contact) (int, error)
+	// Find returns the contact or request between two users, in either direction.
+	Find(ctx context.Context, userID int, otherID int) (Contact, error)
+	// Accept accepts the pending request id sent to addresseeID.
+	Accept(ctx context.Context, id int, addresseeID int, at time.Time) error
+	// Reject removes the pending request id sent to addresseeID.
+	Reject(ctx context.Context, id int, addresseeID int) error
+	// Cancel removes the pending request id sent by requesterID.
+	Cancel(ctx context.Context, id int, requesterID int) error
+	// AreContacts reports whether the two users accepted each other as contacts.
+	AreContacts(ctx context.Context, userID int, otherID int) (bool, error)
+	// Remove removes the contact or request between two users, if any.
+	Remove(ctx context.Context, userID int, otherID int) error
+	// RemoveAccepted removes the accepted contact between two users.
+	RemoveAccepted(ctx context.Context, userID int, otherID int) error
+	// ListAccepted returns the contacts of the user, by username.
+	ListAccepted(ctx context.Context, userID int) ([]ContactUser, error)
+	// ListPending returns the requests sent and received by the user, oldest first.
+	ListPending(ctx context.Context, userID int) ([]Contact, error)
+}
+
+// Report is an abuse report.
+type Report struct {
+	ID         int
+	ReporterID int
+	ReportedID int
+	MessageID  *int
+	Reason     string
+	CreatedAt  time.Time
+	ResolvedAt *time.Time
+}
+
+// Report filters of ReportStore.List.
+const (
+	ReportsOpen     = "open"
+	ReportsResolved = "resolved"
+	ReportsAll      = "all"
+)
+
+// ReportStore keeps the abuse reports.
+type ReportStore interface {
+	// Create stores the report and returns its ID.
+	Create(ctx context.Context, r Report) (int, error)
+	// List returns the reports by id, filtered by one of the Reports constants.
+	List(ctx context.Context, status string) ([]Report, error)
+	// Resolve resolves the report, keeping the time of an earlier resolution.
+	Resolve(ctx context.Context, id int, at time.Time) error
+	CountOpen(ctx context.Context) (int, error)
+}
+
+// AuditEvent is a security relevant event. UserID is 0 when the event is not
+// tied to a known user.
+type AuditEvent struct {
+	ID        int
+	Event     string
+	UserID    int
+	IP        string
+	UserAgent string
+	Details   string
+	CreatedAt time.Time
+}
+
+// AuditFilter selects audit events.
+type AuditFilter struct {
+	// UserID, when not nil, keeps the events of this user.
+	UserID *int
+	// Event, when not empty, keeps the events of this type.
+	Event string
+	// Before, when not 0, keeps the events older than this event id.
+	Before int
+	Limit  int
+}
+
+// AuditStore keeps the audit trail, which is append-only.
+type AuditStore interface {
+	Append(ctx context.Context, event AuditEvent) error
+	// List returns the matching events, newest first.
+	List(ctx context.Context, filter AuditFilter) ([]AuditEvent, error)
+	// Prune removes the events older than before and returns how many were removed.
+	Prune(ctx context.Context, before time.Time) (int64, error)
+}
+
+// Token is an issued opaque token.
+type Token struct {
+	Token     string
+	SessionID string
+	UserID    int
+	Username  string
+	Device    string
+	Scopes    []string
+	ClientIP  string
+	UserAgent string
+	IssuedAt  time.Time
+	ExpiresAt time.Time
+}
+
+// TokenStore keeps the opaque tokens. Expired tokens are never returned.
+type TokenStore interface {
+	Save(ctx context.Context, token Token) error
+	Get(ctx context.Context, token string) (Token, error)
+	Delete(ctx context.Context, token string) error
+	// DeleteSession removes the token with the session id if it belongs to
+	// userID, or returns ErrNotFound.
+	DeleteSession(ctx context.Context, userID int, sessionID string) error
+	// DeleteUserTokens removes the tokens of userID but keep, and returns how
+	// many were removed.
+	DeleteUserTokens(ctx context.Context, userID int, keep string) (int, error)
+	// UserTokens returns the tokens of userID, oldest first.
+	UserTokens(ctx context.Context, userID int) ([]Token, error)
+	Count(ctx context.Context) (int, error)
+	// CountByUser returns the number of tokens of each user having some.
+	CountByUser(ctx context.Context) (map[int]int, error)
+	// DeleteExpired removes the expired tokens and returns how many were
+	// removed.
+	DeleteExpired(ctx context.Context) (int, error)
+}
+
+// RevocationStore keeps the signed tokens revoked before their expiry, until
+// they expire. Revocations kept in the database survive restarts and are
+// shared by the instances using it.
+type RevocationStore interface {
+	// RevokeToken revokes the token id until expiresAt.
+	RevokeToken(ctx context.Context, id string, expiresAt time.Time) error
+	// RevokeUser revokes the tokens of userID issued up to before, but the
+	// token except when not empty, until expiresAt. It replaces the previous
+	// revocation of userID.
+	RevokeUser(ctx context.Context, userID int, before time.Time, except string, expiresAt time.Time) error
+	// IsRevoked reports whether the token id of userID issued at issuedAt has
+	// been revoked.
+	IsRevoked(ctx context.Context, id string, userID int, issuedAt time.Time) (bool, error)
+}
+
+// Stores gathers the stores the routes depend on.
+type Stores struct {
+	Users       UserStore
+	Messages    MessageStore
+	Contacts    ContactStore
+	Reports     ReportStore
+	Audit       AuditStore
+	Tokens      TokenStore
+	Revocations RevocationStore
+}
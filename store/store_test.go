@@ -0,0 +1,97 @@
+package store
+
+import (
+	"database/sql"
+	"fmt"
+	"net/url"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+	_ "time/tzdata"
+
+	"github.com/adrienchanove/alpha-enigma-api/database"
+)
+
+// newTestSQL returns the stores of the migrated database of opts, closed at
+// the end of the test.
+func newTestSQL(t testing.TB, opts database.Options) *Stores {
+	t.Helper()
+	db, err := database.InitDB(opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { db.Close() })
+	return NewSQL(db, opts.Dialect(), nil)
+}
+
+// testSQLite returns the options of a new SQLite database in a temporary
+// directory.
+func testSQLite(t testing.TB) database.Options {
+	return database.Options{DSN: filepath.Join(t.TempDir(), "test.db")}
+}
+
+// testPostgres returns the options of a new schema in the PostgreSQL database
+// of ENIGMA_TEST_POSTGRES_DSN, a "postgres://" URL, dropped at the end of the
+// test. It skips the test when the variable is not set.
+func testPostgres(t testing.TB) database.Options {
+	t.Helper()
+	dsn := os.Getenv("ENIGMA_TEST_POSTGRES_DSN")
+	if dsn == "" {
+		t.Skip("ENIGMA_TEST_POSTGRES_DSN is not set")
+	}
+	u, err := url.Parse(dsn)
+	if err != nil || database.DialectOf(dsn) != database.Postgres {
+		t.Fatalf("ENIGMA_TEST_POSTGRES_DSN must be a postgres:// URL")
+	}
+
+	db, err := sql.Open("postgres", dsn)
+	if err != nil {
+		t.Fatal(err)
+	}
+	schema := fmt.Sprintf("enigma_test_%d", time.Now().UnixNano())
+	if _, err := db.Exec("CREATE SCHEMA " + schema); err != nil {
+		db.Close()
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		if _, err := db.Exec("DROP SCHEMA " + schema + " CASCADE"); err != nil {
+			t.Error(err)
+		}
+		db.Close()
+	})
+
+	query := u.Query()
+	query.Set("search_path", schema)
+	u.RawQuery = query.Encode()
+	return database.Options{DSN: u.String()}
+}
+
+// eachStores runs test on the memory stores, on the SQLite ones and, when
+// ENIGMA_TEST_POSTGRES_DSN is set, on the PostgreSQL ones.
+func eachStores(t *testing.T, test func(t *testing.T, stores *Stores)) {
+	t.Run("memory", func(t *testing.T) { test(t, NewMemory()) })
+	t.Run("sqlite", func(t *testing.T) { test(t, newTestSQL(t, testSQLite(t))) })
+	t.Run("postgres", func(t *testing.T) { test(t, newTestSQL(t, testPostgres(t))) })
+}
+
+// inZone runs the test with zone as the local time zone, the one of the
+// times the server gets from time.Now.
+func inZone(t *testing.T, zone string) *time.Location {
+	t.Helper()
+	location := loadZone(t, zone)
+	local := time.Local
+	time.Local = location
+	t.Cleanup(func() { time.Local = local })
+	return location
+}
+
+// loadZone returns the time zone named zone.
+func loadZone(t *testing.T, zone string) *time.Location {
+	t.Helper()
+	location, err := time.LoadLocation(zone)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return location
+}
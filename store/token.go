@@ -0,0 +1,136 @@
+package store
+
+import (
+	"context"
+	"slices"
+	"sync"
+	"time"
+)
+
+// MemoryTokenStore keeps the tokens in memory, they are lost on restart and
+// not shared between instances.
+type MemoryTokenStore struct {
+	mu     sync.Mutex
+	tokens map[string]Token
+}
+
+// NewMemoryTokenStore returns an empty MemoryTokenStore.
+func NewMemoryTokenStore() *MemoryTokenStore {
+	return &MemoryTokenStore{tokens: make(map[string]Token)}
+}
+
+func (s *MemoryTokenStore) Save(ctx context.Context, token Token) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.tokens[token.Token] = token
+	return nil
+}
+
+func (s *MemoryTokenStore) Get(ctx context.Context, token string) (Token, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	t, ok := s.tokens[token]
+	if !ok {
+		return Token{}, ErrNotFound
+	}
+	if !time.Now().Before(t.ExpiresAt) {
+		delete(s.tokens, token)
+		return Token{}, ErrNotFound
+	}
+	return t, nil
+}
+
+func (s *MemoryTokenStore) Delete(ctx context.Context, token string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	delete(s.tokens, token)
+	return nil
+}
+
+func (s *MemoryTokenStore) DeleteSession(ctx context.Context, userID int, sessionID string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for token, t := range s.tokens {
+		if t.SessionID == sessionID && t.UserID == userID {
+			delete(s.tokens, token)
+			return nil
+		}
+	}
+	return ErrNotFound
+}
+
+func (s *MemoryTokenStore) DeleteUserTokens(ctx context.Context, userID int, keep string) (int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	deleted := 0
+	for token, t := range s.tokens {
+		if t.UserID == userID && token != keep {
+			delete(s.tokens, token)
+			deleted++
+		}
+	}
+	return deleted, nil
+}
+
+func (s *MemoryTokenStore) UserTokens(ctx context.Context, userID int) ([]Token, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := time.Now()
+	var tokens []Token
+	for _, t := range s.tokens {
+		if t.UserID == userID && now.Before(t.ExpiresAt) {
+			tokens = append(tokens, t)
+		}
+	}
+	slices.SortFunc(tokens, func(a, b Token) int { return a.IssuedAt.Compare(b.IssuedAt) })
+	return tokens, nil
+}
+
+func (s *MemoryTokenStore) Count(ctx context.Context) (int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := time.Now()
+	count := 0
+	for _, t := range s.tokens {
+		if now.Before(t.ExpiresAt) {
+			count++
+		}
+	}
+	return count, nil
+}
+
+func (s *MemoryTokenStore) CountByUser(ctx context.Context) (map[int]int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := time.Now()
+	counts := make(map[int]int)
+	for _, t := range s.tokens {
+		if now.Before(t.ExpiresAt) {
+			counts[t.UserID]++
+		}
+	}
+	return counts, nil
+}
+
+func (s *MemoryTokenStore) DeleteExpired(ctx context.Context) (int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := time.Now()
+	deleted := 0
+	for token, t := range s.tokens {
+		if !now.Before(t.ExpiresAt) {
+			delete(s.tokens, token)
+			deleted++
+		}
+	}
+	return deleted, nil
+}
@@ -0,0 +1,184 @@
+package store
+
+import (
+	"context"
+	"errors"
+	"maps"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/adrienchanove/alpha-enigma-api/atrest"
+	"github.com/adrienchanove/alpha-enigma-api/database"
+)
+
+func TestTokens(t *testing.T) {
+	eachStores(t, func(t *testing.T, stores *Stores) {
+		ctx := context.Background()
+		tokens := stores.Tokens
+		now := time.Now()
+
+		for _, token := range []Token{
+			{Token: "alice-1", SessionID: "s1", UserID: 1, Username: "alice", Device: "phone", Scopes: []string{"users:read", "messages:read"},
+				ClientIP: "192.0.2.1", UserAgent: "test", IssuedAt: now.Add(-2 * time.Minute), ExpiresAt: now.Add(time.Hour)},
+			{Token: "alice-2", SessionID: "s2", UserID: 1, Username: "alice", IssuedAt: now.Add(-time.Minute), ExpiresAt: now.Add(time.Hour)},
+			{Token: "alice-expired", SessionID: "s3", UserID: 1, Username: "alice", IssuedAt: now.Add(-2 * time.Hour), ExpiresAt: now.Add(-time.Hour)},
+			{Token: "bob-1", SessionID: "s4", UserID: 2, Username: "bob", IssuedAt: now, ExpiresAt: now.Add(time.Hour)},
+		} {
+			if err := tokens.Save(ctx, token); err != nil {
+				t.Fatal(err)
+			}
+		}
+
+		if count, err := tokens.Count(ctx); err != nil || count != 3 {
+			t.Errorf("count %d, %v, want the 3 unexpired tokens", count, err)
+		}
+		if counts, err := tokens.CountByUser(ctx); err != nil || !maps.Equal(counts, map[int]int{1: 2, 2: 1}) {
+			t.Errorf("counts by user %v, %v, want 2 for alice and 1 for bob", counts, err)
+		}
+		if deleted, err := tokens.DeleteExpired(ctx); err != nil || deleted != 1 {
+			t.Errorf("deleted %d expired tokens, %v, want 1", deleted, err)
+		}
+
+		token, err := tokens.Get(ctx, "alice-1")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if token.Token != "alice-1" || token.SessionID != "s1" || token.UserID != 1 || token.Username != "alice" || token.Device != "phone" ||
+			!slices.Equal(token.Scopes, []string{"users:read", "messages:read"}) || token.ClientIP != "192.0.2.1" || token.UserAgent != "test" ||
+			!token.IssuedAt.Equal(now.Add(-2*time.Minute)) || !token.ExpiresAt.Equal(now.Add(time.Hour)) {
+			t.Errorf("token %+v", token)
+		}
+		for _, missing := range []string{"alice-expired", "unknown"} {
+			if _, err := tokens.Get(ctx, missing); !errors.Is(err, ErrNotFound) {
+				t.Errorf("token %s: %v, want ErrNotFound", missing, err)
+			}
+		}
+
+		userTokens, err := tokens.UserTokens(ctx, 1)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var sessions []string
+		for _, token := range userTokens {
+			sessions = append(sessions, token.SessionID)
+		}
+		if !slices.Equal(sessions, []string{"s1", "s2"}) {
+			t.Errorf("sessions of alice %v, want the unexpired ones, oldest first", sessions)
+		}
+
+		if err := tokens.DeleteSession(ctx, 2, "s1"); !errors.Is(err, ErrNotFound) {
+			t.Errorf("deleting the session of another user: %v, want ErrNotFound", err)
+		}
+		if err := tokens.DeleteSession(ctx, 1, "s1"); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := tokens.Get(ctx, "alice-1"); !errors.Is(err, ErrNotFound) {
+			t.Errorf("token of a deleted session: %v, want ErrNotFound", err)
+		}
+
+		if err := tokens.Save(ctx, Token{Token: "alice-3", SessionID: "s5", UserID: 1, IssuedAt: now, ExpiresAt: now.Add(time.Hour)}); err != nil {
+			t.Fatal(err)
+		}
+		if deleted, err := tokens.DeleteUserTokens(ctx, 1, "alice-3"); err != nil || deleted != 1 {
+			t.Errorf("deleted %d tokens of alice, %v, want 1", deleted, err)
+		}
+		if _, err := tokens.Get(ctx, "alice-3"); err != nil {
+			t.Errorf("kept token: %v", err)
+		}
+		if err := tokens.Delete(ctx, "bob-1"); err != nil {
+			t.Fatal(err)
+		}
+		if count, err := tokens.Count(ctx); err != nil || count != 1 {
+			t.Errorf("count %d, %v, want 1", count, err)
+		}
+	})
+}
+
+func TestTokensExpireAcrossZones(t *testing.T) {
+	paris := inZone(t, "Europe/Paris")
+	newYork, tokyo := loadZone(t, "America/New_York"), loadZone(t, "Asia/Tokyo")
+	eachStores(t, func(t *testing.T, stores *Stores) {
+		ctx := context.Background()
+		tokens := stores.Tokens
+		now := time.Now()
+
+		for i, expiresAt := range []time.Time{
+			now.Add(30 * time.Minute).In(paris), now.Add(30 * time.Minute).In(newYork), now.Add(30 * time.Minute).UTC(),
+			now.Add(-30 * time.Minute).In(paris), now.Add(-30 * time.Minute).In(tokyo), now.Add(-30 * time.Minute).UTC(),
+		} {
+			token := Token{Token: "token-" + strconv.Itoa(i), SessionID: "s" + strconv.Itoa(i), UserID: 1, Username: "alice", IssuedAt: expiresAt.Add(-time.Hour), ExpiresAt: expiresAt}
+			if err := tokens.Save(ctx, token); err != nil {
+				t.Fatal(err)
+			}
+		}
+
+		if count, err := tokens.Count(ctx); err != nil || count != 3 {
+			t.Errorf("count %d, %v, want the 3 unexpired tokens", count, err)
+		}
+		if counts, err := tokens.CountByUser(ctx); err != nil || !maps.Equal(counts, map[int]int{1: 3}) {
+			t.Errorf("counts by user %v, %v, want 3 for alice", counts, err)
+		}
+		if userTokens, err := tokens.UserTokens(ctx, 1); err != nil || len(userTokens) != 3 {
+			t.Errorf("%d tokens of alice, %v, want the 3 unexpired ones", len(userTokens), err)
+		}
+		if deleted, err := tokens.DeleteExpired(ctx); err != nil || deleted != 3 {
+			t.Errorf("deleted %d expired tokens, %v, want 3", deleted, err)
+		}
+	})
+}
+
+func TestSQLTokensAreHashedAndEncrypted(t *testing.T) {
+	key, err := atrest.GenerateKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+	keys, err := atrest.NewKeyring(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	opts := database.Options{DSN: filepath.Join(t.TempDir(), "test.db")}
+	db, err := database.InitDB(opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	ctx := context.Background()
+	// encrypted as the server does when it starts with keys
+	if _, err := Reencrypt(ctx, db, opts.Dialect(), keys, false); err != nil {
+		t.Fatal(err)
+	}
+	tokens := NewSQL(db, opts.Dialect(), keys).Tokens
+
+	now := time.Now()
+	err = tokens.Save(ctx, Token{Token: "secret-token", SessionID: "s1", UserID: 1, Username: "alice", Device: "phone",
+		ClientIP: "192.0.2.1", UserAgent: "enigma-client/1.0", IssuedAt: now, ExpiresAt: now.Add(time.Hour)})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var row string
+	err = db.QueryRow("SELECT token_hash || username || device || client_ip || user_agent FROM tokens").Scan(&row)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, plain := range []string{"secret-token", "alice", "phone", "192.0.2.1", "enigma-client"} {
+		if strings.Contains(row, plain) {
+			t.Errorf("%q stored in plaintext", plain)
+		}
+	}
+	if token, err := tokens.Get(ctx, "secret-token"); err != nil || token.Username != "alice" || token.ClientIP != "192.0.2.1" {
+		t.Errorf("token %+v, %v", token, err)
+	}
+
+	// decrypted by -decrypt like the other columns
+	if _, err := Reencrypt(ctx, db, opts.Dialect(), keys, true); err != nil {
+		t.Fatal(err)
+	}
+	if token, err := NewSQL(db, opts.Dialect(), nil).Tokens.Get(ctx, "secret-token"); err != nil || token.Username != "alice" || token.ClientIP != "192.0.2.1" {
+		t.Errorf("decrypted token %+v, %v", token, err)
+	}
+}
@@ -0,0 +1,135 @@
+package store
+
+import (
+	"context"
+	"errors"
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/adrienchanove/alpha-enigma-api/username"
+)
+
+// createUsers creates the accounts and returns their ids.
+func createUsers(t *testing.T, users UserStore, names ...string) []int {
+	t.Helper()
+	var ids []int
+	for _, name := range names {
+		id, err := users.Create(context.Background(), NewUser{
+			Username:         name,
+			UsernameKey:      username.Key(name),
+			UsernameSkeleton: username.Skeleton(name),
+			PublicKey:        name + "-key",
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		ids = append(ids, id)
+	}
+	return ids
+}
+
+func usernames(users []User) []string {
+	var names []string
+	for _, u := range users {
+		names = append(names, u.Username)
+	}
+	return names
+}
+
+func TestUsers(t *testing.T) {
+	eachStores(t, func(t *testing.T, stores *Stores) {
+		ctx := context.Background()
+		users := stores.Users
+		ids := createUsers(t, users, "carol", "Alice", "bob", "albert")
+		carol, alice, bob := ids[0], ids[1], ids[2]
+
+		_, err := users.Create(ctx, NewUser{Username: "ALICE", UsernameKey: username.Key("ALICE"), UsernameSkeleton: username.Skeleton("ALICE"), PublicKey: "key"})
+		if !errors.Is(err, ErrConflict) {
+			t.Errorf("creating a taken username: %v, want ErrConflict", err)
+		}
+		if taken, lookalike, err := users.UsernameTaken(ctx, username.Key("b0b"), username.Skeleton("b0b")); err != nil || taken || !lookalike {
+			t.Errorf("b0b: taken %v, look-alike %v, %v, want a look-alike", taken, lookalike, err)
+		}
+
+		user, err := users.GetByUsernameKey(ctx, username.Key("alice"))
+		if err != nil || user.ID != alice || user.Username != "Alice" || user.PublicKey != "Alice-key" || user.Role != "user" {
+			t.Errorf("alice: %+v, %v", user, err)
+		}
+		if _, err := users.Get(ctx, 1000); !errors.Is(err, ErrNotFound) {
+			t.Errorf("unknown user: %v, want ErrNotFound", err)
+		}
+
+		// the directory is sorted by username key, and paged
+		page, err := users.List(ctx, ListUsersOptions{ViewerID: carol, Prefix: username.Key("AL"), Limit: 10})
+		if err != nil || !slices.Equal(usernames(page), []string{"albert", "Alice"}) {
+			t.Errorf("users starting with al: %v, %v", usernames(page), err)
+		}
+		page, err = users.List(ctx, ListUsersOptions{ViewerID: carol, AfterID: alice, Limit: 1})
+		if err != nil || !slices.Equal(usernames(page), []string{"bob"}) {
+			t.Errorf("page after alice: %v, %v", usernames(page), err)
+		}
+
+		// blocks hide the users from each other's directory
+		now := time.Now()
+		if err := users.Block(ctx, carol, bob, now); err != nil {
+			t.Fatal(err)
+		}
+		if err := users.Block(ctx, carol, bob, now); err != nil {
+			t.Errorf("blocking twice: %v", err)
+		}
+		if blocked, err := users.IsBlocked(ctx, bob, carol); err != nil || !blocked {
+			t.Errorf("bob and carol blocked: %v, %v", blocked, err)
+		}
+		if blocks, err := users.Blocks(ctx, bob, carol); err != nil || blocks {
+			t.Errorf("bob blocks carol: %v, %v", blocks, err)
+		}
+		page, err = users.List(ctx, ListUsersOptions{ViewerID: bob, Limit: 10})
+		if err != nil || !slices.Equal(usernames(page), []string{"albert", "Alice", "bob"}) {
+			t.Errorf("directory of bob: %v, %v", usernames(page), err)
+		}
+		if ids, err := users.BlockedUserIDs(ctx, bob); err != nil || !slices.Equal(ids, []int{carol}) {
+			t.Errorf("users blocking bob: %v, %v", ids, err)
+		}
+		if err := users.Unblock(ctx, carol, bob); err != nil {
+			t.Fatal(err)
+		}
+
+		bio := "hello"
+		if err := users.UpdateProfile(ctx, bob, ProfileUpdate{Bio: &bio}); err != nil {
+			t.Fatal(err)
+		}
+		if err := users.SetAvatar(ctx, bob, Avatar{ContentType: "image/png", Data: []byte("png"), UpdatedAt: now}); err != nil {
+			t.Fatal(err)
+		}
+		if err := users.Suspend(ctx, bob, now); err != nil {
+			t.Fatal(err)
+		}
+		user, err = users.Get(ctx, bob)
+		if err != nil || user.Bio != "hello" || !user.HasAvatar || user.SuspendedAt == nil {
+			t.Errorf("bob: %+v, %v", user, err)
+		}
+		if counts, err := users.Count(ctx); err != nil || counts != (UserCounts{Users: 4, Suspended: 1}) {
+			t.Errorf("counts %+v, %v", counts, err)
+		}
+
+		// deleted users are tombstones
+		if err := users.Delete(ctx, bob, DeletedMessagesKeep, now); err != nil {
+			t.Fatal(err)
+		}
+		if err := users.Delete(ctx, bob, DeletedMessagesKeep, now); !errors.Is(err, ErrNotFound) {
+			t.Errorf("deleting twice: %v, want ErrNotFound", err)
+		}
+		user, err = users.Get(ctx, bob)
+		if err != nil || user.Username != "" || user.PublicKey != "" || user.HasAvatar || user.DeletedAt == nil {
+			t.Errorf("deleted bob: %+v, %v", user, err)
+		}
+		if taken, _, err := users.UsernameTaken(ctx, username.Key("bob"), username.Skeleton("bob")); err != nil || taken {
+			t.Errorf("username of a deleted user taken: %v, %v", taken, err)
+		}
+		accounts, err := users.ListAccounts(ctx)
+		if err != nil || !slices.Equal(usernames(accounts), []string{"carol", "Alice", "albert"}) {
+			t.Errorf("accounts %v, %v", usernames(accounts), err)
+		}
+	})
+}
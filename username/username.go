@@ -0,0 +1,143 @@
+// Package username validates and normalises usernames.
+//
+// A username is stored in its NFKC form, which is how it is displayed. Two
+// derived forms guard its uniqueness: the key, case folded, so "Alice" and
+// "alice" are the same user, and the skeleton, which also maps look-alike
+// characters to a common prototype so "a1ice" or a Cyrillic "аlice" cannot
+// impersonate "alice".
+//
+// The keys and skeletons are stored with the users: changing how they are
+// computed needs a migration recomputing the stored ones.
+package username
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+
+	"golang.org/x/text/cases"
+	"golang.org/x/text/unicode/norm"
+)
+
+// Length limits of a username, in characters.
+const (
+	MinLength = 3
+	MaxLength = 32
+)
+
+// separators may be used inside a username, one at a time.
+const separators = "._-"
+
+var (
+	ErrLength   = fmt.Errorf("username must be %d to %d characters", MinLength, MaxLength)
+	ErrCharset  = errors.New("username may only contain letters, digits and single '.', '_' or '-' between them")
+	ErrReserved = errors.New("username is reserved")
+)
+
+// reserved are names that could be mistaken for the service or its staff.
+// They are compared by skeleton, so their look-alikes are reserved too.
+var reserved = []string{
+	"abuse", "admin", "administrator", "alpha-enigma", "anonymous", "api", "auth",
+	"deleted", "deleted-user", "doc", "docs", "enigma", "everyone", "help",
+	"mod", "moderator", "nil", "noreply", "null", "official", "postmaster", "root",
+	"security", "staff", "support", "sysadmin", "system", "undefined", "user",
+	"users", "webmaster",
+}
+
+var reservedSkeletons = make(map[string]bool, len(reserved))
+
+func init() {
+	for _, name := range reserved {
+		reservedSkeletons[Skeleton(name)] = true
+	}
+}
+
+var folder = cases.Fold()
+
+// Normalize returns the NFKC form of name, or an error when it breaks the
+// username rules or is reserved.
+func Normalize(name string) (string, error) {
+	name = norm.NFKC.String(strings.TrimSpace(name))
+
+	if n := utf8.RuneCountInString(name); n < MinLength || n > MaxLength {
+		return "", ErrLength
+	}
+
+	previousSeparator := true
+	for _, r := range name {
+		switch {
+		case unicode.IsLetter(r) || unicode.IsDigit(r) || unicode.Is(unicode.Mn, r):
+			previousSeparator = false
+		case strings.ContainsRune(separators, r) && !previousSeparator:
+			previousSeparator = true
+		default:
+			return "", ErrCharset
+		}
+	}
+	if previousSeparator {
+		return "", ErrCharset
+	}
+
+	if IsReserved(name) {
+		return "", ErrReserved
+	}
+	return name, nil
+}
+
+// Key returns the case insensitive form of name, used to look users up.
+func Key(name string) string {
+	return folder.String(norm.NFKC.String(strings.TrimSpace(name)))
+}
+
+// IsReserved reports whether name is, or looks like, a reserved name.
+func IsReserved(name string) bool {
+	return reservedSkeletons[Skeleton(name)]
+}
+
+// Skeleton returns the form of name two look-alike usernames share: case
+// folded, without accents nor separators, and with confusable characters
+// replaced by their Latin prototype.
+func Skeleton(name string) string {
+	decomposed := norm.NFKD.String(folder.String(name))
+
+	var b strings.Builder
+	for _, r := range decomposed {
+		if unicode.Is(unicode.Mn, r) || strings.ContainsRune(separators, r) {
+			continue
+		}
+		if prototype, ok := confusables[r]; ok {
+			r = prototype
+		}
+		b.WriteRune(r)
+	}
+
+	skeleton := b.String()
+	for sequence, prototype := range confusableSequences {
+		skeleton = strings.ReplaceAll(skeleton, sequence, prototype)
+	}
+	return skeleton
+}
+
+// confusables maps characters that look like another one, once case folded,
+// to it. It covers digits and the Cyrillic and Greek letters mistaken for Latin
+// ones, after the confusables list of Unicode TR39.
+var confusables = map[rune]rune{
+	'0': 'o', '1': 'l', 'i': 'l', '|': 'l', 'ı': 'l',
+	// Cyrillic
+	'а': 'a', 'в': 'b', 'е': 'e', 'һ': 'h', 'і': 'l', 'ј': 'j', 'к': 'k',
+	'м': 'm', 'н': 'h', 'о': 'o', 'р': 'p', 'с': 'c', 'т': 't', 'у': 'y', 'х': 'x',
+	'ѕ': 's', 'ԁ': 'd', 'ԛ': 'q', 'ԝ': 'w', 'ь': 'b', 'ү': 'y', 'ӏ': 'l',
+	// Greek
+	'α': 'a', 'β': 'b', 'γ': 'y', 'ε': 'e', 'η': 'n', 'ι': 'l', 'κ': 'k', 'ν': 'v',
+	'ο': 'o', 'ρ': 'p', 'τ': 't', 'υ': 'u', 'χ': 'x', 'ω': 'w',
+	// Latin
+	'ɡ': 'g', 'ɑ': 'a', 'ɩ': 'l', 'ʏ': 'y',
+}
+
+// confusableSequences are letter pairs that look like a single letter.
+var confusableSequences = map[string]string{
+	"rn": "m",
+	"vv": "w",
+}
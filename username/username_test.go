@@ -0,0 +1,123 @@
+package username
+
+import (
+	"errors"
+	"strings"
+	"testing"
+)
+
+func TestNormalize(t *testing.T) {
+	for _, test := range []struct {
+		name string
+		want string
+		err  error
+	}{
+		{"Alice", "Alice", nil},
+		{"  bob  ", "bob", nil},
+		{"ＡＬＩＣＥ", "ALICE", nil}, // full width, folded by NFKC
+		{"ﬁne", "fine", nil},
+		{"jean-luc.o_brien", "jean-luc.o_brien", nil},
+		{"Zoë", "Zoë", nil},
+		{"Zoë", "Zoë", nil}, // composed by NFKC
+		{"日本語", "日本語", nil},
+		{"user42", "user42", nil},
+
+		{"ab", "", ErrLength},
+		{strings.Repeat("a", MaxLength+1), "", ErrLength},
+		{"a..b", "", ErrCharset},
+		{"a._b", "", ErrCharset},
+		{"_abc", "", ErrCharset},
+		{".abc", "", ErrCharset},
+		{"abc-", "", ErrCharset},
+		{"a b c", "", ErrCharset},
+		{"alice@home", "", ErrCharset},
+		{"al:ce", "", ErrCharset},
+		{"abc​", "", ErrCharset}, // zero width space
+
+		{"admin", "", ErrReserved},
+		{"Admin", "", ErrReserved},
+		{"adm1n", "", ErrReserved},
+		{"sys.admin", "", ErrReserved},
+		{"ѕupport", "", ErrReserved}, // Cyrillic dze
+	} {
+		name, err := Normalize(test.name)
+		if name != test.want || !errors.Is(err, test.err) {
+			t.Errorf("Normalize(%q) = %q, %v, want %q, %v", test.name, name, err, test.want, test.err)
+		}
+	}
+}
+
+func TestKey(t *testing.T) {
+	for _, names := range [][]string{
+		{"alice", "Alice", "ALICE", "ＡＬＩＣＥ", " alice "},
+		{"strasse", "Straße", "STRASSE"},
+		{"σας", "ΣΑΣ", "Σας"},
+	} {
+		for _, name := range names[1:] {
+			if Key(name) != Key(names[0]) {
+				t.Errorf("Key(%q) = %q, want the key %q of %q", name, Key(name), Key(names[0]), names[0])
+			}
+		}
+	}
+
+	// look-alikes have distinct keys, the skeleton tells them apart
+	for _, name := range []string{"a1ice", "аlice", "alicé", "a.lice"} {
+		if Key(name) == Key("alice") {
+			t.Errorf("Key(%q) is the key of alice", name)
+		}
+	}
+}
+
+func TestSkeleton(t *testing.T) {
+	for _, names := range [][]string{
+		{"alice", "Alice", "a1ice", "aIice", "аlice", "alicé", "a.l-i_ce", "ΑLICE"},
+		{"modern", "rnodern", "MODERN"},
+		{"wow", "vvow", "ԝow"},
+		{"bob", "b0b", "BOB", "вob"},
+		{"paypal", "раураl"},
+	} {
+		for _, name := range names[1:] {
+			if Skeleton(name) != Skeleton(names[0]) {
+				t.Errorf("Skeleton(%q) = %q, want the skeleton %q of %q", name, Skeleton(name), Skeleton(names[0]), names[0])
+			}
+		}
+	}
+
+	for _, pair := range [][2]string{{"alice", "alina"}, {"bob", "rob"}, {"carol", "karol"}} {
+		if Skeleton(pair[0]) == Skeleton(pair[1]) {
+			t.Errorf("%q and %q share the skeleton %q", pair[0], pair[1], Skeleton(pair[0]))
+		}
+	}
+}
+
+func TestIsReserved(t *testing.T) {
+	for name, want := range map[string]bool{
+		"root": true, "R00T": true, "no-reply": true, "noreply": true, "no.reply": true, "rooted": false,
+	} {
+		if IsReserved(name) != want {
+			t.Errorf("IsReserved(%q) = %v, want %v", name, !want, want)
+		}
+	}
+}
+
+// TestKeyAndSkeletonAreStable pins the keys and skeletons, which are stored
+// with the users. Changing them needs a migration recomputing the stored
+// ones, as migration 0008 computed them, before updating this test.
+func TestKeyAndSkeletonAreStable(t *testing.T) {
+	for _, test := range []struct{ name, key, skeleton string }{
+		{"Alice", "alice", "allce"},
+		{"ＡＬＩＣＥ", "alice", "allce"},
+		{"Straße", "strasse", "strasse"},
+		{"a.l-i_ce", "a.l-i_ce", "allce"},
+		{"аlice", "аlice", "allce"},
+		{"alicé", "alicé", "allce"},
+		{"rnodern", "rnodern", "modem"},
+		{"b0b", "b0b", "bob"},
+		{"İstanbul", "i̇stanbul", "lstanbul"},
+		{"日本語", "日本語", "日本語"},
+	} {
+		if key, skeleton := Key(test.name), Skeleton(test.name); key != test.key || skeleton != test.skeleton {
+			t.Errorf("%q: key %q and skeleton %q, want %q and %q", test.name, key, skeleton, test.key, test.skeleton)
+		}
+	}
+}